@@ -0,0 +1,61 @@
+package buf
+
+import (
+	"sync"
+	"unsafe"
+)
+
+/*
+
+字节池,按容量分级(64B~64KB),减少读取数据时的内存分配
+
+bs := buf.Get(1024)
+...
+buf.Put(bs) //归还之后不能再使用
+
+超过64KB的不进行复用,未归还的由GC回收
+
+*/
+
+const (
+	poolMinShift = 6  //最小64B
+	poolMaxShift = 16 //最大64KB
+)
+
+var pools [poolMaxShift - poolMinShift + 1]sync.Pool
+
+// Get 获取长度为size的字节,容量为大于等于size的分级大小
+func Get(size int) []byte {
+	i := poolIndex(size)
+	if i < 0 {
+		return make([]byte, size)
+	}
+	if p, ok := pools[i].Get().(*byte); ok {
+		//保存的是数组首地址,不会产生额外的内存分配
+		return unsafe.Slice(p, 1<<(i+poolMinShift))[:size]
+	}
+	return make([]byte, size, 1<<(i+poolMinShift))
+}
+
+// Put 归还字节,按容量放入小于等于容量的最大分级,归还之后不能再使用
+func Put(p []byte) {
+	c := cap(p)
+	if c < 1<<poolMinShift || c > 1<<poolMaxShift {
+		return
+	}
+	i := poolIndex(c)
+	if c < 1<<(i+poolMinShift) {
+		i--
+	}
+	pools[i].Put(&p[:1][0])
+}
+
+// poolIndex 获取容量对应的分级,超过最大分级返回-1
+func poolIndex(size int) int {
+	for i := 0; i <= poolMaxShift-poolMinShift; i++ {
+		if size <= 1<<(i+poolMinShift) {
+			return i
+		}
+	}
+	return -1
+}
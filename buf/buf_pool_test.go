@@ -0,0 +1,64 @@
+package buf
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	for _, size := range []int{0, 1, 64, 100, 1024, 1025, 1 << 16} {
+		p := Get(size)
+		if len(p) != size || cap(p) < size {
+			t.Fatalf("长度错误,预期(%d),得到(%d/%d)", size, len(p), cap(p))
+		}
+		Put(p)
+	}
+	//超过最大分级不复用
+	if p := Get(1<<16 + 1); len(p) != 1<<16+1 {
+		t.Fatal("长度错误")
+	}
+	//子切片归还到较小的分级
+	p := Get(1024)
+	Put(p[9:])
+	if p := Get(512); len(p) != 512 || cap(p) < 512 {
+		t.Fatal("长度错误")
+	}
+}
+
+// loopReader 循环返回相同的数据
+type loopReader struct {
+	data   []byte
+	offset int
+}
+
+func (this *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, this.data[this.offset:])
+	this.offset = (this.offset + n) % len(this.data)
+	return n, nil
+}
+
+// BenchmarkReadMostAlloc 每帧分配新的内存,之前的方式
+func BenchmarkReadMostAlloc(b *testing.B) {
+	r := bufio.NewReader(&loopReader{data: bytes.Repeat([]byte{1}, 512)})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bs := make([]byte, 1024)
+		if _, err := r.Read(bs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadMost 使用字节池,处理完成后归还
+func BenchmarkReadMost(b *testing.B) {
+	r := bufio.NewReader(&loopReader{data: bytes.Repeat([]byte{1}, 512)})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bs, err := Read1KB(r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		Put(bs)
+	}
+}
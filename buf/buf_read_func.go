@@ -45,16 +45,16 @@ func ReadPrefix(buf *bufio.Reader, prefix []byte) ([]byte, error) {
 	return cache, nil
 }
 
-// ReadMost 读取至多x字节
+// ReadMost 读取至多x字节,字节从字节池获取,处理完成后可以通过Put归还
 func ReadMost(r *bufio.Reader, max int) ([]byte, error) {
-	buf := make([]byte, max)
+	buf := Get(max)
 	n, err := r.Read(buf)
 	return buf[:n], err
 }
 
-// ReadLeast 读取至少x字节,除非返回错误
+// ReadLeast 读取至少x字节,除非返回错误,字节从字节池获取,处理完成后可以通过Put归还
 func ReadLeast(r *bufio.Reader, min int) ([]byte, error) {
-	buf := make([]byte, min)
+	buf := Get(min)
 	n, err := io.ReadAtLeast(r, buf, min)
 	return buf[:n], err
 }
@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/*
+
+编解码,用于io.Model,rpc和p2p的数据,可以切换格式,例如流量受限的4G网络使用CBOR或MessagePack
+内置JSON,CBOR(RFC 8949),MessagePack,CBOR和MessagePack是纯Go实现,字段名和json标签一致
+也可以实现Codec接口接入其他库,注册后使用
+
+编号用于在数据包中标识格式,通讯双方注册的编号需要一致,内置的是1-3
+
+*/
+
+const (
+	IDJSON    byte = 1
+	IDCBOR    byte = 2
+	IDMsgPack byte = 3
+)
+
+// Codec 编解码
+type Codec interface {
+	// Name 名称,用于协商,例 json
+	Name() string
+
+	// Marshal 编码
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal 解码,v需要是指针
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	CBOR    Codec = cborCodec{}
+	MsgPack Codec = msgpackCodec{}
+
+	mu    sync.RWMutex
+	ids   = map[byte]Codec{}
+	names = map[string]byte{}
+)
+
+func init() {
+	Register(IDJSON, JSON)
+	Register(IDCBOR, CBOR)
+	Register(IDMsgPack, MsgPack)
+}
+
+// Register 注册编解码,编号为0无效,相同的编号或名称会覆盖
+func Register(id byte, c Codec) {
+	if id == 0 || c == nil {
+		panic(fmt.Sprintf("无效的编解码(%d)", id))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if old, ok := ids[id]; ok {
+		delete(names, old.Name())
+	}
+	ids[id] = c
+	names[c.Name()] = id
+}
+
+// Get 根据名称获取编解码,不存在返回nil
+func Get(name string) Codec {
+	mu.RLock()
+	defer mu.RUnlock()
+	return ids[names[name]]
+}
+
+// GetByID 根据编号获取编解码,不存在返回nil
+func GetByID(id byte) Codec {
+	mu.RLock()
+	defer mu.RUnlock()
+	return ids[id]
+}
+
+// ID 获取编解码的编号,未注册返回false
+func ID(c Codec) (byte, bool) {
+	if c == nil {
+		return 0, false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	id, ok := names[c.Name()]
+	return id, ok
+}
+
+// Names 已注册的编解码名称,按编号排序
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]byte, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	result := make([]string, 0, len(list))
+	for _, id := range list {
+		result = append(result, ids[id].Name())
+	}
+	return result
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
@@ -0,0 +1,346 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+/*
+
+CBOR(RFC 8949),整数和长度使用最短的编码,浮点数能无损转换的使用float32
+解码支持不定长,标签(忽略标签号)和float16
+
+*/
+
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborString = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+
+	cborFalse   = 0xf4
+	cborTrue    = 0xf5
+	cborNull    = 0xf6
+	cborUndef   = 0xf7
+	cborFloat16 = 0xf9
+	cborFloat32 = 0xfa
+	cborFloat64 = 0xfb
+	cborBreak   = 0xff
+)
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string { return "cbor" }
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	w := &cborWriter{}
+	if err := encode(w, v); err != nil {
+		return nil, err
+	}
+	return w.bs, nil
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	r := &cborReader{bs: data}
+	src, err := r.read(0)
+	if err != nil {
+		return err
+	}
+	if r.pos != len(data) {
+		return fmt.Errorf("多余的数据(%d字节)", len(data)-r.pos)
+	}
+	return unmarshal(src, v)
+}
+
+type cborWriter struct {
+	bs []byte
+}
+
+// head 写入类型和长度(数值)
+func (this *cborWriter) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		this.bs = append(this.bs, major|byte(n))
+	case n <= math.MaxUint8:
+		this.bs = append(this.bs, major|24, byte(n))
+	case n <= math.MaxUint16:
+		this.bs = append(this.bs, major|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		this.bs = append(this.bs, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		this.bs = append(this.bs, major|27)
+		this.bs = appendUint64(this.bs, n)
+	}
+}
+
+func (this *cborWriter) writeNil() { this.bs = append(this.bs, cborNull) }
+
+func (this *cborWriter) writeBool(b bool) {
+	if b {
+		this.bs = append(this.bs, cborTrue)
+		return
+	}
+	this.bs = append(this.bs, cborFalse)
+}
+
+func (this *cborWriter) writeInt(i int64) {
+	if i >= 0 {
+		this.head(cborUint, uint64(i))
+		return
+	}
+	this.head(cborNegInt, uint64(-1-i))
+}
+
+func (this *cborWriter) writeUint(u uint64) { this.head(cborUint, u) }
+
+func (this *cborWriter) writeFloat32(f float32) {
+	n := math.Float32bits(f)
+	this.bs = append(this.bs, cborFloat32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func (this *cborWriter) writeFloat64(f float64) {
+	this.bs = append(this.bs, cborFloat64)
+	this.bs = appendUint64(this.bs, math.Float64bits(f))
+}
+
+func (this *cborWriter) writeString(s string) {
+	this.head(cborString, uint64(len(s)))
+	this.bs = append(this.bs, s...)
+}
+
+func (this *cborWriter) writeBytes(p []byte) {
+	this.head(cborBytes, uint64(len(p)))
+	this.bs = append(this.bs, p...)
+}
+
+func (this *cborWriter) writeArray(n int) { this.head(cborArray, uint64(n)) }
+
+func (this *cborWriter) writeMap(n int) { this.head(cborMap, uint64(n)) }
+
+type cborReader struct {
+	bs  []byte
+	pos int
+}
+
+func (this *cborReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(this.bs)-this.pos) {
+		return nil, errShortData
+	}
+	p := this.bs[this.pos : this.pos+int(n)]
+	this.pos += int(n)
+	return p, nil
+}
+
+// head 读取类型和长度(数值),indefinite表示不定长
+func (this *cborReader) head() (major, info byte, n uint64, indefinite bool, err error) {
+	p, err := this.next(1)
+	if err != nil {
+		return
+	}
+	major, info = p[0]&0xe0, p[0]&0x1f
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		p, err = this.next(1 << (info - 24))
+		if err != nil {
+			return
+		}
+		for _, b := range p {
+			n = n<<8 | uint64(b)
+		}
+	case info == 31:
+		indefinite = true
+	default:
+		err = fmt.Errorf("无效的附加信息(%d)", info)
+	}
+	return
+}
+
+// count 数组或map的元素数量,每个元素至少1字节,防止恶意数据申请过大的内存
+func (this *cborReader) count(n uint64, per uint64) (int, error) {
+	if n > uint64(len(this.bs)-this.pos)/per {
+		return 0, errShortData
+	}
+	return int(n), nil
+}
+
+func (this *cborReader) read(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+	major, info, n, indefinite, err := this.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		if indefinite {
+			break
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+
+	case cborNegInt:
+		if indefinite {
+			break
+		}
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("负数(-1-%d)超出范围", n)
+		}
+		return -1 - int64(n), nil
+
+	case cborBytes, cborString:
+		var p []byte
+		if indefinite {
+			p, err = this.readChunks(major)
+		} else {
+			p, err = this.next(n)
+			p = append([]byte{}, p...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if major == cborString {
+			return string(p), nil
+		}
+		return p, nil
+
+	case cborArray:
+		if indefinite {
+			list := []interface{}{}
+			for !this.isBreak() {
+				item, err := this.read(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			return list, nil
+		}
+		size, err := this.count(n, 1)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			if list[i], err = this.read(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case cborMap:
+		size := 0
+		if !indefinite {
+			if size, err = this.count(n, 2); err != nil {
+				return nil, err
+			}
+		}
+		m := make(mapValue, 0, size)
+		for i := 0; indefinite || i < size; i++ {
+			if indefinite && this.isBreak() {
+				break
+			}
+			key, err := this.read(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			val, err := this.read(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, kv{key: key, val: val})
+		}
+		return m, nil
+
+	case cborTag:
+		if indefinite {
+			break
+		}
+		return this.read(depth + 1)
+
+	case cborSimple:
+		switch major | info {
+		case cborFalse:
+			return false, nil
+		case cborTrue:
+			return true, nil
+		case cborNull, cborUndef:
+			return nil, nil
+		case cborFloat16:
+			return float16(uint16(n)), nil
+		case cborFloat32:
+			return float64(math.Float32frombits(uint32(n))), nil
+		case cborFloat64:
+			return math.Float64frombits(n), nil
+		}
+		return nil, fmt.Errorf("不支持的简单值(%#x)", major|info)
+	}
+	return nil, fmt.Errorf("无效的不定长类型(%#x)", major|info)
+}
+
+// isBreak 是否是不定长的结束符,是则跳过
+func (this *cborReader) isBreak() bool {
+	if this.pos < len(this.bs) && this.bs[this.pos] == cborBreak {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+// readChunks 读取不定长的字节或字符串,由多个定长的同类型分块组成
+func (this *cborReader) readChunks(major byte) ([]byte, error) {
+	result := []byte{}
+	for !this.isBreak() {
+		m, _, n, indefinite, err := this.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || indefinite {
+			return nil, fmt.Errorf("无效的分块类型(%#x)", m)
+		}
+		p, err := this.next(n)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p...)
+	}
+	return result, nil
+}
+
+// float16 半精度浮点数转float64
+func float16(n uint16) float64 {
+	exp := int(n>>10) & 0x1f
+	mant := float64(n & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if n&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+func appendUint64(bs []byte, n uint64) []byte {
+	p := make([]byte, 8)
+	binary.BigEndian.PutUint64(p, n)
+	return append(bs, p...)
+}
@@ -0,0 +1,327 @@
+package codec
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+
+MessagePack,整数和长度使用最短的编码,浮点数能无损转换的使用float32
+字节使用bin类型,不支持扩展类型(ext)
+
+*/
+
+const (
+	mpNil     = 0xc0
+	mpFalse   = 0xc2
+	mpTrue    = 0xc3
+	mpBin8    = 0xc4
+	mpBin16   = 0xc5
+	mpBin32   = 0xc6
+	mpFloat32 = 0xca
+	mpFloat64 = 0xcb
+	mpUint8   = 0xcc
+	mpUint16  = 0xcd
+	mpUint32  = 0xce
+	mpUint64  = 0xcf
+	mpInt8    = 0xd0
+	mpInt16   = 0xd1
+	mpInt32   = 0xd2
+	mpInt64   = 0xd3
+	mpStr8    = 0xd9
+	mpStr16   = 0xda
+	mpStr32   = 0xdb
+	mpArray16 = 0xdc
+	mpArray32 = 0xdd
+	mpMap16   = 0xde
+	mpMap32   = 0xdf
+
+	mpFixMap   = 0x80
+	mpFixArray = 0x90
+	mpFixStr   = 0xa0
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	w := &msgpackWriter{}
+	if err := encode(w, v); err != nil {
+		return nil, err
+	}
+	return w.bs, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	r := &msgpackReader{bs: data}
+	src, err := r.read(0)
+	if err != nil {
+		return err
+	}
+	if r.pos != len(data) {
+		return fmt.Errorf("多余的数据(%d字节)", len(data)-r.pos)
+	}
+	return unmarshal(src, v)
+}
+
+type msgpackWriter struct {
+	bs []byte
+}
+
+// size 写入长度,fix是长度小于fixMax时的前缀,否则按8/16/32位使用对应的前缀,code8为0表示没有8位的类型
+func (this *msgpackWriter) size(n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		this.bs = append(this.bs, fix|byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		this.bs = append(this.bs, code8, byte(n))
+	case n <= math.MaxUint16:
+		this.bs = append(this.bs, code16, byte(n>>8), byte(n))
+	default:
+		this.bs = append(this.bs, code32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func (this *msgpackWriter) writeNil() { this.bs = append(this.bs, mpNil) }
+
+func (this *msgpackWriter) writeBool(b bool) {
+	if b {
+		this.bs = append(this.bs, mpTrue)
+		return
+	}
+	this.bs = append(this.bs, mpFalse)
+}
+
+func (this *msgpackWriter) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		this.writeUint(uint64(i))
+	case i >= -32:
+		this.bs = append(this.bs, byte(i))
+	case i >= math.MinInt8:
+		this.bs = append(this.bs, mpInt8, byte(i))
+	case i >= math.MinInt16:
+		this.bs = append(this.bs, mpInt16, byte(i>>8), byte(i))
+	case i >= math.MinInt32:
+		this.bs = append(this.bs, mpInt32, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	default:
+		this.bs = append(this.bs, mpInt64)
+		this.bs = appendUint64(this.bs, uint64(i))
+	}
+}
+
+func (this *msgpackWriter) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		this.bs = append(this.bs, byte(u))
+	case u <= math.MaxUint8:
+		this.bs = append(this.bs, mpUint8, byte(u))
+	case u <= math.MaxUint16:
+		this.bs = append(this.bs, mpUint16, byte(u>>8), byte(u))
+	case u <= math.MaxUint32:
+		this.bs = append(this.bs, mpUint32, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+	default:
+		this.bs = append(this.bs, mpUint64)
+		this.bs = appendUint64(this.bs, u)
+	}
+}
+
+func (this *msgpackWriter) writeFloat32(f float32) {
+	n := math.Float32bits(f)
+	this.bs = append(this.bs, mpFloat32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func (this *msgpackWriter) writeFloat64(f float64) {
+	this.bs = append(this.bs, mpFloat64)
+	this.bs = appendUint64(this.bs, math.Float64bits(f))
+}
+
+func (this *msgpackWriter) writeString(s string) {
+	this.size(len(s), mpFixStr, 32, mpStr8, mpStr16, mpStr32)
+	this.bs = append(this.bs, s...)
+}
+
+func (this *msgpackWriter) writeBytes(p []byte) {
+	//bin没有fix类型
+	this.size(len(p), 0, 0, mpBin8, mpBin16, mpBin32)
+	this.bs = append(this.bs, p...)
+}
+
+func (this *msgpackWriter) writeArray(n int) {
+	this.size(n, mpFixArray, 16, 0, mpArray16, mpArray32)
+}
+
+func (this *msgpackWriter) writeMap(n int) {
+	this.size(n, mpFixMap, 16, 0, mpMap16, mpMap32)
+}
+
+type msgpackReader struct {
+	bs  []byte
+	pos int
+}
+
+func (this *msgpackReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(this.bs)-this.pos) {
+		return nil, errShortData
+	}
+	p := this.bs[this.pos : this.pos+int(n)]
+	this.pos += int(n)
+	return p, nil
+}
+
+// uint 读取n字节的大端无符号数
+func (this *msgpackReader) uint(n uint64) (uint64, error) {
+	p, err := this.next(n)
+	if err != nil {
+		return 0, err
+	}
+	result := uint64(0)
+	for _, b := range p {
+		result = result<<8 | uint64(b)
+	}
+	return result, nil
+}
+
+func (this *msgpackReader) read(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+	p, err := this.next(1)
+	if err != nil {
+		return nil, err
+	}
+	code := p[0]
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == mpFixMap:
+		return this.readMap(uint64(code&0x0f), depth)
+	case code&0xf0 == mpFixArray:
+		return this.readArray(uint64(code&0x0f), depth)
+	case code&0xe0 == mpFixStr:
+		return this.readString(uint64(code & 0x1f))
+	}
+
+	switch code {
+	case mpNil:
+		return nil, nil
+	case mpFalse:
+		return false, nil
+	case mpTrue:
+		return true, nil
+
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		n, err := this.uint(1 << (code - mpUint8))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+
+	case mpInt8, mpInt16, mpInt32, mpInt64:
+		size := uint64(1 << (code - mpInt8))
+		n, err := this.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		//符号扩展
+		shift := 64 - size*8
+		return int64(n<<shift) >> shift, nil
+
+	case mpFloat32:
+		n, err := this.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(n))), nil
+
+	case mpFloat64:
+		n, err := this.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(n), nil
+
+	case mpStr8, mpStr16, mpStr32:
+		n, err := this.uint(1 << (code - mpStr8))
+		if err != nil {
+			return nil, err
+		}
+		return this.readString(n)
+
+	case mpBin8, mpBin16, mpBin32:
+		n, err := this.uint(1 << (code - mpBin8))
+		if err != nil {
+			return nil, err
+		}
+		p, err := this.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, p...), nil
+
+	case mpArray16, mpArray32:
+		n, err := this.uint(2 << (code - mpArray16))
+		if err != nil {
+			return nil, err
+		}
+		return this.readArray(n, depth)
+
+	case mpMap16, mpMap32:
+		n, err := this.uint(2 << (code - mpMap16))
+		if err != nil {
+			return nil, err
+		}
+		return this.readMap(n, depth)
+	}
+	return nil, fmt.Errorf("不支持的类型(%#x)", code)
+}
+
+func (this *msgpackReader) readString(n uint64) (interface{}, error) {
+	p, err := this.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(p), nil
+}
+
+func (this *msgpackReader) readArray(n uint64, depth int) (interface{}, error) {
+	//每个元素至少1字节,防止恶意数据申请过大的内存
+	if n > uint64(len(this.bs)-this.pos) {
+		return nil, errShortData
+	}
+	list := make([]interface{}, n)
+	for i := range list {
+		item, err := this.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = item
+	}
+	return list, nil
+}
+
+func (this *msgpackReader) readMap(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(this.bs)-this.pos)/2 {
+		return nil, errShortData
+	}
+	m := make(mapValue, 0, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := this.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		val, err := this.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, kv{key: key, val: val})
+	}
+	return m, nil
+}
@@ -0,0 +1,298 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type base struct {
+	ID   string `json:"id"`
+	Skip string `json:"-"`
+}
+
+type report struct {
+	base
+	Time     time.Time          `json:"time"`
+	Online   bool               `json:"online"`
+	Signal   int8               `json:"signal"`
+	Count    uint64             `json:"count"`
+	Voltage  float32            `json:"voltage"`
+	Values   []float64          `json:"values"`
+	Tags     map[string]string  `json:"tags,omitempty"`
+	Index    map[int]string     `json:"index"`
+	Raw      []byte             `json:"raw"`
+	Mac      [6]byte            `json:"mac"`
+	Next     *report            `json:"next"`
+	Memo     string             `json:"memo,omitempty"`
+	Extra    interface{}        `json:"extra"`
+	Children []report           `json:"children"`
+	Points   map[string][]int32 `json:"points"`
+	private  int
+}
+
+func newReport() *report {
+	return &report{
+		base:    base{ID: "dev-0001"},
+		Time:    time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC),
+		Online:  true,
+		Signal:  -87,
+		Count:   math.MaxUint64,
+		Voltage: 3.3,
+		Values:  []float64{0.5, 25.1, -40, 1e300},
+		Tags:    map[string]string{"site": "A", "line": "2"},
+		Index:   map[int]string{1: "a", -2: "b"},
+		Raw:     []byte{0, 1, 2, 0xff},
+		Mac:     [6]byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		Next:    &report{base: base{ID: "next"}, Values: []float64{}},
+		Extra:   map[string]interface{}{"a": int64(1), "b": []interface{}{"x", true, nil}},
+		Children: []report{
+			{base: base{ID: "child"}, Signal: 1},
+		},
+		Points: map[string][]int32{"p": {math.MinInt32, 0, math.MaxInt32}},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{CBOR, MsgPack} {
+		want := newReport()
+		bs, err := c.Marshal(want)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		got := new(report)
+		if err := c.Unmarshal(bs, got); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s: 预期\n%+v\n得到\n%+v", c.Name(), want, got)
+		}
+	}
+}
+
+// TestInterface 解码到interface{},和json的结构一致
+func TestInterface(t *testing.T) {
+	for _, c := range []Codec{CBOR, MsgPack} {
+		bs, err := c.Marshal(map[string]interface{}{"a": 1, "b": "x", "c": []int{1}, "d": map[int]bool{1: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got interface{}
+		if err := c.Unmarshal(bs, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{"a": int64(1), "b": "x", "c": []interface{}{int64(1)}, "d": map[string]interface{}{"1": true}}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s: 预期(%v),得到(%v)", c.Name(), want, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, v := range []struct {
+		codec Codec
+		value interface{}
+		hex   string
+	}{
+		{CBOR, 0, "00"},
+		{CBOR, 23, "17"},
+		{CBOR, 24, "1818"},
+		{CBOR, 1000, "1903e8"},
+		{CBOR, 1000000, "1a000f4240"},
+		{CBOR, uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{CBOR, -1, "20"},
+		{CBOR, -1000, "3903e7"},
+		{CBOR, 1.5, "fa3fc00000"},
+		{CBOR, 1.1, "fb3ff199999999999a"},
+		{CBOR, false, "f4"},
+		{CBOR, nil, "f6"},
+		{CBOR, "IETF", "6449455446"},
+		{CBOR, []byte{1, 2, 3, 4}, "4401020304"},
+		{CBOR, []int{1, 2, 3}, "83010203"},
+		{CBOR, map[string]int{"a": 1, "b": 2}, "a2616101616202"},
+
+		{MsgPack, 0, "00"},
+		{MsgPack, 127, "7f"},
+		{MsgPack, 128, "cc80"},
+		{MsgPack, 256, "cd0100"},
+		{MsgPack, -1, "ff"},
+		{MsgPack, -32, "e0"},
+		{MsgPack, -33, "d0df"},
+		{MsgPack, -129, "d1ff7f"},
+		{MsgPack, 1.5, "ca3fc00000"},
+		{MsgPack, true, "c3"},
+		{MsgPack, nil, "c0"},
+		{MsgPack, "abc", "a3616263"},
+		{MsgPack, []byte{1}, "c40101"},
+		{MsgPack, []int{1}, "9101"},
+		{MsgPack, map[string]int{"a": 1}, "81a16101"},
+	} {
+		bs, err := v.codec.Marshal(v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(bs) != v.hex {
+			t.Fatalf("%s(%v): 预期(%s),得到(%x)", v.codec.Name(), v.value, v.hex, bs)
+		}
+	}
+}
+
+// TestDecode 解码其他实现编码的数据,例如不定长和半精度
+func TestDecode(t *testing.T) {
+	for _, v := range []struct {
+		codec Codec
+		hex   string
+		want  interface{}
+	}{
+		{CBOR, "f93e00", 1.5},
+		{CBOR, "f97c00", math.Inf(1)},
+		{CBOR, "f98001", -math.Ldexp(1, -24)},
+		{CBOR, "9f0102ff", []interface{}{int64(1), int64(2)}},
+		{CBOR, "7f657374726561646d696e67ff", "streaming"},
+		{CBOR, "bf61610161629f0203ffff", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{CBOR, "c11a514b67b0", int64(1363896240)},
+		{MsgPack, "d3ffffffffffffffff", int64(-1)},
+		{MsgPack, "cb3ff199999999999a", 1.1},
+		{MsgPack, "dc000101", []interface{}{int64(1)}},
+		{MsgPack, "de0001a161c0", map[string]interface{}{"a": nil}},
+	} {
+		bs, _ := hex.DecodeString(v.hex)
+		var got interface{}
+		if err := v.codec.Unmarshal(bs, &got); err != nil {
+			t.Fatalf("%s(%s): %v", v.codec.Name(), v.hex, err)
+		}
+		if !reflect.DeepEqual(v.want, got) {
+			t.Fatalf("%s(%s): 预期(%v),得到(%v)", v.codec.Name(), v.hex, v.want, got)
+		}
+	}
+}
+
+// TestInvalid 错误的数据返回错误,不能panic或申请过大的内存
+func TestInvalid(t *testing.T) {
+	for _, v := range []struct {
+		codec Codec
+		hex   string
+	}{
+		{CBOR, ""},
+		{CBOR, "1903"},
+		{CBOR, "9bffffffffffffffff"},
+		{CBOR, "bbffffffffffffffff"},
+		{CBOR, "5bffffffffffffffff"},
+		{CBOR, "3bffffffffffffffff"},
+		{CBOR, "0101"},
+		{CBOR, "1c"},
+		{MsgPack, ""},
+		{MsgPack, "cd01"},
+		{MsgPack, "ddffffffff"},
+		{MsgPack, "dfffffffff"},
+		{MsgPack, "c6ffffffff"},
+		{MsgPack, "c1"},
+		{MsgPack, "d401ff"},
+	} {
+		bs, _ := hex.DecodeString(v.hex)
+		var got interface{}
+		if err := v.codec.Unmarshal(bs, &got); err == nil {
+			t.Fatalf("%s(%s): 预期错误,得到(%v)", v.codec.Name(), v.hex, got)
+		}
+	}
+
+	//嵌套过深
+	deep := bytes.Repeat([]byte{0x81}, maxDepth+10)
+	var got interface{}
+	if err := CBOR.Unmarshal(deep, &got); err != errTooDeep {
+		t.Fatalf("预期(%v),得到(%v)", errTooDeep, err)
+	}
+
+	//类型不匹配
+	bs, _ := CBOR.Marshal(map[string]interface{}{"signal": 1000})
+	if err := CBOR.Unmarshal(bs, new(report)); err == nil {
+		t.Fatal("预期溢出错误")
+	}
+	bs, _ = MsgPack.Marshal(map[string]interface{}{"online": "true"})
+	if err := MsgPack.Unmarshal(bs, new(report)); err == nil {
+		t.Fatal("预期类型错误")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if Get("cbor") != CBOR || GetByID(IDMsgPack) != MsgPack || Get("xml") != nil {
+		t.Fatal("获取编解码错误")
+	}
+	if id, ok := ID(JSON); !ok || id != IDJSON {
+		t.Fatalf("预期(%d),得到(%d)", IDJSON, id)
+	}
+	if names := Names(); !reflect.DeepEqual(names, []string{"json", "cbor", "msgpack"}) {
+		t.Fatalf("预期(json,cbor,msgpack),得到(%v)", names)
+	}
+}
+
+// telemetry 典型的设备上报数据,用于比较数据长度
+type telemetry struct {
+	DeviceID  string             `json:"deviceID"`
+	Timestamp int64              `json:"timestamp"`
+	Online    bool               `json:"online"`
+	Signal    int                `json:"signal"`
+	Values    map[string]float64 `json:"values"`
+	Alarms    []string           `json:"alarms"`
+}
+
+func newTelemetry() *telemetry {
+	return &telemetry{
+		DeviceID:  "860000000000001",
+		Timestamp: 1714552200000,
+		Online:    true,
+		Signal:    -87,
+		Values: map[string]float64{
+			"temperature": 25.5,
+			"humidity":    61,
+			"voltage":     3.3,
+			"current":     0.125,
+		},
+		Alarms: []string{"door", "smoke"},
+	}
+}
+
+// TestSize 二进制格式的数据长度应小于json
+func TestSize(t *testing.T) {
+	sizes := map[string]int{}
+	for _, c := range []Codec{JSON, CBOR, MsgPack} {
+		bs, err := c.Marshal(newTelemetry())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[c.Name()] = len(bs)
+		t.Logf("%-8s %d字节", c.Name(), len(bs))
+	}
+	if sizes["cbor"] >= sizes["json"] || sizes["msgpack"] >= sizes["json"] {
+		t.Fatalf("预期小于json,得到(%v)", sizes)
+	}
+}
+
+// BenchmarkMarshal 比较编码速度和数据长度(bytes/msg)
+func BenchmarkMarshal(b *testing.B) {
+	v := newTelemetry()
+	for _, c := range []Codec{JSON, CBOR, MsgPack} {
+		b.Run(c.Name(), func(b *testing.B) {
+			var bs []byte
+			for i := 0; i < b.N; i++ {
+				bs, _ = c.Marshal(v)
+			}
+			b.ReportMetric(float64(len(bs)), "bytes/msg")
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for _, c := range []Codec{JSON, CBOR, MsgPack} {
+		bs, _ := c.Marshal(newTelemetry())
+		b.Run(c.Name(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.Unmarshal(bs, new(telemetry))
+			}
+			b.ReportMetric(float64(len(bs)), "bytes/msg")
+		})
+	}
+}
@@ -0,0 +1,571 @@
+package codec
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+
+CBOR和MessagePack共用的反射部分
+编码: 按类型遍历数据,调用各格式的writer写入,结构体字段和json标签一致(名称,-,omitempty,匿名字段展开)
+解码: 各格式先解析成通用的数据(nil,bool,int64,uint64,float64,string,[]byte,[]interface{},mapValue),再赋值到目标
+
+*/
+
+const maxDepth = 512
+
+var (
+	errTooDeep   = errors.New("数据嵌套过深")
+	errShortData = errors.New("数据长度不足")
+
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// writer 各格式的写入
+type writer interface {
+	writeNil()
+	writeBool(b bool)
+	writeInt(i int64)
+	writeUint(u uint64)
+	writeFloat32(f float32)
+	writeFloat64(f float64)
+	writeString(s string)
+	writeBytes(p []byte)
+	writeArray(n int)
+	writeMap(n int)
+}
+
+// writeFloat 能无损转成float32的使用float32,减少数据长度
+func writeFloat(w writer, f float64) {
+	if float64(float32(f)) == f || math.IsNaN(f) {
+		w.writeFloat32(float32(f))
+		return
+	}
+	w.writeFloat64(f)
+}
+
+// kv 解析出的键值对,保持原有的顺序和键的类型
+type kv struct {
+	key interface{}
+	val interface{}
+}
+
+// mapValue 解析出的map
+type mapValue []kv
+
+func encode(w writer, v interface{}) error {
+	return encodeValue(w, reflect.ValueOf(v), 0)
+}
+
+func encodeValue(w writer, v reflect.Value, depth int) error {
+	if depth > maxDepth {
+		return errTooDeep
+	}
+	if !v.IsValid() {
+		w.writeNil()
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+	}
+	if v.Type().Implements(textMarshalerType) && v.CanInterface() {
+		bs, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		w.writeString(string(bs))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return encodeValue(w, v.Elem(), depth+1)
+	case reflect.Bool:
+		w.writeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		w.writeUint(v.Uint())
+	case reflect.Float32:
+		w.writeFloat32(float32(v.Float()))
+	case reflect.Float64:
+		writeFloat(w, v.Float())
+	case reflect.String:
+		w.writeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			w.writeBytes(v.Bytes())
+			return nil
+		}
+		return encodeArray(w, v, depth)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			bs := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bs), v)
+			w.writeBytes(bs)
+			return nil
+		}
+		return encodeArray(w, v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			w.writeNil()
+			return nil
+		}
+		keys := v.MapKeys()
+		//排序,保证相同的数据编码结果一致
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		w.writeMap(len(keys))
+		for _, k := range keys {
+			if err := encodeValue(w, k, depth+1); err != nil {
+				return err
+			}
+			if err := encodeValue(w, v.MapIndex(k), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		values := make([]reflect.Value, 0, len(fields))
+		list := make([]*field, 0, len(fields))
+		for _, f := range fields {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmpty(fv)) {
+				continue
+			}
+			values = append(values, fv)
+			list = append(list, f)
+		}
+		w.writeMap(len(list))
+		for i, f := range list {
+			w.writeString(f.name)
+			if err := encodeValue(w, values[i], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("不支持的类型(%s)", v.Type())
+	}
+	return nil
+}
+
+func encodeArray(w writer, v reflect.Value, depth int) error {
+	w.writeArray(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := encodeValue(w, v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// field 结构体字段
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map
+
+func cachedFields(t reflect.Type) []*field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]*field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t, nil))
+	return f.([]*field)
+}
+
+// typeFields 获取结构体的字段,匿名的结构体字段(无标签名称)展开,外层的字段优先
+func typeFields(t reflect.Type, index []int) []*field {
+	var result, embedded []*field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		idx := append(append([]int{}, index...), i)
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, typeFields(ft, idx)...)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		result = append(result, &field{
+			name:      name,
+			index:     idx,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+	exist := make(map[string]bool, len(result))
+	for _, f := range result {
+		exist[f.name] = true
+	}
+	for _, f := range embedded {
+		if !exist[f.name] {
+			exist[f.name] = true
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// fieldByIndex 获取字段,匿名指针为nil时返回false
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc 获取字段,匿名指针为nil时新建
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// unmarshal 把解析出的通用数据赋值到v
+func unmarshal(src interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("解码需要非nil的指针,得到(%T)", v)
+	}
+	return assign(rv.Elem(), src)
+}
+
+func assign(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			dst.Set(reflect.Zero(dst.Type()))
+		}
+		return nil
+	}
+
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(dst.Elem(), src)
+	}
+
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		dst.Set(reflect.ValueOf(toInterface(src)))
+		return nil
+	}
+
+	if dst.CanAddr() && dst.Addr().Type().Implements(textUnmarshalerType) {
+		switch val := src.(type) {
+		case string:
+			return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(val))
+		case []byte:
+			return dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(val)
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Bool:
+		if b, ok := src.(bool); ok {
+			dst.SetBool(b)
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch val := src.(type) {
+		case int64:
+			n = val
+		case uint64:
+			if val > math.MaxInt64 {
+				return overflow(src, dst)
+			}
+			n = int64(val)
+		case float64:
+			if val != math.Trunc(val) {
+				return mismatch(src, dst)
+			}
+			n = int64(val)
+		default:
+			return mismatch(src, dst)
+		}
+		if dst.OverflowInt(n) {
+			return overflow(src, dst)
+		}
+		dst.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch val := src.(type) {
+		case int64:
+			if val < 0 {
+				return overflow(src, dst)
+			}
+			n = uint64(val)
+		case uint64:
+			n = val
+		case float64:
+			if val < 0 || val != math.Trunc(val) {
+				return mismatch(src, dst)
+			}
+			n = uint64(val)
+		default:
+			return mismatch(src, dst)
+		}
+		if dst.OverflowUint(n) {
+			return overflow(src, dst)
+		}
+		dst.SetUint(n)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		switch val := src.(type) {
+		case int64:
+			dst.SetFloat(float64(val))
+		case uint64:
+			dst.SetFloat(float64(val))
+		case float64:
+			dst.SetFloat(val)
+		default:
+			return mismatch(src, dst)
+		}
+		return nil
+
+	case reflect.String:
+		switch val := src.(type) {
+		case string:
+			dst.SetString(val)
+			return nil
+		case []byte:
+			dst.SetString(string(val))
+			return nil
+		}
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch val := src.(type) {
+			case []byte:
+				dst.SetBytes(append([]byte{}, val...))
+				return nil
+			case string:
+				dst.SetBytes([]byte(val))
+				return nil
+			}
+		}
+		list, ok := src.([]interface{})
+		if !ok {
+			break
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := assign(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+		return nil
+
+	case reflect.Array:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			if val, ok := src.([]byte); ok {
+				reflect.Copy(dst, reflect.ValueOf(val))
+				return nil
+			}
+		}
+		list, ok := src.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < dst.Len() && i < len(list); i++ {
+			if err := assign(dst.Index(i), list[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		m, ok := src.(mapValue)
+		if !ok {
+			break
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), len(m)))
+		}
+		for _, item := range m {
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := assignKey(key, item.key); err != nil {
+				return err
+			}
+			val := reflect.New(dst.Type().Elem()).Elem()
+			if err := assign(val, item.val); err != nil {
+				return err
+			}
+			dst.SetMapIndex(key, val)
+		}
+		return nil
+
+	case reflect.Struct:
+		m, ok := src.(mapValue)
+		if !ok {
+			break
+		}
+		fields := cachedFields(dst.Type())
+		for _, item := range m {
+			name, ok := item.key.(string)
+			if !ok {
+				continue
+			}
+			f := findField(fields, name)
+			if f == nil {
+				continue
+			}
+			fv, ok := fieldByIndexAlloc(dst, f.index)
+			if !ok {
+				continue
+			}
+			if err := assign(fv, item.val); err != nil {
+				return fmt.Errorf("字段(%s): %v", name, err)
+			}
+		}
+		return nil
+	}
+
+	return mismatch(src, dst)
+}
+
+// assignKey map的键,字符串和数字可以互相转换,和json一致
+func assignKey(dst reflect.Value, src interface{}) error {
+	switch dst.Kind() {
+	case reflect.String:
+		if _, ok := src.(string); !ok {
+			dst.SetString(fmt.Sprint(toInterface(src)))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if s, ok := src.(string); ok {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			src = n
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if s, ok := src.(string); ok {
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return err
+			}
+			src = n
+		}
+	}
+	return assign(dst, src)
+}
+
+// findField 查找字段,先精确匹配,再忽略大小写,和json一致
+func findField(fields []*field, name string) *field {
+	for _, f := range fields {
+		if f.name == name {
+			return f
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// toInterface 转成常用的类型,map转成map[string]interface{}
+func toInterface(src interface{}) interface{} {
+	switch val := src.(type) {
+	case mapValue:
+		m := make(map[string]interface{}, len(val))
+		for _, item := range val {
+			key, ok := item.key.(string)
+			if !ok {
+				key = fmt.Sprint(toInterface(item.key))
+			}
+			m[key] = toInterface(item.val)
+		}
+		return m
+	case []interface{}:
+		for i := range val {
+			val[i] = toInterface(val[i])
+		}
+		return val
+	}
+	return src
+}
+
+func mismatch(src interface{}, dst reflect.Value) error {
+	return fmt.Errorf("不能把(%T)解码到(%s)", src, dst.Type())
+}
+
+func overflow(src interface{}, dst reflect.Value) error {
+	return fmt.Errorf("数值(%v)超出(%s)的范围", src, dst.Type())
+}
@@ -2,6 +2,7 @@ package dial
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"github.com/injoyai/base/maps"
 	"github.com/injoyai/io"
@@ -31,13 +32,21 @@ func TCPTimeout(addr string, timeout time.Duration) (io.ReadWriteCloser, string,
 	return r, addr, err
 }
 
+// TCPContext 连接,使用上下文中的底层连接函数,例如代理
+func TCPContext(ctx context.Context, addr string, timeout time.Duration) (io.ReadWriteCloser, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	r, err := NetDialFromContext(ctx)(ctx, io.TCP, addr)
+	return r, addr, err
+}
+
 // WithTCP 连接函数
 func WithTCP(addr string) io.DialFunc {
-	return func(ctx context.Context) (io.ReadWriteCloser, string, error) { return TCP(addr) }
+	return WithTCPTimeout(addr, io.DefaultConnectTimeout)
 }
 
 func WithTCPTimeout(addr string, timeout time.Duration) io.DialFunc {
-	return func(ctx context.Context) (io.ReadWriteCloser, string, error) { return TCPTimeout(addr, timeout) }
+	return func(ctx context.Context) (io.ReadWriteCloser, string, error) { return TCPContext(ctx, addr, timeout) }
 }
 
 // NewTCP 新建TCP连接
@@ -54,6 +63,49 @@ func RedialTCPTimeout(addr string, timeout time.Duration, options ...io.OptionCl
 	return io.Redial(WithTCPTimeout(addr, timeout), options...)
 }
 
+//================================TLSDial================================
+
+// TLS 连接,cfg为nil时使用默认配置,未设置ServerName时使用地址中的域名
+func TLS(addr string, cfg *tls.Config) (io.ReadWriteCloser, string, error) {
+	return TLSContext(context.Background(), addr, cfg)
+}
+
+// TLSContext 连接,使用上下文中的底层连接函数,例如代理
+func TLSContext(ctx context.Context, addr string, cfg *tls.Config) (io.ReadWriteCloser, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, io.DefaultConnectTimeout)
+	defer cancel()
+	conn, err := NetDialFromContext(ctx)(ctx, io.TCP, addr)
+	if err != nil {
+		return nil, addr, err
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	c := tls.Client(conn, cfg)
+	if err := c.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, addr, err
+	}
+	return c, addr, nil
+}
+
+// WithTLS 连接函数
+func WithTLS(addr string, cfg *tls.Config) io.DialFunc {
+	return func(ctx context.Context) (io.ReadWriteCloser, string, error) { return TLSContext(ctx, addr, cfg) }
+}
+
+func NewTLS(addr string, cfg *tls.Config, options ...io.OptionClient) (*io.Client, error) {
+	return io.NewDial(WithTLS(addr, cfg), options...)
+}
+
+func RedialTLS(addr string, cfg *tls.Config, options ...io.OptionClient) *io.Client {
+	return io.Redial(WithTLS(addr, cfg), options...)
+}
+
 //================================UDPDial================================
 
 // UDP 连接
@@ -83,7 +83,10 @@ func (this *MQTTClient) Read(p []byte) (int, error) {
 }
 
 func (this *MQTTClient) ReadAck() (io.Acker, error) {
-	msg := <-this.ch
+	msg, ok := <-this.ch
+	if !ok {
+		return nil, io.ErrReadClosed
+	}
 	return &Message{msg}, nil
 }
 
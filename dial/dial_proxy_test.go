@@ -0,0 +1,283 @@
+package dial_test
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/dial"
+	"github.com/injoyai/io/listen"
+	goio "io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newServer 新建本地服务,返回地址
+func newServer(t *testing.T, options ...io.OptionServer) string {
+	s, err := listen.NewTCPServer(0, func(s *io.Server) {
+		s.Debug(false)
+		s.SetOptions(options...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	go s.Run()
+	_, port, _ := net.SplitHostPort(s.Listener().Addr())
+	return "127.0.0.1:" + port
+}
+
+// newEchoServer 回复收到的数据
+func newEchoServer(t *testing.T) string {
+	return newServer(t, func(s *io.Server) {
+		s.SetDealFunc(func(c *io.Client, msg io.Message) {
+			c.Write(msg)
+		})
+	})
+}
+
+// pipe 连接目标,转发数据
+func pipe(c *io.Client, addr string) error {
+	target, err := net.Dial(io.TCP, addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		goio.Copy(c, target)
+		c.Close()
+	}()
+	go func() {
+		<-c.Done()
+		target.Close()
+	}()
+	c.SetDealFunc(func(c *io.Client, msg io.Message) {
+		target.Write(msg)
+	})
+	return nil
+}
+
+// proxyRecord 记录代理的请求地址
+type proxyRecord struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (this *proxyRecord) add(addr string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.list = append(this.list, addr)
+}
+
+func (this *proxyRecord) get() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]string(nil), this.list...)
+}
+
+// newSocks5Server SOCKS5代理,username不为空时需要认证
+func newSocks5Server(t *testing.T, username, password string) (string, *proxyRecord) {
+	record := &proxyRecord{}
+	addr := newServer(t, func(s *io.Server) {
+		s.SetConnectFunc(func(c *io.Client) error {
+			r := c.Buffer()
+			buf := make([]byte, 2)
+			if _, err := goio.ReadFull(r, buf); err != nil {
+				return err
+			}
+			if _, err := goio.ReadFull(r, make([]byte, buf[1])); err != nil {
+				return err
+			}
+			if username == "" {
+				c.Write([]byte{0x05, 0x00})
+			} else {
+				c.Write([]byte{0x05, 0x02})
+				read := func() string {
+					b, _ := r.ReadByte()
+					bs := make([]byte, b)
+					goio.ReadFull(r, bs)
+					return string(bs)
+				}
+				r.ReadByte()
+				if read() != username || read() != password {
+					c.Write([]byte{0x01, 0x01})
+					return fmt.Errorf("认证失败")
+				}
+				c.Write([]byte{0x01, 0x00})
+			}
+
+			buf = make([]byte, 4)
+			if _, err := goio.ReadFull(r, buf); err != nil {
+				return err
+			}
+			var host string
+			switch buf[3] {
+			case 0x01:
+				bs := make([]byte, 4)
+				goio.ReadFull(r, bs)
+				host = net.IP(bs).String()
+			case 0x03:
+				b, _ := r.ReadByte()
+				bs := make([]byte, b)
+				goio.ReadFull(r, bs)
+				host = string(bs)
+			}
+			port := make([]byte, 2)
+			goio.ReadFull(r, port)
+			addr := net.JoinHostPort(host, fmt.Sprint(int(port[0])<<8|int(port[1])))
+			record.add(addr)
+			if err := pipe(c, addr); err != nil {
+				c.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+				return err
+			}
+			_, err := c.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			return err
+		})
+	})
+	return addr, record
+}
+
+// newConnectServer HTTP CONNECT代理,auth不为空时需要认证
+func newConnectServer(t *testing.T, auth string) (string, *proxyRecord) {
+	record := &proxyRecord{}
+	addr := newServer(t, func(s *io.Server) {
+		s.SetConnectFunc(func(c *io.Client) error {
+			req, err := http.ReadRequest(c.Buffer())
+			if err != nil {
+				return err
+			}
+			if auth != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)) {
+				c.WriteString("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+				return fmt.Errorf("认证失败")
+			}
+			record.add(req.Host)
+			if err := pipe(c, req.Host); err != nil {
+				c.WriteString("HTTP/1.1 502 Bad Gateway\r\n\r\n")
+				return err
+			}
+			_, err = c.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+			return err
+		})
+	})
+	return addr, record
+}
+
+// testEcho 连接并验证回复的数据
+func testEcho(t *testing.T, fn io.DialFunc) {
+	t.Helper()
+	c, err := dial.New(fn, func(c *io.Client) { c.Debug(false) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseAll()
+	if _, err := c.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello" {
+		t.Fatalf("数据错误(%s)", msg)
+	}
+}
+
+func TestProxySocks5(t *testing.T) {
+	echo := newEchoServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+	proxyAddr, record := newSocks5Server(t, "user", "pass")
+
+	//代理服务器解析域名
+	testEcho(t, dial.WithProxy("socks5h://user:pass@"+proxyAddr, dial.WithTCP("localhost:"+port)))
+	//本地解析域名
+	testEcho(t, dial.WithProxy("socks5://user:pass@"+proxyAddr, dial.WithTCP("localhost:"+port)))
+	if list := record.get(); len(list) != 2 || list[0] != "localhost:"+port || net.ParseIP(strings.Split(list[1], ":")[0]) == nil {
+		t.Fatalf("请求地址错误: %v", list)
+	}
+
+	//认证失败
+	if _, err := dial.New(dial.WithProxy("socks5://user:wrong@"+proxyAddr, dial.WithTCP(echo))); err == nil {
+		t.Fatal("预期认证失败")
+	}
+}
+
+func TestProxyHTTP(t *testing.T) {
+	echo := newEchoServer(t)
+	proxyAddr, record := newConnectServer(t, "user:pass")
+
+	testEcho(t, dial.WithProxy("http://user:pass@"+proxyAddr, dial.WithTCP(echo)))
+	if list := record.get(); len(list) != 1 || list[0] != echo {
+		t.Fatalf("请求地址错误: %v", list)
+	}
+
+	//认证失败
+	_, err := dial.New(dial.WithProxy("http://user:wrong@"+proxyAddr, dial.WithTCP(echo)))
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("预期认证失败,得到(%v)", err)
+	}
+}
+
+func TestProxyTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	cfg := srv.Client().Transport.(*http.Transport).TLSClientConfig
+	proxyAddr, _ := newConnectServer(t, "")
+
+	c, err := dial.New(dial.WithProxy(proxyAddr, dial.WithTLS(srv.Listener.Addr().String(), cfg)), func(c *io.Client) {
+		c.Debug(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseAll()
+	if _, ok := c.ReadWriteCloser().(*tls.Conn); !ok {
+		t.Fatal("预期TLS连接")
+	}
+	c.WriteString("GET / HTTP/1.0\r\n\r\n")
+	resp, err := goio.ReadAll(c)
+	if err != nil && !strings.Contains(err.Error(), "EOF") {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(resp), "ok") {
+		t.Fatalf("数据错误(%s)", resp)
+	}
+}
+
+func TestProxyChain(t *testing.T) {
+	echo := newEchoServer(t)
+	httpAddr, httpRecord := newConnectServer(t, "")
+	socksAddr, socksRecord := newSocks5Server(t, "", "")
+
+	//先连接HTTP代理,再通过HTTP代理连接SOCKS5代理
+	testEcho(t, dial.WithProxy("http://"+httpAddr, dial.WithProxy("socks5://"+socksAddr, dial.WithTCP(echo))))
+	if list := httpRecord.get(); len(list) != 1 || list[0] != socksAddr {
+		t.Fatalf("HTTP代理请求地址错误: %v", list)
+	}
+	if list := socksRecord.get(); len(list) != 1 || list[0] != echo {
+		t.Fatalf("SOCKS5代理请求地址错误: %v", list)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	echo := newEchoServer(t)
+	proxyAddr, record := newSocks5Server(t, "", "")
+	t.Setenv("ALL_PROXY", "socks5h://"+proxyAddr)
+	t.Setenv("NO_PROXY", "example.com,10.0.0.0/8")
+
+	testEcho(t, dial.ProxyFromEnvironment(dial.WithTCP(echo)))
+	if len(record.get()) != 1 {
+		t.Fatalf("预期通过代理连接: %v", record.get())
+	}
+
+	//直连
+	t.Setenv("NO_PROXY", ".example.com, 127.0.0.0/8")
+	testEcho(t, dial.ProxyFromEnvironment(dial.WithTCP(echo)))
+	if len(record.get()) != 1 {
+		t.Fatalf("预期直连: %v", record.get())
+	}
+}
@@ -0,0 +1,180 @@
+package dial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/injoyai/io"
+	"sync"
+	"time"
+)
+
+//================================Replay================================
+
+type ReplayConfig struct {
+	Filename string  //记录文件,由io.Recorder生成
+	Key      string  //回放的客户端标识,为空则使用第一条记录的标识
+	Speed    float64 //回放速度,1为原始速度,2为2倍速,0或负数表示不等待
+	Verify   bool    //校验写入的数据是否和记录一致,并在记录的写入完成后才回放后续的接收数据
+}
+
+// Replay 回放记录文件,按原始时间回放接收的数据
+func Replay(filename string) (io.ReadWriteCloser, string, error) {
+	return ReplayWithConfig(&ReplayConfig{Filename: filename, Speed: 1})
+}
+
+// ReplayWithConfig 按配置回放记录文件
+func ReplayWithConfig(cfg *ReplayConfig) (io.ReadWriteCloser, string, error) {
+	records, err := io.ReadRecordsWithFile(cfg.Filename)
+	if err != nil {
+		return nil, cfg.Filename, err
+	}
+	key := cfg.Key
+	if len(key) == 0 && len(records) > 0 {
+		key = records[0].Key
+	}
+	r := &ReplayClient{cfg: cfg, wait: make(chan struct{}, 1)}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	var written int
+	for _, v := range records {
+		if v.Key != key {
+			continue
+		}
+		switch v.Type {
+		case io.RecordRead:
+			r.reads = append(r.reads, replayRead{Record: v, data: v.Bytes(), written: written})
+		case io.RecordWrite:
+			bs := v.Bytes()
+			r.expect = append(r.expect, bs...)
+			written += len(bs)
+		}
+	}
+	if len(r.reads) > 0 {
+		r.first = r.reads[0].Time
+	}
+	return r, key, nil
+}
+
+// WithReplay 回放记录文件函数
+func WithReplay(filename string) io.DialFunc {
+	return func(ctx context.Context) (io.ReadWriteCloser, string, error) { return Replay(filename) }
+}
+
+// WithReplayConfig 按配置回放记录文件函数
+func WithReplayConfig(cfg *ReplayConfig) io.DialFunc {
+	return func(ctx context.Context) (io.ReadWriteCloser, string, error) { return ReplayWithConfig(cfg) }
+}
+
+// NewReplay 新建回放客户端
+func NewReplay(filename string, options ...io.OptionClient) (*io.Client, error) {
+	return io.NewDial(WithReplay(filename), options...)
+}
+
+type replayRead struct {
+	*io.Record
+	data    []byte
+	written int //回放前需要写入的字节数
+}
+
+// ReplayClient 回放客户端,接收的数据来自记录文件,数据回放完成后返回io.EOF
+type ReplayClient struct {
+	cfg    *ReplayConfig
+	reads  []replayRead
+	expect []byte    //记录的写入数据
+	first  time.Time //第一条接收记录的时间
+	start  time.Time //开始回放的时间
+
+	mu      sync.Mutex
+	index   int           //下一条接收记录
+	cache   []byte        //未读取完的数据
+	written int           //已写入的字节数
+	wait    chan struct{} //写入信号
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (this *ReplayClient) Read(p []byte) (int, error) {
+	this.mu.Lock()
+	if len(this.cache) == 0 {
+		if this.index >= len(this.reads) {
+			this.mu.Unlock()
+			return 0, io.EOF
+		}
+		if this.start.IsZero() {
+			this.start = time.Now()
+		}
+		r := this.reads[this.index]
+		this.mu.Unlock()
+		if err := this.waitRead(r); err != nil {
+			return 0, err
+		}
+		this.mu.Lock()
+		this.index++
+		this.cache = r.data
+	}
+	n := copy(p, this.cache)
+	this.cache = this.cache[n:]
+	this.mu.Unlock()
+	return n, nil
+}
+
+// waitRead 等待到记录的时间,校验模式下还需要等待之前的数据写入完成
+func (this *ReplayClient) waitRead(r replayRead) error {
+	if this.cfg.Verify {
+		for {
+			this.mu.Lock()
+			written := this.written
+			this.mu.Unlock()
+			if written >= r.written {
+				break
+			}
+			select {
+			case <-this.ctx.Done():
+				return io.EOF
+			case <-this.wait:
+			}
+		}
+	}
+	if this.cfg.Speed > 0 {
+		at := this.start.Add(time.Duration(float64(r.Time.Sub(this.first)) / this.cfg.Speed))
+		if d := time.Until(at); d > 0 {
+			select {
+			case <-this.ctx.Done():
+				return io.EOF
+			case <-time.After(d):
+			}
+		}
+	}
+	return nil
+}
+
+func (this *ReplayClient) Write(p []byte) (int, error) {
+	select {
+	case <-this.ctx.Done():
+		return 0, errors.New("回放已关闭")
+	default:
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.cfg.Verify {
+		end := this.written + len(p)
+		if end > len(this.expect) {
+			return 0, fmt.Errorf("写入数据超过记录,得到(%x)", p)
+		}
+		if expect := this.expect[this.written:end]; !bytes.Equal(expect, p) {
+			return 0, fmt.Errorf("写入数据不一致,预期(%x),得到(%x)", expect, p)
+		}
+	}
+	this.written += len(p)
+	select {
+	case this.wait <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func (this *ReplayClient) Close() error {
+	this.cancel()
+	return nil
+}
@@ -0,0 +1,87 @@
+package dial
+
+import (
+	"github.com/injoyai/io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "record.jsonl")
+	r, err := io.NewRecorderWithFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, v := range []struct {
+		Type string
+		Data string
+	}{
+		{io.RecordWrite, "ping"},
+		{io.RecordRead, "pong"},
+		{io.RecordWrite, "close"},
+		{io.RecordRead, "bye"},
+	} {
+		r.Record(&io.Record{
+			Time: now.Add(time.Millisecond * 20 * time.Duration(i)),
+			Type: v.Type,
+			Key:  "device",
+			Data: io.Message(v.Data).HEX(),
+		})
+	}
+	r.Close()
+
+	received := make(chan string, 10)
+	start := time.Now()
+	c, err := io.NewDial(WithReplayConfig(&ReplayConfig{
+		Filename: filename,
+		Speed:    2,
+		Verify:   true,
+	}), func(c *io.Client) {
+		c.Debug(false)
+		c.SetDealFunc(func(c *io.Client, msg io.Message) {
+			received <- msg.String()
+			if msg.String() == "pong" {
+				c.WriteString("close")
+			}
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetKey() != "device" {
+		t.Errorf("预期(device),得到(%s)", c.GetKey())
+	}
+	if _, err := c.WriteString("ping"); err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	for _, expect := range []string{"pong", "bye"} {
+		select {
+		case msg := <-received:
+			if msg != expect {
+				t.Fatalf("预期(%s),得到(%s)", expect, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("未收到数据(%s)", expect)
+		}
+	}
+	//接收记录间隔40毫秒,2倍速回放需要20毫秒
+	if d := time.Since(start); d < time.Millisecond*20 {
+		t.Errorf("回放时间过短(%v)", d)
+	}
+	<-c.Done()
+
+	//写入的数据和记录不一致
+	c, err = io.NewDial(WithReplayConfig(&ReplayConfig{Filename: filename, Verify: true}), func(c *io.Client) {
+		c.Debug(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseAll()
+	if _, err := c.WriteString("pong"); err == nil {
+		t.Error("预期写入数据不一致错误")
+	}
+}
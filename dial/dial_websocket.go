@@ -35,8 +35,23 @@ func Websocket(url string, header http.Header) (io.ReadWriteCloser, string, erro
 	return &WebsocketClient{Conn: c}, url, err
 }
 
+// WebsocketContext 连接,使用上下文中的底层连接函数,例如代理
+func WebsocketContext(ctx context.Context, url string, header http.Header) (io.ReadWriteCloser, string, error) {
+	dialer := websocket.DefaultDialer
+	if fn, ok := ctx.Value(netDialKey{}).(NetDialFunc); ok && fn != nil {
+		d := *websocket.DefaultDialer
+		d.Proxy = nil
+		d.NetDialContext = fn
+		dialer = &d
+	}
+	c, _, err := dialer.DialContext(ctx, url, header)
+	return &WebsocketClient{Conn: c}, url, err
+}
+
 func WithWebsocket(url string, header http.Header) io.DialFunc {
-	return func(ctx context.Context) (io.ReadWriteCloser, string, error) { return Websocket(url, header) }
+	return func(ctx context.Context) (io.ReadWriteCloser, string, error) {
+		return WebsocketContext(ctx, url, header)
+	}
 }
 
 func NewWebsocket(url string, header http.Header, options ...io.OptionClient) (*io.Client, error) {
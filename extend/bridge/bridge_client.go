@@ -1,67 +1,273 @@
 package bridge
 
 import (
+	"context"
 	"errors"
-	"github.com/injoyai/base/maps/wait"
 	"github.com/injoyai/io"
 	"github.com/injoyai/io/dial"
-	"log"
+	"sort"
+	"sync"
 	"time"
 )
 
+// RedialClient 订阅者,一直连接直到成功,断开后自动重连,重新打开监听服务和订阅
+func RedialClient(address string, options ...func(c *Client)) *Client {
+	cli := &Client{
+		pending: make(map[uint32]chan *Packet),
+		subs:    make(map[string]struct{}),
+		listens: make(map[string]*Packet),
+	}
+	once := sync.Once{}
+	var option io.OptionClient
+	option = func(c *io.Client) {
+		once.Do(func() {
+			cli.Client = c
+			go cli.keepAlive()
+		})
+		c.SetReadWriteWithPkg()
+		c.SetDealFunc(cli.deal)
+		for _, v := range options {
+			v(cli)
+		}
+		//重连时会重置客户端的字段,重连期间持有写入锁,不能同时写入
+		c.SetCloseFunc(func(ctx context.Context, c *io.Client, err error) {
+			<-time.After(time.Second)
+			cli.wmu.Lock()
+			defer cli.wmu.Unlock()
+			if err := c.MustDial(ctx, func(c *io.Client) { c.Redial(option) }); err != nil {
+				c.Errorf("[%s] 重连错误,%v\n", c.GetKey(), err)
+			}
+		})
+		go cli.recover()
+	}
+	dial.RedialTCP(address, option)
+	return cli
+}
+
+// Client 订阅者
 type Client struct {
 	*io.Client
-	wait *wait.Entity
+	wmu       sync.Mutex //写入锁
+	mu        sync.Mutex
+	id        uint32
+	pending   map[uint32]chan *Packet //等待响应的请求
+	subs      map[string]struct{}     //订阅的监听服务,重连后重新订阅
+	listens   map[string]*Packet      //打开的监听服务,重连后使用相同的端口重新打开
+	onReceive func(key, address string, data []byte)
+	onConnect func(key, address string)
+	onClose   func(key, address string, err error)
 }
 
-func (this *Client) Subscribe(Type string, port string) error {
-	_, err := this.Write(io.NewSimple(io.SimpleControl{Type: io.OprSubscribe}, io.SimpleData{
-		FliedListenType: []byte(Type),
-		FliedListenPort: []byte(port),
-	}, 3).Bytes())
-	if err != nil {
+// SetReceiveFunc 设置接收数据的函数,远程地址上来的数据
+func (this *Client) SetReceiveFunc(fn func(key, address string, data []byte)) *Client {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.onReceive = fn
+	return this
+}
+
+// SetRemoteConnectFunc 设置远程地址连接的函数,只有TCP
+func (this *Client) SetRemoteConnectFunc(fn func(key, address string)) *Client {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.onConnect = fn
+	return this
+}
+
+// SetRemoteCloseFunc 设置远程地址断开的函数,只有TCP
+func (this *Client) SetRemoteCloseFunc(fn func(key, address string, err error)) *Client {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.onClose = fn
+	return this
+}
+
+// Subscribe 订阅监听服务,例 tcp.10086
+func (this *Client) Subscribe(key string) error {
+	if _, err := this.request(&Packet{Type: TypeSubscribe, Key: key}); err != nil {
 		return err
 	}
-	_, err = this.wait.Wait("3")
+	this.mu.Lock()
+	this.subs[key] = struct{}{}
+	this.mu.Unlock()
+	return nil
+}
+
+// Unsubscribe 取消订阅
+func (this *Client) Unsubscribe(key string) error {
+	this.mu.Lock()
+	delete(this.subs, key)
+	this.mu.Unlock()
+	_, err := this.request(&Packet{Type: TypeUnsubscribe, Key: key})
 	return err
 }
 
-func RedialClient(address string, option ...func(c *Client)) *Client {
-	cli := &Client{
-		wait: wait.New(time.Second),
-	}
-	dial.RedialTCP(address, func(c *io.Client) {
-		cli.Client = c
-		c.SetPrintWithUTF8()
-		c.SetReadWriteWithSimple()
-		c.SetKeepAlive(io.DefaultKeepAlive, io.NewSimplePing().Bytes())
-		c.SetDealFunc(func(c *io.Client, msg io.Message) {
-			p, err := io.DecodeSimple(msg)
-			if err != nil {
-				c.Errorf("decode bridge error: %v", err)
-				return
-			}
+// Subscriptions 订阅的监听服务
+func (this *Client) Subscriptions() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	list := make([]string, 0, len(this.subs))
+	for k := range this.subs {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
 
-			switch p.Control.Type {
-			case io.OprSubscribe:
+// Listen 在服务端打开监听服务,并订阅,端口为0时使用随机端口,返回监听服务的标识
+// 断开后服务端会关闭,重连后使用相同的端口重新打开
+func (this *Client) Listen(network string, port int) (string, error) {
+	resp, err := this.request(&Packet{Type: TypeListen, Network: network, Port: port})
+	if err != nil {
+		return "", err
+	}
+	_, p, err := ParseListenKey(resp.Key)
+	if err != nil {
+		return "", err
+	}
+	this.mu.Lock()
+	this.listens[resp.Key] = &Packet{Type: TypeListen, Network: network, Port: p}
+	this.subs[resp.Key] = struct{}{}
+	this.mu.Unlock()
+	return resp.Key, nil
+}
 
-				if p.Control.IsResponse {
-					if p.Control.IsErr {
-						cli.wait.Done("3", nil, errors.New(string(p.Data[io.FliedError])))
-						return
-					}
-					cli.wait.Done("3", nil)
-				}
+// CloseListen 关闭自己打开的监听服务
+func (this *Client) CloseListen(key string) error {
+	this.mu.Lock()
+	delete(this.listens, key)
+	delete(this.subs, key)
+	this.mu.Unlock()
+	_, err := this.request(&Packet{Type: TypeCloseListen, Key: key})
+	return err
+}
 
-			case io.OprWrite:
+// Send 通过监听服务向远程地址写入数据,等待服务端写入的结果
+func (this *Client) Send(key, address string, data []byte) error {
+	_, err := this.request(&Packet{Type: TypeWrite, Key: key, Address: address, Data: data})
+	return err
+}
 
-				log.Printf("[接收] [%s] %s", string(p.Data[io.FliedAddress]), string(p.Data[io.FliedData]))
+// request 发送请求并等待响应
+func (this *Client) request(p *Packet) (*Packet, error) {
+	ch := make(chan *Packet, 1)
+	this.mu.Lock()
+	this.id++
+	p.ID = this.id
+	this.pending[p.ID] = ch
+	this.mu.Unlock()
+	defer func() {
+		this.mu.Lock()
+		delete(this.pending, p.ID)
+		this.mu.Unlock()
+	}()
 
+	done := this.Client.Done()
+	if err := this.write(p); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(io.DefaultResponseTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if len(resp.Err) > 0 {
+			return resp, errors.New(resp.Err)
+		}
+		return resp, nil
+	case <-done:
+		return nil, io.ErrReadClosed
+	case <-timer.C:
+		return nil, io.ErrWithTimeout
+	}
+}
+
+// write 写入数据,连接断开后直接返回错误,等待重连时不写入
+func (this *Client) write(p *Packet) error {
+	if err := this.Client.Err(); err != nil {
+		return err
+	}
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	if err := this.Client.Err(); err != nil {
+		return err
+	}
+	_, err := this.Client.Write(p.Bytes())
+	return err
+}
+
+// deal 处理服务端的数据
+func (this *Client) deal(c *io.Client, msg io.Message) {
+	p, err := decodePacket(msg)
+	if err != nil {
+		c.Errorf("[%s] 解析数据失败: %v\n", c.GetKey(), err)
+		return
+	}
+	this.mu.Lock()
+	ch := this.pending[p.ID]
+	onReceive, onConnect, onClose := this.onReceive, this.onConnect, this.onClose
+	this.mu.Unlock()
+
+	switch p.Type {
+	case TypeResponse:
+		if ch != nil {
+			ch <- p
+		}
+	case TypeReceive:
+		if onReceive != nil {
+			onReceive(p.Key, p.Address, p.Data)
+		}
+	case TypeConnect:
+		if onConnect != nil {
+			onConnect(p.Key, p.Address)
+		}
+	case TypeClose:
+		if onClose != nil {
+			var err error
+			if len(p.Err) > 0 {
+				err = errors.New(p.Err)
 			}
-		})
-		for _, v := range option {
-			v(cli)
+			onClose(p.Key, p.Address, err)
 		}
-	})
-	return cli
+	}
+}
+
+// recover 重连后重新打开监听服务和订阅
+func (this *Client) recover() {
+	this.mu.Lock()
+	listens := make([]*Packet, 0, len(this.listens))
+	for _, v := range this.listens {
+		listens = append(listens, &Packet{Type: v.Type, Network: v.Network, Port: v.Port})
+	}
+	subs := make([]string, 0, len(this.subs))
+	for k := range this.subs {
+		if _, ok := this.listens[k]; !ok {
+			subs = append(subs, k)
+		}
+	}
+	this.mu.Unlock()
+
+	for _, p := range listens {
+		if _, err := this.request(p); err != nil {
+			this.Errorf("[%s] 重新打开监听服务(%s.%d)失败: %v\n", this.GetKey(), p.Network, p.Port, err)
+		}
+	}
+	for _, key := range subs {
+		if _, err := this.request(&Packet{Type: TypeSubscribe, Key: key}); err != nil {
+			this.Errorf("[%s] 重新订阅(%s)失败: %v\n", this.GetKey(), key, err)
+		}
+	}
+}
+
+// keepAlive 定时发送心跳,直到关闭
+func (this *Client) keepAlive() {
+	t := time.NewTicker(io.DefaultKeepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case <-this.Client.DoneAll():
+			return
+		case <-t.C:
+			this.write(&Packet{Type: TypePing})
+		}
+	}
 }
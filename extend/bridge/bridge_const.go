@@ -1,7 +1,89 @@
 package bridge
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+
+桥接,服务端打开监听服务(TCP或UDP),订阅者连接服务端后订阅监听服务
+监听服务的客户端(远程地址)上来的数据转发给订阅者,订阅者可以通过服务端向指定的远程地址写入数据
+
+远程地址 --- 监听服务(tcp.10086) --- Server ===订阅=== Client(订阅者)
+
+订阅者和服务端之间使用通用封装包(io.Pkg),内容是json(Packet),简易封装包的数据不能超过255字节
+请求带上编号(ID),服务端使用相同的编号响应,错误信息在Err中
+监听服务的标识是类型.端口,例 tcp.10086,udp.10087
+订阅者可以动态打开监听服务(Listen),并自动订阅,订阅者断开后关闭
+UDP没有连接,每个数据包单独转发,写入时根据地址发送
+
+*/
+
 const (
-	FliedListenKey  = "listenKey"
-	FliedListenType = "listenType"
-	FliedListenPort = "listenPort"
+	TypeSubscribe   = "subscribe"   //订阅监听服务
+	TypeUnsubscribe = "unsubscribe" //取消订阅
+	TypeListen      = "listen"      //打开监听服务
+	TypeCloseListen = "closeListen" //关闭监听服务
+	TypeWrite       = "write"       //向远程地址写入数据
+	TypeResponse    = "response"    //响应
+	TypePing        = "ping"        //心跳
+	TypeReceive     = "receive"     //服务端推送,远程地址上来的数据
+	TypeConnect     = "connect"     //服务端推送,远程地址连接,只有TCP
+	TypeClose       = "close"       //服务端推送,远程地址断开,只有TCP
+
+	NetworkTCP = "tcp"
+	NetworkUDP = "udp"
 )
+
+// Packet 订阅者和服务端之间的数据
+type Packet struct {
+	Type    string `json:"type"`              //类型
+	ID      uint32 `json:"id,omitempty"`      //请求编号,响应使用相同的编号
+	Key     string `json:"key,omitempty"`     //监听服务的标识,例 tcp.10086
+	Network string `json:"network,omitempty"` //打开监听服务的类型,tcp或udp
+	Port    int    `json:"port,omitempty"`    //打开监听服务的端口,0表示随机端口
+	Address string `json:"address,omitempty"` //远程地址
+	Data    []byte `json:"data,omitempty"`    //数据
+	Err     string `json:"err,omitempty"`     //响应的错误,为空表示成功
+}
+
+func (this *Packet) Bytes() []byte {
+	bs, _ := json.Marshal(this)
+	return bs
+}
+
+// resp 生成响应
+func (this *Packet) resp(err error) *Packet {
+	p := &Packet{Type: TypeResponse, ID: this.ID, Key: this.Key}
+	if err != nil {
+		p.Err = err.Error()
+	}
+	return p
+}
+
+func decodePacket(bs []byte) (*Packet, error) {
+	p := new(Packet)
+	err := json.Unmarshal(bs, p)
+	return p, err
+}
+
+// ListenKey 监听服务的标识
+func ListenKey(network string, port int) string {
+	return fmt.Sprintf("%s.%d", network, port)
+}
+
+// ParseListenKey 解析监听服务的标识,返回类型和端口
+func ParseListenKey(key string) (string, int, error) {
+	network, port, ok := strings.Cut(key, ".")
+	if !ok {
+		return "", 0, fmt.Errorf("无效的监听服务(%s)", key)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("无效的监听服务(%s)", key)
+	}
+	return network, n, nil
+}
@@ -1,150 +1,367 @@
 package bridge
 
 import (
+	"errors"
 	"fmt"
-	"github.com/injoyai/base/maps"
-	"github.com/injoyai/conv"
 	"github.com/injoyai/io"
 	"github.com/injoyai/io/listen"
-	"github.com/injoyai/logs"
 	"net"
+	"sort"
+	"strconv"
+	"sync"
 )
 
-type Server struct {
-	Listener     *maps.Safe //监听服务
-	bridge       *io.Server //用于请求转发数据
-	bridgeClient *maps.Safe //订阅客户端管理
-}
-
-func (this *Server) Listen(Type, port string, options ...io.OptionServer) (*io.Server, error) {
-	listenKey := Type + "." + port
-	var listener io.ListenFunc
-	switch Type {
-	case io.UDP:
-		listener = listen.WithUDP(conv.Int(port))
-	default:
-		listener = listen.WithTCP(conv.Int(port))
-	}
-	s, err := io.NewServer(listener, func(s *io.Server) {
-		s.SetKey(fmt.Sprintf("%s.%s", Type, port))
-		s.SetOptions(options...)
-		s.SetBeforeFunc(func(c *io.Client) error {
-			conn := c.ReadWriteCloser().(net.Conn)
-			addr := conn.RemoteAddr().String()
-			c.Tag().Set(io.FliedAddress, addr)
-			return nil
+// NewServer 桥接服务,监听订阅者的连接
+func NewServer(port int, options ...func(s *Server)) (*Server, error) {
+	ser := &Server{
+		listens:     make(map[string]*listener),
+		subscribers: make(map[*io.Client]*subscriber),
+	}
+	_, err := listen.NewTCPServer(port, func(s *io.Server) {
+		ser.Server = s
+		s.ClientManage.SetOptions(func(c *io.Client) {
+			c.SetReadWriteWithPkg()
 		})
-		s.SetCloseFunc(func(c *io.Client, msg io.Message) {
-			this.bridgeClient.Del(c.GetKey())
+		s.SetConnectFunc(func(c *io.Client) error {
+			ser.mu.Lock()
+			defer ser.mu.Unlock()
+			ser.subscribers[c] = &subscriber{c: c, keys: make(map[string]struct{})}
+			return nil
 		})
-		msgID := uint8(0)
-		s.SetDealFunc(func(c *io.Client, msg io.Message) {
-			//处理客户端上来的数据
-			for _, bs := range io.SplitWithLength(msg, 65500) {
-				msgID++
-				val, _ := this.bridgeClient.GetOrSetByHandler(listenKey, func() (interface{}, error) {
-					return []*io.Client(nil), nil
-				})
-				for _, v := range val.([]*io.Client) {
-					//向订阅者发送客户端上来的数据
-					v.Write(io.NewSimple(io.SimpleControl{Type: io.OprWrite}, io.SimpleData{
-						io.FliedKey:     []byte(listenKey),
-						io.FliedAddress: c.Tag().GetBytes(io.FliedAddress),
-						io.FliedData:    bs,
-					}, msgID).Bytes())
-				}
-			}
+		s.SetDealFunc(ser.deal)
+		s.SetCloseFunc(func(c *io.Client, err error) {
+			ser.removeSubscriber(c)
 		})
-		go s.Run()
+		for _, v := range options {
+			v(ser)
+		}
 	})
-	if err == nil {
-		this.Listener.Set(listenKey, s)
+	return ser, err
+}
+
+// Server 桥接服务
+type Server struct {
+	*io.Server
+	mu            sync.RWMutex
+	listens       map[string]*listener       //监听服务,标识:监听服务
+	subscribers   map[*io.Client]*subscriber //订阅者
+	listenOptions []io.OptionServer          //TCP监听服务的选项
+	allowListen   func(c *io.Client, network string, port int) error
+}
+
+// SetListenOptions 设置TCP监听服务的选项,例如设置读取函数(分包)
+func (this *Server) SetListenOptions(options ...io.OptionServer) *Server {
+	this.listenOptions = options
+	return this
+}
+
+// SetAllowListen 设置订阅者能否打开监听服务,返回错误则拒绝,默认都允许
+func (this *Server) SetAllowListen(fn func(c *io.Client, network string, port int) error) *Server {
+	this.allowListen = fn
+	return this
+}
+
+// Listen 打开监听服务,端口为0时使用随机端口,返回监听服务的标识
+func (this *Server) Listen(network string, port int) (string, error) {
+	l, err := this.listen(network, port, nil)
+	if err != nil {
+		return "", err
 	}
-	return s, err
+	return l.key, nil
 }
 
+// CloseListen 关闭监听服务,订阅者的订阅也会取消
 func (this *Server) CloseListen(key string) error {
-	l := this.Listener.GetAndDel(key)
-	if l != nil {
-		return l.(*io.Server).Close()
+	this.mu.Lock()
+	l, ok := this.listens[key]
+	delete(this.listens, key)
+	for _, s := range this.subscribers {
+		delete(s.keys, key)
+	}
+	this.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("监听服务(%s)不存在", key)
+	}
+	return l.close()
+}
+
+// Listens 打开的监听服务的标识
+func (this *Server) Listens() []string {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	list := make([]string, 0, len(this.listens))
+	for k := range this.listens {
+		list = append(list, k)
 	}
-	return nil
+	sort.Strings(list)
+	return list
 }
 
-func (this *Server) Run() error {
-	return this.bridge.Run()
+// Send 向监听服务的远程地址写入数据
+func (this *Server) Send(key, address string, data []byte) error {
+	this.mu.RLock()
+	l, ok := this.listens[key]
+	this.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("监听服务(%s)不存在", key)
+	}
+	return l.write(address, data)
+}
+
+// Close 关闭服务和全部监听服务
+func (this *Server) Close() error {
+	this.mu.Lock()
+	listens := this.listens
+	this.listens = make(map[string]*listener)
+	this.mu.Unlock()
+	for _, l := range listens {
+		l.close()
+	}
+	return this.Server.Close()
 }
 
-func NewServer(port int, options ...io.OptionServer) (*Server, error) {
-	bridge, err := listen.NewTCPServer(port, options...)
+func (this *Server) listen(network string, port int, owner *subscriber) (*listener, error) {
+	l := &listener{s: this, network: network, owner: owner}
+	switch network {
+	case NetworkUDP:
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			return nil, err
+		}
+		l.udp = conn
+		l.key = ListenKey(network, conn.LocalAddr().(*net.UDPAddr).Port)
+	case NetworkTCP:
+		s, err := listen.NewTCPServer(port, this.listenOptions...)
+		if err != nil {
+			return nil, err
+		}
+		l.tcp = s
+		l.clients = make(map[string]*io.Client)
+		_, p, _ := net.SplitHostPort(s.Listener().Addr())
+		n, _ := strconv.Atoi(p)
+		l.key = ListenKey(network, n)
+	default:
+		return nil, fmt.Errorf("不支持的监听类型(%s)", network)
+	}
+	this.mu.Lock()
+	if _, ok := this.listens[l.key]; ok {
+		this.mu.Unlock()
+		l.close()
+		return nil, fmt.Errorf("监听服务(%s)已存在", l.key)
+	}
+	this.listens[l.key] = l
+	if owner != nil {
+		owner.keys[l.key] = struct{}{}
+	}
+	this.mu.Unlock()
+	l.run()
+	return l, nil
+}
+
+// publish 推送给订阅了监听服务的订阅者
+func (this *Server) publish(p *Packet) {
+	bs := p.Bytes()
+	this.mu.RLock()
+	list := []*subscriber(nil)
+	for _, s := range this.subscribers {
+		if _, ok := s.keys[p.Key]; ok {
+			list = append(list, s)
+		}
+	}
+	this.mu.RUnlock()
+	for _, s := range list {
+		if err := s.write(bs); err != nil {
+			s.c.Errorf("[%s] 推送(%s)失败: %v\n", s.c.GetKey(), p.Key, err)
+		}
+	}
+}
+
+// removeSubscriber 订阅者断开,关闭订阅者打开的监听服务
+func (this *Server) removeSubscriber(c *io.Client) {
+	this.mu.Lock()
+	s := this.subscribers[c]
+	delete(this.subscribers, c)
+	list := []*listener(nil)
+	for k, l := range this.listens {
+		if s != nil && l.owner == s {
+			delete(this.listens, k)
+			list = append(list, l)
+		}
+	}
+	this.mu.Unlock()
+	for _, l := range list {
+		l.close()
+	}
+}
+
+func (this *Server) getSubscriber(c *io.Client) *subscriber {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.subscribers[c]
+}
+
+// deal 处理订阅者的请求
+func (this *Server) deal(c *io.Client, msg io.Message) {
+	s := this.getSubscriber(c)
+	if s == nil {
+		return
+	}
+	p, err := decodePacket(msg)
 	if err != nil {
-		return nil, err
+		c.Errorf("[%s] 解析数据失败: %v\n", c.GetKey(), err)
+		return
 	}
-	bridge.SetPrintWithHEX()
-	ser := &Server{
-		Listener:     maps.NewSafe(),
-		bridge:       bridge,
-		bridgeClient: maps.NewSafe(),
-	}
-	ser.bridge.SetReadWriteWithSimple()
-	ser.bridge.SetCloseFunc(func(c *io.Client, msg io.Message) {
-		key := c.Tag().GetString("listenKey")
-		val, ok := ser.bridgeClient.Get(key)
-		if ok {
-			for i, v := range val.([]*io.Client) {
-				if v == c {
-					ser.bridgeClient.Set(key, append(val.([]*io.Client)[:i], val.([]*io.Client)[i+1:]...))
-					break
-				}
-			}
+
+	var resp *Packet
+	switch p.Type {
+	case TypePing:
+		return
+
+	case TypeSubscribe:
+		this.mu.Lock()
+		if _, ok := this.listens[p.Key]; ok {
+			s.keys[p.Key] = struct{}{}
+		} else {
+			err = fmt.Errorf("监听服务(%s)不存在", p.Key)
+		}
+		this.mu.Unlock()
+		resp = p.resp(err)
+
+	case TypeUnsubscribe:
+		this.mu.Lock()
+		delete(s.keys, p.Key)
+		this.mu.Unlock()
+		resp = p.resp(nil)
+
+	case TypeListen:
+		if this.allowListen != nil {
+			err = this.allowListen(c, p.Network, p.Port)
+		}
+		var l *listener
+		if err == nil {
+			l, err = this.listen(p.Network, p.Port, s)
 		}
+		resp = p.resp(err)
+		if err == nil {
+			resp.Key = l.key
+		}
+
+	case TypeCloseListen:
+		this.mu.RLock()
+		l, ok := this.listens[p.Key]
+		this.mu.RUnlock()
+		switch {
+		case !ok:
+			err = fmt.Errorf("监听服务(%s)不存在", p.Key)
+		case l.owner != s:
+			err = errors.New("只能关闭自己打开的监听服务")
+		default:
+			err = this.CloseListen(p.Key)
+		}
+		resp = p.resp(err)
+
+	case TypeWrite:
+		resp = p.resp(this.Send(p.Key, p.Address, p.Data))
+
+	default:
+		resp = p.resp(fmt.Errorf("未知类型(%s)", p.Type))
+	}
+
+	if err := s.write(resp.Bytes()); err != nil {
+		c.Errorf("[%s] 响应失败: %v\n", c.GetKey(), err)
+	}
+}
+
+// subscriber 订阅者,客户端的写入不能并发
+type subscriber struct {
+	c    *io.Client
+	mu   sync.Mutex
+	keys map[string]struct{} //订阅的监听服务,使用Server.mu
+}
+
+func (this *subscriber) write(p []byte) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	_, err := this.c.Write(p)
+	return err
+}
+
+// listener 监听服务
+type listener struct {
+	s       *Server
+	key     string
+	network string
+	owner   *subscriber //打开监听服务的订阅者,服务端打开的为nil
+	tcp     *io.Server
+	udp     *net.UDPConn
+	mu      sync.Mutex            //TCP客户端的写入锁
+	clients map[string]*io.Client //TCP客户端,连接事件之前加入,GetClient在连接事件之后才能获取
+}
+
+func (this *listener) run() {
+	if this.udp != nil {
+		go this.runUDP()
+		return
+	}
+	this.tcp.SetConnectFunc(func(c *io.Client) error {
+		this.mu.Lock()
+		this.clients[c.GetKey()] = c
+		this.mu.Unlock()
+		this.s.publish(&Packet{Type: TypeConnect, Key: this.key, Address: c.GetKey()})
+		return nil
+	})
+	this.tcp.SetDealFunc(func(c *io.Client, msg io.Message) {
+		this.s.publish(&Packet{Type: TypeReceive, Key: this.key, Address: c.GetKey(), Data: msg})
+	})
+	this.tcp.SetCloseFunc(func(c *io.Client, err error) {
+		this.mu.Lock()
+		if this.clients[c.GetKey()] == c {
+			delete(this.clients, c.GetKey())
+		}
+		this.mu.Unlock()
+		p := &Packet{Type: TypeClose, Key: this.key, Address: c.GetKey()}
+		if err != nil {
+			p.Err = err.Error()
+		}
+		this.s.publish(p)
 	})
-	ser.bridge.SetDealFunc(func(c2 *io.Client, msg io.Message) {
-		p, err := io.DecodeSimple(msg)
+	go this.tcp.Run()
+}
+
+func (this *listener) runUDP() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := this.udp.ReadFromUDP(buf)
 		if err != nil {
-			ser.bridge.Errorf("decode bridge error:%v", err)
 			return
 		}
+		this.s.publish(&Packet{Type: TypeReceive, Key: this.key, Address: addr.String(), Data: buf[:n]})
+	}
+}
 
-		listenType := string(p.Data[FliedListenType]) //监听服务的类型
-		listenPort := string(p.Data[FliedListenPort]) //监听服务的端口
-		listenKey := listenType + "." + listenPort
-		address := string(p.Data[io.FliedAddress]) //客户端的地址
-		data := p.Data[io.FliedMsg]                //消息内容
-
-		//判断订阅客户端的消息类型
-		switch p.Control.Type {
-		case io.OprSubscribe:
-			c2.Infof("[%s] 订阅[%s]\n", c2.GetKey(), listenKey)
-			c2.Tag().Set(FliedListenType, listenType)
-			c2.Tag().Set(FliedListenPort, listenPort)
-			c2.Tag().Set(FliedListenKey, listenKey)
-			v, _ := ser.bridgeClient.GetOrSetByHandler(listenKey, func() (interface{}, error) {
-				return []*io.Client{}, nil
-			})
-			ser.bridgeClient.Set(listenKey, append(v.([]*io.Client), c2))
-			_, err := c2.Write(p.Resp(io.SimpleData{io.FliedCode: conv.Bytes(uint16(200))}).Bytes())
-			if err != nil {
-				ser.bridge.Errorf("订阅[%s]失败: %v", listenKey, err)
-			}
-
-		case io.OprWrite:
-			l := ser.Listener.MustGet(listenKey)
-			if l == nil {
-				_, err := c2.Write(p.Resp(nil, fmt.Errorf("监听服务[%s.%s]未开启", listenType, listenPort)).Bytes())
-				logs.PrintErr(err)
-				return
-			}
-			c := l.(*io.Server).GetClient(address)
-			if c == nil {
-				_, err := c2.Write(p.Resp(nil, fmt.Errorf("客户端[%s]未连接", address)).Bytes())
-				logs.PrintErr(err)
-				return
-			}
-			_, err := c.Write(data)
-			logs.PrintErr(err)
+// write 向远程地址写入数据,TCP需要已经连接
+func (this *listener) write(address string, data []byte) error {
+	if this.udp != nil {
+		addr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			return err
 		}
+		_, err = this.udp.WriteToUDP(data, addr)
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	c := this.clients[address]
+	if c == nil {
+		return fmt.Errorf("远程地址(%s)未连接", address)
+	}
+	_, err := c.Write(data)
+	return err
+}
 
-	})
-	return ser, nil
+func (this *listener) close() error {
+	if this.udp != nil {
+		return this.udp.Close()
+	}
+	return this.tcp.Close()
 }
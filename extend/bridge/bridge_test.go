@@ -0,0 +1,246 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"github.com/injoyai/io"
+	goio "io"
+	"net"
+	"testing"
+	"time"
+)
+
+type event struct {
+	typ     string
+	key     string
+	address string
+	data    string
+}
+
+func newBridgeServer(t *testing.T, options ...func(s *Server)) *Server {
+	s, err := NewServer(0, append([]func(s *Server){func(s *Server) {
+		s.Debug(false)
+		s.SetListenOptions(func(s *io.Server) { s.Debug(false) })
+	}}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	go s.Run()
+	return s
+}
+
+// newBridgeClient 订阅者,收到的数据和事件写入通道
+func newBridgeClient(t *testing.T, s *Server) (*Client, chan *event) {
+	events := make(chan *event, 100)
+	c := RedialClient(s.Listener().Addr(), func(c *Client) {
+		c.Debug(false)
+		c.SetReceiveFunc(func(key, address string, data []byte) {
+			events <- &event{typ: TypeReceive, key: key, address: address, data: string(data)}
+		})
+		c.SetRemoteConnectFunc(func(key, address string) {
+			events <- &event{typ: TypeConnect, key: key, address: address}
+		})
+		c.SetRemoteCloseFunc(func(key, address string, err error) {
+			events <- &event{typ: TypeClose, key: key, address: address}
+		})
+	})
+	t.Cleanup(func() { c.CloseAll() })
+	return c, events
+}
+
+func waitEvent(t *testing.T, events chan *event, typ string) *event {
+	select {
+	case e := <-events:
+		if e.typ != typ {
+			t.Fatalf("预期事件(%s),得到(%s)", typ, e.typ)
+		}
+		return e
+	case <-time.After(time.Second * 3):
+		t.Fatalf("等待事件(%s)超时", typ)
+	}
+	return nil
+}
+
+func portOf(t *testing.T, key string) int {
+	_, port, err := ParseListenKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func readString(conn net.Conn, n int) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	buf := make([]byte, n)
+	_, err := goio.ReadFull(conn, buf)
+	return string(buf), err
+}
+
+func TestBridgeTCP(t *testing.T) {
+	s := newBridgeServer(t)
+	key, err := s.Listen(NetworkTCP, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, events := newBridgeClient(t, s)
+	if err := c.Subscribe(key); err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", portOf(t, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	e := waitEvent(t, events, TypeConnect)
+	if e.key != key || e.address != remote.LocalAddr().String() {
+		t.Fatalf("连接事件错误: %+v", e)
+	}
+
+	remote.Write([]byte("hello"))
+	if e := waitEvent(t, events, TypeReceive); e.data != "hello" || e.address != remote.LocalAddr().String() {
+		t.Fatalf("数据错误: %+v", e)
+	}
+
+	//写入远程地址
+	if err := c.Send(key, e.address, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := readString(remote, 5); err != nil || s != "world" {
+		t.Fatalf("写入错误(%s): %v", s, err)
+	}
+	if err := c.Send(key, "127.0.0.1:1", []byte("world")); err == nil {
+		t.Fatal("远程地址未连接,预期错误")
+	}
+	if err := c.Send("tcp.1", e.address, []byte("world")); err == nil {
+		t.Fatal("监听服务不存在,预期错误")
+	}
+
+	remote.Close()
+	waitEvent(t, events, TypeClose)
+}
+
+func TestBridgeUDP(t *testing.T) {
+	s := newBridgeServer(t)
+	c, events := newBridgeClient(t, s)
+	//订阅者打开监听服务,自动订阅
+	key, err := c.Listen(NetworkUDP, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", portOf(t, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	remote.Write([]byte("hello"))
+	e := waitEvent(t, events, TypeReceive)
+	if e.key != key || e.data != "hello" || e.address != remote.LocalAddr().String() {
+		t.Fatalf("数据错误: %+v", e)
+	}
+	if err := c.Send(key, e.address, []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	remote.SetReadDeadline(time.Now().Add(time.Second * 3))
+	buf := make([]byte, 100)
+	n, err := remote.Read(buf)
+	if err != nil || string(buf[:n]) != "world" {
+		t.Fatalf("写入错误(%s): %v", buf[:n], err)
+	}
+
+	//订阅者断开后关闭监听服务
+	c.CloseAll()
+	deadline := time.Now().Add(time.Second * 3)
+	for len(s.Listens()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 20)
+	}
+	if list := s.Listens(); len(list) > 0 {
+		t.Fatalf("监听服务未关闭: %v", list)
+	}
+}
+
+func TestBridgeSubscribe(t *testing.T) {
+	s := newBridgeServer(t, func(s *Server) {
+		s.SetAllowListen(func(c *io.Client, network string, port int) error {
+			if network == NetworkUDP {
+				return errors.New("不允许UDP")
+			}
+			return nil
+		})
+	})
+	key, err := s.Listen(NetworkTCP, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, events1 := newBridgeClient(t, s)
+	c2, events2 := newBridgeClient(t, s)
+	for _, c := range []*Client{c1, c2} {
+		if err := c.Subscribe(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c1.Subscribe("tcp.1"); err == nil {
+		t.Fatal("监听服务不存在,预期订阅失败")
+	}
+	if _, err := c1.Listen(NetworkUDP, 0); err == nil {
+		t.Fatal("不允许UDP,预期失败")
+	}
+	if err := c1.CloseListen(key); err == nil {
+		t.Fatal("只能关闭自己打开的监听服务,预期失败")
+	}
+
+	remote, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", portOf(t, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	waitEvent(t, events1, TypeConnect)
+	waitEvent(t, events2, TypeConnect)
+	remote.Write([]byte("a"))
+	waitEvent(t, events1, TypeReceive)
+	waitEvent(t, events2, TypeReceive)
+
+	//取消订阅后不再推送
+	if err := c2.Unsubscribe(key); err != nil {
+		t.Fatal(err)
+	}
+	remote.Write([]byte("b"))
+	if e := waitEvent(t, events1, TypeReceive); e.data != "b" {
+		t.Fatalf("数据错误: %+v", e)
+	}
+	select {
+	case e := <-events2:
+		t.Fatalf("取消订阅后收到数据: %+v", e)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestBridgeReconnect(t *testing.T) {
+	s := newBridgeServer(t)
+	c, events := newBridgeClient(t, s)
+	key, err := c.Listen(NetworkTCP, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CloseClientAll()
+	//重连后使用相同的端口重新打开,断开前的监听服务可能还未关闭,收到连接事件才算成功
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 50) {
+		remote, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", portOf(t, key)))
+		if err != nil {
+			continue
+		}
+		select {
+		case e := <-events:
+			remote.Close()
+			if e.typ != TypeConnect || e.key != key {
+				t.Fatalf("连接事件错误: %+v", e)
+			}
+			return
+		case <-time.After(time.Millisecond * 500):
+			remote.Close()
+		}
+	}
+	t.Fatal("重连后未重新打开监听服务")
+}
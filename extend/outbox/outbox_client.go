@@ -0,0 +1,236 @@
+package outbox
+
+import (
+	"context"
+	"github.com/injoyai/io"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSegmentSize = 4 << 20                   //默认段大小4MB
+	DefaultMaxSize     = 256 << 20                 //默认总大小256MB
+	DefaultRetention   = time.Hour * 24 * 7        //默认保留7天
+	DefaultAckTimeout  = io.DefaultResponseTimeout //默认确认超时时间
+)
+
+// New 新建发件箱,数据先持久化到dir目录,再按顺序发送给绑定的客户端
+// 使用方式 io.Redial(dial, o.Option),每次连接成功后补发未确认的数据
+func New(dir string, options ...Option) (*Outbox, error) {
+	s, err := newStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &Outbox{
+		store:   s,
+		timeout: DefaultAckTimeout,
+		ackChan: make(chan struct{}, 1),
+		signal:  make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, v := range options {
+		v(o)
+	}
+	go o.run()
+	return o, nil
+}
+
+type Option func(o *Outbox)
+
+/*
+Outbox 发件箱,断网期间的数据保存到本地文件,连接成功后按顺序补发
+数据按段追加写入,超过总大小或者保留时间的段会被删除(包括未发送的数据)
+未设置确认函数时,写入客户端成功即视为已确认,
+设置确认函数时,收到对方的确认才会发送下一条,超时未确认则重新发送
+*/
+type Outbox struct {
+	*store
+	mu      sync.RWMutex
+	client  *io.Client                //绑定的客户端
+	ackFunc func(msg io.Message) bool //判断是否是确认数据
+	timeout time.Duration             //确认超时时间
+	ackChan chan struct{}             //收到确认信号
+	signal  chan struct{}             //发送信号
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// SetSegmentSize 设置单个段文件的大小
+func (this *Outbox) SetSegmentSize(size int64) *Outbox {
+	this.store.mu.Lock()
+	defer this.store.mu.Unlock()
+	if size > 0 {
+		this.store.segmentSize = size
+	}
+	return this
+}
+
+// SetMaxSize 设置总大小,超过则删除最早的段
+func (this *Outbox) SetMaxSize(size int64) *Outbox {
+	this.store.mu.Lock()
+	defer this.store.mu.Unlock()
+	if size > 0 {
+		this.store.maxSize = size
+	}
+	return this
+}
+
+// SetRetention 设置保留时间,超过则删除段,0表示不限制
+func (this *Outbox) SetRetention(t time.Duration) *Outbox {
+	this.store.mu.Lock()
+	defer this.store.mu.Unlock()
+	this.store.retention = t
+	return this
+}
+
+// SetSync 设置每次写入后是否同步到磁盘,默认同步,关闭后异常断电可能丢失数据
+func (this *Outbox) SetSync(b bool) *Outbox {
+	this.store.mu.Lock()
+	defer this.store.mu.Unlock()
+	this.store.sync = b
+	return this
+}
+
+// SetAckFunc 设置确认函数,判断收到的数据是否是对方的确认,需要在绑定客户端之前设置
+func (this *Outbox) SetAckFunc(f func(msg io.Message) bool) *Outbox {
+	this.ackFunc = f
+	return this
+}
+
+// SetAckTimeout 设置确认超时时间,超时则重新发送
+func (this *Outbox) SetAckTimeout(t time.Duration) *Outbox {
+	if t > 0 {
+		this.timeout = t
+	}
+	return this
+}
+
+// Option 绑定客户端,作为客户端的选项使用,重连时需要重新绑定
+func (this *Outbox) Option(c *io.Client) {
+	this.mu.Lock()
+	this.client = c
+	this.mu.Unlock()
+	c.SetConnectFunc(func(c *io.Client) error {
+		this.notify()
+		return nil
+	})
+	if this.ackFunc != nil {
+		c.SetDealFunc(func(c *io.Client, msg io.Message) {
+			if this.ackFunc(msg) {
+				select {
+				case this.ackChan <- struct{}{}:
+				default:
+				}
+			}
+		})
+	}
+	if c.Running() {
+		//已经连接的客户端
+		this.notify()
+	}
+}
+
+// Client 绑定的客户端
+func (this *Outbox) Client() *io.Client {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.client
+}
+
+// Write 写入数据,实现io.Writer,持久化成功即返回,连接成功后发送
+func (this *Outbox) Write(p []byte) (int, error) {
+	if err := this.store.append(p); err != nil {
+		return 0, err
+	}
+	this.notify()
+	return len(p), nil
+}
+
+// WriteString 写入字符串
+func (this *Outbox) WriteString(s string) (int, error) {
+	return this.Write([]byte(s))
+}
+
+// Pending 未确认的数据大小(字节)
+func (this *Outbox) Pending() int64 {
+	return this.store.pending()
+}
+
+// Close 关闭发件箱,未发送的数据保留在文件中,下次打开后继续发送
+func (this *Outbox) Close() error {
+	this.cancel()
+	return this.store.close()
+}
+
+func (this *Outbox) notify() {
+	select {
+	case this.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run 等待信号,发送未确认的数据
+func (this *Outbox) run() {
+	for {
+		select {
+		case <-this.ctx.Done():
+			return
+		case <-this.signal:
+			this.drain()
+		}
+	}
+}
+
+// drain 按顺序发送未确认的数据,直到发送完成或者连接断开
+func (this *Outbox) drain() {
+	c := this.Client()
+	if c == nil {
+		return
+	}
+	//断开期间读取过的数据需要重新发送
+	this.store.rewind()
+	for !c.Closed() {
+		r, err := this.store.next()
+		if err != nil {
+			if err != ErrEmpty && err != ErrClosed {
+				c.Errorf("[%s] 读取发件箱错误: %v\n", c.GetKey(), err)
+			}
+			return
+		}
+
+		//清除过期的确认信号
+		select {
+		case <-this.ackChan:
+		default:
+		}
+
+		if _, err := c.Write(r.Payload()); err != nil {
+			return
+		}
+
+		if this.ackFunc == nil {
+			if err := r.Ack(); err != nil {
+				c.Errorf("[%s] 确认发件箱错误: %v\n", c.GetKey(), err)
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-this.ctx.Done():
+			return
+		case <-c.Done():
+			return
+		case <-time.After(this.timeout):
+			//超时未确认,重新发送
+			this.store.rewind()
+		case <-this.ackChan:
+			if err := r.Ack(); err != nil {
+				c.Errorf("[%s] 确认发件箱错误: %v\n", c.GetKey(), err)
+				return
+			}
+		}
+	}
+}
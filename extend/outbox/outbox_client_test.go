@@ -0,0 +1,76 @@
+package outbox
+
+import (
+	"fmt"
+	"github.com/injoyai/io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	o, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.SetAckFunc(func(msg io.Message) bool { return msg.String() == "ack" })
+
+	//未连接时写入
+	for i := 0; i < 5; i++ {
+		if _, err := o.WriteString(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c1, c2 := net.Pipe()
+	received := make(chan string, 10)
+	b := io.NewClient(c2, func(c *io.Client) {
+		c.Debug(false)
+		c.SetReadWriteWithPkg()
+		c.SetDealFunc(func(c *io.Client, msg io.Message) {
+			received <- msg.String()
+			c.WriteString("ack")
+		})
+	})
+	a := io.NewClient(c1, func(c *io.Client) {
+		c.Debug(false)
+		c.SetReadWriteWithPkg()
+	})
+	defer a.CloseAll()
+	defer b.CloseAll()
+	go b.Run()
+	go a.Run()
+	for !a.Running() {
+		time.Sleep(time.Millisecond)
+	}
+	a.SetOptions(o.Option)
+
+	for i := 0; i < 5; i++ {
+		select {
+		case msg := <-received:
+			if msg != fmt.Sprint(i) {
+				t.Fatalf("预期(%d),得到(%s)", i, msg)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("未收到数据(%d)", i)
+		}
+	}
+	for i := 0; o.Pending() > 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if o.Pending() != 0 {
+		t.Fatalf("预期全部确认,剩余(%d)", o.Pending())
+	}
+	o.Close()
+
+	//重新打开,已确认的数据不会再次发送
+	o, err = New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	if o.Pending() != 0 {
+		t.Fatalf("预期全部确认,剩余(%d)", o.Pending())
+	}
+}
@@ -0,0 +1,447 @@
+package outbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+文件存储,按段追加写入
+
+段文件: 00000000000000000001.log
+记录:
+长度 4字节(大端)
+校验 4字节(crc32)
+数据 n字节
+
+确认文件: commit
+段序号 8字节
+偏移量 8字节
+校验 4字节(crc32)
+
+写入记录前不会修改已有数据,异常断电后最后一条记录可能不完整,
+打开时校验记录,从第一条损坏的记录处截断
+
+*/
+
+const (
+	recordHeadLength = 8
+	commitLength     = 20
+	segmentSuffix    = ".log"
+	commitName       = "commit"
+)
+
+var (
+	ErrClosed    = errors.New("发件箱已关闭")
+	ErrEmpty     = errors.New("发件箱为空")
+	ErrTooLarge  = errors.New("数据超过最大长度")
+	errCorrupted = errors.New("记录损坏")
+)
+
+// pos 记录位置
+type pos struct {
+	seg uint64 //段序号
+	off int64  //段内偏移量
+}
+
+func (this pos) less(p pos) bool {
+	return this.seg < p.seg || (this.seg == p.seg && this.off < p.off)
+}
+
+// segment 段文件信息
+type segment struct {
+	index   uint64
+	size    int64
+	modTime time.Time
+}
+
+func segmentName(index uint64) string {
+	return fmt.Sprintf("%020d%s", index, segmentSuffix)
+}
+
+func newStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &store{
+		dir:         dir,
+		segmentSize: DefaultSegmentSize,
+		maxSize:     DefaultMaxSize,
+		retention:   DefaultRetention,
+		sync:        true,
+	}
+	return s, s.load()
+}
+
+// store 追加写入的文件存储,支持确认和重新读取
+type store struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64         //单个段的大小,超过则新建段
+	maxSize     int64         //总大小,超过则删除最早的段
+	retention   time.Duration //保留时间,超过则删除段,0表示不限制
+	sync        bool          //每次写入后是否同步到磁盘
+
+	segments []*segment //段信息,按序号排序,最后一个为当前写入的段
+	w        *os.File   //当前写入的段
+	r        *os.File   //当前读取的段
+	rIndex   uint64     //当前读取的段序号
+	commit   pos        //已确认的位置
+	read     pos        //下次读取的位置
+	closed   bool
+}
+
+// load 加载段文件和确认位置,并截断损坏的记录
+func (this *store) load() error {
+	entries, err := os.ReadDir(this.dir)
+	if err != nil {
+		return err
+	}
+	for _, v := range entries {
+		name := v.Name()
+		if v.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		var index uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, segmentSuffix), "%d", &index); err != nil {
+			continue
+		}
+		size, err := this.recover(index)
+		if err != nil {
+			return err
+		}
+		info, err := v.Info()
+		if err != nil {
+			return err
+		}
+		this.segments = append(this.segments, &segment{index: index, size: size, modTime: info.ModTime()})
+	}
+	sort.Slice(this.segments, func(i, j int) bool {
+		return this.segments[i].index < this.segments[j].index
+	})
+
+	//读取确认位置,文件损坏则从头开始
+	this.commit = this.loadCommit()
+	if len(this.segments) == 0 {
+		index := this.commit.seg
+		if index == 0 {
+			index = 1
+		}
+		this.segments = append(this.segments, &segment{index: index, modTime: time.Now()})
+	}
+	if first := this.segments[0]; this.commit.seg < first.index {
+		this.commit = pos{seg: first.index}
+	}
+	if last := this.segments[len(this.segments)-1]; last.index < this.commit.seg ||
+		(last.index == this.commit.seg && last.size < this.commit.off) {
+		//确认位置超过了数据(数据文件被截断),从最后位置开始
+		this.commit = pos{seg: last.index, off: last.size}
+	}
+	this.read = this.commit
+
+	last := this.segments[len(this.segments)-1]
+	this.w, err = os.OpenFile(this.segmentPath(last.index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	this.clean()
+	return nil
+}
+
+// recover 校验段文件的记录,从第一条损坏的记录处截断,返回有效大小
+func (this *store) recover(index uint64) (int64, error) {
+	f, err := os.OpenFile(this.segmentPath(index), os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var off int64
+	for {
+		bs, err := readRecord(f, off, info.Size())
+		if err != nil {
+			break
+		}
+		off += recordHeadLength + int64(len(bs))
+	}
+	if info.Size() > off {
+		if err := f.Truncate(off); err != nil {
+			return 0, err
+		}
+		if err := f.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	return off, nil
+}
+
+func (this *store) segmentPath(index uint64) string {
+	return filepath.Join(this.dir, segmentName(index))
+}
+
+func (this *store) commitPath() string {
+	return filepath.Join(this.dir, commitName)
+}
+
+func (this *store) loadCommit() pos {
+	bs, err := os.ReadFile(this.commitPath())
+	if err != nil || len(bs) != commitLength {
+		return pos{}
+	}
+	if crc32.ChecksumIEEE(bs[:16]) != binary.BigEndian.Uint32(bs[16:]) {
+		return pos{}
+	}
+	return pos{
+		seg: binary.BigEndian.Uint64(bs[:8]),
+		off: int64(binary.BigEndian.Uint64(bs[8:16])),
+	}
+}
+
+// saveCommit 保存确认位置,先写临时文件再重命名,保证原子性
+func (this *store) saveCommit(p pos) error {
+	bs := make([]byte, commitLength)
+	binary.BigEndian.PutUint64(bs[:8], p.seg)
+	binary.BigEndian.PutUint64(bs[8:16], uint64(p.off))
+	binary.BigEndian.PutUint32(bs[16:], crc32.ChecksumIEEE(bs[:16]))
+	filename := this.commitPath() + ".tmp"
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(bs); err == nil && this.sync {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename, this.commitPath())
+}
+
+// append 追加一条记录
+func (this *store) append(p []byte) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return ErrClosed
+	}
+	if int64(len(p)) > this.segmentSize || len(p) > int(^uint32(0)>>1) {
+		return ErrTooLarge
+	}
+
+	//当前段已满,新建段
+	last := this.segments[len(this.segments)-1]
+	if last.size > 0 && last.size+recordHeadLength+int64(len(p)) > this.segmentSize {
+		if err := this.rotate(); err != nil {
+			return err
+		}
+		last = this.segments[len(this.segments)-1]
+	}
+
+	bs := make([]byte, recordHeadLength+len(p))
+	binary.BigEndian.PutUint32(bs[:4], uint32(len(p)))
+	binary.BigEndian.PutUint32(bs[4:8], crc32.ChecksumIEEE(p))
+	copy(bs[recordHeadLength:], p)
+	if _, err := this.w.Write(bs); err != nil {
+		//写入失败,删除不完整的记录
+		_ = this.w.Truncate(last.size)
+		return err
+	}
+	if this.sync {
+		if err := this.w.Sync(); err != nil {
+			return err
+		}
+	}
+	last.size += int64(len(bs))
+	last.modTime = time.Now()
+
+	this.clean()
+	return nil
+}
+
+// rotate 新建段,之后的数据写入新的段
+func (this *store) rotate() error {
+	index := this.segments[len(this.segments)-1].index + 1
+	w, err := os.OpenFile(this.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	this.w.Close()
+	this.w = w
+	this.segments = append(this.segments, &segment{index: index, modTime: time.Now()})
+	return nil
+}
+
+// clean 按照总大小和保留时间删除最早的段,当前写入的段不会删除
+func (this *store) clean() {
+	var total int64
+	for _, v := range this.segments {
+		total += v.size
+	}
+	for len(this.segments) > 1 {
+		first := this.segments[0]
+		if total <= this.maxSize && (this.retention <= 0 || time.Since(first.modTime) <= this.retention) {
+			break
+		}
+		total -= first.size
+		this.remove(first.index)
+		this.segments = this.segments[1:]
+		next := pos{seg: this.segments[0].index}
+		if this.commit.less(next) {
+			this.commit = next
+			_ = this.saveCommit(next)
+		}
+		if this.read.less(next) {
+			this.read = next
+		}
+	}
+}
+
+// remove 删除段文件
+func (this *store) remove(index uint64) {
+	if this.r != nil && this.rIndex == index {
+		this.r.Close()
+		this.r = nil
+	}
+	_ = os.Remove(this.segmentPath(index))
+}
+
+// next 读取下一条未读取的记录
+func (this *store) next() (*record, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return nil, ErrClosed
+	}
+	for _, seg := range this.segments {
+		if seg.index < this.read.seg {
+			continue
+		}
+		if seg.index > this.read.seg {
+			this.read = pos{seg: seg.index}
+		}
+		if this.read.off >= seg.size {
+			continue
+		}
+		if this.r == nil || this.rIndex != seg.index {
+			if this.r != nil {
+				this.r.Close()
+			}
+			f, err := os.Open(this.segmentPath(seg.index))
+			if err != nil {
+				return nil, err
+			}
+			this.r, this.rIndex = f, seg.index
+		}
+		bs, err := readRecord(this.r, this.read.off, seg.size)
+		if err != nil {
+			return nil, err
+		}
+		this.read.off += recordHeadLength + int64(len(bs))
+		return &record{s: this, data: bs, next: this.read}, nil
+	}
+	return nil, ErrEmpty
+}
+
+// rewind 回到已确认的位置,未确认的数据会重新读取
+func (this *store) rewind() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.read = this.commit
+}
+
+// ack 确认数据到指定位置,并删除已经确认的段
+func (this *store) ack(p pos) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return ErrClosed
+	}
+	if !this.commit.less(p) {
+		return nil
+	}
+	if err := this.saveCommit(p); err != nil {
+		return err
+	}
+	this.commit = p
+	for len(this.segments) > 1 && this.segments[0].index < p.seg {
+		this.remove(this.segments[0].index)
+		this.segments = this.segments[1:]
+	}
+	return nil
+}
+
+// pending 未确认的数据大小(包括记录头)
+func (this *store) pending() int64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	var total int64
+	for _, v := range this.segments {
+		switch {
+		case v.index == this.commit.seg:
+			total += v.size - this.commit.off
+		case v.index > this.commit.seg:
+			total += v.size
+		}
+	}
+	return total
+}
+
+func (this *store) close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	if this.r != nil {
+		this.r.Close()
+	}
+	return this.w.Close()
+}
+
+// readRecord 读取并校验指定位置的记录,size为文件的有效大小
+func readRecord(r io.ReaderAt, off, size int64) ([]byte, error) {
+	head := make([]byte, recordHeadLength)
+	if _, err := r.ReadAt(head, off); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(head[:4]))
+	if off+recordHeadLength+length > size {
+		return nil, errCorrupted
+	}
+	bs := make([]byte, length)
+	if _, err := r.ReadAt(bs, off+recordHeadLength); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(bs) != binary.BigEndian.Uint32(head[4:8]) {
+		return nil, errCorrupted
+	}
+	return bs, nil
+}
+
+// record 一条记录,实现io.Acker,确认后不会再次发送
+type record struct {
+	s    *store
+	data []byte
+	next pos
+}
+
+func (this *record) Payload() []byte { return this.data }
+
+func (this *record) Ack() error { return this.s.ack(this.next) }
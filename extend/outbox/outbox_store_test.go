@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestStoreRecover(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := s.append([]byte(fmt.Sprint("data", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//确认前2条
+	for i := 0; i < 2; i++ {
+		r, err := s.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.close()
+
+	//模拟异常断电,最后一条记录写入一半
+	filename := s.segmentPath(1)
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filename, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = newStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if err := s.append([]byte("data5")); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{"data2", "data3", "data5"} {
+		r, err := s.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Payload()) != expect {
+			t.Fatalf("预期(%s),得到(%s)", expect, r.Payload())
+		}
+		if err := r.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.next(); err != ErrEmpty {
+		t.Fatalf("预期(%v),得到(%v)", ErrEmpty, err)
+	}
+	if s.pending() != 0 {
+		t.Fatalf("预期未确认数据为0,得到(%d)", s.pending())
+	}
+}
+
+func TestStoreSegment(t *testing.T) {
+	s, err := newStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	s.segmentSize = 32
+	s.maxSize = 64
+	for i := 0; i < 10; i++ {
+		if err := s.append([]byte(fmt.Sprintf("data%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//每段2条记录,总大小限制保留最后4条
+	if len(s.segments) != 2 {
+		t.Fatalf("预期2个段,得到(%d)", len(s.segments))
+	}
+	for i := 6; i < 10; i++ {
+		r, err := s.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := fmt.Sprintf("data%02d", i); string(r.Payload()) != expect {
+			t.Fatalf("预期(%s),得到(%s)", expect, r.Payload())
+		}
+		if err := r.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.segments) != 1 {
+		t.Fatalf("预期确认后删除段,剩余(%d)", len(s.segments))
+	}
+}
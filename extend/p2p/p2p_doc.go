@@ -5,10 +5,14 @@ package p2p
 2.需要一个Stun服务器,以便获取当前请求经过NAT转换后的公网IP:Port
 3.需要用UDP对Stun服务发起请求,udp无状态,tcp断开nat缓存会失效
 
-
-
-
-
-
+流程
+1.节点(NewPeer)向中间服务(NewServer)注册,注册前检测NAT类型(DetectNAT),之后定时重新注册,保持NAT映射
+2.节点A连接节点B(Dial),中间服务通知B,B确认后把B的信息响应给A
+3.A和B同时向对方的公网地址发送打洞消息,收到对方的消息则打洞成功,之后直连
+4.NAT类型不能打洞(例如对称型和限制锥形)或打洞超时,则通过中间服务转发
+5.B的会话交给节点的io.Server处理(Run),A的会话作为*io.Client返回
+
+数据包第1个字节区分类型,控制消息(编码编号+消息,编码可以设置,默认json),节点之间的数据,中间服务转发的数据包
+每次写入一个UDP数据包,不能超过60KB,UDP不保证可靠和顺序,需要的话在上层处理
 
 */
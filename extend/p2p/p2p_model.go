@@ -1,40 +1,127 @@
 package p2p
 
-import "github.com/injoyai/io"
+import (
+	"encoding/json"
+	"errors"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/codec"
+)
 
 const (
 	TypePing = io.Ping
 	TypePong = io.Pong
 )
 
+const (
+	flagMsg   byte = 0x01 //控制消息,1字节编码编号(codec.ID)+编码后的消息(Msg)
+	flagData  byte = 0x02 //节点之间的数据
+	flagRelay byte = 0x03 //通过中间服务转发,1字节节点标识长度+节点标识+转发的数据包
+)
+
 type Msg struct {
-	Code  int         `json:"code,omitempty"` //状态
-	Type  string      `json:"type"`           //消息类型
-	MsgID string      `json:"msgId"`          //消息标识
-	Data  interface{} `json:"data,omitempty"` //消息数据
+	Code  int             `json:"code,omitempty"` //状态
+	Type  string          `json:"type"`           //消息类型
+	MsgID string          `json:"msgId"`          //消息标识
+	Data  json.RawMessage `json:"data,omitempty"` //消息数据,和消息使用相同的编码
+	codec codec.Codec     //消息的编码,响应时使用
+}
+
+// decode 解析消息数据
+func (this *Msg) decode(v interface{}) error {
+	if len(this.Data) == 0 {
+		return nil
+	}
+	return this.codec.Unmarshal(this.Data, v)
+}
+
+// newMsg 生成控制消息的数据包,c需要是已注册的编码
+func newMsg(c codec.Codec, Type, msgID string, data interface{}) []byte {
+	id, ok := codec.ID(c)
+	if !ok {
+		c, id = codec.JSON, codec.IDJSON
+	}
+	m := &Msg{Type: Type, MsgID: msgID}
+	if data != nil {
+		m.Data, _ = c.Marshal(data)
+	}
+	bs, _ := c.Marshal(m)
+	return append([]byte{flagMsg, id}, bs...)
+}
+
+// decodeMsg 解析控制消息的数据包
+func decodeMsg(p []byte) (*Msg, error) {
+	if len(p) < 2 {
+		return nil, errors.New("无效的消息")
+	}
+	c := codec.GetByID(p[1])
+	if c == nil {
+		return nil, errors.New("未知的编码格式")
+	}
+	m := &Msg{codec: c}
+	if err := c.Unmarshal(p[2:], m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// newData 生成数据的数据包
+func newData(p []byte) []byte {
+	return append([]byte{flagData}, p...)
+}
+
+// newRelay 生成转发的数据包,发送时是目标节点,接收时是来源节点
+func newRelay(nodeID string, p []byte) []byte {
+	bs := make([]byte, 0, 2+len(nodeID)+len(p))
+	bs = append(bs, flagRelay, byte(len(nodeID)))
+	bs = append(bs, nodeID...)
+	return append(bs, p...)
+}
+
+// decodeRelay 解析转发的数据包,返回节点标识和转发的数据包
+func decodeRelay(p []byte) (string, []byte, bool) {
+	if len(p) < 2 || len(p) < 2+int(p[1]) {
+		return "", nil, false
+	}
+	return string(p[2 : 2+p[1]]), p[2+p[1]:], true
 }
 
 type MsgRegister struct {
-	NodeID     string `json:"nodeID"`     //名称
-	Version    string `json:"version"`    //版本信息
-	StartTime  int64  `json:"startTime"`  //运行时间
-	ConnectKey string `json:"connectKey"` //连接秘钥
-	LocalAddr  string `json:"localAddr"`  //本地地址
-	RemoteAddr string `json:"-"`          //远程地址
+	NodeID     string  `json:"nodeID"`          //名称
+	Version    string  `json:"version"`         //版本信息
+	StartTime  int64   `json:"startTime"`       //运行时间
+	ConnectKey string  `json:"connectKey"`      //连接秘钥
+	LocalAddr  string  `json:"localAddr"`       //本地地址
+	RemoteAddr string  `json:"remoteAddr"`      //远程地址,中间服务看到的地址(NAT转换后)
+	NAT        NATType `json:"nat,omitempty"`   //NAT类型
+	Codec      string  `json:"codec,omitempty"` //控制消息的编码,其他节点和中间服务发起时使用
 }
 
 type MsgGetRegister struct {
 	NodeID string `json:"nodeID"` //节点标识
 }
 
+// MsgConnectNotice 中间服务通知节点,有其他节点要连接
 type MsgConnectNotice struct {
-	NodeID string `json:"nodeID"` //节点标识
+	NodeID string       `json:"nodeID"` //节点标识
+	Info   *MsgRegister `json:"info"`   //发起连接的节点信息
 }
 
 type MsgConnect struct {
 	NodeID string `json:"nodeID"` //名称
 }
 
+// MsgPunch 打洞消息,节点之间互相发送
+type MsgPunch struct {
+	NodeID string `json:"nodeID"` //发送方的节点标识
+}
+
+// MsgBinding 获取NAT转换后的地址,用于检测NAT类型
+type MsgBinding struct {
+	ChangePort bool   `json:"changePort,omitempty"` //请求,使用备用端口响应
+	Addr       string `json:"addr,omitempty"`       //响应,中间服务看到的地址
+	AltPort    int    `json:"altPort,omitempty"`    //响应,中间服务的备用端口
+}
+
 type MsgError struct {
 	Code int         `json:"code"`
 	Data interface{} `json:"data"`
@@ -0,0 +1,112 @@
+package p2p
+
+import (
+	"net"
+	"strconv"
+)
+
+// NATType NAT类型
+type NATType string
+
+const (
+	NATUnknown    NATType = ""           //未检测
+	NATNone       NATType = "none"       //没有NAT,公网地址
+	NATFullCone   NATType = "fullCone"   //完全锥形,映射后任意地址都能发送数据过来
+	NATRestricted NATType = "restricted" //限制锥形,映射不变,只接收发送过的地址的数据(地址或端口限制)
+	NATSymmetric  NATType = "symmetric"  //对称型,每个目标地址使用不同的映射
+	NATBlocked    NATType = "blocked"    //UDP不通
+)
+
+// canPunch 能否打洞,对称型只能和完全锥形(或公网)打洞
+func canPunch(a, b NATType) bool {
+	if a == NATBlocked || b == NATBlocked {
+		return false
+	}
+	if a == NATSymmetric {
+		a, b = b, a
+	}
+	if b == NATSymmetric {
+		return a != NATSymmetric && a != NATRestricted
+	}
+	return true
+}
+
+/*
+DetectNAT 检测NAT类型(类似STUN),中间服务需要监听主端口和备用端口
+1. 向主端口请求映射地址,无响应则UDP不通,映射地址和本地地址一致则没有NAT
+2. 请求主端口使用备用端口响应,能收到则是完全锥形
+3. 向备用端口请求映射地址,和第1步的一致则是限制锥形,否则是对称型
+*/
+func (this *peer) DetectNAT(addr string) (NATType, error) {
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return NATUnknown, err
+	}
+
+	res := new(MsgBinding)
+	if err := this.request(server, TypeBindingReq, &MsgBinding{}, res); err != nil {
+		if err == errTimeout {
+			return this.setNAT(NATBlocked), nil
+		}
+		return NATUnknown, err
+	}
+	mapped, err := net.ResolveUDPAddr("udp", res.Addr)
+	if err != nil {
+		return NATUnknown, err
+	}
+	if isLocalAddr(this.conn.LocalAddr(), mapped) {
+		return this.setNAT(NATNone), nil
+	}
+
+	if err := this.request(server, TypeBindingReq, &MsgBinding{ChangePort: true}, &MsgBinding{}); err == nil {
+		return this.setNAT(NATFullCone), nil
+	} else if err != errTimeout {
+		return NATUnknown, err
+	}
+
+	alt := &net.UDPAddr{IP: server.IP, Port: res.AltPort, Zone: server.Zone}
+	res2 := new(MsgBinding)
+	if err := this.request(alt, TypeBindingReq, &MsgBinding{}, res2); err != nil {
+		return NATUnknown, err
+	}
+	if res2.Addr == res.Addr {
+		return this.setNAT(NATRestricted), nil
+	}
+	return this.setNAT(NATSymmetric), nil
+}
+
+// NAT 检测到的NAT类型
+func (this *peer) NAT() NATType {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.nat
+}
+
+func (this *peer) setNAT(nat NATType) NATType {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.nat = nat
+	return nat
+}
+
+// isLocalAddr 映射地址是否是本机地址,本地地址未指定IP时和网卡地址比较
+func isLocalAddr(local net.Addr, mapped *net.UDPAddr) bool {
+	host, port, err := net.SplitHostPort(local.String())
+	if err != nil || port != strconv.Itoa(mapped.Port) {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && !ip.IsUnspecified() {
+		return ip.Equal(mapped.IP)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, v := range addrs {
+		if n, ok := v.(*net.IPNet); ok && n.IP.Equal(mapped.IP) {
+			return true
+		}
+	}
+	return false
+}
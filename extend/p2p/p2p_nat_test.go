@@ -0,0 +1,214 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type natPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// natConn 模拟NAT,内部没有真实的端口,每个映射使用一个本地UDP端口
+// 锥形所有目标地址使用同一个映射,对称型每个目标地址使用不同的映射
+// 完全锥形接收任意地址的数据,其他只接收发送过的地址的数据,firewall只接收allow的数据
+type natConn struct {
+	typ      NATType
+	allow    []string
+	mu       sync.Mutex
+	mappings map[string]*net.UDPConn
+	sent     map[*net.UDPConn]map[string]bool
+	in       chan natPacket
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newNATConn(typ NATType, allow ...string) *natConn {
+	return &natConn{
+		typ:      typ,
+		allow:    allow,
+		mappings: make(map[string]*net.UDPConn),
+		sent:     make(map[*net.UDPConn]map[string]bool),
+		in:       make(chan natPacket, 1000),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (this *natConn) mapping(addr net.Addr) (*net.UDPConn, error) {
+	key := ""
+	if this.typ == NATSymmetric {
+		key = addr.String()
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if c, ok := this.mappings[key]; ok {
+		this.sent[c][addr.String()] = true
+		return c, nil
+	}
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	this.mappings[key] = c
+	this.sent[c] = map[string]bool{addr.String(): true}
+	go this.run(c)
+	return c, nil
+}
+
+func (this *natConn) run(c *net.UDPConn) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := c.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !this.filter(c, addr) {
+			continue
+		}
+		select {
+		case this.in <- natPacket{data: append([]byte(nil), buf[:n]...), addr: addr}:
+		case <-this.closed:
+			return
+		}
+	}
+}
+
+func (this *natConn) filter(c *net.UDPConn, addr *net.UDPAddr) bool {
+	if len(this.allow) > 0 {
+		for _, v := range this.allow {
+			if v == addr.String() {
+				return true
+			}
+		}
+		return false
+	}
+	if this.typ == NATFullCone {
+		return true
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.sent[c][addr.String()]
+}
+
+func (this *natConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case v := <-this.in:
+		return copy(p, v.data), v.addr, nil
+	case <-this.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (this *natConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c, err := this.mapping(addr)
+	if err != nil {
+		return 0, err
+	}
+	return c.WriteTo(p, addr)
+}
+
+func (this *natConn) Close() error {
+	this.once.Do(func() {
+		close(this.closed)
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		for _, c := range this.mappings {
+			c.Close()
+		}
+	})
+	return nil
+}
+
+// LocalAddr 内部地址,未指定IP,不作为打洞的地址
+func (this *natConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 1}
+}
+
+func (this *natConn) SetDeadline(t time.Time) error      { return errors.New("不支持") }
+func (this *natConn) SetReadDeadline(t time.Time) error  { return errors.New("不支持") }
+func (this *natConn) SetWriteDeadline(t time.Time) error { return errors.New("不支持") }
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetTimeout(time.Millisecond * 500)
+	t.Cleanup(func() { s.Close() })
+	go s.Run()
+	return s
+}
+
+// serverAddr 中间服务的本地地址
+func serverAddr(s *Server) string {
+	return (&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: s.conn.LocalAddr().(*net.UDPAddr).Port}).String()
+}
+
+func newTestPeer(t *testing.T, conn net.PacketConn) *peer {
+	p, err := NewPeerWithConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Debug(false)
+	p.SetTimeout(time.Millisecond * 300)
+	p.SetPunchTimeout(time.Millisecond * 500)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestDetectNAT(t *testing.T) {
+	s := newTestServer(t)
+	for _, v := range []NATType{NATFullCone, NATRestricted, NATSymmetric} {
+		t.Run(string(v), func(t *testing.T) {
+			p := newTestPeer(t, newNATConn(v))
+			nat, err := p.DetectNAT(serverAddr(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nat != v {
+				t.Fatalf("预期(%s),得到(%s)", v, nat)
+			}
+		})
+	}
+
+	t.Run(string(NATNone), func(t *testing.T) {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := newTestPeer(t, conn)
+		if nat, err := p.DetectNAT(serverAddr(s)); err != nil || nat != NATNone {
+			t.Fatalf("预期(%s),得到(%s): %v", NATNone, nat, err)
+		}
+	})
+
+	t.Run(string(NATBlocked), func(t *testing.T) {
+		p := newTestPeer(t, newNATConn(NATFullCone, "127.0.0.1:1"))
+		if nat, err := p.DetectNAT(serverAddr(s)); err != nil || nat != NATBlocked {
+			t.Fatalf("预期(%s),得到(%s): %v", NATBlocked, nat, err)
+		}
+	})
+}
+
+func TestCanPunch(t *testing.T) {
+	for _, v := range []struct {
+		a, b NATType
+		ok   bool
+	}{
+		{NATNone, NATSymmetric, true},
+		{NATFullCone, NATSymmetric, true},
+		{NATRestricted, NATRestricted, true},
+		{NATSymmetric, NATRestricted, false},
+		{NATSymmetric, NATSymmetric, false},
+		{NATBlocked, NATNone, false},
+		{NATUnknown, NATSymmetric, true},
+	} {
+		if canPunch(v.a, v.b) != v.ok || canPunch(v.b, v.a) != v.ok {
+			t.Errorf("(%s,%s)预期(%v)", v.a, v.b, v.ok)
+		}
+	}
+}
@@ -1,20 +1,21 @@
 package p2p
 
 import (
-	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/injoyai/base/g"
-	"github.com/injoyai/base/maps"
-	"github.com/injoyai/base/maps/wait"
-	"github.com/injoyai/conv"
 	"github.com/injoyai/io"
-	"github.com/injoyai/io/listen"
+	"github.com/injoyai/io/codec"
 	"net"
+	"sync"
 	"time"
 )
 
 var (
 	StartTime = time.Now()
+
+	ErrNotFind = errors.New("节点不存在")
+	errTimeout = errors.New("等待响应超时")
 )
 
 const (
@@ -23,222 +24,540 @@ const (
 	CodeNotFind = 403
 	CodeSuccess = 200
 
+	DefaultTimeout      = time.Second * 2  //默认等待响应的超时时间
+	DefaultPunchTimeout = time.Second * 3  //默认打洞超时时间,超时后使用中间服务转发
+	DefaultKeepAlive    = time.Second * 20 //默认保持NAT映射的间隔,重新注册和发送心跳
+	punchInterval       = time.Millisecond * 50
+
 	TypeError            = "error"            //错误信息
 	TypeRegisterReq      = "registerReq"      //注册自己节点信息
 	TypeRegisterRes      = "registerRes"      //注册自己节点信息
 	TypeGetRegisterReq   = "getRegisterReq"   //获取其他节点信息
 	TypeGetRegisterRes   = "getRegisterRes"   //获取节点信息响应
 	TypeConnectReq       = "connectReq"       //连接其他节点
+	TypeConnectRes       = "connectRes"       //连接其他节点响应,目标节点的信息
 	TypeConnectNoticeReq = "connectNotice"    //连接其他节点通知
 	TypeConnectNoticeRes = "connectNoticeRes" //连接其他节点通知
+	TypeBindingReq       = "bindingReq"       //获取NAT转换后的地址
+	TypeBindingRes       = "bindingRes"       //NAT转换后的地址
+	TypePunchReq         = "punchReq"         //打洞
+	TypePunchRes         = "punchRes"         //打洞响应
+	TypeClose            = "close"            //关闭会话
 )
 
 type Peer interface {
-	LocalAddr() net.Addr    //本地地址
-	Ping(addr string) error //ping下地址,如果协议一直,则有消息返回
-
+	LocalAddr() net.Addr                                                //本地地址
+	Ping(addr string, timeout ...time.Duration) error                   //ping下地址,如果协议一直,则有消息返回
+	Register(addr string) error                                         //向中间服务注册
+	Dial(nodeID string, options ...io.OptionClient) (*io.Client, error) //连接其他节点
 }
 
+// NewPeer 新建节点,监听UDP端口,节点标识默认随机
+// 其他节点发起的会话通过io.Server处理,需要运行Run
 func NewPeer(port int, options ...io.OptionServer) (p *peer, err error) {
-	p = &peer{
-		localAddr: &net.UDPAddr{Port: port},
-		clients:   maps.NewSafe(),
-		wait:      wait.New(time.Second * 2),
-	}
-	p.Server, err = listen.NewUDPServer(port, func(s *io.Server) {
-		s.SetReadFunc(io.ReadWithPkg)
-		s.SetWriteFunc(io.WriteWithPkg)
-		s.SetDealFunc(func(c *io.Client, msg io.Message) {
-
-			m := new(Msg)
-			json.Unmarshal(msg.Bytes(), m)
-
-			switch m.Type {
-
-			case TypeError:
-				//响应的错误信息
-				errMsg := new(MsgError)
-				json.Unmarshal(conv.Bytes(m.Data), errMsg)
-				switch errMsg.Code {
-				case CodeNotFind:
-					s.Tag().Del(TypeRegisterReq + "_" + conv.String(errMsg.Data))
-				}
-				p.wait.Done(m.MsgID, errMsg.Msg)
-
-			case TypeRegisterReq:
-				//上报注册信息
-				registerMsg := new(MsgRegister)
-				json.Unmarshal(conv.Bytes(m.Data), registerMsg)
-				registerMsg.RemoteAddr = c.GetKey()
-				c.SetKey(registerMsg.NodeID)
-				c.Tag().Set("register", registerMsg)
-				s.Tag().Set(TypeRegisterReq+"_"+registerMsg.NodeID, registerMsg)
-
-			case TypeRegisterRes:
-				//响应注册成功
-				p.wait.Done(m.MsgID, nil)
-
-			case TypeGetRegisterReq:
-				//上报获取注册信息
-				getRegisterMsg := new(MsgGetRegister)
-				json.Unmarshal(conv.Bytes(m.Data), getRegisterMsg)
-				registerMsg := new(MsgRegister)
-				v, ok := s.Tag().Get(getRegisterMsg.NodeID)
-				if ok {
-					registerMsg = v.(*MsgRegister)
-				}
-				c.WriteAny(Msg{
-					Type:  TypeRegisterRes,
-					MsgID: m.MsgID,
-					Data:  registerMsg,
-				})
-
-			case TypeGetRegisterRes:
-				//响应注册信息成功
-				registerMsg := new(MsgRegister)
-				json.Unmarshal(conv.Bytes(m.Data), registerMsg)
-				p.wait.Done(m.MsgID, registerMsg)
-
-			case TypeConnectNoticeReq:
-				//连接其他节点
-				connectMsg := new(MsgConnectNotice)
-				json.Unmarshal(conv.Bytes(m.Data), connectMsg)
-				nextPeer := s.GetClient(connectMsg.NodeID)
-				if nextPeer == nil {
-					c.WriteAny(Msg{
-						Type:  TypeError,
-						MsgID: m.MsgID,
-						Data:  CodeNotFind,
-					})
-					return
-				}
-				uuid := g.UUID()
-				nextPeer.WriteAny(Msg{
-					Type:  TypeConnectReq,
-					MsgID: uuid,
-					Data:  c.Tag().Get("register"),
-				})
-				_, err := p.wait.Wait(uuid)
-				if err != nil {
-					c.WriteAny(Msg{
-						Type:  TypeError,
-						MsgID: m.MsgID,
-						Data: MsgError{
-							Code: 0,
-							Data: nil,
-							Msg:  "",
-						},
-					})
-				} else {
-					c.WriteAny(Msg{
-						Type:  TypeConnectNoticeRes,
-						MsgID: m.MsgID,
-					})
-				}
-
-			case TypeConnectNoticeRes:
-				p.wait.Done(m.MsgID, nil)
-
-			case TypeConnectReq:
-				//连接其他节点
-
-			}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	return NewPeerWithConn(conn, options...)
+}
 
-		})
-		s.SetOptions(options...)
-	})
+// NewPeerWithConn 使用自定义的连接新建节点
+func NewPeerWithConn(conn net.PacketConn, options ...io.OptionServer) (p *peer, err error) {
+	p = &peer{
+		NodeID:       g.UUID(),
+		conn:         conn,
+		timeout:      DefaultTimeout,
+		punchTimeout: DefaultPunchTimeout,
+		codec:        codec.JSON,
+		pending:      make(map[string]chan *Msg),
+		sessions:     make(map[string]*session),
+		addrs:        make(map[string]*session),
+		done:         make(chan struct{}),
+	}
+	p.listener = &listener{p: p, ch: make(chan *session, 16)}
+	p.Server, err = io.NewServer(func() (io.Listener, error) { return p.listener, nil }, options...)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	go p.run()
 	return p, nil
 }
 
 type peer struct {
 	*io.Server
-	NodeID    string
-	localAddr *net.UDPAddr
-	clients   *maps.Safe
-	nat       *maps.Safe
-	wait      *wait.Entity
+	NodeID       string
+	conn         net.PacketConn
+	listener     *listener
+	timeout      time.Duration
+	punchTimeout time.Duration
+	mu           sync.Mutex
+	server       *net.UDPAddr         //注册的中间服务
+	nat          NATType              //NAT类型
+	codec        codec.Codec          //发送控制消息的编码
+	pending      map[string]chan *Msg //等待响应,响应可能比等待先到,不使用wait
+	sessions     map[string]*session  //会话,节点标识:会话
+	addrs        map[string]*session  //直连的会话,远程地址:会话
+	keepOnce     sync.Once
+	closeOnce    sync.Once
+	done         chan struct{}
 }
 
-func (this *peer) getClient(addr string) (*io.Client, error) {
-	return this.GetClientOrDial(addr, func(ctx context.Context) (io.ReadWriteCloser, string, error) {
-		c, err := this.Listener().(*listen.UDPServer).NewUDPClient(addr)
-		return c, addr, err
-	})
+// SetNodeID 设置节点标识,需要在注册之前设置
+func (this *peer) SetNodeID(nodeID string) *peer {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.NodeID = nodeID
+	return this
+}
+
+// GetNodeID 获取节点标识
+func (this *peer) GetNodeID() string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.NodeID
+}
+
+// SetCodec 设置控制消息的编码,需要是已注册的,在注册之前设置,例如流量受限时使用codec.CBOR
+func (this *peer) SetCodec(c codec.Codec) *peer {
+	if _, ok := codec.ID(c); ok {
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		this.codec = c
+	}
+	return this
+}
+
+// GetCodec 获取控制消息的编码
+func (this *peer) GetCodec() codec.Codec {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.codec
+}
+
+// SetTimeout 设置等待响应的超时时间
+func (this *peer) SetTimeout(timeout time.Duration) *peer {
+	this.timeout = timeout
+	return this
+}
+
+// SetPunchTimeout 设置打洞超时时间,超时后使用中间服务转发
+func (this *peer) SetPunchTimeout(timeout time.Duration) *peer {
+	this.punchTimeout = timeout
+	return this
 }
 
+func (this *peer) LocalAddr() net.Addr {
+	return this.conn.LocalAddr()
+}
+
+// WriteTo 向地址发送数据
 func (this *peer) WriteTo(addr string, p []byte) (int, error) {
-	c, err := this.getClient(addr)
+	raddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return 0, err
 	}
-	return c.Write(p)
+	if _, err := this.conn.WriteTo(newData(p), raddr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
 func (this *peer) Ping(addr string, timeout ...time.Duration) error {
-	c, err := this.getClient(addr)
+	raddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return err
 	}
-	return c.Ping(timeout...)
+	return this.request(raddr, TypePing, nil, nil, timeout...)
 }
 
-// Register 向服务端注册节点信息
+// Register 向中间服务注册节点信息,未检测NAT类型时先检测,之后定时重新注册,保持NAT映射
 func (this *peer) Register(addr string) error {
-	c, err := this.getClient(addr)
+	server, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return err
 	}
-
-	uuid := g.UUID()
-	_, err = c.WriteAny(Msg{
-		Type:  TypeRegisterReq,
-		MsgID: uuid,
-		Data: MsgRegister{
-			NodeID:     this.NodeID,
-			Version:    Version,
-			StartTime:  StartTime.Unix(),
-			ConnectKey: "",
-			LocalAddr:  this.localAddr.String(),
-		},
-	})
-
-	_, err = this.wait.Wait(uuid)
-	return err
+	if this.NAT() == NATUnknown {
+		if _, err := this.DetectNAT(addr); err != nil {
+			return err
+		}
+	}
+	if err := this.request(server, TypeRegisterReq, this.registerMsg(), &MsgRegister{}); err != nil {
+		return err
+	}
+	this.mu.Lock()
+	this.server = server
+	this.mu.Unlock()
+	this.keepOnce.Do(func() { go this.keepAlive() })
+	return nil
 }
 
+// GetRegister 从中间服务获取节点信息
 func (this *peer) GetRegister(addr string, nodeID string) (*MsgRegister, error) {
-	c, err := this.getClient(addr)
+	server, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
 	}
-
-	uuid := g.UUID()
-	_, err = c.WriteAny(Msg{
-		Type:  TypeGetRegisterReq,
-		MsgID: uuid,
-		Data: MsgGetRegister{
-			NodeID: nodeID,
-		},
-	})
-	if err != nil {
+	res := new(MsgRegister)
+	if err := this.request(server, TypeGetRegisterReq, &MsgGetRegister{NodeID: nodeID}, res); err != nil {
 		return nil, err
 	}
+	return res, nil
+}
 
-	res, err := wait.Wait(uuid)
+// Dial 通过中间服务连接其他节点,双方同时打洞,失败则通过中间服务转发
+// 返回的客户端需要运行Run或者直接读写
+func (this *peer) Dial(nodeID string, options ...io.OptionClient) (*io.Client, error) {
+	server := this.getServer()
+	if server == nil {
+		return nil, errors.New("节点未注册")
+	}
+	//先建立会话,对方收到通知后可能先发送打洞消息
+	s, err := this.newSession(nodeID)
 	if err != nil {
 		return nil, err
 	}
+	info := new(MsgRegister)
+	if err := this.request(server, TypeConnectReq, &MsgConnect{NodeID: nodeID}, info); err != nil {
+		s.close(err)
+		return nil, err
+	}
+	this.punch(s, info)
+	return io.NewClient(s, func(c *io.Client) {
+		c.SetKey(nodeID)
+		c.SetOptions(options...)
+	}), nil
+}
+
+// punch 打洞,同时向对方的公网地址和本地地址发送打洞消息,直到收到对方的消息或超时
+// NAT类型不能打洞或打洞超时则使用中间服务转发
+func (this *peer) punch(s *session, info *MsgRegister) {
+	if !canPunch(this.NAT(), info.NAT) {
+		s.setRelay()
+		return
+	}
+	addrs := []*net.UDPAddr(nil)
+	for _, v := range []string{info.RemoteAddr, info.LocalAddr} {
+		addr, err := net.ResolveUDPAddr("udp", v)
+		if err == nil && addr.IP != nil && !addr.IP.IsUnspecified() && (len(addrs) == 0 || addr.String() != addrs[0].String()) {
+			addrs = append(addrs, addr)
+		}
+	}
+	bs := newMsg(this.GetCodec(), TypePunchReq, "", &MsgPunch{NodeID: this.GetNodeID()})
+	ticker := time.NewTicker(punchInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(this.punchTimeout)
+	defer timer.Stop()
+	for {
+		for _, addr := range addrs {
+			this.conn.WriteTo(bs, addr)
+		}
+		select {
+		case <-s.ready:
+			return
+		case <-timer.C:
+			s.setRelay()
+			return
+		case <-this.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
-	return res.(*MsgRegister), nil
+// request 发送请求,等待响应,res为nil时不解析响应的数据
+func (this *peer) request(addr *net.UDPAddr, Type string, data, res interface{}, timeout ...time.Duration) error {
+	uuid := g.UUID()
+	ch := make(chan *Msg, 1)
+	this.mu.Lock()
+	this.pending[uuid] = ch
+	this.mu.Unlock()
+	defer func() {
+		this.mu.Lock()
+		delete(this.pending, uuid)
+		this.mu.Unlock()
+	}()
+
+	if _, err := this.conn.WriteTo(newMsg(this.GetCodec(), Type, uuid, data), addr); err != nil {
+		return err
+	}
+	t := this.timeout
+	if len(timeout) > 0 {
+		t = timeout[0]
+	}
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case m := <-ch:
+		if m.Type == TypeError {
+			errMsg := new(MsgError)
+			m.decode(errMsg)
+			if errMsg.Code == CodeNotFind {
+				return fmt.Errorf("%w: %s", ErrNotFind, errMsg.Msg)
+			}
+			return errors.New(errMsg.Msg)
+		}
+		if res != nil {
+			return m.decode(res)
+		}
+		return nil
+	case <-timer.C:
+		return errTimeout
+	case <-this.done:
+		return io.ErrReadClosed
+	}
 }
 
-func (this *peer) Connect() {
+// sendTo 向会话的对方发送数据包,直连或通过中间服务转发
+func (this *peer) sendTo(s *session, p []byte) error {
+	s.mu.Lock()
+	relay, addr := s.relay, s.addr
+	s.mu.Unlock()
+	if relay {
+		server := this.getServer()
+		if server == nil {
+			return errors.New("节点未注册")
+		}
+		p, addr = newRelay(s.nodeID, p), server
+	}
+	if addr == nil {
+		return errors.New("会话未建立")
+	}
+	_, err := this.conn.WriteTo(p, addr)
+	return err
+}
 
+func (this *peer) run() {
+	defer this.close()
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := this.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n == 0 {
+			continue
+		}
+		this.deal(udpAddr, "", buf[:n])
+	}
 }
 
-func (this *peer) LocalAddr() net.Addr {
-	return this.localAddr
+// deal 处理收到的数据包,relay是转发的来源节点,直连时为空
+func (this *peer) deal(addr *net.UDPAddr, relay string, p []byte) {
+	switch p[0] {
+	case flagMsg:
+		m, err := decodeMsg(p)
+		if err != nil {
+			return
+		}
+		this.dealMsg(addr, relay, m)
+
+	case flagData:
+		s := this.getSession(addr, relay)
+		if s == nil {
+			return
+		}
+		if len(relay) > 0 {
+			//对方打洞失败使用了转发
+			s.setRelay()
+		}
+		s.push(p[1:])
+
+	case flagRelay:
+		if len(relay) > 0 || !this.isServer(addr) {
+			return
+		}
+		nodeID, data, ok := decodeRelay(p)
+		if ok && len(nodeID) > 0 && len(data) > 0 {
+			this.deal(addr, nodeID, data)
+		}
+	}
+}
+
+func (this *peer) dealMsg(addr *net.UDPAddr, relay string, m *Msg) {
+	switch m.Type {
+	case TypeRegisterRes, TypeGetRegisterRes, TypeConnectRes, TypeBindingRes, TypePong, TypeError:
+		this.mu.Lock()
+		ch := this.pending[m.MsgID]
+		this.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- m:
+			default:
+			}
+		}
+
+	case TypePing:
+		bs := newMsg(this.GetCodec(), TypePong, m.MsgID, nil)
+		if len(relay) > 0 {
+			bs = newRelay(relay, bs)
+		}
+		this.conn.WriteTo(bs, addr)
+
+	case TypeConnectNoticeReq:
+		//其他节点发起连接,同时打洞,结束后交给服务处理
+		if !this.isServer(addr) {
+			return
+		}
+		notice := new(MsgConnectNotice)
+		if err := m.decode(notice); err != nil || notice.Info == nil {
+			return
+		}
+		if s := this.getSession(nil, notice.NodeID); s != nil {
+			s.close(errors.New("重新连接"))
+		}
+		s, err := this.newSession(notice.NodeID)
+		if err != nil {
+			return
+		}
+		this.conn.WriteTo(newMsg(this.GetCodec(), TypeConnectNoticeRes, m.MsgID, nil), addr)
+		go func() {
+			this.punch(s, notice.Info)
+			select {
+			case this.listener.ch <- s:
+			case <-this.done:
+			}
+		}()
+
+	case TypePunchReq, TypePunchRes:
+		if len(relay) > 0 {
+			return
+		}
+		msg := new(MsgPunch)
+		if err := m.decode(msg); err != nil {
+			return
+		}
+		s := this.getSession(nil, msg.NodeID)
+		if s == nil {
+			return
+		}
+		if s.establish(addr) {
+			this.mu.Lock()
+			this.addrs[addr.String()] = s
+			this.mu.Unlock()
+		}
+		if m.Type == TypePunchReq {
+			this.conn.WriteTo(newMsg(this.GetCodec(), TypePunchRes, "", &MsgPunch{NodeID: this.GetNodeID()}), addr)
+		}
+
+	case TypeClose:
+		msg := new(MsgConnect)
+		if err := m.decode(msg); err != nil {
+			return
+		}
+		if s := this.getSession(addr, relay); s != nil && s.nodeID == msg.NodeID {
+			s.close(io.EOF)
+		}
+
+	}
+}
+
+func (this *peer) registerMsg() *MsgRegister {
+	return &MsgRegister{
+		NodeID:    this.GetNodeID(),
+		Version:   Version,
+		StartTime: StartTime.Unix(),
+		LocalAddr: this.conn.LocalAddr().String(),
+		NAT:       this.NAT(),
+		Codec:     this.GetCodec().Name(),
+	}
+}
+
+// keepAlive 定时重新注册和向直连的会话发送心跳,保持NAT映射
+func (this *peer) keepAlive() {
+	t := time.NewTicker(DefaultKeepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-t.C:
+			if server := this.getServer(); server != nil {
+				this.conn.WriteTo(newMsg(this.GetCodec(), TypeRegisterReq, "", this.registerMsg()), server)
+			}
+			this.mu.Lock()
+			addrs := make([]*net.UDPAddr, 0, len(this.addrs))
+			for _, s := range this.addrs {
+				addrs = append(addrs, s.addr)
+			}
+			this.mu.Unlock()
+			for _, addr := range addrs {
+				this.conn.WriteTo(newMsg(this.GetCodec(), TypePing, "", nil), addr)
+			}
+		}
+	}
+}
+
+func (this *peer) getServer() *net.UDPAddr {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.server
+}
+
+func (this *peer) isServer(addr *net.UDPAddr) bool {
+	server := this.getServer()
+	return server != nil && server.String() == addr.String()
+}
+
+// getSession 获取会话,转发时根据来源节点,直连时根据远程地址
+func (this *peer) getSession(addr *net.UDPAddr, relay string) *session {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if len(relay) > 0 {
+		return this.sessions[relay]
+	}
+	if addr == nil {
+		return nil
+	}
+	return this.addrs[addr.String()]
+}
+
+func (this *peer) newSession(nodeID string) (*session, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	select {
+	case <-this.done:
+		return nil, io.ErrReadClosed
+	default:
+	}
+	if _, ok := this.sessions[nodeID]; ok {
+		return nil, fmt.Errorf("节点(%s)已连接", nodeID)
+	}
+	s := newSession(this, nodeID)
+	this.sessions[nodeID] = s
+	return s, nil
+}
+
+func (this *peer) removeSession(s *session) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.sessions[s.nodeID] == s {
+		delete(this.sessions, s.nodeID)
+	}
+	for k, v := range this.addrs {
+		if v == s {
+			delete(this.addrs, k)
+		}
+	}
+}
+
+// close 关闭连接和全部会话
+func (this *peer) close() error {
+	err := error(nil)
+	this.closeOnce.Do(func() {
+		close(this.done)
+		err = this.conn.Close()
+		this.mu.Lock()
+		list := make([]*session, 0, len(this.sessions))
+		for _, s := range this.sessions {
+			list = append(list, s)
+		}
+		this.mu.Unlock()
+		for _, s := range list {
+			s.close(io.ErrReadClosed)
+		}
+	})
+	return err
 }
@@ -1,13 +1,27 @@
 package p2p
 
 import (
+	"errors"
+	"flag"
 	"github.com/injoyai/io"
+	"github.com/injoyai/io/codec"
 	"github.com/injoyai/logs"
+	"net"
 	"testing"
 	"time"
 )
 
+// manual 手动测试,需要公网服务并且会一直运行,使用 go test -run xxx -manual 运行
+var manual = flag.Bool("manual", false, "运行手动测试")
+
+func skipManual(t *testing.T) {
+	if !*manual {
+		t.Skip("手动测试,使用-manual运行")
+	}
+}
+
 func TestNewPeer(t *testing.T) {
+	skipManual(t)
 	remoteAddr1 := "39.107.120.124:20001"
 	remoteAddr2 := "39.107.120.124:20002"
 	p, err := NewPeer(20000)
@@ -21,10 +35,10 @@ func TestNewPeer(t *testing.T) {
 		p.WriteTo(remoteAddr1, []byte("666"))
 		p.WriteTo(remoteAddr2, []byte("666"))
 	}
-	select {}
 }
 
 func TestNewPeer2(t *testing.T) {
+	skipManual(t)
 	p, err := NewPeer(20001)
 	if err != nil {
 		t.Log(err)
@@ -41,6 +55,7 @@ func TestNewPeer2(t *testing.T) {
 }
 
 func TestNewPeer3(t *testing.T) {
+	skipManual(t)
 	remoteAddr1 := "39.107.120.124:20001"
 	remoteAddr2 := "39.107.120.124:20002"
 
@@ -55,5 +70,162 @@ func TestNewPeer3(t *testing.T) {
 		p.WriteTo(remoteAddr1, []byte("666"))
 		p.WriteTo(remoteAddr2, []byte("666"))
 	}
-	select {}
+}
+
+func TestPeerDial(t *testing.T) {
+	s := newTestServer(t)
+	alt := (&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: s.alt.LocalAddr().(*net.UDPAddr).Port}).String()
+	for _, v := range []struct {
+		name  string
+		a, b  *natConn
+		relay bool
+	}{
+		{"完全锥形", newNATConn(NATFullCone), newNATConn(NATFullCone), false},
+		{"限制锥形", newNATConn(NATRestricted), newNATConn(NATRestricted), false},
+		{"对称型和完全锥形", newNATConn(NATSymmetric), newNATConn(NATFullCone), false},
+		{"完全锥形和对称型", newNATConn(NATFullCone), newNATConn(NATSymmetric), false},
+		{"对称型和限制锥形", newNATConn(NATSymmetric), newNATConn(NATRestricted), true},
+		{"对称型", newNATConn(NATSymmetric), newNATConn(NATSymmetric), true},
+		//只能和中间服务通讯,打洞超时后转发
+		{"防火墙", newNATConn(NATRestricted, serverAddr(s), alt), newNATConn(NATRestricted, serverAddr(s), alt), true},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			a, b := newTestPeer(t, v.a), newTestPeer(t, v.b)
+			a.SetNodeID("a-" + v.name)
+			b.SetNodeID("b-" + v.name)
+			closed := make(chan string, 1)
+			b.SetDealFunc(func(c *io.Client, msg io.Message) {
+				c.Write(append([]byte("echo:"), msg...))
+			})
+			b.SetCloseFunc(func(c *io.Client, err error) {
+				closed <- c.GetKey()
+			})
+			go b.Run()
+			for _, p := range []*peer{a, b} {
+				if err := p.Register(serverAddr(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c, err := a.Dial(b.GetNodeID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if relay := c.ReadWriteCloser().(*session).Relay(); relay != v.relay {
+				t.Fatalf("预期转发(%v),得到(%v)", v.relay, relay)
+			}
+			for _, data := range []string{"hello", "world"} {
+				if _, err := c.Write([]byte(data)); err != nil {
+					t.Fatal(err)
+				}
+				result := make(chan string, 1)
+				go func() {
+					bs, _ := c.ReadMessage()
+					result <- string(bs)
+				}()
+				select {
+				case got := <-result:
+					if got != "echo:"+data {
+						t.Fatalf("预期(echo:%s),得到(%s)", data, got)
+					}
+				case <-time.After(time.Second * 3):
+					t.Fatal("等待响应超时")
+				}
+			}
+
+			//关闭后通知对方
+			c.Close()
+			select {
+			case key := <-closed:
+				if key != a.GetNodeID() {
+					t.Fatalf("关闭的节点错误(%s)", key)
+				}
+			case <-time.After(time.Second * 3):
+				t.Fatal("对方未关闭")
+			}
+		})
+	}
+}
+
+// TestPeerCodec 节点使用不同的控制消息编码,按消息中的编码编号解析
+func TestPeerCodec(t *testing.T) {
+	s := newTestServer(t)
+	for _, v := range []struct {
+		name  string
+		a, b  *natConn
+		relay bool
+	}{
+		{"直连", newNATConn(NATFullCone), newNATConn(NATFullCone), false},
+		{"转发", newNATConn(NATSymmetric), newNATConn(NATSymmetric), true},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			a, b := newTestPeer(t, v.a), newTestPeer(t, v.b)
+			a.SetCodec(codec.CBOR)
+			b.SetCodec(codec.MsgPack)
+			b.SetDealFunc(func(c *io.Client, msg io.Message) {
+				c.Write(msg)
+			})
+			go b.Run()
+			for _, p := range []*peer{a, b} {
+				if err := p.Register(serverAddr(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if node := s.GetNode(b.GetNodeID()); node == nil || node.Codec != "msgpack" {
+				t.Fatalf("预期注册的编码(msgpack),得到(%v)", node)
+			}
+
+			c, err := a.Dial(b.GetNodeID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if relay := c.ReadWriteCloser().(*session).Relay(); relay != v.relay {
+				t.Fatalf("预期转发(%v),得到(%v)", v.relay, relay)
+			}
+			if _, err := c.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			result := make(chan string, 1)
+			go func() {
+				bs, _ := c.ReadMessage()
+				result <- string(bs)
+			}()
+			select {
+			case got := <-result:
+				if got != "hello" {
+					t.Fatalf("预期(hello),得到(%s)", got)
+				}
+			case <-time.After(time.Second * 3):
+				t.Fatal("等待响应超时")
+			}
+		})
+	}
+}
+
+func TestPeerDialNotFind(t *testing.T) {
+	s := newTestServer(t)
+	a := newTestPeer(t, newNATConn(NATFullCone))
+	if _, err := a.Dial("b"); err == nil {
+		t.Fatal("未注册,预期错误")
+	}
+	if err := a.Register(serverAddr(s)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Dial("b"); !errors.Is(err, ErrNotFind) {
+		t.Fatalf("预期节点不存在,得到(%v)", err)
+	}
+	if _, err := a.GetRegister(serverAddr(s), "b"); !errors.Is(err, ErrNotFind) {
+		t.Fatalf("预期节点不存在,得到(%v)", err)
+	}
+	info, err := a.GetRegister(serverAddr(s), a.GetNodeID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NAT != NATFullCone || info.RemoteAddr != s.GetNode(a.GetNodeID()).RemoteAddr {
+		t.Fatalf("节点信息错误: %+v", info)
+	}
+	if err := a.Ping(serverAddr(s)); err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,233 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"github.com/injoyai/base/g"
+	"github.com/injoyai/io/codec"
+	"net"
+	"sync"
+	"time"
+)
+
+// NewServer 中间服务,节点注册和交换地址,检测NAT类型,打洞失败时转发节点之间的数据
+// 检测NAT类型需要备用端口,端口为0时使用随机端口
+func NewServer(port, altPort int) (*Server, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	alt, err := net.ListenUDP("udp", &net.UDPAddr{Port: altPort})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Server{
+		conn:    conn,
+		alt:     alt,
+		timeout: time.Second,
+		nodes:   make(map[string]*MsgRegister),
+		addrs:   make(map[string]string),
+		pending: make(map[string]chan *Msg),
+	}, nil
+}
+
+// Server 中间服务
+type Server struct {
+	conn    *net.UDPConn
+	alt     *net.UDPConn //备用端口,检测NAT类型
+	timeout time.Duration
+	mu      sync.RWMutex
+	nodes   map[string]*MsgRegister //注册的节点,节点标识:节点信息
+	addrs   map[string]string       //远程地址:节点标识,转发时确认来源
+	pending map[string]chan *Msg    //等待节点响应连接通知
+}
+
+// SetTimeout 设置等待节点响应连接通知的超时时间
+func (this *Server) SetTimeout(timeout time.Duration) *Server {
+	this.timeout = timeout
+	return this
+}
+
+// Addr 监听的地址
+func (this *Server) Addr() string {
+	return this.conn.LocalAddr().String()
+}
+
+// AltAddr 备用端口监听的地址
+func (this *Server) AltAddr() string {
+	return this.alt.LocalAddr().String()
+}
+
+// GetNode 获取注册的节点信息
+func (this *Server) GetNode(nodeID string) *MsgRegister {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.nodes[nodeID]
+}
+
+// Run 运行,直到关闭
+func (this *Server) Run() error {
+	go this.run(this.alt)
+	return this.run(this.conn)
+}
+
+// Close 关闭服务
+func (this *Server) Close() error {
+	this.alt.Close()
+	return this.conn.Close()
+}
+
+func (this *Server) run(conn *net.UDPConn) error {
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		bs := append([]byte(nil), buf[:n]...)
+		switch bs[0] {
+		case flagMsg:
+			m, err := decodeMsg(bs)
+			if err != nil {
+				continue
+			}
+			this.deal(conn, addr, m)
+		case flagRelay:
+			this.relay(addr, bs)
+		}
+	}
+}
+
+// deal 处理节点的控制消息,使用请求的编码响应,备用端口只响应映射地址
+func (this *Server) deal(conn *net.UDPConn, addr *net.UDPAddr, m *Msg) {
+	if conn == this.alt && m.Type != TypeBindingReq {
+		return
+	}
+	switch m.Type {
+	case TypeBindingReq:
+		req := new(MsgBinding)
+		m.decode(req)
+		if req.ChangePort {
+			conn = this.alt
+		}
+		conn.WriteToUDP(newMsg(m.codec, TypeBindingRes, m.MsgID, &MsgBinding{
+			Addr:    addr.String(),
+			AltPort: this.alt.LocalAddr().(*net.UDPAddr).Port,
+		}), addr)
+
+	case TypeRegisterReq:
+		info := new(MsgRegister)
+		if err := m.decode(info); err != nil || len(info.NodeID) == 0 {
+			this.writeErr(m.codec, addr, m.MsgID, 0, errors.New("无效的节点信息"))
+			return
+		}
+		info.RemoteAddr = addr.String()
+		this.mu.Lock()
+		if old, ok := this.nodes[info.NodeID]; ok {
+			delete(this.addrs, old.RemoteAddr)
+		}
+		this.nodes[info.NodeID] = info
+		this.addrs[info.RemoteAddr] = info.NodeID
+		this.mu.Unlock()
+		this.conn.WriteToUDP(newMsg(m.codec, TypeRegisterRes, m.MsgID, info), addr)
+
+	case TypeGetRegisterReq:
+		req := new(MsgGetRegister)
+		m.decode(req)
+		info := this.GetNode(req.NodeID)
+		if info == nil {
+			this.writeErr(m.codec, addr, m.MsgID, CodeNotFind, fmt.Errorf("节点(%s)不存在", req.NodeID))
+			return
+		}
+		this.conn.WriteToUDP(newMsg(m.codec, TypeGetRegisterRes, m.MsgID, info), addr)
+
+	case TypeConnectReq:
+		//通知目标节点开始打洞,目标节点确认后响应发起方
+		req := new(MsgConnect)
+		m.decode(req)
+		from, to := this.getNodeByAddr(addr), this.GetNode(req.NodeID)
+		switch {
+		case from == nil:
+			this.writeErr(m.codec, addr, m.MsgID, 0, errors.New("节点未注册"))
+		case to == nil:
+			this.writeErr(m.codec, addr, m.MsgID, CodeNotFind, fmt.Errorf("节点(%s)不存在", req.NodeID))
+		default:
+			go this.connect(m.codec, addr, m.MsgID, from, to)
+		}
+
+	case TypeConnectNoticeRes:
+		this.mu.Lock()
+		ch := this.pending[m.MsgID]
+		this.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- m:
+			default:
+			}
+		}
+
+	case TypePing:
+		this.conn.WriteToUDP(newMsg(m.codec, TypePong, m.MsgID, nil), addr)
+
+	}
+}
+
+// connect 通知目标节点,等待确认,c是发起方的编码,通知使用目标节点的编码
+func (this *Server) connect(c codec.Codec, addr *net.UDPAddr, msgID string, from, to *MsgRegister) {
+	toAddr, err := net.ResolveUDPAddr("udp", to.RemoteAddr)
+	if err != nil {
+		this.writeErr(c, addr, msgID, 0, err)
+		return
+	}
+	uuid := g.UUID()
+	ch := make(chan *Msg, 1)
+	this.mu.Lock()
+	this.pending[uuid] = ch
+	this.mu.Unlock()
+	defer func() {
+		this.mu.Lock()
+		delete(this.pending, uuid)
+		this.mu.Unlock()
+	}()
+
+	this.conn.WriteToUDP(newMsg(codec.Get(to.Codec), TypeConnectNoticeReq, uuid, &MsgConnectNotice{NodeID: from.NodeID, Info: from}), toAddr)
+	timer := time.NewTimer(this.timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		this.conn.WriteToUDP(newMsg(c, TypeConnectRes, msgID, to), addr)
+	case <-timer.C:
+		this.writeErr(c, addr, msgID, 0, fmt.Errorf("节点(%s)未响应", to.NodeID))
+	}
+}
+
+// relay 转发节点之间的数据,替换成来源节点的标识
+func (this *Server) relay(addr *net.UDPAddr, p []byte) {
+	nodeID, data, ok := decodeRelay(p)
+	if !ok {
+		return
+	}
+	from, to := this.getNodeByAddr(addr), this.GetNode(nodeID)
+	if from == nil || to == nil {
+		return
+	}
+	toAddr, err := net.ResolveUDPAddr("udp", to.RemoteAddr)
+	if err != nil {
+		return
+	}
+	this.conn.WriteToUDP(newRelay(from.NodeID, data), toAddr)
+}
+
+func (this *Server) getNodeByAddr(addr *net.UDPAddr) *MsgRegister {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.nodes[this.addrs[addr.String()]]
+}
+
+func (this *Server) writeErr(c codec.Codec, addr *net.UDPAddr, msgID string, code int, err error) {
+	this.conn.WriteToUDP(newMsg(c, TypeError, msgID, &MsgError{Code: code, Msg: err.Error()}), addr)
+}
@@ -0,0 +1,195 @@
+package p2p
+
+import (
+	"errors"
+	"github.com/injoyai/io"
+	goio "io"
+	"net"
+	"sync"
+)
+
+// maxData 每次写入的最大字节数,UDP数据包不能超过64KB
+const maxData = 60 << 10
+
+// session 和其他节点的会话,打洞成功后直连,失败则通过中间服务转发
+// 每次读取一个数据包
+type session struct {
+	p      *peer
+	nodeID string
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    [][]byte
+	addr   *net.UDPAddr //直连的远程地址
+	relay  bool         //是否通过中间服务转发
+	ready  chan struct{}
+	closed bool
+	err    error
+}
+
+func newSession(p *peer, nodeID string) *session {
+	s := &session{p: p, nodeID: nodeID, ready: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// NodeID 对方的节点标识
+func (this *session) NodeID() string {
+	return this.nodeID
+}
+
+// Relay 是否通过中间服务转发
+func (this *session) Relay() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.relay
+}
+
+// RemoteAddr 直连的远程地址,转发时是中间服务的地址
+func (this *session) RemoteAddr() net.Addr {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.relay {
+		return this.p.getServer()
+	}
+	return this.addr
+}
+
+// ReadMessage 读取一个数据包,对方关闭后返回EOF
+func (this *session) ReadMessage() ([]byte, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for len(this.buf) == 0 {
+		if this.err != nil {
+			return nil, this.err
+		}
+		this.cond.Wait()
+	}
+	p := this.buf[0]
+	this.buf[0] = nil
+	this.buf = this.buf[1:]
+	return p, nil
+}
+
+// Read 读取数据,数据包超过p的长度时,下次继续读取
+func (this *session) Read(p []byte) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for len(this.buf) == 0 {
+		if this.err != nil {
+			return 0, this.err
+		}
+		this.cond.Wait()
+	}
+	n := copy(p, this.buf[0])
+	if n == len(this.buf[0]) {
+		this.buf[0] = nil
+		this.buf = this.buf[1:]
+	} else {
+		this.buf[0] = this.buf[0][n:]
+	}
+	return n, nil
+}
+
+// Write 写入一个数据包,直连或通过中间服务转发
+func (this *session) Write(p []byte) (int, error) {
+	if len(p) > maxData {
+		return 0, errors.New("数据包过大")
+	}
+	this.mu.Lock()
+	err := this.err
+	this.mu.Unlock()
+	if err != nil {
+		return 0, io.ErrWriteClosed
+	}
+	if err := this.p.sendTo(this, newData(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close 关闭会话,并通知对方
+func (this *session) Close() error {
+	if !this.close(goio.EOF) {
+		return nil
+	}
+	return this.p.sendTo(this, newMsg(this.p.GetCodec(), TypeClose, "", &MsgConnect{NodeID: this.p.GetNodeID()}))
+}
+
+// close 关闭会话,从节点中移除,返回是否是第一次关闭
+func (this *session) close(err error) bool {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return false
+	}
+	this.closed = true
+	this.err = err
+	this.cond.Broadcast()
+	this.mu.Unlock()
+	this.p.removeSession(this)
+	return true
+}
+
+func (this *session) push(p []byte) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.err == nil {
+		this.buf = append(this.buf, append([]byte(nil), p...))
+		this.cond.Broadcast()
+	}
+}
+
+// establish 打洞成功,使用直连地址,返回是否是第一次成功
+func (this *session) establish(addr *net.UDPAddr) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	select {
+	case <-this.ready:
+		return false
+	default:
+	}
+	this.addr = addr
+	close(this.ready)
+	return true
+}
+
+// setRelay 使用中间服务转发,打洞失败或对方已经使用转发
+func (this *session) setRelay() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.relay = true
+	select {
+	case <-this.ready:
+	default:
+		close(this.ready)
+	}
+}
+
+/*
+
+
+
+ */
+
+// listener 接收其他节点发起的会话,用于io.Server
+type listener struct {
+	p  *peer
+	ch chan *session
+}
+
+func (this *listener) Accept() (io.ReadWriteCloser, string, error) {
+	select {
+	case s := <-this.ch:
+		return s, s.nodeID, nil
+	case <-this.p.done:
+		return nil, "", io.ErrReadClosed
+	}
+}
+
+func (this *listener) Close() error {
+	return this.p.close()
+}
+
+func (this *listener) Addr() string {
+	return this.p.conn.LocalAddr().String()
+}
@@ -0,0 +1,117 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+
+	protocolTCP = 6
+	protocolUDP = 17
+
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+var (
+	macLocal  = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	macRemote = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// encodeEthernet 生成模拟的以太网/IP/TCP(UDP)帧
+func encodeEthernet(srcIP, dstIP net.IP, srcPort, dstPort uint16, udp bool, seq, ack uint32, read bool, p []byte) []byte {
+	//传输层
+	var segment []byte
+	protocol := uint8(protocolTCP)
+	if udp {
+		protocol = protocolUDP
+		segment = make([]byte, 8+len(p))
+		binary.BigEndian.PutUint16(segment[0:2], srcPort)
+		binary.BigEndian.PutUint16(segment[2:4], dstPort)
+		binary.BigEndian.PutUint16(segment[4:6], uint16(len(segment)))
+		copy(segment[8:], p)
+	} else {
+		segment = make([]byte, 20+len(p))
+		binary.BigEndian.PutUint16(segment[0:2], srcPort)
+		binary.BigEndian.PutUint16(segment[2:4], dstPort)
+		binary.BigEndian.PutUint32(segment[4:8], seq)
+		binary.BigEndian.PutUint32(segment[8:12], ack)
+		segment[12] = 5 << 4
+		segment[13] = tcpFlagPSH | tcpFlagACK
+		binary.BigEndian.PutUint16(segment[14:16], 0xFFFF)
+		copy(segment[20:], p)
+	}
+
+	//网络层
+	var packet []byte
+	etherType := uint16(etherTypeIPv4)
+	src4, dst4 := srcIP.To4(), dstIP.To4()
+	if src4 != nil && dst4 != nil {
+		packet = make([]byte, 20, 20+len(segment))
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:4], uint16(20+len(segment)))
+		packet[8] = 64
+		packet[9] = protocol
+		copy(packet[12:16], src4)
+		copy(packet[16:20], dst4)
+		binary.BigEndian.PutUint16(packet[10:12], checksum(packet, 0))
+		pseudo := append(append([]byte{}, src4...), dst4...)
+		pseudo = append(pseudo, 0, protocol, byte(len(segment)>>8), byte(len(segment)))
+		setChecksum(segment, udp, pseudo)
+	} else {
+		etherType = etherTypeIPv6
+		packet = make([]byte, 40, 40+len(segment))
+		packet[0] = 0x60
+		binary.BigEndian.PutUint16(packet[4:6], uint16(len(segment)))
+		packet[6] = protocol
+		packet[7] = 64
+		copy(packet[8:24], srcIP.To16())
+		copy(packet[24:40], dstIP.To16())
+		pseudo := append(append([]byte{}, packet[8:40]...), 0, 0, byte(len(segment)>>8), byte(len(segment)), 0, 0, 0, protocol)
+		setChecksum(segment, udp, pseudo)
+	}
+	packet = append(packet, segment...)
+
+	//链路层
+	frame := make([]byte, 14, 14+len(packet))
+	if read {
+		copy(frame[0:6], macLocal)
+		copy(frame[6:12], macRemote)
+	} else {
+		copy(frame[0:6], macRemote)
+		copy(frame[6:12], macLocal)
+	}
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+	return append(frame, packet...)
+}
+
+// setChecksum 计算TCP/UDP的校验和
+func setChecksum(segment []byte, udp bool, pseudo []byte) {
+	sum := checksum(segment, checksum(pseudo, 0)^0xFFFF)
+	if udp {
+		if sum == 0 {
+			sum = 0xFFFF
+		}
+		binary.BigEndian.PutUint16(segment[6:8], sum)
+		return
+	}
+	binary.BigEndian.PutUint16(segment[16:18], sum)
+}
+
+// checksum 计算互联网校验和,init为之前的累加值
+func checksum(bs []byte, init uint16) uint16 {
+	sum := uint32(init)
+	for i := 0; i+1 < len(bs); i += 2 {
+		sum += uint32(bs[i])<<8 | uint32(bs[i+1])
+	}
+	if len(bs)%2 == 1 {
+		sum += uint32(bs[len(bs)-1]) << 8
+	}
+	for sum>>16 > 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,256 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/injoyai/io"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+
+pcapng格式,可以用Wireshark打开
+
+文件头(SHB),接口描述(IDB)x2,数据包(EPB)...
+接口0: 以太网,网络连接的数据,生成模拟的以太网/IP/TCP(UDP)帧
+接口1: LINKTYPE_USER0,串口,MQTT等非网络连接的数据,注释中记录客户端标识和方向
+
+*/
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	LinkTypeEthernet = 1
+	LinkTypeUser0    = 147
+
+	interfaceEthernet = 0
+	interfaceUser0    = 1
+
+	optEndOfOpt = 0
+	optComment  = 1
+
+	snapLen = 0x40000
+
+	//单个模拟帧的最大数据长度,超过则分成多个帧
+	maxSegment = 0xFFFF - 60
+
+	TagLocalAddr  = "pcapng_local_addr"  //本地地址,非网络连接时可以通过标签指定,值为net.Addr或者字符串(TCP)
+	TagRemoteAddr = "pcapng_remote_addr" //远程地址,非网络连接时可以通过标签指定,值为net.Addr或者字符串(TCP)
+)
+
+// NewWriter 新建pcapng写入,写入文件头和接口描述
+func NewWriter(w io.Writer) (*Writer, error) {
+	this := &Writer{w: w, seq: make(map[*io.Client]*[2]uint32)}
+	this.recorder = io.NewRecorderWithFunc(func(c *io.Client, Type string, p []byte) {
+		_ = this.Capture(c, Type == io.RecordRead, p)
+	})
+	if err := this.writeHeader(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+// Writer 记录客户端的收发数据为pcapng格式
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	seq map[*io.Client]*[2]uint32 //TCP序号,0为发送,1为接收
+
+	recorder *io.Recorder
+}
+
+// Option 客户端选项,记录客户端的原始收发数据(封装后的数据),收发方向在同一层,
+// 通过记录器(SetRecorder)实现,会替换客户端已设置的记录器
+func (this *Writer) Option(c *io.Client) {
+	c.SetRecorder(this.recorder)
+}
+
+// Close 关闭,如果是io.Closer则关闭
+func (this *Writer) Close() error {
+	if c, ok := this.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Capture 记录一次收发数据,read表示是否是接收的数据
+func (this *Writer) Capture(c *io.Client, read bool, p []byte) error {
+	local, remote := addr(c)
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if local == nil || remote == nil {
+		comment := fmt.Sprintf("%s %s", c.GetKey(), direction(read))
+		return this.writePacket(interfaceUser0, time.Now(), p, comment)
+	}
+	src, dst := local, remote
+	if read {
+		src, dst = remote, local
+	}
+	for first := true; first || len(p) > 0; first = false {
+		n := len(p)
+		if n > maxSegment {
+			n = maxSegment
+		}
+		frame := this.frame(c, read, src, dst, p[:n])
+		if err := this.writePacket(interfaceEthernet, time.Now(), frame, ""); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// frame 生成模拟的以太网帧
+func (this *Writer) frame(c *io.Client, read bool, src, dst net.Addr, p []byte) []byte {
+	srcIP, srcPort, udp := splitAddr(src)
+	dstIP, dstPort, _ := splitAddr(dst)
+	var seq, ack uint32
+	if !udp {
+		s, ok := this.seq[c]
+		if !ok {
+			s = &[2]uint32{1, 1}
+			this.seq[c] = s
+			//连接断开后重新计算序号
+			go func(done <-chan struct{}) {
+				<-done
+				this.mu.Lock()
+				delete(this.seq, c)
+				this.mu.Unlock()
+			}(c.Done())
+		}
+		i := 0
+		if read {
+			i = 1
+		}
+		seq, ack = s[i], s[1-i]
+		s[i] += uint32(len(p))
+	}
+	return encodeEthernet(srcIP, dstIP, srcPort, dstPort, udp, seq, ack, read, p)
+}
+
+func (this *Writer) writeHeader() error {
+	//SHB,长度不限
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint16(shb[6:8], 0)
+	binary.LittleEndian.PutUint64(shb[8:16], 0xFFFFFFFFFFFFFFFF)
+	if err := this.writeBlock(blockSHB, shb); err != nil {
+		return err
+	}
+	for _, linkType := range []uint16{LinkTypeEthernet, LinkTypeUser0} {
+		idb := make([]byte, 8)
+		binary.LittleEndian.PutUint16(idb[0:2], linkType)
+		binary.LittleEndian.PutUint32(idb[4:8], snapLen)
+		if err := this.writeBlock(blockIDB, idb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Writer) writePacket(iface uint32, t time.Time, p []byte, comment string) error {
+	ts := uint64(t.UnixNano() / 1e3)
+	body := make([]byte, 20, 20+len(p)+len(comment)+16)
+	binary.LittleEndian.PutUint32(body[0:4], iface)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(p)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(p)))
+	body = append(body, pad(p)...)
+	if len(comment) > 0 {
+		body = appendOption(body, optComment, []byte(comment))
+		body = appendOption(body, optEndOfOpt, nil)
+	}
+	return this.writeBlock(blockEPB, body)
+}
+
+func (this *Writer) writeBlock(Type uint32, body []byte) error {
+	length := 12 + len(body)
+	bs := make([]byte, length)
+	binary.LittleEndian.PutUint32(bs[0:4], Type)
+	binary.LittleEndian.PutUint32(bs[4:8], uint32(length))
+	copy(bs[8:], body)
+	binary.LittleEndian.PutUint32(bs[length-4:], uint32(length))
+	_, err := this.w.Write(bs)
+	return err
+}
+
+func appendOption(bs []byte, code uint16, value []byte) []byte {
+	head := make([]byte, 4)
+	binary.LittleEndian.PutUint16(head[0:2], code)
+	binary.LittleEndian.PutUint16(head[2:4], uint16(len(value)))
+	return append(append(bs, head...), pad(value)...)
+}
+
+// pad 补齐4字节
+func pad(p []byte) []byte {
+	if n := len(p) % 4; n > 0 {
+		return append(append([]byte(nil), p...), make([]byte, 4-n)...)
+	}
+	return p
+}
+
+func direction(read bool) string {
+	if read {
+		return "read"
+	}
+	return "write"
+}
+
+// addr 获取客户端的本地和远程地址,优先使用网络连接,其次使用标签
+func addr(c *io.Client) (local, remote net.Addr) {
+	if conn, ok := c.NetConn(); ok {
+		local, remote = conn.LocalAddr(), conn.RemoteAddr()
+	} else {
+		local, remote = tagAddr(c, TagLocalAddr), tagAddr(c, TagRemoteAddr)
+	}
+	if !validAddr(local) || !validAddr(remote) {
+		return nil, nil
+	}
+	return
+}
+
+func tagAddr(c *io.Client, key string) net.Addr {
+	v, ok := c.Tag().Get(key)
+	if !ok {
+		return nil
+	}
+	switch val := v.(type) {
+	case net.Addr:
+		return val
+	case string:
+		a, err := net.ResolveTCPAddr("tcp", val)
+		if err != nil {
+			return nil
+		}
+		return a
+	}
+	return nil
+}
+
+func validAddr(a net.Addr) bool {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		return v != nil && v.IP != nil
+	case *net.UDPAddr:
+		return v != nil && v.IP != nil
+	}
+	return false
+}
+
+func splitAddr(a net.Addr) (ip net.IP, port uint16, udp bool) {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		return v.IP, uint16(v.Port), false
+	case *net.UDPAddr:
+		return v.IP, uint16(v.Port), true
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,244 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/injoyai/io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPacket struct {
+	iface   uint32
+	data    []byte
+	comment string
+}
+
+// readPcapng 简易的pcapng解析,返回接口类型和数据包
+func readPcapng(bs []byte) (linkTypes []uint16, packets []testPacket, err error) {
+	for len(bs) > 0 {
+		if len(bs) < 12 {
+			return nil, nil, errors.New("块长度错误")
+		}
+		Type := binary.LittleEndian.Uint32(bs[0:4])
+		length := binary.LittleEndian.Uint32(bs[4:8])
+		if length%4 != 0 || int(length) > len(bs) || binary.LittleEndian.Uint32(bs[length-4:length]) != length {
+			return nil, nil, errors.New("块长度错误")
+		}
+		body := bs[8 : length-4]
+		switch Type {
+		case blockSHB:
+			if binary.LittleEndian.Uint32(body[0:4]) != byteOrderMagic {
+				return nil, nil, errors.New("字节序错误")
+			}
+		case blockIDB:
+			linkTypes = append(linkTypes, binary.LittleEndian.Uint16(body[0:2]))
+		case blockEPB:
+			capLen := binary.LittleEndian.Uint32(body[12:16])
+			p := testPacket{
+				iface: binary.LittleEndian.Uint32(body[0:4]),
+				data:  body[20 : 20+capLen],
+			}
+			opts := body[20+(capLen+3)/4*4:]
+			for len(opts) >= 4 {
+				code := binary.LittleEndian.Uint16(opts[0:2])
+				n := binary.LittleEndian.Uint16(opts[2:4])
+				if code == optEndOfOpt {
+					break
+				}
+				if code == optComment {
+					p.comment = string(opts[4 : 4+n])
+				}
+				opts = opts[4+(n+3)/4*4:]
+			}
+			if int(p.iface) >= len(linkTypes) {
+				return nil, nil, errors.New("接口不存在")
+			}
+			packets = append(packets, p)
+		default:
+			return nil, nil, errors.New("未知块类型")
+		}
+		bs = bs[length:]
+	}
+	return
+}
+
+func TestWriterUser0(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := net.Pipe()
+	a := io.NewClient(c1, func(c *io.Client) {
+		c.Debug(false)
+		c.SetKey("COM1")
+		c.SetOptions(w.Option)
+	})
+	defer a.CloseAll()
+	b := io.NewClient(c2, func(c *io.Client) { c.Debug(false) })
+	defer b.CloseAll()
+	go b.Run()
+	if _, err := a.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	linkTypes, packets, err := readPcapng(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(linkTypes) != 2 || linkTypes[interfaceUser0] != LinkTypeUser0 {
+		t.Fatalf("接口错误: %v", linkTypes)
+	}
+	if len(packets) != 1 {
+		t.Fatalf("预期1个数据包,得到(%d)", len(packets))
+	}
+	p := packets[0]
+	if p.iface != interfaceUser0 || string(p.data) != "hello" || p.comment != "COM1 write" {
+		t.Fatalf("数据包错误: %#v", p)
+	}
+}
+
+func TestWriterTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		bs := make([]byte, 5)
+		c.Read(bs)
+		c.Write([]byte("world"))
+		time.Sleep(time.Second)
+	}()
+
+	buf := bytes.NewBuffer(nil)
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan struct{})
+	c := io.NewClient(conn, func(c *io.Client) {
+		c.Debug(false)
+		c.SetOptions(w.Option)
+		c.SetDealFunc(func(c *io.Client, msg io.Message) { close(received) })
+	})
+	defer c.CloseAll()
+	go c.Run()
+	c.WriteString("hello")
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("未收到数据")
+	}
+
+	_, packets, err := readPcapng(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("预期2个数据包,得到(%d)", len(packets))
+	}
+	local := conn.LocalAddr().(*net.TCPAddr)
+	for i, expect := range []string{"hello", "world"} {
+		p := packets[i]
+		if p.iface != interfaceEthernet {
+			t.Fatalf("预期以太网接口,得到(%d)", p.iface)
+		}
+		frame := p.data
+		if binary.BigEndian.Uint16(frame[12:14]) != etherTypeIPv4 {
+			t.Fatal("预期IPv4")
+		}
+		ip := frame[14:34]
+		if checksum(ip, 0) != 0 {
+			t.Error("IP校验和错误")
+		}
+		segment := frame[34:]
+		pseudo := append(append([]byte{}, ip[12:20]...), 0, protocolTCP, byte(len(segment)>>8), byte(len(segment)))
+		if checksum(segment, checksum(pseudo, 0)^0xFFFF) != 0 {
+			t.Error("TCP校验和错误")
+		}
+		port := binary.BigEndian.Uint16(segment[0:2])
+		if i == 1 {
+			port = binary.BigEndian.Uint16(segment[2:4])
+		}
+		if int(port) != local.Port {
+			t.Errorf("端口错误,预期(%d),得到(%d)", local.Port, port)
+		}
+		if string(segment[20:]) != expect || strings.Contains(p.comment, "write") {
+			t.Errorf("数据错误: %s", segment[20:])
+		}
+	}
+	//应答序号为对方已发送的数据
+	if seq := binary.BigEndian.Uint32(packets[1].data[34+4:]); seq != 1 {
+		t.Errorf("序号错误(%d)", seq)
+	}
+	if ack := binary.BigEndian.Uint32(packets[1].data[34+8:]); ack != 6 {
+		t.Errorf("应答序号错误(%d)", ack)
+	}
+}
+
+// TestWriterPkg 收发都记录封装后的原始数据,和选项的设置顺序无关
+func TestWriterPkg(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := net.Pipe()
+	received := make(chan struct{})
+	a := io.NewClient(c1, func(c *io.Client) {
+		c.Debug(false)
+		c.SetKey("COM1")
+		c.SetOptions(w.Option)
+		c.SetReadWriteWithPkg()
+		c.SetDealFunc(func(c *io.Client, msg io.Message) { close(received) })
+	})
+	defer a.CloseAll()
+	b := io.NewClient(c2, func(c *io.Client) {
+		c.Debug(false)
+		c.SetReadWriteWithPkg()
+		c.SetDealFunc(func(c *io.Client, msg io.Message) { c.Write(msg) })
+	})
+	defer b.CloseAll()
+	go a.Run()
+	go b.Run()
+	if _, err := a.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("未收到数据")
+	}
+
+	_, packets, err := readPcapng(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("预期2个数据包,得到(%d)", len(packets))
+	}
+	for i, comment := range []string{"COM1 write", "COM1 read"} {
+		p := packets[i]
+		pkg, err := io.DecodePkg(p.data)
+		if err != nil {
+			t.Fatalf("%s预期封装后的数据,得到(%x): %v", comment, p.data, err)
+		}
+		if p.comment != comment || string(pkg.Data) != "hello" {
+			t.Errorf("数据包错误: %s %s", p.comment, pkg.Data)
+		}
+	}
+}
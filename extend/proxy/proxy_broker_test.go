@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	goio "io"
+	"net"
+	"sync"
+	"testing"
+)
+
+/*
+
+测试用的MQTT(3.1.1)和RabbitMQ(AMQP 0-9-1)服务,只实现了测试需要的部分
+
+*/
+
+// newListener 本地监听,每个连接执行fn
+func newListener(t *testing.T, fn func(c net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				fn(c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+//================================MQTT================================
+
+// mqttBroker 只支持QoS0的转发,主题需要完全匹配
+type mqttBroker struct {
+	mu   sync.Mutex
+	subs map[string][]net.Conn
+}
+
+func newMQTTBroker(t *testing.T) string {
+	b := &mqttBroker{subs: map[string][]net.Conn{}}
+	return newListener(t, b.serve)
+}
+
+func (this *mqttBroker) serve(c net.Conn) {
+	r := bufio.NewReader(c)
+	for {
+		head, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch head >> 4 {
+		case 1: //CONNECT
+			c.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: //PUBLISH
+			n := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+n])
+			payload := body[2+n:]
+			if qos := head >> 1 & 0x03; qos > 0 {
+				c.Write([]byte{0x40, 0x02, payload[0], payload[1]})
+				payload = payload[2:]
+			}
+			this.publish(topic, payload)
+		case 8: //SUBSCRIBE
+			ack := []byte{body[0], body[1]}
+			for i := 2; i < len(body); {
+				n := int(binary.BigEndian.Uint16(body[i:]))
+				this.mu.Lock()
+				this.subs[string(body[i+2:i+2+n])] = append(this.subs[string(body[i+2:i+2+n])], c)
+				this.mu.Unlock()
+				ack = append(ack, 0x00)
+				i += 3 + n
+			}
+			c.Write(append([]byte{0x90, byte(len(ack))}, ack...))
+		case 10: //UNSUBSCRIBE
+			c.Write([]byte{0xB0, 0x02, body[0], body[1]})
+		case 12: //PINGREQ
+			c.Write([]byte{0xD0, 0x00})
+		case 14: //DISCONNECT
+			return
+		}
+	}
+}
+
+func (this *mqttBroker) publish(topic string, payload []byte) {
+	body := append([]byte{byte(len(topic) >> 8), byte(len(topic))}, topic...)
+	body = append(body, payload...)
+	packet := append([]byte{0x30}, mqttLength(len(body))...)
+	packet = append(packet, body...)
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, c := range this.subs[topic] {
+		c.Write(packet)
+	}
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	head, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = goio.ReadFull(r, body)
+	return head, body, err
+}
+
+func mqttLength(n int) (bs []byte) {
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		bs = append(bs, b)
+		if n == 0 {
+			return
+		}
+	}
+}
+
+//================================RabbitMQ================================
+
+// amqpBroker 发布到队列的消息投递给订阅该队列的消费者,没有消费者时丢弃
+type amqpBroker struct {
+	mu     sync.Mutex
+	queues map[string]*amqpConsumer
+}
+
+// amqpConsumer 队列的消费者
+type amqpConsumer struct {
+	w        *amqpWriter
+	channel  uint16
+	tag      string
+	delivery uint64
+}
+
+func newAMQPBroker(t *testing.T) string {
+	b := &amqpBroker{queues: map[string]*amqpConsumer{}}
+	return newListener(t, b.serve)
+}
+
+func (this *amqpBroker) serve(c net.Conn) {
+	r := bufio.NewReader(c)
+	if _, err := goio.ReadFull(r, make([]byte, 8)); err != nil {
+		return
+	}
+	w := &amqpWriter{c: c}
+	defer func() {
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		for k, v := range this.queues {
+			if v.w == w {
+				delete(this.queues, k)
+			}
+		}
+	}()
+	//connection.start: 版本0-9,服务属性,认证方式,语言
+	w.method(0, 10, 10, []byte{0, 9, 0, 0, 0, 0}, amqpLongStr("PLAIN"), amqpLongStr("en_US"))
+
+	//正在发布的消息的消费者,内容帧转发给该消费者
+	var target *amqpConsumer
+	for {
+		typ, channel, payload, err := readAMQPFrame(r)
+		if err != nil {
+			return
+		}
+		if typ != 1 {
+			if (typ == 2 || typ == 3) && target != nil {
+				target.w.frame(typ, target.channel, payload)
+			}
+			continue
+		}
+		class, method, args := binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:]), payload[4:]
+		switch {
+		case class == 10 && method == 11: //connection.start-ok
+			//connection.tune: channel-max,frame-max,heartbeat
+			w.method(0, 10, 30, []byte{0x07, 0xFF, 0, 2, 0, 0, 0, 0})
+		case class == 10 && method == 40: //connection.open
+			w.method(0, 10, 41, amqpShortStr(""))
+		case class == 10 && method == 50: //connection.close
+			w.method(0, 10, 51)
+			return
+		case class == 20 && method == 10: //channel.open
+			w.method(channel, 20, 11, amqpLongStr(""))
+		case class == 20 && method == 40: //channel.close
+			w.method(channel, 20, 41)
+		case class == 50 && method == 10: //queue.declare
+			name, _ := readAMQPShortStr(args[2:])
+			w.method(channel, 50, 11, amqpShortStr(name), make([]byte, 8))
+		case class == 60 && method == 20: //basic.consume
+			queue, n := readAMQPShortStr(args[2:])
+			tag, _ := readAMQPShortStr(args[2+n:])
+			this.mu.Lock()
+			this.queues[queue] = &amqpConsumer{w: w, channel: channel, tag: tag}
+			this.mu.Unlock()
+			w.method(channel, 60, 21, amqpShortStr(tag))
+		case class == 60 && method == 40: //basic.publish,投递给队列的消费者
+			exchange, n := readAMQPShortStr(args[2:])
+			key, _ := readAMQPShortStr(args[2+n:])
+			this.mu.Lock()
+			target = this.queues[key]
+			if target != nil {
+				target.delivery++
+				tag := make([]byte, 8)
+				binary.BigEndian.PutUint64(tag, target.delivery)
+				target.w.method(target.channel, 60, 60, amqpShortStr(target.tag), tag, []byte{0}, amqpShortStr(exchange), amqpShortStr(key))
+			}
+			this.mu.Unlock()
+		}
+	}
+}
+
+type amqpWriter struct {
+	mu sync.Mutex
+	c  net.Conn
+}
+
+func (this *amqpWriter) method(channel, class, method uint16, args ...[]byte) {
+	payload := []byte{byte(class >> 8), byte(class), byte(method >> 8), byte(method)}
+	for _, v := range args {
+		payload = append(payload, v...)
+	}
+	this.frame(1, channel, payload)
+}
+
+func (this *amqpWriter) frame(typ byte, channel uint16, payload []byte) {
+	bs := []byte{typ, byte(channel >> 8), byte(channel), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(bs[3:], uint32(len(payload)))
+	bs = append(bs, payload...)
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.c.Write(append(bs, 0xCE))
+}
+
+func readAMQPFrame(r *bufio.Reader) (byte, uint16, []byte, error) {
+	head := make([]byte, 7)
+	if _, err := goio.ReadFull(r, head); err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(head[3:])+1)
+	if _, err := goio.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return head[0], binary.BigEndian.Uint16(head[1:]), payload[:len(payload)-1], nil
+}
+
+func readAMQPShortStr(bs []byte) (string, int) {
+	n := int(bs[0])
+	return string(bs[1 : 1+n]), 1 + n
+}
+
+func amqpShortStr(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func amqpLongStr(s string) []byte {
+	bs := make([]byte, 4)
+	binary.BigEndian.PutUint32(bs, uint32(len(s)))
+	return append(bs, s...)
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/dial"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewPortForwardingClient 端口转发客户端,一直连接直到注册成功,断开后自动重连并重新注册
+func NewPortForwardingClient(cfg *PortConfig, options ...func(c *PortForwardingClient)) *PortForwardingClient {
+	cli := &PortForwardingClient{
+		cfg:   cfg,
+		stats: make(map[string]*portStat),
+	}
+	for _, m := range cfg.Mappings {
+		cli.stats[m.Name] = new(portStat)
+	}
+	cli.allow = cli.allowMapping
+	once := sync.Once{}
+	io.Redial(cli.dial, func(c *io.Client) {
+		once.Do(func() { cli.Client = c })
+		for _, v := range options {
+			v(cli)
+		}
+		m := io.NewMuxClient(c)
+		go cli.accept(m)
+	})
+	return cli
+}
+
+// PortForwardingClient 端口转发客户端,在内网运行,把服务端转发的连接转发到本地地址
+type PortForwardingClient struct {
+	*io.Client
+	cfg         *PortConfig
+	allow       func(m *PortMapping) bool
+	mu          sync.Mutex
+	stats       map[string]*portStat //连接统计,名称:统计
+	result      *PortRegisterResult  //最后一次注册的结果
+	connectTime time.Time            //最后一次注册成功的时间
+}
+
+// SetAllow 设置允许的端口映射,默认只允许配置中的映射,服务端打开的其他映射(Listen)需要允许
+// 在选项中设置,每次重连都会执行选项
+func (this *PortForwardingClient) SetAllow(fn func(m *PortMapping) bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.allow = fn
+}
+
+// PortClientStatus 客户端状态
+type PortClientStatus struct {
+	SN          string        `json:"sn"`          //客户端编号
+	Address     string        `json:"address"`     //服务端地址
+	Connected   bool          `json:"connected"`   //是否已连接
+	ConnectTime time.Time     `json:"connectTime"` //最后一次注册成功的时间
+	Ports       []*PortStatus `json:"ports"`       //端口映射,端口是服务端监听的端口
+}
+
+// Status 客户端状态
+func (this *PortForwardingClient) Status() *PortClientStatus {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	s := &PortClientStatus{
+		SN:          this.cfg.SN,
+		Address:     this.cfg.Address,
+		Connected:   !this.Client.Closed(),
+		ConnectTime: this.connectTime,
+	}
+	for name, stat := range this.stats {
+		m := &PortMapping{Name: name}
+		for _, v := range this.cfg.Mappings {
+			if v.Name == name {
+				m = v
+			}
+		}
+		ps := stat.status(m)
+		if this.result != nil {
+			ps.Port = this.result.Ports[name]
+			ps.Err = this.result.Errors[name]
+		}
+		s.Ports = append(s.Ports, ps)
+	}
+	sort.Slice(s.Ports, func(i, j int) bool { return s.Ports[i].Name < s.Ports[j].Name })
+	return s
+}
+
+// dial 连接服务端并注册,注册失败会重试
+func (this *PortForwardingClient) dial(ctx context.Context) (io.ReadWriteCloser, string, error) {
+	i, _, err := dial.TCPContext(ctx, this.cfg.Address, io.DefaultConnectTimeout)
+	if err != nil {
+		return nil, "", err
+	}
+	if this.cfg.Cipher != nil {
+		i = io.NewCipherClient(i, this.cfg.Cipher)
+	}
+	conn, _ := i.(net.Conn)
+	setReadDeadline(conn, io.DefaultConnectTimeout)
+	result := new(PortRegisterResult)
+	if err = writePortFrame(i, &this.cfg.PortRegister); err == nil {
+		err = readPortFrame(i, result)
+	}
+	if err == nil && len(result.Err) > 0 {
+		err = errors.New(result.Err)
+	}
+	if err != nil {
+		i.Close()
+		return nil, this.cfg.SN, fmt.Errorf("注册失败: %v", err)
+	}
+	setReadDeadline(conn, 0)
+	this.mu.Lock()
+	this.result = result
+	this.connectTime = time.Now()
+	this.mu.Unlock()
+	return i, this.cfg.SN, nil
+}
+
+func (this *PortForwardingClient) allowMapping(m *PortMapping) bool {
+	for _, v := range this.cfg.Mappings {
+		if v.Name == m.Name && v.network() == m.network() && v.Local == m.Local {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *PortForwardingClient) stat(name string) *portStat {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	s, ok := this.stats[name]
+	if !ok {
+		s = new(portStat)
+		this.stats[name] = s
+	}
+	return s
+}
+
+// accept 接收服务端打开的流,直到连接断开
+func (this *PortForwardingClient) accept(m *io.Mux) {
+	for {
+		stream, err := m.AcceptStream()
+		if err != nil {
+			return
+		}
+		go this.dealStream(m.Client(), stream)
+	}
+}
+
+func (this *PortForwardingClient) dealStream(c *io.Client, stream *io.Stream) {
+	mapping := new(PortMapping)
+	if err := readPortFrame(stream, mapping); err != nil {
+		stream.Close()
+		return
+	}
+	this.mu.Lock()
+	allow := this.allow
+	this.mu.Unlock()
+	if !allow(mapping) {
+		c.Errorf("[%s] 拒绝端口映射(%s): %s\n", c.GetKey(), mapping.Name, mapping.Local)
+		stream.Close()
+		return
+	}
+	conn, err := net.DialTimeout(mapping.network(), mapping.Local, io.DefaultConnectTimeout)
+	if err != nil {
+		c.Errorf("[%s] 连接本地地址(%s)失败: %v\n", c.GetKey(), mapping.Local, err)
+		stream.Close()
+		return
+	}
+	stat := this.stat(mapping.Name)
+	if mapping.network() == PortUDP {
+		portPipeUDP(stat, conn, stream)
+		return
+	}
+	portPipe(stat, conn, stream)
+}
+
+// portPipeUDP 本地UDP连接和流交换数据,流中每个数据包加上了长度,空闲超时后关闭
+func portPipeUDP(stat *portStat, conn net.Conn, stream *io.Stream) {
+	stat.open()
+	defer stat.close()
+	defer conn.Close()
+	defer stream.Close()
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 64<<10)
+		for {
+			conn.SetReadDeadline(time.Now().Add(PortUDPTimeout))
+			n, err := conn.Read(buf)
+			if err != nil {
+				stream.Close()
+				return
+			}
+			atomic.AddInt64(&stat.input, int64(n))
+			if _, err := stream.Write(encodePortPacket(buf[:n])); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		bs, err := readPortPacket(stream)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(bs); err == nil {
+			atomic.AddInt64(&stat.output, int64(len(bs)))
+		}
+	}
+}
@@ -3,12 +3,13 @@ package proxy
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"github.com/injoyai/base/maps"
 	"github.com/injoyai/io"
 	"github.com/injoyai/io/buf"
 	"github.com/injoyai/io/dial"
+	"net/http"
 	"os"
+	"strings"
 )
 
 // New 新建代理实例
@@ -96,12 +97,8 @@ func (this *Entity) writeMessage(msg *Message) (err error) {
 	//需要协程执行,避免阻塞后续请求
 	if proxyClient == nil && (msg.OperateType == Connect || msg.OperateType == Write) {
 		go func() {
-			if this.connectFunc == nil {
-				//设置默认连接函数
-				this.connectFunc = DefaultConnectFunc
-			}
 			//使用连接建立连接
-			i, err := this.connectFunc(msg)
+			i, err := this.connect(msg)
 			if err != nil {
 				//连接失败,则响应关闭连接
 				this.AddMessage(NewCloseMessage(msg.Key, err.Error()))
@@ -149,6 +146,14 @@ func (this *Entity) writeMessage(msg *Message) (err error) {
 	return
 }
 
+// connect 使用连接函数建立连接,未设置则使用默认连接函数
+func (this *Entity) connect(msg *Message) (io.ReadWriteCloser, error) {
+	if this.connectFunc == nil {
+		return DefaultConnectFunc(msg)
+	}
+	return this.connectFunc(msg)
+}
+
 // Close 实现 io.Closer
 func (this *Entity) Close() error {
 	this.closeIOAll()
@@ -156,8 +161,9 @@ func (this *Entity) Close() error {
 }
 
 // DefaultConnectFunc 默认连接函数
+// TCP,UDP,File(文件路径)的Addr是地址,Serial,MQ,MQTT的Addr是json配置
+// HTTP,Websocket的Addr可以是地址,也可以是json配置
 func DefaultConnectFunc(msg *Message) (i io.ReadWriteCloser, err error) {
-	err = errors.New("未实现")
 	switch msg.ConnectType {
 	case TCP:
 		i, _, err = dial.TCP(msg.Addr)
@@ -170,14 +176,85 @@ func DefaultConnectFunc(msg *Message) (i io.ReadWriteCloser, err error) {
 			return
 		}
 		i, _, err = dial.Serial(cfg)
-	case File, MQ, MQTT, HTTP, Websocket:
-		// todo 待实现
+	case File:
+		i, _, err = dial.File(msg.Addr)
+	case MQ:
+		cfg := new(dial.RabbitMQConfig)
+		err = json.Unmarshal([]byte(msg.Addr), cfg)
+		if err != nil {
+			return
+		}
+		i, _, err = dial.RabbitMQ(cfg)
+	case MQTT:
+		cfg := new(MQTTConfig)
+		err = json.Unmarshal([]byte(msg.Addr), cfg)
+		if err != nil {
+			return
+		}
+		i, _, err = cfg.Dial()
+	case HTTP:
+		cfg := &HTTPConfig{Method: http.MethodPost, Url: msg.Addr}
+		if strings.HasPrefix(msg.Addr, "{") {
+			err = json.Unmarshal([]byte(msg.Addr), cfg)
+			if err != nil {
+				return
+			}
+		}
+		i, _, err = cfg.Dial()
+	case Websocket:
+		cfg := &dial.WebsocketConfig{Url: msg.Addr}
+		if strings.HasPrefix(msg.Addr, "{") {
+			err = json.Unmarshal([]byte(msg.Addr), cfg)
+			if err != nil {
+				return
+			}
+		}
+		i, _, err = cfg.Dial()
 	default:
 		i, _, err = dial.TCP(msg.Addr)
 	}
+	if err != nil && i != nil {
+		//部分连接失败时也会返回实例
+		i = nil
+	}
 	return
 }
 
+// MQTTConfig MQTT连接配置,一个连接可以订阅和发布多个主题
+type MQTTConfig struct {
+	dial.MQTTBaseConfig
+	Subscribe []dial.MQTTSubscribe
+	Publish   []dial.MQTTPublish
+}
+
+func (this *MQTTConfig) Dial() (io.ReadWriteCloser, string, error) {
+	if this.Timeout <= 0 {
+		this.Timeout = io.DefaultConnectTimeout
+	}
+	return dial.MQTT(dial.WithMQTTBase(&this.MQTTBaseConfig), &dial.MQTTTopic{
+		Subscribe: this.Subscribe,
+		Publish:   this.Publish,
+	})
+}
+
+// HTTPConfig HTTP连接配置,每次写入发起一次请求,响应内容作为读取的数据
+type HTTPConfig struct {
+	Method string      //请求方法,默认POST
+	Url    string      //请求地址
+	Header http.Header //请求头
+}
+
+func (this *HTTPConfig) Dial() (io.ReadWriteCloser, string, error) {
+	if this.Method == "" {
+		this.Method = http.MethodPost
+	}
+	return dial.HTTP(&http.Client{Timeout: HTTPResponseTimeout}, this.Method, this.Url, func(r *http.Request) {
+		for k, v := range this.Header {
+			r.Header[k] = v
+		}
+	})
+}
+
 //func NewTCPClient(addr string, options ...func(c *io.Client, e *Entity)) *io.Client {
 //	return dial.RedialPipe(addr, func(c *io.Client) {
 //		c.SetPrintFunc(PrintWithASCII)
@@ -269,8 +346,8 @@ func (this *Entity) closeIO(key string) {
 // CloseIOAll 关闭全部io
 func (this *Entity) closeIOAll() {
 	this.ioMap.Range(func(key, value interface{}) bool {
+		this.ioMap.Del(key)
 		value.(*io.Client).TryCloseWithDeadline()
 		return true
 	})
-	this.ioMap = maps.NewSafe()
 }
@@ -1,7 +1,19 @@
 package proxy
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/dial"
+	goio "io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -18,3 +30,117 @@ Connection: close
 	}
 	select {}
 }
+
+// roundTrip 通过代理实例连接并写入数据,返回响应的数据
+func roundTrip(t *testing.T, ct ConnectType, addr string, data string) string {
+	t.Helper()
+	e := New()
+	e.Debug(false)
+	defer e.Close()
+	if _, err := e.Write(NewWriteMessage("key", addr, []byte(data)).SetConnectType(ct).Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan []byte, 1)
+	go func() {
+		bs, _ := e.ReadMessage()
+		ch <- bs
+	}()
+	select {
+	case bs := <-ch:
+		msg, err := DecodeMessage(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.OperateType != Response {
+			t.Fatalf("%s连接失败: %s", ct, msg.GetDataString())
+		}
+		return msg.GetDataString()
+	case <-time.After(time.Second * 5):
+		t.Fatalf("%s等待响应超时", ct)
+	}
+	return ""
+}
+
+func TestConnectFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(filename, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if s := roundTrip(t, File, filename, ""); s != "hello" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+}
+
+func TestConnectHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := goio.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("X-Test"), bs)
+	}))
+	defer srv.Close()
+	if s := roundTrip(t, HTTP, srv.URL, "hello"); s != "POST  hello" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+	cfg, _ := json.Marshal(HTTPConfig{Method: http.MethodPut, Url: srv.URL, Header: http.Header{"X-Test": {"1"}}})
+	if s := roundTrip(t, HTTP, string(cfg), "hello"); s != "PUT 1 hello" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+}
+
+func TestConnectWebsocket(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, bs, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, append([]byte(r.URL.Path+" "), bs...))
+		}
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	if s := roundTrip(t, Websocket, url+"/a", "hello"); s != "/a hello" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+	cfg, _ := json.Marshal(map[string]string{"url": url + "/b"})
+	if s := roundTrip(t, Websocket, string(cfg), "hello"); s != "/b hello" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+}
+
+func TestConnectMQTT(t *testing.T) {
+	addr := newMQTTBroker(t)
+	cfg, _ := json.Marshal(MQTTConfig{
+		MQTTBaseConfig: dial.MQTTBaseConfig{BrokerURL: addr},
+		Subscribe:      []dial.MQTTSubscribe{{Topic: "test"}},
+		Publish:        []dial.MQTTPublish{{Topic: "test"}},
+	})
+	if s := roundTrip(t, MQTT, string(cfg), "hello"); s != "hello" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+}
+
+func TestConnectMQ(t *testing.T) {
+	addr := newAMQPBroker(t)
+	address := "amqp://guest:guest@" + addr + "/"
+	//对端,读取up队列的数据,加上前缀写入down队列
+	peer, err := dial.NewRabbitMQ(&dial.RabbitMQConfig{Address: address, Consume: "up", Publish: "down"}, func(c *io.Client) {
+		c.Debug(false)
+		c.SetDealFunc(func(c *io.Client, msg io.Message) {
+			c.Write(append([]byte("peer "), msg...))
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.CloseAll()
+	go peer.Run()
+	cfg, _ := json.Marshal(dial.RabbitMQConfig{Address: address, Consume: "down", Publish: "up"})
+	if s := roundTrip(t, MQ, string(cfg), "hello"); s != "peer hello" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+}
@@ -0,0 +1,312 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/buf"
+	goio "io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+
+HTTP 正向代理(HTTP/1.1)
+
+未设置处理函数(SetDealFunc)时,在本地处理HTTP代理请求,通过Entity的连接函数(SetConnectFunc)连接目标
+CONNECT 先连接目标,成功响应200,失败响应502,然后交换数据
+其他请求 逐个解析请求,按请求的目标连接,长连接的后续请求可以是不同的目标
+去除逐跳头(Connection等),请求体和响应体流式转发,不会缓存整个请求
+
+s, err := proxy.NewTCPServer(8080, func(s *proxy.Server) {
+	s.SetHTTPLog(func(l *proxy.HTTPLog) {
+		logs.Debug(l)
+	})
+})
+
+*/
+
+var (
+	// HTTPIdleTimeout 等待客户端请求头的超时时间,包括长连接的空闲时间,以及读取请求体时每次读取的超时时间
+	HTTPIdleTimeout = time.Second * 90
+
+	// HTTPResponseTimeout 发送请求后,等待目标响应头的超时时间
+	HTTPResponseTimeout = time.Minute
+)
+
+// hopHeaders 逐跳头,只对单个连接有效,不转发
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HTTPLog HTTP代理访问日志,每个请求一条
+type HTTPLog struct {
+	Client  *io.Client    //客户端
+	Method  string        //请求方法
+	Host    string        //目标地址
+	URL     string        //请求地址
+	Status  int           //响应状态码,代理失败时为代理响应的状态码
+	Written int64         //响应字节数,包括响应头,CONNECT不统计
+	Start   time.Time     //开始时间
+	Spend   time.Duration //耗时
+	Err     error         //错误
+}
+
+func (this *HTTPLog) String() string {
+	s := fmt.Sprintf("[%s] %s %s %d %d %s", this.Client.GetKey(), this.Method, this.URL, this.Status, this.Written, this.Spend)
+	if this.Err != nil {
+		s += " " + this.Err.Error()
+	}
+	return s
+}
+
+// SetHTTPLog 设置HTTP访问日志,请求处理完成后执行
+func (this *Server) SetHTTPLog(fn func(l *HTTPLog)) {
+	this.httpLog = fn
+}
+
+// dealHTTP 本地处理HTTP代理请求,设置客户端的读取函数,连接事件结束后,
+// 在客户端的读取协程中逐个处理请求,客户端加入管理,受最大连接数和超时的限制,收到CONNECT请求后交换数据
+// 设置了处理函数时,使用隧道模式,交给Server的处理函数
+func (this *Server) dealHTTP(c *io.Client) {
+	if this.dealFunc != nil {
+		return
+	}
+	conn, _ := c.ReadWriteCloser().(net.Conn)
+	up := &httpUpstream{}
+	go func(ctx context.Context) {
+		//客户端关闭时关闭目标连接,例如正在等待目标响应
+		<-ctx.Done()
+		up.close()
+	}(c.Ctx())
+	c.SetReadAckFunc(func(r *bufio.Reader) (io.Acker, error) {
+		setReadDeadline(conn, HTTPIdleTimeout)
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			if !errors.Is(err, goio.EOF) && !isTimeout(err) {
+				httpError(c, http.StatusBadRequest)
+			}
+			return nil, err
+		}
+		l := &HTTPLog{Client: c, Method: req.Method, URL: req.RequestURI, Start: time.Now()}
+		if req.Method == http.MethodConnect {
+			setReadDeadline(conn, 0)
+			up.close()
+			//隧道的数据交给dealFunc写入目标
+			c.SetReadFunc(buf.Read1KB)
+			err = this.httpConnect(c, req, l)
+			this.dealHTTPLog(l, err)
+			return io.Ack(nil), err
+		}
+		if req.Body != http.NoBody {
+			req.Body = &httpBody{ReadCloser: req.Body, conn: conn}
+		}
+		keep, err := this.httpForward(c, req, up, l)
+		this.dealHTTPLog(l, err)
+		if err == nil && !keep {
+			err = io.ErrHandClose
+		}
+		return io.Ack(nil), err
+	})
+}
+
+// httpConnect 连接目标,成功响应200后交换数据,失败响应502
+func (this *Server) httpConnect(c *io.Client, req *http.Request, l *HTTPLog) error {
+	l.Host = httpHostPort(req.Host, "443")
+	i, err := this.e.connect(NewConnectMessage(c.GetKey(), l.Host))
+	if err != nil {
+		l.Status = http.StatusBadGateway
+		httpError(c, l.Status)
+		return err
+	}
+	if _, err := c.WriteString(Connection); err != nil {
+		i.Close()
+		return err
+	}
+	l.Status = http.StatusOK
+	this.pipe(c, i, l.Host)
+	return nil
+}
+
+// httpForward 转发请求到目标,并把响应写入客户端,返回是否保持长连接
+func (this *Server) httpForward(c *io.Client, req *http.Request, up *httpUpstream, l *HTTPLog) (bool, error) {
+	if req.URL.Scheme != "" && req.URL.Scheme != "http" {
+		l.Status = http.StatusBadRequest
+		httpError(c, l.Status)
+		return false, fmt.Errorf("不支持的协议(%s)", req.URL.Scheme)
+	}
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+	if host == "" {
+		l.Status = http.StatusBadRequest
+		httpError(c, l.Status)
+		return false, errors.New("缺少请求地址")
+	}
+	l.Host = httpHostPort(host, "80")
+	removeHopHeaders(req.Header)
+
+	resp, err := up.roundTrip(this.e, c.GetKey(), l.Host, req)
+	if err != nil {
+		l.Status = http.StatusBadGateway
+		if isTimeout(err) {
+			l.Status = http.StatusGatewayTimeout
+		}
+		httpError(c, l.Status)
+		return false, err
+	}
+	defer resp.Body.Close()
+	l.Status = resp.StatusCode
+	removeHopHeaders(resp.Header)
+	w := &httpCounter{w: c}
+	err = resp.Write(w)
+	l.Written = w.n
+	if err != nil || resp.Close {
+		up.close()
+	}
+	return !req.Close && !resp.Close, err
+}
+
+func (this *Server) dealHTTPLog(l *HTTPLog, err error) {
+	if this.httpLog != nil {
+		l.Spend = time.Since(l.Start)
+		l.Err = err
+		this.httpLog(l)
+	}
+}
+
+// httpUpstream 目标连接,长连接的后续请求是相同目标时复用
+// 只在客户端的读取协程中使用,客户端关闭时会在其他协程关闭连接
+type httpUpstream struct {
+	mu   sync.Mutex
+	addr string
+	i    io.ReadWriteCloser
+	r    *bufio.Reader
+}
+
+// roundTrip 发送请求并读取响应头,复用的连接可能已被目标关闭,没有请求体时重试一次
+func (this *httpUpstream) roundTrip(e *Entity, key, addr string, req *http.Request) (*http.Response, error) {
+	//客户端关闭时会在其他协程执行close,复制连接后使用,已关闭的连接读写会返回错误
+	this.mu.Lock()
+	i, r := this.i, this.r
+	reused := i != nil && this.addr == addr
+	this.mu.Unlock()
+	if !reused {
+		this.close()
+		var err error
+		i, err = e.connect(NewConnectMessage(key, addr))
+		if err != nil {
+			return nil, err
+		}
+		r = bufio.NewReader(i)
+		this.mu.Lock()
+		this.addr, this.i, this.r = addr, i, r
+		this.mu.Unlock()
+	}
+	if err := req.Write(i); err != nil {
+		this.close()
+		return nil, err
+	}
+	conn, _ := i.(net.Conn)
+	setReadDeadline(conn, HTTPResponseTimeout)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		this.close()
+		if reused && req.Body == http.NoBody && errors.Is(err, goio.EOF) {
+			return this.roundTrip(e, key, addr, req)
+		}
+		return nil, err
+	}
+	setReadDeadline(conn, 0)
+	return resp, nil
+}
+
+func (this *httpUpstream) close() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.i != nil {
+		this.i.Close()
+		this.i, this.r = nil, nil
+	}
+}
+
+// httpBody 请求体,每次读取前重置客户端的读取超时
+type httpBody struct {
+	goio.ReadCloser
+	conn net.Conn
+}
+
+func (this *httpBody) Read(p []byte) (int, error) {
+	setReadDeadline(this.conn, HTTPIdleTimeout)
+	return this.ReadCloser.Read(p)
+}
+
+// httpCounter 统计写入的字节数
+type httpCounter struct {
+	w goio.Writer
+	n int64
+}
+
+func (this *httpCounter) Write(p []byte) (int, error) {
+	n, err := this.w.Write(p)
+	this.n += int64(n)
+	return n, err
+}
+
+// removeHopHeaders 去除逐跳头,包括Connection中声明的头
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				h.Del(k)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+// httpHostPort 补全默认端口
+func httpHostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port)
+}
+
+// httpError 代理错误响应,响应后关闭连接
+func httpError(c *io.Client, code int) {
+	c.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", code, http.StatusText(code)))
+}
+
+func setReadDeadline(conn net.Conn, timeout time.Duration) {
+	if conn == nil {
+		return
+	}
+	if timeout <= 0 {
+		conn.SetReadDeadline(time.Time{})
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+func isTimeout(err error) bool {
+	var e net.Error
+	return errors.As(err, &e) && e.Timeout()
+}
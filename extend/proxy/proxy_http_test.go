@@ -0,0 +1,301 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/dial"
+	goio "io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// httpLogs 记录访问日志
+type httpLogs struct {
+	mu   sync.Mutex
+	list []HTTPLog
+}
+
+func (this *httpLogs) add(l *HTTPLog) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.list = append(this.list, *l)
+}
+
+func (this *httpLogs) get() []HTTPLog {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]HTTPLog(nil), this.list...)
+}
+
+func newHTTPServer(t *testing.T, name string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//逐跳头不应该转发
+		for _, k := range []string{"Proxy-Connection", "X-Hop", "Keep-Alive"} {
+			if r.Header.Get(k) != "" {
+				http.Error(w, "逐跳头:"+k, http.StatusBadRequest)
+				return
+			}
+		}
+		n, _ := goio.Copy(goio.Discard, r.Body)
+		w.Header().Set("Connection", "X-Resp-Hop")
+		w.Header().Set("X-Resp-Hop", "1")
+		fmt.Fprintf(w, "%s %s %d", name, r.URL.Path, n)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPForward(t *testing.T) {
+	a := newHTTPServer(t, "a")
+	b := newHTTPServer(t, "b")
+	logs := &httpLogs{}
+	addr := newSocks5Server(t, func(s *Server) {
+		s.SetHTTPLog(logs.add)
+	})
+
+	//同一个连接,请求不同的目标
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for i, srv := range []*httptest.Server{a, b, a} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+fmt.Sprintf("/%d", i), strings.NewReader("hello"))
+		req.Header.Set("Proxy-Connection", "keep-alive")
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "1")
+		if err := req.WriteProxy(c); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(r, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := goio.ReadAll(resp.Body)
+		resp.Body.Close()
+		want := fmt.Sprintf("%s /%d 5", map[*httptest.Server]string{a: "a", b: "b"}[srv], i)
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Fatalf("响应错误,预期(%s),得到(%d %s)", want, resp.StatusCode, body)
+		}
+		if resp.Header.Get("X-Resp-Hop") != "" {
+			t.Fatal("响应的逐跳头不应该转发")
+		}
+	}
+
+	list := logs.get()
+	if len(list) != 3 {
+		t.Fatalf("访问日志数量错误: %d", len(list))
+	}
+	for _, l := range list {
+		if l.Client != list[0].Client || l.Method != http.MethodPost || l.Status != http.StatusOK || l.Written == 0 || l.Err != nil {
+			t.Fatalf("访问日志错误: %v", l.String())
+		}
+	}
+	if list[1].Host != strings.TrimPrefix(b.URL, "http://") {
+		t.Fatalf("目标地址错误: %s", list[1].Host)
+	}
+}
+
+// TestHTTPManage 长连接的HTTP代理客户端加入管理,能统计和关闭
+func TestHTTPManage(t *testing.T) {
+	a := newHTTPServer(t, "a")
+	var ser *Server
+	addr := newSocks5Server(t, func(s *Server) { ser = s })
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	req, _ := http.NewRequest(http.MethodGet, a.URL+"/", nil)
+	if err := req.WriteProxy(c); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goio.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	//等待长连接的下一个请求时,客户端在管理中
+	if n := ser.Server().GetClientLen(); n != 1 {
+		t.Fatalf("预期客户端数量(1),得到(%d)", n)
+	}
+	ser.Server().CloseClientAll()
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := r.ReadByte(); err != goio.EOF {
+		t.Fatalf("预期连接被关闭,得到(%v)", err)
+	}
+}
+
+func TestHTTPForwardStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//读取全部请求体,然后分段响应相同长度的数据,响应使用chunked
+		n, _ := goio.Copy(goio.Discard, r.Body)
+		chunk := []byte(strings.Repeat("y", 32<<10))
+		for ; n > 0; n -= int64(len(chunk)) {
+			if n < int64(len(chunk)) {
+				chunk = chunk[:n]
+			}
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+	addr := newSocks5Server(t)
+	u, _ := url.Parse("http://" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+
+	//未知长度的请求体
+	size := 4000 * 1000
+	pr, pw := goio.Pipe()
+	go func() {
+		chunk := []byte(strings.Repeat("x", 1000))
+		for n := 0; n < size; n += len(chunk) {
+			pw.Write(chunk)
+		}
+		pw.Close()
+	}()
+	resp, err := client.Post(srv.URL, "text/plain", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	n, err := goio.Copy(goio.Discard, resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(size) {
+		t.Fatalf("数据长度错误,预期(%d),得到(%d)", size, n)
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	target := newEcho(t)
+	logs := &httpLogs{}
+	addr := newSocks5Server(t, func(s *Server) {
+		s.SetHTTPLog(logs.add)
+	})
+
+	c, err := dial.New(dial.WithProxy("http://"+addr, dial.WithTCP(target)), func(c *io.Client) {
+		c.Debug(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseAll()
+	c.WriteString("hello")
+	msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello" {
+		t.Fatalf("数据错误(%s)", msg)
+	}
+
+	//目标不可达,响应502
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := l.Addr().String()
+	l.Close()
+	_, err = dial.New(dial.WithProxy("http://"+addr, dial.WithTCP(closed)))
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("预期502,得到(%v)", err)
+	}
+
+	list := logs.get()
+	if len(list) != 2 || list[0].Status != http.StatusOK || list[1].Status != http.StatusBadGateway || list[1].Err == nil || list[1].Host != closed {
+		t.Fatalf("访问日志错误: %v", list)
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer srv.Close()
+	old := HTTPResponseTimeout
+	HTTPResponseTimeout = time.Millisecond * 100
+	defer func() { HTTPResponseTimeout = old }()
+
+	addr := newSocks5Server(t)
+	u, _ := url.Parse("http://" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("预期504,得到(%d)", resp.StatusCode)
+	}
+}
+
+// TestHTTPClientClose 等待目标响应时关闭客户端,关闭目标连接
+func TestHTTPClientClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	requested := make(chan struct{})
+	closed := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		if _, err := http.ReadRequest(r); err != nil {
+			closed <- err
+			return
+		}
+		close(requested)
+		c.SetReadDeadline(time.Now().Add(time.Second * 2))
+		_, err = r.ReadByte()
+		closed <- err
+	}()
+
+	logs := &httpLogs{}
+	var ser *Server
+	addr := newSocks5Server(t, func(s *Server) {
+		ser = s
+		s.SetHTTPLog(logs.add)
+	})
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	req, _ := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/", nil)
+	if err := req.WriteProxy(c); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-requested:
+	case err := <-closed:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("目标未收到请求")
+	}
+	ser.Server().CloseClientAll()
+	if err := <-closed; err != goio.EOF {
+		t.Fatalf("预期目标连接被关闭,得到(%v)", err)
+	}
+	for i := 0; i < 100 && len(logs.get()) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if list := logs.get(); len(list) != 1 || list[0].Err == nil {
+		t.Fatalf("访问日志错误: %v", list)
+	}
+}
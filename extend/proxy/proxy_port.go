@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/injoyai/io"
+	goio "io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+端口转发(内网穿透),类似frp
+
+客户端在内网运行,连接服务端后使用编号(sn)和认证信息注册,并上报端口映射
+服务端按映射打开公网端口(TCP或UDP),每个公网连接通过多路复用(io.Mux)打开一个流,由客户端连接本地地址
+客户端断开后自动重连,重新注册,服务端关闭原先打开的端口
+
+公网连接 --- PortForwardingServer(公网端口) ===多路复用=== PortForwardingClient --- 本地地址
+
+注册 客户端连接后先发送注册信息(PortRegister),服务端响应结果(PortRegisterResult),然后开始多路复用
+流 服务端打开,第一个数据是端口映射(PortMapping),然后交换数据
+UDP 每个公网地址对应一个流,每个数据包加上2字节长度(大端),空闲超过PortUDPTimeout关闭
+加密(可选) 客户端配置Cipher,服务端SetCipher,连接后先交换密钥(io.CipherConn),再注册
+
+配置文件(json)
+{
+	"address": "1.2.3.4:7000",
+	"sn": "device1",
+	"auth": "token",
+	"mappings": [
+		{"name": "ssh", "type": "tcp", "port": 6022, "local": "127.0.0.1:22"},
+		{"name": "dns", "type": "udp", "port": 6053, "local": "127.0.0.1:53"}
+	],
+	"cipher": {"PSK": "cHNr"}
+}
+
+*/
+
+const (
+	PortTCP = "tcp"
+	PortUDP = "udp"
+)
+
+// PortUDPTimeout UDP会话的空闲时间,超过则关闭
+var PortUDPTimeout = time.Minute
+
+// PortMapping 端口映射
+type PortMapping struct {
+	Name  string `json:"name"`  //名称,同一个客户端内唯一
+	Type  string `json:"type"`  //类型,tcp或udp,默认tcp
+	Port  int    `json:"port"`  //服务端监听的端口,0表示随机端口
+	Local string `json:"local"` //客户端连接的本地地址,例 127.0.0.1:22
+}
+
+func (this *PortMapping) network() string {
+	if this.Type == PortUDP {
+		return PortUDP
+	}
+	return PortTCP
+}
+
+// PortRegister 注册信息
+type PortRegister struct {
+	SN       string         `json:"sn"`       //客户端编号,唯一,重复注册会关闭老连接
+	Auth     string         `json:"auth"`     //认证信息
+	Mappings []*PortMapping `json:"mappings"` //端口映射
+}
+
+// PortRegisterResult 注册结果
+type PortRegisterResult struct {
+	Err    string            `json:"err,omitempty"`    //注册失败的原因
+	Ports  map[string]int    `json:"ports,omitempty"`  //映射成功的端口,名称:端口
+	Errors map[string]string `json:"errors,omitempty"` //映射失败的原因,名称:错误
+}
+
+// PortConfig 端口转发客户端配置
+type PortConfig struct {
+	Address string           `json:"address"`          //服务端地址
+	Cipher  *io.CipherConfig `json:"cipher,omitempty"` //加密传输,需要和服务端一致,密钥在json中是base64
+	PortRegister
+}
+
+// LoadPortConfig 从配置文件(json)加载客户端配置
+func LoadPortConfig(filename string) (*PortConfig, error) {
+	bs, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(PortConfig)
+	if err := json.Unmarshal(bs, cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Address) == 0 || len(cfg.SN) == 0 {
+		return nil, errors.New("缺少服务端地址或编号")
+	}
+	return cfg, nil
+}
+
+// PortStatus 端口映射状态
+type PortStatus struct {
+	PortMapping
+	Conns  int64  `json:"conns"`         //当前连接数,UDP为会话数
+	Total  int64  `json:"total"`         //累计连接数
+	Input  int64  `json:"input"`         //从连接(公网连接或本地连接)读取的字节数
+	Output int64  `json:"output"`        //写入连接的字节数
+	Err    string `json:"err,omitempty"` //映射失败的原因
+}
+
+// portStat 连接统计,原子操作
+type portStat struct {
+	conns, total, input, output int64
+}
+
+func (this *portStat) status(m *PortMapping) *PortStatus {
+	return &PortStatus{
+		PortMapping: *m,
+		Conns:       atomic.LoadInt64(&this.conns),
+		Total:       atomic.LoadInt64(&this.total),
+		Input:       atomic.LoadInt64(&this.input),
+		Output:      atomic.LoadInt64(&this.output),
+	}
+}
+
+func (this *portStat) open() {
+	atomic.AddInt64(&this.conns, 1)
+	atomic.AddInt64(&this.total, 1)
+}
+
+func (this *portStat) close() {
+	atomic.AddInt64(&this.conns, -1)
+}
+
+// portPipe TCP连接和流交换数据,任意一端结束后关闭
+func portPipe(stat *portStat, conn net.Conn, stream *io.Stream) {
+	stat.open()
+	defer stat.close()
+	defer stream.Close()
+	defer conn.Close()
+	go func() {
+		n, _ := goio.Copy(stream, conn)
+		atomic.AddInt64(&stat.input, n)
+		stream.Close()
+		conn.Close()
+	}()
+	n, _ := goio.Copy(conn, stream)
+	atomic.AddInt64(&stat.output, n)
+}
+
+// writePortFrame 写入控制数据,2字节长度(大端)+json
+func writePortFrame(w goio.Writer, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encodePortPacket(bs))
+	return err
+}
+
+func readPortFrame(r goio.Reader, v interface{}) error {
+	bs, err := readPortPacket(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+// encodePortPacket 加上2字节长度(大端),用于UDP数据包和控制数据
+func encodePortPacket(p []byte) []byte {
+	bs := make([]byte, 2, 2+len(p))
+	binary.BigEndian.PutUint16(bs, uint16(len(p)))
+	return append(bs, p...)
+}
+
+func readPortPacket(r goio.Reader) ([]byte, error) {
+	bs := make([]byte, 2)
+	if _, err := goio.ReadFull(r, bs); err != nil {
+		return nil, err
+	}
+	bs = make([]byte, binary.BigEndian.Uint16(bs))
+	_, err := goio.ReadFull(r, bs)
+	return bs, err
+}
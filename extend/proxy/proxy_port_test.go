@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/injoyai/io"
+	goio "io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newUDPEcho UDP原样返回
+func newUDPEcho(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 64<<10)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func newPortServer(t *testing.T, options ...func(s *PortForwardingServer)) (*PortForwardingServer, string) {
+	s, err := NewPortForwardingServer(0, append([]func(s *PortForwardingServer){func(s *PortForwardingServer) {
+		s.Debug(false)
+		s.SetAuth(func(r *PortRegister) error {
+			if r.Auth != "token" {
+				return errors.New("认证失败")
+			}
+			return nil
+		})
+	}}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	go s.Run()
+	return s, s.Listener().Addr()
+}
+
+func newPortClient(t *testing.T, cfg *PortConfig, options ...func(c *PortForwardingClient)) *PortForwardingClient {
+	c := NewPortForwardingClient(cfg, append([]func(c *PortForwardingClient){func(c *PortForwardingClient) {
+		c.Debug(false)
+	}}, options...)...)
+	t.Cleanup(func() { c.CloseAll() })
+	return c
+}
+
+// portOf 客户端状态中映射的公网端口
+func portOf(c *PortForwardingClient, name string) int {
+	for _, v := range c.Status().Ports {
+		if v.Name == name {
+			return v.Port
+		}
+	}
+	return 0
+}
+
+func tcpEcho(t *testing.T, port int, data string) error {
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second * 3))
+	if _, err := c.Write([]byte(data)); err != nil {
+		return err
+	}
+	buf := make([]byte, len(data))
+	if _, err := goio.ReadFull(c, buf); err != nil {
+		return err
+	}
+	if string(buf) != data {
+		return fmt.Errorf("数据错误(%s)", buf)
+	}
+	return nil
+}
+
+func TestPortForwarding(t *testing.T) {
+	s, addr := newPortServer(t)
+	cfg := &PortConfig{
+		Address: addr,
+		PortRegister: PortRegister{
+			SN:   "device1",
+			Auth: "token",
+			Mappings: []*PortMapping{
+				{Name: "echo", Type: PortTCP, Local: newEcho(t)},
+				{Name: "udp", Type: PortUDP, Local: newUDPEcho(t)},
+				{Name: "closed", Local: "127.0.0.1:1"},
+			},
+		},
+	}
+	c := newPortClient(t, cfg)
+
+	//多个连接同时转发
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := tcpEcho(t, portOf(c, "echo"), strings.Repeat(fmt.Sprintf("%d", i), 10000)); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	//UDP
+	u, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", portOf(c, "udp")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	u.SetDeadline(time.Now().Add(time.Second * 3))
+	for _, v := range []string{"hello", "world"} {
+		u.Write([]byte(v))
+		buf := make([]byte, 100)
+		n, err := u.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != v {
+			t.Fatalf("UDP数据错误(%s)", buf[:n])
+		}
+	}
+
+	//本地地址不可达,关闭公网连接
+	if err := tcpEcho(t, portOf(c, "closed"), "hello"); err == nil {
+		t.Fatal("本地地址不可达,预期错误")
+	}
+
+	//状态接口,等待连接都关闭
+	status := new(PortAgentStatus)
+	w := httptest.NewRecorder()
+	for deadline := time.Now().Add(time.Second * 3); ; {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/?sn=device1", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), status); err != nil {
+			t.Fatal(err)
+		}
+		conns := int64(0)
+		for _, v := range status.Ports {
+			if v.Type != PortUDP {
+				conns += v.Conns
+			}
+		}
+		if conns == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if status.SN != "device1" || len(status.Ports) != 3 {
+		t.Fatalf("状态错误: %s", w.Body.String())
+	}
+	for _, v := range status.Ports {
+		if v.Name == "echo" && (v.Total != 10 || v.Input != 10*10000 || v.Output != 10*10000) {
+			t.Fatalf("统计错误: %+v", v)
+		}
+		if v.Name == "udp" && (v.Conns != 1 || v.Input != 10) {
+			t.Fatalf("统计错误: %+v", v)
+		}
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/?sn=device2", nil))
+	if w.Code != 404 {
+		t.Fatalf("预期404,得到(%d)", w.Code)
+	}
+}
+
+func TestPortForwardingAuth(t *testing.T) {
+	_, addr := newPortServer(t)
+	c := &PortForwardingClient{cfg: &PortConfig{Address: addr, PortRegister: PortRegister{SN: "device1", Auth: "error"}}}
+	if _, _, err := c.dial(context.Background()); err == nil || !strings.Contains(err.Error(), "认证失败") {
+		t.Fatalf("预期认证失败,得到(%v)", err)
+	}
+}
+
+func TestPortForwardingCipher(t *testing.T) {
+	cipher := &io.CipherConfig{PSK: []byte("psk")}
+	_, addr := newPortServer(t, func(s *PortForwardingServer) { s.SetCipher(cipher) })
+	c := newPortClient(t, &PortConfig{
+		Address: addr,
+		Cipher:  cipher,
+		PortRegister: PortRegister{
+			SN:       "device1",
+			Auth:     "token",
+			Mappings: []*PortMapping{{Name: "echo", Local: newEcho(t)}},
+		},
+	})
+	if err := tcpEcho(t, portOf(c, "echo"), strings.Repeat("hello", 10000)); err != nil {
+		t.Fatal(err)
+	}
+	//客户端未加密
+	c2 := &PortForwardingClient{cfg: &PortConfig{Address: addr, PortRegister: PortRegister{SN: "device2", Auth: "token"}}}
+	if _, _, err := c2.dial(context.Background()); err == nil {
+		t.Fatal("客户端未加密,预期注册失败")
+	}
+}
+
+func TestPortForwardingReconnect(t *testing.T) {
+	s, addr := newPortServer(t)
+	c := newPortClient(t, &PortConfig{
+		Address: addr,
+		PortRegister: PortRegister{
+			SN:       "device1",
+			Auth:     "token",
+			Mappings: []*PortMapping{{Name: "echo", Local: newEcho(t)}},
+		},
+	})
+	old := portOf(c, "echo")
+	if err := s.CloseClient("device1"); err != nil {
+		t.Fatal(err)
+	}
+	//断开后关闭端口
+	time.Sleep(time.Millisecond * 100)
+	if err := tcpEcho(t, old, "hello"); err == nil {
+		t.Fatal("断开后端口未关闭")
+	}
+	//重连后重新注册
+	deadline := time.Now().Add(time.Second * 5)
+	for (portOf(c, "echo") == old || len(s.Status()) == 0) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if err := tcpEcho(t, portOf(c, "echo"), "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPortForwardingListen(t *testing.T) {
+	s, addr := newPortServer(t)
+	allow := make(chan bool, 1)
+	allow <- false
+	newPortClient(t, &PortConfig{Address: addr, PortRegister: PortRegister{SN: "device1", Auth: "token"}}, func(c *PortForwardingClient) {
+		c.SetAllow(func(m *PortMapping) bool {
+			b := <-allow
+			allow <- b
+			return b
+		})
+	})
+	port, err := s.Listen("device1", &PortMapping{Name: "echo", Local: newEcho(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//客户端不允许
+	if err := tcpEcho(t, port, "hello"); err == nil {
+		t.Fatal("客户端不允许的映射,预期错误")
+	}
+	<-allow
+	allow <- true
+	if err := tcpEcho(t, port, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CloseListen("device1", "echo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tcpEcho(t, port, "hello"); err == nil {
+		t.Fatal("关闭映射后预期错误")
+	}
+}
+
+func TestLoadPortConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "port.json")
+	os.WriteFile(filename, []byte(`{
+	"address": "127.0.0.1:7000",
+	"sn": "device1",
+	"auth": "token",
+	"mappings": [{"name": "ssh", "port": 6022, "local": "127.0.0.1:22"}]
+}`), 0666)
+	cfg, err := LoadPortConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != "127.0.0.1:7000" || cfg.SN != "device1" || cfg.Auth != "token" ||
+		len(cfg.Mappings) != 1 || cfg.Mappings[0].Port != 6022 || cfg.Mappings[0].network() != PortTCP {
+		t.Fatalf("配置错误: %+v", cfg)
+	}
+}
@@ -1,14 +1,15 @@
 package proxy
 
-import "C"
 import (
 	"bufio"
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"github.com/injoyai/io"
+	"github.com/injoyai/io/buf"
 	"github.com/injoyai/io/listen"
 	"github.com/injoyai/logs"
+	goio "io"
 	"net/http"
 	"net/url"
 	"strings"
@@ -17,22 +18,43 @@ import (
 const (
 	KeyAddr    = "addr"
 	Connection = "HTTP/1.1 200 Connection established\r\n\r\n"
+
+	// KeyUpstream 代理连接的上游,SOCKS5和HTTP CONNECT是*io.Client,SOCKS5 UDP ASSOCIATE是UDP中继
+	KeyUpstream = "upstream"
 )
 
 type Server struct {
-	s        *io.Server                //监听服务
-	e        *Entity                   //代理实例,正向,反向
-	dealFunc func(msg *CMessage) error //处理函数
+	s          *io.Server                           //监听服务
+	e          *Entity                              //代理实例,正向,反向
+	dealFunc   func(msg *CMessage) error            //处理函数
+	socks5Auth func(username, password string) bool //SOCKS5认证
+	socks5ACL  func(r *Socks5Request) error         //SOCKS5访问控制
+	httpLog    func(l *HTTPLog)                     //HTTP访问日志
 }
 
 func (this *Server) Debug(b ...bool) {
 	this.s.Debug(b...)
+	this.e.Debug(b...)
 }
 
 func (this *Server) Run() error {
 	return this.s.Run()
 }
 
+func (this *Server) Close() error {
+	return this.s.Close()
+}
+
+// Server 监听服务
+func (this *Server) Server() *io.Server {
+	return this.s
+}
+
+// Entity 代理实例,可以设置连接函数,例如通过隧道连接
+func (this *Server) Entity() *Entity {
+	return this.e
+}
+
 // Write 写入数据,实现io.Writer
 func (this *Server) Write(p []byte) (int, error) {
 	m, err := DecodeMessage(p)
@@ -64,6 +86,7 @@ func (this *Server) WriteMessage(m *Message) (int, error) {
 }
 
 // SetDealFunc 设置处理函数,例如像通道发送数据
+// 设置后HTTP代理使用隧道模式,数据交给处理函数,未设置则在本地处理HTTP代理请求
 func (this *Server) SetDealFunc(fn func(msg *CMessage) error) {
 	this.dealFunc = fn
 }
@@ -84,24 +107,34 @@ func NewServer(dial io.ListenFunc, options ...func(s *Server)) (*Server, error)
 	ser := &Server{}
 	_, err := io.NewServer(dial, func(s *io.Server) {
 		//读取全部数据
-		s.SetReadWithKB(1)
+		s.SetReadFunc(buf.Read1KB)
 		//s.SetPrintFunc(func(msg io.Message, tag ...string) {
 		//	io.PrintWithASCII(msg.Bytes(), append([]string{"PR|S"}, tag...)...)
 		//})
-		ser = &Server{s: s, e: New(), dealFunc: func(msg *CMessage) error {
-			m := "未设置处理函数"
-			s.Errorf("[PR|S] 未设置处理函数\n")
-			//s.Print([]byte("未设置处理函数"), "PR|S", io.TagErr)
-			return errors.New(m)
-		}}
-		s.SetCloseFunc(func(c *io.Client, msg io.Message) {
+		ser = &Server{s: s, e: New()}
+		//SOCKS5握手,其他协议交给HTTP代理处理
+		s.SetConnectFunc(ser.dealSocks5)
+		s.SetCloseFunc(func(c *io.Client, err error) {
+			//SOCKS5或HTTP CONNECT连接,关闭上游
+			if up, ok := c.Tag().GetInterface(KeyUpstream).(goio.Closer); ok {
+				up.Close()
+				return
+			}
 			//客户端关闭了连接,发送是数据到代理端关闭代理客户端
-			m := NewCMessage(c, NewCloseMessage(c.GetKey(), msg.String()))
+			m := NewCMessage(c, NewCloseMessage(c.GetKey(), err.Error()))
 			if ser.dealFunc != nil {
 				logs.PrintErr(ser.dealFunc(m))
 			}
 		})
 		s.SetDealFunc(func(c *io.Client, msg io.Message) {
+			//SOCKS5或HTTP CONNECT连接,写入上游,UDP ASSOCIATE的TCP连接不应该有数据
+			if up := c.Tag().GetInterface(KeyUpstream); up != nil {
+				if w, ok := up.(goio.Writer); ok {
+					w.Write(msg)
+				}
+				return
+			}
+
 			// 设置处理数据函数
 			// 处理监听到的用户数据,只能监听http协议数据
 			// 处理http的CONNECT数据,及处理端口等
@@ -114,7 +147,7 @@ func NewServer(dial io.ListenFunc, options ...func(s *Server)) (*Server, error)
 				addr, err := getAddr(c, msg)
 				if err != nil {
 					logs.Err(err)
-					C.Close()
+					c.Close()
 					return
 				}
 
@@ -164,6 +197,24 @@ func NewTCPServer(port int, options ...func(s *Server)) (*Server, error) {
 	return s, err
 }
 
+// pipe 客户端和目标交换数据,客户端的数据在Server的处理函数中写入目标
+func (this *Server) pipe(c *io.Client, i io.ReadWriteCloser, addr string) {
+	up := io.NewClient(i, func(up *io.Client) {
+		up.Debug(this.e.debug)
+		up.SetLogger(this.e.Logger)
+		up.SetKey(addr)
+		up.SetReadFunc(buf.Read1KB)
+		up.SetDealFunc(func(up *io.Client, msg io.Message) {
+			c.Write(msg)
+		})
+		up.SetCloseFunc(func(ctx context.Context, up *io.Client, err error) {
+			c.Close()
+		})
+	})
+	c.Tag().Set(KeyUpstream, up)
+	go up.Run()
+}
+
 // 获取请求地址
 func getAddr(c *io.Client, msg io.Message) (string, error) {
 	addr := c.Tag().GetString(KeyAddr)
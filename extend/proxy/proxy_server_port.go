@@ -1,61 +1,415 @@
 package proxy
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/injoyai/base/maps"
-	"github.com/injoyai/conv"
 	"github.com/injoyai/io"
 	"github.com/injoyai/io/listen"
-	"regexp"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-//func NewPortForwardingClient(addr, sn string, options ...func(c *io.Client, e *Entity)) *io.Client {
-//	return NewTCPClient(addr, func(c *io.Client, e *Entity) {
-//		for _, v := range options {
-//			v(c, e)
-//		}
-//		//注册
-//		c.Write(NewRegisterMessage(sn, sn).Bytes())
-//	})
-//}
-
-type PortForwardingServer struct {
-	*io.Server
-	listen *maps.Safe
-}
-
-// Listen 监听
-func (this *PortForwardingServer) Listen(port int, sn, addr string, options ...io.OptionServer) error {
-	s, err := listen.NewTCPServer(port, func(s *io.Server) {
-		s.Tag().Set("sn", sn)
-		s.Tag().Set("addr", addr)
-		s.SetDealFunc(func(c *io.Client, msg io.Message) {
-			{
-				if _addr := regexp.MustCompile("(\\?|&)(_addr=)[0-9.:]+").FindString(msg.String()); len(_addr) > 7 {
-					s.Tag().Set("addr", _addr[7:])
-				}
-				if _sn := regexp.MustCompile("(\\?|&)(_sn=)[0-9a-zA-Z]+").FindString(msg.String()); len(_sn) > 5 {
-					s.Tag().Set("sn", _sn[5:])
+// NewPortForwardingServer 端口转发服务端,监听客户端的注册
+func NewPortForwardingServer(port int, options ...func(s *PortForwardingServer)) (*PortForwardingServer, error) {
+	ser := &PortForwardingServer{agents: maps.NewSafe()}
+	_, err := listen.NewTCPServer(port, func(s *io.Server) {
+		ser.Server = s
+		s.SetConnectFunc(ser.register)
+		s.SetCloseFunc(func(c *io.Client, err error) {
+			if a, ok := c.Tag().GetInterface(KeyPortAgent).(*portAgent); ok {
+				//重复注册时已经替换成新的客户端
+				if ser.getAgent(a.sn) == a {
+					ser.agents.Del(a.sn)
 				}
-			}
-			sn = s.Tag().GetString("sn")
-			addr = s.Tag().GetString("addr")
-			pipe := this.GetClient(sn)
-			if pipe == nil {
-				c.CloseWithErr(fmt.Errorf("通道客户端未连接,关闭连接"))
-				return
-			}
-			key := fmt.Sprintf("%d#%s", port, c.GetKey())
-			if _, err := pipe.WriteAny(NewWriteMessage(key, addr, msg.Bytes())); err != nil {
-				c.Close()
+				a.close()
 			}
 		})
+		for _, v := range options {
+			v(ser)
+		}
+	})
+	return ser, err
+}
+
+// KeyPortAgent 客户端连接上保存的注册信息
+const KeyPortAgent = "port_agent"
+
+// PortForwardingServer 端口转发服务端
+type PortForwardingServer struct {
+	*io.Server
+	auth   func(r *PortRegister) error
+	cipher *io.CipherConfig
+	agents *maps.Safe //已注册的客户端,sn:*portAgent
+}
+
+// SetAuth 设置认证函数,返回错误则拒绝注册,也可以用于限制客户端能映射的端口
+func (this *PortForwardingServer) SetAuth(fn func(r *PortRegister) error) *PortForwardingServer {
+	this.auth = fn
+	return this
+}
+
+// SetCipher 设置加密传输,客户端需要配置相同的加密(PortConfig.Cipher)
+func (this *PortForwardingServer) SetCipher(cfg *io.CipherConfig) *PortForwardingServer {
+	this.cipher = cfg
+	return this
+}
+
+// Listen 为已注册的客户端打开端口映射,返回监听的端口,客户端需要允许该映射(SetAllow)
+func (this *PortForwardingServer) Listen(sn string, m *PortMapping) (int, error) {
+	a := this.getAgent(sn)
+	if a == nil {
+		return 0, fmt.Errorf("客户端(%s)未注册", sn)
+	}
+	return a.listen(m)
+}
+
+// CloseListen 关闭客户端的端口映射
+func (this *PortForwardingServer) CloseListen(sn, name string) error {
+	a := this.getAgent(sn)
+	if a == nil {
+		return fmt.Errorf("客户端(%s)未注册", sn)
+	}
+	return a.closeListen(name)
+}
+
+// PortAgentStatus 客户端状态
+type PortAgentStatus struct {
+	SN          string        `json:"sn"`          //客户端编号
+	Addr        string        `json:"addr"`        //客户端地址
+	ConnectTime time.Time     `json:"connectTime"` //注册时间
+	Streams     int           `json:"streams"`     //当前流的数量
+	Ports       []*PortStatus `json:"ports"`       //端口映射
+}
+
+// Status 已注册客户端的状态,按编号排序
+func (this *PortForwardingServer) Status() []*PortAgentStatus {
+	list := []*PortAgentStatus(nil)
+	this.agents.Range(func(key, value interface{}) bool {
+		list = append(list, value.(*portAgent).status())
+		return true
 	})
+	sort.Slice(list, func(i, j int) bool { return list[i].SN < list[j].SN })
+	return list
+}
+
+// ServeHTTP 状态接口,返回json,可以通过参数sn查询单个客户端
+func (this *PortForwardingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if sn := r.URL.Query().Get("sn"); len(sn) > 0 {
+		a := this.getAgent(sn)
+		if a == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"err": "客户端未注册"})
+			return
+		}
+		json.NewEncoder(w).Encode(a.status())
+		return
+	}
+	json.NewEncoder(w).Encode(this.Status())
+}
+
+func (this *PortForwardingServer) getAgent(sn string) *portAgent {
+	a, _ := this.agents.GetInterface(sn).(*portAgent)
+	return a
+}
+
+// register 读取注册信息,认证后打开端口映射,响应注册结果后开始多路复用
+func (this *PortForwardingServer) register(c *io.Client) error {
+	if this.cipher != nil {
+		c.SetCipherServer(this.cipher)
+	}
+	conn, _ := c.NetConn()
+	setReadDeadline(conn, io.DefaultConnectTimeout)
+	r := new(PortRegister)
+	if err := readPortFrame(c.Buffer(), r); err != nil {
+		return err
+	}
+	setReadDeadline(conn, 0)
+
+	a, result, err := this.newAgent(c, r)
 	if err != nil {
+		writePortFrame(c, &PortRegisterResult{Err: err.Error()})
+		return err
+	}
+	if err := writePortFrame(c, result); err != nil {
+		a.close()
 		return err
 	}
-	s.SetOptions(options...)
-	this.listen.Set(conv.String(port), s)
-	go s.Run()
+	c.SetKey(r.SN)
+	c.Tag().Set(KeyPortAgent, a)
+	this.agents.Set(r.SN, a)
+	//响应之后才能打开流
+	a.start()
 	return nil
 }
+
+func (this *PortForwardingServer) newAgent(c *io.Client, r *PortRegister) (*portAgent, *PortRegisterResult, error) {
+	if len(r.SN) == 0 {
+		return nil, nil, errors.New("缺少编号")
+	}
+	if this.auth != nil {
+		if err := this.auth(r); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	//重复注册,关闭老连接,释放端口
+	if old := this.getAgent(r.SN); old != nil {
+		this.agents.Del(r.SN)
+		old.close()
+		old.c.CloseAllWithErr(fmt.Errorf("重复注册(%s),关闭老客户端", r.SN))
+	}
+
+	a := &portAgent{
+		sn:          r.SN,
+		c:           c,
+		mux:         io.NewMuxServer(c),
+		listens:     make(map[string]*portListener),
+		connectTime: time.Now(),
+	}
+	result := &PortRegisterResult{
+		Ports:  make(map[string]int),
+		Errors: make(map[string]string),
+	}
+	for _, m := range r.Mappings {
+		port, err := a.listen(m)
+		if err != nil {
+			result.Errors[m.Name] = err.Error()
+			c.Errorf("[%s] 端口映射(%s)失败: %v\n", r.SN, m.Name, err)
+			continue
+		}
+		result.Ports[m.Name] = port
+	}
+	return a, result, nil
+}
+
+// portAgent 已注册的客户端
+type portAgent struct {
+	sn          string
+	c           *io.Client
+	mux         *io.Mux
+	mu          sync.Mutex
+	listens     map[string]*portListener
+	started     bool //是否已经响应注册结果,之后才能接收公网连接
+	connectTime time.Time
+}
+
+// start 开始接收公网连接
+func (this *portAgent) start() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.started = true
+	for _, l := range this.listens {
+		l.start()
+	}
+}
+
+func (this *portAgent) status() *PortAgentStatus {
+	s := &PortAgentStatus{
+		SN:          this.sn,
+		ConnectTime: this.connectTime,
+		Streams:     this.mux.NumStreams(),
+	}
+	if conn, ok := this.c.NetConn(); ok {
+		s.Addr = conn.RemoteAddr().String()
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, l := range this.listens {
+		s.Ports = append(s.Ports, l.stat.status(l.mapping))
+	}
+	sort.Slice(s.Ports, func(i, j int) bool { return s.Ports[i].Name < s.Ports[j].Name })
+	return s
+}
+
+// listen 打开公网端口,端口为0时使用随机端口
+func (this *portAgent) listen(m *PortMapping) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if _, ok := this.listens[m.Name]; ok {
+		return 0, fmt.Errorf("端口映射(%s)已存在", m.Name)
+	}
+	l := &portListener{agent: this}
+	switch m.network() {
+	case PortUDP:
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: m.Port})
+		if err != nil {
+			return 0, err
+		}
+		l.closer = conn
+		l.udp = &portUDP{listener: l, conn: conn, sessions: make(map[string]*portUDPSession)}
+		m2 := *m
+		m2.Port = conn.LocalAddr().(*net.UDPAddr).Port
+		l.mapping = &m2
+	default:
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", m.Port))
+		if err != nil {
+			return 0, err
+		}
+		l.closer = listener
+		l.tcp = listener
+		m2 := *m
+		m2.Port = listener.Addr().(*net.TCPAddr).Port
+		l.mapping = &m2
+	}
+	this.listens[m.Name] = l
+	if this.started {
+		l.start()
+	}
+	return l.mapping.Port, nil
+}
+
+func (this *portAgent) closeListen(name string) error {
+	this.mu.Lock()
+	l, ok := this.listens[name]
+	delete(this.listens, name)
+	this.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("端口映射(%s)不存在", name)
+	}
+	return l.closer.Close()
+}
+
+// close 关闭全部端口,已经建立的连接随多路复用关闭
+func (this *portAgent) close() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for k, l := range this.listens {
+		l.closer.Close()
+		delete(this.listens, k)
+	}
+}
+
+// portListener 公网端口
+type portListener struct {
+	agent   *portAgent
+	mapping *PortMapping
+	closer  interface{ Close() error }
+	tcp     net.Listener
+	udp     *portUDP
+	stat    portStat
+}
+
+func (this *portListener) start() {
+	if this.udp != nil {
+		go this.udp.run()
+		return
+	}
+	go this.runTCP(this.tcp)
+}
+
+// open 打开流,并发送端口映射
+func (this *portListener) open() (*io.Stream, error) {
+	stream, err := this.agent.mux.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := writePortFrame(stream, this.mapping); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (this *portListener) runTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			stream, err := this.open()
+			if err != nil {
+				conn.Close()
+				return
+			}
+			portPipe(&this.stat, conn, stream)
+		}()
+	}
+}
+
+// portUDP 公网UDP端口,每个公网地址对应一个流
+type portUDP struct {
+	listener *portListener
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	sessions map[string]*portUDPSession
+}
+
+type portUDPSession struct {
+	stream *io.Stream
+	timer  *time.Timer
+}
+
+func (this *portUDP) run() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := this.conn.ReadFromUDP(buf)
+		if err != nil {
+			this.mu.Lock()
+			for _, s := range this.sessions {
+				s.stream.Close()
+			}
+			this.mu.Unlock()
+			return
+		}
+		s, err := this.session(addr)
+		if err != nil {
+			continue
+		}
+		s.timer.Reset(PortUDPTimeout)
+		if _, err := s.stream.Write(encodePortPacket(buf[:n])); err == nil {
+			atomic.AddInt64(&this.listener.stat.input, int64(n))
+		}
+	}
+}
+
+func (this *portUDP) session(addr *net.UDPAddr) (*portUDPSession, error) {
+	key := addr.String()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if s, ok := this.sessions[key]; ok {
+		return s, nil
+	}
+	stream, err := this.listener.open()
+	if err != nil {
+		return nil, err
+	}
+	s := &portUDPSession{stream: stream}
+	s.timer = time.AfterFunc(PortUDPTimeout, func() {
+		this.mu.Lock()
+		delete(this.sessions, key)
+		this.mu.Unlock()
+		stream.Close()
+	})
+	this.sessions[key] = s
+	this.listener.stat.open()
+	//读取客户端的响应,写入公网地址
+	go func() {
+		defer this.listener.stat.close()
+		for {
+			bs, err := readPortPacket(stream)
+			if err != nil {
+				s.timer.Stop()
+				this.mu.Lock()
+				if this.sessions[key] == s {
+					delete(this.sessions, key)
+				}
+				this.mu.Unlock()
+				stream.Close()
+				return
+			}
+			s.timer.Reset(PortUDPTimeout)
+			if _, err := this.conn.WriteToUDP(bs, addr); err == nil {
+				atomic.AddInt64(&this.listener.stat.output, int64(len(bs)))
+			}
+		}
+	}()
+	return s, nil
+}
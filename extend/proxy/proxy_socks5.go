@@ -0,0 +1,502 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/injoyai/io"
+	goio "io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+
+SOCKS5 代理服务(RFC1928),用户名密码认证(RFC1929)
+
+和HTTP代理共用端口,根据第一个字节(0x05)自动识别
+支持CONNECT,BIND,UDP ASSOCIATE
+CONNECT和UDP ASSOCIATE通过Entity的连接函数(SetConnectFunc)连接目标,可以通过隧道转发
+BIND在本地监听端口,等待目标连接
+
+s, err := proxy.NewTCPServer(1080, func(s *proxy.Server) {
+	s.SetSocks5Auth(proxy.Socks5Users(map[string]string{"user": "pass"}))
+	s.SetSocks5ACL(func(r *proxy.Socks5Request) error {
+		if r.Username != "admin" && r.Command != proxy.Socks5Connect {
+			return errors.New("只允许CONNECT")
+		}
+		return nil
+	})
+})
+
+*/
+
+// Socks5BindTimeout BIND等待目标连接的超时时间
+var Socks5BindTimeout = time.Minute
+
+const (
+	socks5Version  = 0x05
+	socks5AuthNone = 0x00
+	socks5AuthUser = 0x02
+	socks5AuthNo   = 0xFF
+	socks5IPv4     = 0x01
+	socks5Domain   = 0x03
+	socks5IPv6     = 0x04
+
+	socks5Succeeded          = 0x00
+	socks5ServerFailure      = 0x01
+	socks5NotAllowed         = 0x02
+	socks5HostUnreachable    = 0x04
+	socks5ConnectionRefused  = 0x05
+	socks5CommandUnsupported = 0x07
+)
+
+// Socks5Command SOCKS5命令
+type Socks5Command uint8
+
+const (
+	Socks5Connect      Socks5Command = 0x01 //"connect"
+	Socks5Bind         Socks5Command = 0x02 //"bind"
+	Socks5UDPAssociate Socks5Command = 0x03 //"udp_associate"
+)
+
+func (this Socks5Command) String() string {
+	switch this {
+	case Socks5Connect:
+		return "connect"
+	case Socks5Bind:
+		return "bind"
+	case Socks5UDPAssociate:
+		return "udp_associate"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(this))
+	}
+}
+
+// Socks5Request SOCKS5请求,用于访问控制
+// UDP ASSOCIATE的每个新目标地址也会进行访问控制
+type Socks5Request struct {
+	Client   *io.Client    //客户端
+	Username string        //用户名,未开启认证为空
+	Command  Socks5Command //命令
+	Addr     string        //目标地址
+}
+
+// Socks5Users 根据用户名和密码认证
+func Socks5Users(users map[string]string) func(username, password string) bool {
+	return func(username, password string) bool {
+		pwd, ok := users[username]
+		return ok && pwd == password
+	}
+}
+
+// SetSocks5Auth 设置SOCKS5用户名密码认证,nil表示不需要认证
+func (this *Server) SetSocks5Auth(fn func(username, password string) bool) {
+	this.socks5Auth = fn
+}
+
+// SetSocks5ACL 设置SOCKS5访问控制,返回错误则拒绝请求
+func (this *Server) SetSocks5ACL(fn func(r *Socks5Request) error) {
+	this.socks5ACL = fn
+}
+
+// dealSocks5 连接事件,识别SOCKS5协议并握手,其他协议交给HTTP代理处理
+func (this *Server) dealSocks5(c *io.Client) error {
+	r := c.Buffer()
+	if conn, ok := c.ReadWriteCloser().(net.Conn); ok {
+		conn.SetReadDeadline(time.Now().Add(io.DefaultConnectTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	b, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		this.dealHTTP(c)
+		return nil
+	}
+
+	req, err := this.socks5Handshake(c, r)
+	if err != nil {
+		return err
+	}
+	if err := this.socks5Check(req); err != nil {
+		socks5Reply(c, socks5NotAllowed, nil)
+		return err
+	}
+	switch req.Command {
+	case Socks5Connect:
+		return this.socks5Connect(c, req)
+	case Socks5Bind:
+		return this.socks5Bind(c, req)
+	case Socks5UDPAssociate:
+		return this.socks5UDPAssociate(c, req)
+	default:
+		socks5Reply(c, socks5CommandUnsupported, nil)
+		return fmt.Errorf("不支持的SOCKS5命令(%s)", req.Command)
+	}
+}
+
+// socks5Handshake 协商认证方式,认证,读取请求
+func (this *Server) socks5Handshake(c *io.Client, r *bufio.Reader) (*Socks5Request, error) {
+	head := make([]byte, 2)
+	if _, err := goio.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, head[1])
+	if _, err := goio.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+	method := byte(socks5AuthNone)
+	if this.socks5Auth != nil {
+		method = socks5AuthUser
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		c.Write([]byte{socks5Version, socks5AuthNo})
+		return nil, errors.New("SOCKS5客户端不支持的认证方式")
+	}
+	if _, err := c.Write([]byte{socks5Version, method}); err != nil {
+		return nil, err
+	}
+
+	req := &Socks5Request{Client: c}
+	if method == socks5AuthUser {
+		//VER ULEN UNAME PLEN PASSWD
+		if _, err := r.ReadByte(); err != nil {
+			return nil, err
+		}
+		username, err := readSocks5String(r)
+		if err != nil {
+			return nil, err
+		}
+		password, err := readSocks5String(r)
+		if err != nil {
+			return nil, err
+		}
+		if !this.socks5Auth(username, password) {
+			c.Write([]byte{0x01, 0x01})
+			return nil, fmt.Errorf("SOCKS5用户(%s)认证失败", username)
+		}
+		if _, err := c.Write([]byte{0x01, 0x00}); err != nil {
+			return nil, err
+		}
+		req.Username = username
+	}
+
+	//VER CMD RSV ATYP DST.ADDR DST.PORT
+	buf := make([]byte, 3)
+	if _, err := goio.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5Version {
+		return nil, fmt.Errorf("无效SOCKS版本(%d)", buf[0])
+	}
+	req.Command = Socks5Command(buf[1])
+	addr, err := readSocks5Addr(r)
+	if err != nil {
+		return nil, err
+	}
+	req.Addr = addr
+	return req, nil
+}
+
+// socks5Check 访问控制
+func (this *Server) socks5Check(req *Socks5Request) error {
+	if this.socks5ACL == nil {
+		return nil
+	}
+	return this.socks5ACL(req)
+}
+
+// socks5Connect 通过Entity的连接函数连接目标
+func (this *Server) socks5Connect(c *io.Client, req *Socks5Request) error {
+	i, err := this.e.connect(NewConnectMessage(c.GetKey(), req.Addr))
+	if err != nil {
+		socks5Reply(c, socks5ReplyCode(err), nil)
+		return err
+	}
+	var local net.Addr
+	if conn, ok := i.(net.Conn); ok {
+		local = conn.LocalAddr()
+	}
+	if err := socks5Reply(c, socks5Succeeded, local); err != nil {
+		i.Close()
+		return err
+	}
+	this.pipe(c, i, req.Addr)
+	return nil
+}
+
+// socks5Bind 本地监听端口,等待目标连接
+func (this *Server) socks5Bind(c *io.Client, req *Socks5Request) error {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localIP(c)})
+	if err != nil {
+		socks5Reply(c, socks5ServerFailure, nil)
+		return err
+	}
+	defer l.Close()
+	if err := socks5Reply(c, socks5Succeeded, l.Addr()); err != nil {
+		return err
+	}
+	l.SetDeadline(time.Now().Add(Socks5BindTimeout))
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		socks5Reply(c, socks5ServerFailure, nil)
+		return err
+	}
+	//只允许请求的地址连接
+	if host, _, err := net.SplitHostPort(req.Addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() && !ip.Equal(conn.RemoteAddr().(*net.TCPAddr).IP) {
+			conn.Close()
+			socks5Reply(c, socks5NotAllowed, nil)
+			return fmt.Errorf("BIND连接地址(%s)和请求地址(%s)不一致", conn.RemoteAddr(), req.Addr)
+		}
+	}
+	if err := socks5Reply(c, socks5Succeeded, conn.RemoteAddr()); err != nil {
+		conn.Close()
+		return err
+	}
+	this.pipe(c, conn, conn.RemoteAddr().String())
+	return nil
+}
+
+// socks5UDPAssociate 本地监听UDP端口,中继客户端的UDP数据,TCP连接断开时结束
+func (this *Server) socks5UDPAssociate(c *io.Client, req *Socks5Request) error {
+	ip := localIP(c)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		socks5Reply(c, socks5ServerFailure, nil)
+		return err
+	}
+	if err := socks5Reply(c, socks5Succeeded, conn.LocalAddr()); err != nil {
+		conn.Close()
+		return err
+	}
+	relay := &socks5UDP{
+		s:       this,
+		req:     req,
+		conn:    conn,
+		targets: make(map[string]io.ReadWriteCloser),
+	}
+	if addr, ok := c.ReadWriteCloser().(net.Conn); ok {
+		relay.clientIP = addr.RemoteAddr().(*net.TCPAddr).IP
+	}
+	c.Tag().Set(KeyUpstream, relay)
+	go relay.run()
+	return nil
+}
+
+// socks5UDP UDP中继
+type socks5UDP struct {
+	s        *Server
+	req      *Socks5Request
+	conn     *net.UDPConn
+	clientIP net.IP //只接收TCP客户端IP的数据
+
+	mu      sync.Mutex
+	client  *net.UDPAddr                  //客户端的UDP地址,第一次收到数据时记录
+	targets map[string]io.ReadWriteCloser //目标地址的连接
+	closed  bool
+}
+
+func (this *socks5UDP) run() {
+	defer this.Close()
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := this.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if this.clientIP != nil && !this.clientIP.Equal(from.IP) {
+			continue
+		}
+		this.mu.Lock()
+		this.client = from
+		this.mu.Unlock()
+
+		//RSV RSV FRAG ATYP DST.ADDR DST.PORT DATA,不支持分片
+		if n < 4 || buf[2] != 0x00 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		addr, err := readSocks5Addr(r)
+		if err != nil {
+			continue
+		}
+		target, err := this.target(addr)
+		if err != nil {
+			this.s.e.Errorf("[SOCKS5] UDP连接(%s)失败: %v\n", addr, err)
+			continue
+		}
+		target.Write(buf[n-r.Len() : n])
+	}
+}
+
+// target 获取或者连接目标地址,新的目标地址需要进行访问控制
+func (this *socks5UDP) target(addr string) (io.ReadWriteCloser, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return nil, io.ErrWithContext
+	}
+	if i, ok := this.targets[addr]; ok {
+		return i, nil
+	}
+	if err := this.s.socks5Check(&Socks5Request{
+		Client:   this.req.Client,
+		Username: this.req.Username,
+		Command:  Socks5UDPAssociate,
+		Addr:     addr,
+	}); err != nil {
+		return nil, err
+	}
+	i, err := this.s.e.connect(&Message{
+		OperateType: Connect,
+		ConnectType: UDP,
+		Key:         this.req.Client.GetKey() + "#" + addr,
+		Addr:        addr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	this.targets[addr] = i
+	go this.read(addr, i)
+	return i, nil
+}
+
+// read 读取目标的数据,加上地址头发送给客户端
+func (this *socks5UDP) read(addr string, i io.ReadWriteCloser) {
+	head := append([]byte{0x00, 0x00, 0x00}, socks5Addr(addr)...)
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := i.Read(buf)
+		if err != nil {
+			this.mu.Lock()
+			delete(this.targets, addr)
+			this.mu.Unlock()
+			return
+		}
+		this.mu.Lock()
+		client := this.client
+		this.mu.Unlock()
+		if client != nil {
+			this.conn.WriteToUDP(append(head[:len(head):len(head)], buf[:n]...), client)
+		}
+	}
+}
+
+// Close 关闭UDP中继和所有目标连接
+func (this *socks5UDP) Close() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	for _, i := range this.targets {
+		i.Close()
+	}
+	return this.conn.Close()
+}
+
+//================================Inside================================
+
+// localIP 客户端连接的本地IP,用于BIND和UDP ASSOCIATE监听
+func localIP(c *io.Client) net.IP {
+	if conn, ok := c.ReadWriteCloser().(net.Conn); ok {
+		if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+			return addr.IP
+		}
+	}
+	return nil
+}
+
+// socks5Reply 响应,VER REP RSV ATYP BND.ADDR BND.PORT
+func socks5Reply(c *io.Client, rep byte, addr net.Addr) error {
+	bnd := "0.0.0.0:0"
+	if addr != nil {
+		bnd = addr.String()
+	}
+	_, err := c.Write(append([]byte{socks5Version, rep, 0x00}, socks5Addr(bnd)...))
+	return err
+}
+
+// socks5ReplyCode 根据连接错误获取响应码
+func socks5ReplyCode(err error) byte {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5ConnectionRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return socks5HostUnreachable
+	default:
+		return socks5ServerFailure
+	}
+}
+
+// socks5Addr 编码地址,ATYP DST.ADDR DST.PORT
+func socks5Addr(addr string) []byte {
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	var bs []byte
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		bs = append([]byte{socks5Domain, byte(len(host))}, host...)
+	case ip.To4() != nil:
+		bs = append([]byte{socks5IPv4}, ip.To4()...)
+	default:
+		bs = append([]byte{socks5IPv6}, ip.To16()...)
+	}
+	return append(bs, byte(port>>8), byte(port))
+}
+
+// readSocks5Addr 读取地址,ATYP DST.ADDR DST.PORT
+func readSocks5Addr(r goio.Reader) (string, error) {
+	b := make([]byte, 1)
+	if _, err := goio.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	var host string
+	switch b[0] {
+	case socks5IPv4, socks5IPv6:
+		ip := make(net.IP, net.IPv4len)
+		if b[0] == socks5IPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := goio.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5Domain:
+		s, err := readSocks5String(r)
+		if err != nil {
+			return "", err
+		}
+		host = s
+	default:
+		return "", fmt.Errorf("无效地址类型(%d)", b[0])
+	}
+	port := make([]byte, 2)
+	if _, err := goio.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+// readSocks5String 读取长度+字符串
+func readSocks5String(r goio.Reader) (string, error) {
+	b := make([]byte, 1)
+	if _, err := goio.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	bs := make([]byte, b[0])
+	if _, err := goio.ReadFull(r, bs); err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/listen"
+	xproxy "golang.org/x/net/proxy"
+	goio "io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSocks5Server 新建本地代理服务,返回地址
+func newSocks5Server(t *testing.T, options ...func(s *Server)) string {
+	s, err := NewServer(listen.WithTCP(0), func(s *Server) {
+		s.Debug(false)
+		s.SetOptions(options...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	go s.Run()
+	_, port, _ := net.SplitHostPort(s.Server().Listener().Addr())
+	return "127.0.0.1:" + port
+}
+
+// newEcho 新建TCP回复服务
+func newEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go goio.Copy(c, c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// echo 写入并读取回复
+func echo(t *testing.T, c net.Conn, s string) {
+	t.Helper()
+	if _, err := c.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, len(s))
+	if _, err := goio.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != s {
+		t.Fatalf("数据错误(%s)", buf)
+	}
+}
+
+// socks5Request 不认证,发送请求并读取响应的地址
+func socks5Request(t *testing.T, addr string, cmd Socks5Command, dst string) (net.Conn, string) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(time.Second * 5))
+	c.Write([]byte{socks5Version, 1, socks5AuthNone})
+	buf := make([]byte, 2)
+	if _, err := goio.ReadFull(c, buf); err != nil || buf[1] != socks5AuthNone {
+		t.Fatalf("协商失败: %v %v", buf, err)
+	}
+	c.Write(append([]byte{socks5Version, byte(cmd), 0x00}, socks5Addr(dst)...))
+	return c, socks5ReadReply(t, c)
+}
+
+func socks5ReadReply(t *testing.T, c net.Conn) string {
+	t.Helper()
+	buf := make([]byte, 3)
+	if _, err := goio.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[1] != socks5Succeeded {
+		t.Fatalf("请求失败(%d)", buf[1])
+	}
+	bnd, err := readSocks5Addr(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bnd
+}
+
+func TestSocks5Connect(t *testing.T) {
+	target := newEcho(t)
+	addr := newSocks5Server(t, func(s *Server) {
+		s.SetSocks5Auth(Socks5Users(map[string]string{"user": "pass", "guest": "guest"}))
+		s.SetSocks5ACL(func(r *Socks5Request) error {
+			if r.Username == "guest" {
+				return errors.New("禁止访问")
+			}
+			return nil
+		})
+	})
+
+	d, err := xproxy.SOCKS5("tcp", addr, &xproxy.Auth{User: "user", Password: "pass"}, xproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	echo(t, c, "hello")
+	echo(t, c, strings.Repeat("x", 5000))
+
+	//认证失败
+	d, _ = xproxy.SOCKS5("tcp", addr, &xproxy.Auth{User: "user", Password: "wrong"}, xproxy.Direct)
+	if _, err := d.Dial("tcp", target); err == nil {
+		t.Fatal("预期认证失败")
+	}
+
+	//访问控制
+	d, _ = xproxy.SOCKS5("tcp", addr, &xproxy.Auth{User: "guest", Password: "guest"}, xproxy.Direct)
+	if _, err := d.Dial("tcp", target); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("预期禁止访问,得到(%v)", err)
+	}
+}
+
+func TestSocks5ConnectEntity(t *testing.T) {
+	target := newEcho(t)
+	var connected []string
+	addr := newSocks5Server(t, func(s *Server) {
+		//上游连接函数,例如通过隧道连接
+		s.Entity().SetConnectFunc(func(msg *Message) (io.ReadWriteCloser, error) {
+			connected = append(connected, msg.Addr)
+			return net.Dial("tcp", target)
+		})
+	})
+	d, _ := xproxy.SOCKS5("tcp", addr, nil, xproxy.Direct)
+	c, err := d.Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	echo(t, c, "hello")
+	if len(connected) != 1 || connected[0] != "example.com:80" {
+		t.Fatalf("上游连接错误: %v", connected)
+	}
+}
+
+func TestSocks5UDPAssociate(t *testing.T) {
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := target.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			target.WriteTo(buf[:n], from)
+		}
+	}()
+
+	addr := newSocks5Server(t)
+	_, bnd := socks5Request(t, addr, Socks5UDPAssociate, "0.0.0.0:0")
+	_, port, _ := net.SplitHostPort(bnd)
+	c, err := net.Dial("udp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	head := append([]byte{0, 0, 0}, socks5Addr(target.LocalAddr().String())...)
+	if _, err := c.Write(append(head, "hello"...)); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != string(head)+"hello" {
+		t.Fatalf("数据错误(%v)", buf[:n])
+	}
+}
+
+func TestSocks5Bind(t *testing.T) {
+	addr := newSocks5Server(t)
+	c, bnd := socks5Request(t, addr, Socks5Bind, "127.0.0.1:0")
+
+	//目标连接BIND的地址
+	remote, err := net.Dial("tcp", bnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	if from := socks5ReadReply(t, c); from != remote.LocalAddr().String() {
+		t.Fatalf("连接地址错误,预期(%s),得到(%s)", remote.LocalAddr(), from)
+	}
+
+	go goio.Copy(remote, remote)
+	echo(t, c, "hello")
+}
@@ -1,46 +1,104 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
-	"github.com/injoyai/base/g"
-	"github.com/injoyai/base/maps"
-	"github.com/injoyai/base/maps/wait"
 	"github.com/injoyai/io"
+	"github.com/injoyai/io/codec"
 	"github.com/injoyai/io/dial"
+	"sync"
 	"time"
 )
 
 type Client struct {
-	cfg  *ClientConfig
-	pool *io.Pool
-	wait *wait.Entity
-	bind *maps.Safe
+	cfg      *ClientConfig
+	pool     *io.Pool
+	handlers *handlers
+	mu       sync.Mutex
+	conns    map[*io.Client]*clientConn
 }
 
+// clientConn 连接池中的连接,第一次调用前注册
+type clientConn struct {
+	*Conn
+	once sync.Once
+	err  error
+}
+
+// Bind 注册处理函数,服务端可以调用,推荐使用Register注册具体类型的处理函数
 func (this *Client) Bind(Type string, handler Handler) {
-	this.bind.Set(Type, handler)
+	this.handlers.Bind(Type, handler)
 }
 
+// Do 调用服务端注册的处理函数
 func (this *Client) Do(Type string, data interface{}) (interface{}, error) {
-	return do(this.pool, this.wait, Type, data)
+	ctx, cancel := context.WithTimeout(context.Background(), this.cfg.ResponseTimeout)
+	defer cancel()
+	return Call[interface{}, interface{}](ctx, this, Type, data)
+}
+
+// NewStream 发起调用,从连接池获取一个连接,整个调用都使用这个连接
+func (this *Client) NewStream(ctx context.Context, method string, req interface{}) (*Stream, error) {
+	conn, err := this.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.NewStream(ctx, method, req)
+}
+
+// Close 关闭连接池
+func (this *Client) Close() error {
+	return this.pool.Close()
+}
+
+// getConn 从连接池获取连接,未注册的先注册,注册失败则关闭连接
+func (this *Client) getConn(ctx context.Context) (*Conn, error) {
+	c, err := this.pool.Get()
+	if err != nil {
+		return nil, NewError(CodeUnavailable, err.Error())
+	}
+	this.pool.Put(c)
+	this.mu.Lock()
+	conn := this.conns[c]
+	this.mu.Unlock()
+	if conn == nil {
+		return nil, NewError(CodeUnavailable, "连接已断开")
+	}
+	conn.once.Do(func() {
+		var res registerRes
+		res, conn.err = Call[*ClientConfig, registerRes](ctx, conn.Conn, io.Register, this.cfg)
+		if conn.err != nil {
+			c.CloseWithErr(conn.err)
+			return
+		}
+		//服务端选择的编码格式,旧版本的服务端不返回,继续使用json
+		if len(res.Codec) > 0 && !conn.setCodec(codec.Get(res.Codec)) {
+			c.Errorf("[%s] 未知的编码格式(%s)\n", c.GetKey(), res.Codec)
+		}
+	})
+	return conn.Conn, conn.err
 }
 
 func NewClient(cfg *ClientConfig, option ...io.OptionClient) *Client {
 	cfg.init()
 	cli := &Client{
-		cfg:  cfg,
-		wait: wait.New(cfg.ResponseTimeout),
+		cfg:      cfg,
+		handlers: newHandlers(),
+		conns:    make(map[*io.Client]*clientConn),
 	}
-	cli.pool = io.NewPool(dial.WithTCP(cfg.Address), func(c *io.Client) {
+	cli.pool = io.NewPool(dial.WithTCPTimeout(cfg.Address, cfg.ConnectTimeout), func(c *io.Client) {
 		c.SetOptions(option...)
 		c.SetReadWriteWithPkg()
-		c.SetDealFunc(func(c *io.Client, msg io.Message) {
-			dealFunc(cli.bind, cli.wait, c, msg)
-		})
-		c.WriteAny(&io.Model{
-			Type: io.Register,
-			UID:  g.UUID(),
-			Data: cfg,
+		conn := &clientConn{Conn: newConn(c, cli.handlers, 1)}
+		cli.mu.Lock()
+		cli.conns[c] = conn
+		cli.mu.Unlock()
+		c.SetDealFunc(conn.deal)
+		c.SetCloseFunc(func(ctx context.Context, c *io.Client, err error) {
+			cli.mu.Lock()
+			delete(cli.conns, c)
+			cli.mu.Unlock()
+			conn.close(err)
 		})
 	})
 	go cli.pool.PutNew(3)
@@ -52,10 +110,17 @@ type ClientConfig struct {
 	Name            string        `json:"name"`    //客户端名称
 	Memo            string        `json:"memo"`    //客户端备注
 	Version         string        `json:"version"` //客户端版本
+	Codecs          []string      `json:"codecs"`  //支持的编码格式,按优先级排列,注册时协商,默认全部已注册的(json优先)
 	ConnectTimeout  time.Duration `json:"-"`       //连接超时时间
 	ResponseTimeout time.Duration `json:"-"`       //响应超时时间
 }
 
+// registerRes 注册的响应
+type registerRes struct {
+	Key   string `json:"key"`
+	Codec string `json:"codec"` //服务端选择的编码格式
+}
+
 func (this *ClientConfig) init() {
 	if len(this.Name) == 0 {
 		this.Name = fmt.Sprintf("%p", this)
@@ -63,6 +128,9 @@ func (this *ClientConfig) init() {
 	if len(this.Version) == 0 {
 		this.Version = "v0.0.0"
 	}
+	if len(this.Codecs) == 0 {
+		this.Codecs = codec.Names()
+	}
 	if this.ConnectTimeout <= 0 {
 		this.ConnectTimeout = io.DefaultConnectTimeout
 	}
@@ -2,61 +2,435 @@ package rpc
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/binary"
 	"errors"
-	"github.com/injoyai/base/g"
-	"github.com/injoyai/base/maps"
-	"github.com/injoyai/base/maps/wait"
-	"github.com/injoyai/conv"
+	"fmt"
 	"github.com/injoyai/io"
-	"net/http"
+	"github.com/injoyai/io/codec"
+	"strconv"
+	"sync"
+	"time"
 )
 
-// dealFunc 处理远程消息
-func dealFunc(bind *maps.Safe, wait *wait.Entity, c *io.Client, msg io.Message) {
-	req := new(io.Model)
-	if err := json.Unmarshal(msg.Bytes(), req); err != nil {
-		c.CloseWithErr(err)
-		return
+/*
+
+调用通过帧传输,使用通用封装包(io.Pkg)分包
+一次调用是一个流,由调用方分配编号,客户端发起的是奇数,服务端发起的是偶数,双方可以同时发起调用
+调用方发送请求帧(可携带请求数据),之后双方都可以发送数据帧,调用方发送结束帧表示不再发送
+被调用方发送响应帧结束调用,带错误码,调用方可以发送取消帧,被调用方的上下文会被取消
+调用方上下文的截止时间通过元数据(剩余时间)传给被调用方
+数据的编码格式在注册时协商,默认json,每帧带编码编号(codec.ID),接收方按编号解码
+
+帧格式
+类型(1) 编码(1) 编号(4) 错误码(2) 方法名长度(1)+方法名 错误信息长度(2)+错误信息 元数据数量(1)+[键长度(1)+键 值长度(2)+值] 数据
+
+*/
+
+const (
+	frameRequest  uint8 = iota + 1 //发起调用
+	frameData                      //数据
+	frameEnd                       //调用方结束发送
+	frameResponse                  //被调用方响应,结束调用
+	frameCancel                    //调用方取消调用
+
+	metaTimeout = "timeout" //调用的剩余时间(毫秒),对方根据收到的时间计算截止时间,避免时钟不一致
+)
+
+var errFrame = errors.New("无效的数据帧")
+
+// Handler 处理函数,通过s读取请求,接收和发送流数据,返回响应的数据
+type Handler func(ctx context.Context, s *Stream) (interface{}, error)
+
+// Binder 能注册处理函数,例如Server和Client
+type Binder interface {
+	Bind(method string, handler Handler)
+}
+
+// Caller 能发起调用,例如Client和Conn
+type Caller interface {
+	NewStream(ctx context.Context, method string, req interface{}) (*Stream, error)
+}
+
+type frame struct {
+	Kind   uint8
+	Codec  byte
+	ID     uint32
+	Code   int
+	Method string
+	Msg    string
+	Meta   map[string]string
+	Data   []byte
+}
+
+func (this *frame) Bytes() []byte {
+	bs := make([]byte, 8, 12+len(this.Method)+len(this.Msg)+len(this.Data))
+	bs[0] = this.Kind
+	bs[1] = this.Codec
+	binary.BigEndian.PutUint32(bs[2:6], this.ID)
+	binary.BigEndian.PutUint16(bs[6:8], uint16(this.Code))
+	bs = appendString(bs, this.Method, 1)
+	bs = appendString(bs, this.Msg, 2)
+	bs = append(bs, byte(len(this.Meta)))
+	for k, v := range this.Meta {
+		bs = appendString(bs, k, 1)
+		bs = appendString(bs, v, 2)
 	}
+	return append(bs, this.Data...)
+}
+
+// appendString 添加长度和字符串,超过长度的部分丢弃
+func appendString(bs []byte, s string, size int) []byte {
+	max := 1<<(8*size) - 1
+	if len(s) > max {
+		s = s[:max]
+	}
+	if size == 1 {
+		bs = append(bs, byte(len(s)))
+	} else {
+		bs = append(bs, byte(len(s)>>8), byte(len(s)))
+	}
+	return append(bs, s...)
+}
 
-	if req.IsRequest() {
-		h, ok := bind.Get(req.Type)
+func decodeFrame(bs []byte) (*frame, error) {
+	if len(bs) < 8 {
+		return nil, errFrame
+	}
+	f := &frame{
+		Kind:  bs[0],
+		Codec: bs[1],
+		ID:    binary.BigEndian.Uint32(bs[2:6]),
+		Code:  int(binary.BigEndian.Uint16(bs[6:8])),
+	}
+	bs = bs[8:]
+	readString := func(size int) (string, bool) {
+		if len(bs) < size {
+			return "", false
+		}
+		n := int(bs[0])
+		if size == 2 {
+			n = int(binary.BigEndian.Uint16(bs))
+		}
+		if len(bs) < size+n {
+			return "", false
+		}
+		s := string(bs[size : size+n])
+		bs = bs[size+n:]
+		return s, true
+	}
+	var ok bool
+	if f.Method, ok = readString(1); !ok {
+		return nil, errFrame
+	}
+	if f.Msg, ok = readString(2); !ok {
+		return nil, errFrame
+	}
+	if len(bs) < 1 {
+		return nil, errFrame
+	}
+	n := int(bs[0])
+	bs = bs[1:]
+	if n > 0 {
+		f.Meta = make(map[string]string, n)
+	}
+	for i := 0; i < n; i++ {
+		k, ok := readString(1)
 		if !ok {
-			req.Resp(http.StatusNotFound, nil, "not find")
-			return
+			return nil, errFrame
 		}
-		//协程处理,防止阻塞
-		go func(c *io.Client, h Handler, m *io.Model) {
-			data, err := h(context.Background(), c, m)
-			c.WriteAny(m.Resp(
-				conv.SelectInt(err == nil, http.StatusOK, http.StatusInternalServerError),
-				data,
-				conv.New(err).String("成功"),
-			))
-		}(c, h.(Handler), req)
+		v, ok := readString(2)
+		if !ok {
+			return nil, errFrame
+		}
+		f.Meta[k] = v
+	}
+	//读取的数据可能会被归还到字节池,需要复制
+	f.Data = append([]byte(nil), bs...)
+	return f, nil
+}
+
+func (this *frame) payload() payload {
+	return payload{codec: this.Codec, data: this.Data}
+}
+
+// payload 数据和数据的编码编号
+type payload struct {
+	codec byte
+	data  []byte
+}
+
+func (this payload) decode(v interface{}) error {
+	if len(this.data) == 0 || v == nil {
+		return nil
+	}
+	c := codec.GetByID(this.codec)
+	if c == nil {
+		return fmt.Errorf("未知的编码格式(%d)", this.codec)
+	}
+	return c.Unmarshal(this.data, v)
+}
+
+// handlers 注册的处理函数
+type handlers struct {
+	mu sync.RWMutex
+	m  map[string]Handler
+}
+
+func newHandlers() *handlers {
+	return &handlers{m: make(map[string]Handler)}
+}
+
+func (this *handlers) Bind(method string, handler Handler) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.m[method] = handler
+}
+
+func (this *handlers) get(method string) Handler {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.m[method]
+}
+
+// newConn 新建连接,客户端的firstID是1,服务端是2
+func newConn(c *io.Client, h *handlers, firstID uint32) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		c:        c,
+		handlers: h,
+		nextID:   firstID,
+		calls:    make(map[uint32]*Stream),
+		serves:   make(map[uint32]*Stream),
+		codec:    codec.JSON,
+		codecID:  codec.IDJSON,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Conn 一个连接,可以同时发起和处理多个调用
+type Conn struct {
+	c        *io.Client
+	handlers *handlers
+	wmu      sync.Mutex //写入锁,客户端的写入不能并发
+	mu       sync.Mutex
+	nextID   uint32
+	calls    map[uint32]*Stream //发起的调用
+	serves   map[uint32]*Stream //处理的调用
+	closed   bool
+	codec    codec.Codec //发送数据的编码格式,注册时协商
+	codecID  byte
+	ctx      context.Context //连接断开后取消处理的调用
+	cancel   context.CancelFunc
+}
+
+// Client 底层的客户端
+func (this *Conn) Client() *io.Client {
+	return this.c
+}
+
+// Codec 发送数据使用的编码格式
+func (this *Conn) Codec() codec.Codec {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.codec
+}
+
+// setCodec 设置发送数据的编码格式,需要是已注册的
+func (this *Conn) setCodec(c codec.Codec) bool {
+	id, ok := codec.ID(c)
+	if !ok {
+		return false
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.codec, this.codecID = c, id
+	return true
+}
+
+// marshal 使用当前的编码格式编码
+func (this *Conn) marshal(v interface{}) (payload, error) {
+	if v == nil {
+		return payload{}, nil
+	}
+	this.mu.Lock()
+	c, id := this.codec, this.codecID
+	this.mu.Unlock()
+	data, err := c.Marshal(v)
+	return payload{codec: id, data: data}, err
+}
+
+// NewStream 发起调用,上下文取消后通知对方取消,需要读取响应(Stream.Response)或接收到结束
+func (this *Conn) NewStream(ctx context.Context, method string, req interface{}) (*Stream, error) {
+	p, err := this.marshal(req)
+	if err != nil {
+		return nil, Errorf(CodeBadRequest, "编码请求数据失败: %v", err)
+	}
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return nil, NewError(CodeUnavailable, "连接已断开")
+	}
+	id := this.nextID
+	this.nextID += 2
+	s := newStream(this, id, method, true)
+	this.calls[id] = s
+	this.mu.Unlock()
+
+	f := &frame{Kind: frameRequest, Codec: p.codec, ID: id, Method: method, Data: p.data}
+	if deadline, ok := ctx.Deadline(); ok {
+		f.Meta = map[string]string{metaTimeout: strconv.FormatInt(int64(time.Until(deadline)/time.Millisecond), 10)}
+	}
+	if err := this.write(f); err != nil {
+		s.finish(payload{}, NewError(CodeUnavailable, err.Error()))
+		return nil, s.err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			if s.finish(payload{}, toError(ctx.Err())) {
+				this.write(&frame{Kind: frameCancel, ID: id})
+			}
+		case <-s.done:
+		}
+	}()
+	return s, nil
+}
+
+func (this *Conn) write(f *frame) error {
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	_, err := this.c.Write(f.Bytes())
+	return err
+}
+
+// deal 处理对方的数据帧
+func (this *Conn) deal(c *io.Client, msg io.Message) {
+	f, err := decodeFrame(msg)
+	if err != nil {
+		c.Errorf("[%s] %v\n", c.GetKey(), err)
 		return
 	}
-	//响应数据
-	wait.Done(
-		req.UID,
-		conv.Select(req.IsSucc(), req.Data, errors.New(req.Msg)),
-	)
+
+	switch f.Kind {
+	case frameRequest:
+		this.serve(f)
+
+	case frameData:
+		if s := this.get(f.ID); s != nil {
+			s.push(f.payload())
+		}
+
+	case frameEnd:
+		if s := this.get(f.ID); s != nil && !s.caller {
+			s.closeRecv()
+		}
+
+	case frameResponse:
+		if s := this.get(f.ID); s != nil && s.caller {
+			if f.Code != CodeOK {
+				s.finish(payload{}, NewError(f.Code, f.Msg))
+			} else {
+				s.finish(f.payload(), nil)
+			}
+		}
+
+	case frameCancel:
+		if s := this.get(f.ID); s != nil && !s.caller {
+			s.finish(payload{}, NewError(CodeCanceled, context.Canceled.Error()))
+		}
+
+	}
 }
 
-// do 执行远程调用
-func do(c io.AnyWriterClosed, wait *wait.Entity, Type string, data interface{}) (interface{}, error) {
-	if c == nil || c.Closed() {
-		return nil, errors.New("rpc未连接")
+// serve 处理对方发起的调用,协程处理,防止阻塞
+func (this *Conn) serve(f *frame) {
+	h := this.handlers.get(f.Method)
+	if h == nil {
+		this.write(&frame{Kind: frameResponse, ID: f.ID, Code: CodeNotFound, Msg: "方法(" + f.Method + ")不存在"})
+		return
+	}
+	s := newStream(this, f.ID, f.Method, false)
+	s.req = f.payload()
+	if ms, err := strconv.ParseInt(f.Meta[metaTimeout], 10, 64); err == nil {
+		s.ctx, s.cancel = context.WithTimeout(this.ctx, time.Duration(ms)*time.Millisecond)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(this.ctx)
+	}
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return
+	}
+	this.serves[f.ID] = s
+	this.mu.Unlock()
+
+	go func() {
+		data, err := h(s.ctx, s)
+		if !s.finish(payload{}, nil) {
+			//调用方已经取消
+			return
+		}
+		resp := &frame{Kind: frameResponse, ID: f.ID, Code: CodeOK}
+		if err == nil {
+			p, e := this.marshal(data)
+			if e != nil {
+				err = Errorf(CodeInternal, "编码响应数据失败: %v", e)
+			}
+			resp.Codec, resp.Data = p.codec, p.data
+		}
+		if err != nil {
+			e := toError(err)
+			resp.Code, resp.Msg, resp.Codec, resp.Data = e.Code, e.Msg, 0, nil
+		}
+		if err := this.write(resp); err != nil {
+			this.c.Errorf("[%s] 响应(%s)失败: %v\n", this.c.GetKey(), f.Method, err)
+		}
+	}()
+}
+
+// get 获取调用,编号和自己发起的奇偶一致则是发起的调用
+func (this *Conn) get(id uint32) *Stream {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if id%2 == this.nextID%2 {
+		return this.calls[id]
+	}
+	return this.serves[id]
+}
+
+func (this *Conn) remove(s *Stream) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if s.caller {
+		delete(this.calls, s.id)
+	} else {
+		delete(this.serves, s.id)
+	}
+}
+
+// close 连接断开,结束全部调用
+func (this *Conn) close(err error) {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return
+	}
+	this.closed = true
+	list := make([]*Stream, 0, len(this.calls)+len(this.serves))
+	for _, s := range this.calls {
+		list = append(list, s)
+	}
+	for _, s := range this.serves {
+		list = append(list, s)
 	}
-	uid := g.UUID()
-	m := &io.Model{
-		Type: Type,
-		UID:  uid,
-		Data: data,
+	this.mu.Unlock()
+	this.cancel()
+	msg := "连接断开"
+	if err != nil {
+		msg = err.Error()
 	}
-	if _, err := c.WriteAny(m); err != nil {
-		return nil, err
+	for _, s := range list {
+		s.finish(payload{}, NewError(CodeUnavailable, msg))
 	}
-	return wait.Wait(uid)
 }
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 错误码,同http好记一点
+const (
+	CodeOK          = http.StatusOK                  //成功
+	CodeBadRequest  = http.StatusBadRequest          //请求数据错误
+	CodeNotFound    = http.StatusNotFound            //方法不存在
+	CodeCanceled    = 499                            //调用方取消
+	CodeInternal    = http.StatusInternalServerError //处理失败
+	CodeUnavailable = http.StatusServiceUnavailable  //连接断开
+	CodeDeadline    = http.StatusGatewayTimeout      //超时
+)
+
+// Error 调用错误,带错误码,处理函数返回*Error时使用其中的错误码
+type Error struct {
+	Code int
+	Msg  string
+}
+
+func (this *Error) Error() string {
+	return fmt.Sprintf("rpc错误(%d): %s", this.Code, this.Msg)
+}
+
+// Is 取消和超时的错误码和标准库的错误一致,errors.Is(err,context.Canceled)
+func (this *Error) Is(target error) bool {
+	switch target {
+	case context.Canceled:
+		return this.Code == CodeCanceled
+	case context.DeadlineExceeded:
+		return this.Code == CodeDeadline
+	}
+	e, ok := target.(*Error)
+	return ok && e.Code == this.Code
+}
+
+// NewError 新建调用错误
+func NewError(code int, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
+
+// Errorf 新建调用错误
+func Errorf(code int, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, a...)}
+}
+
+// CodeOf 获取错误的错误码,nil为成功,未知错误为处理失败
+func CodeOf(err error) int {
+	var e *Error
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadline
+	default:
+		return CodeInternal
+	}
+}
+
+// toError 转成调用错误
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Code: CodeOf(err), Msg: err.Error()}
+}
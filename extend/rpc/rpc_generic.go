@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"github.com/injoyai/io"
+)
+
+// Register 注册处理函数,请求和响应使用具体的类型
+func Register[Req, Resp any](b Binder, method string, fn func(ctx context.Context, c *io.Client, req Req) (Resp, error)) {
+	b.Bind(method, func(ctx context.Context, s *Stream) (interface{}, error) {
+		var req Req
+		if err := s.Decode(&req); err != nil {
+			return nil, Errorf(CodeBadRequest, "解析请求数据失败: %v", err)
+		}
+		return fn(ctx, s.Client(), req)
+	})
+}
+
+// RegisterServerStream 注册服务端流的处理函数,一个请求,多次发送响应
+func RegisterServerStream[Req, Resp any](b Binder, method string, fn func(ctx context.Context, c *io.Client, req Req, send func(Resp) error) error) {
+	b.Bind(method, func(ctx context.Context, s *Stream) (interface{}, error) {
+		var req Req
+		if err := s.Decode(&req); err != nil {
+			return nil, Errorf(CodeBadRequest, "解析请求数据失败: %v", err)
+		}
+		return nil, fn(ctx, s.Client(), req, func(resp Resp) error { return s.Send(resp) })
+	})
+}
+
+// RegisterClientStream 注册客户端流的处理函数,多次接收请求,recv返回EOF表示结束,最后返回一个响应
+func RegisterClientStream[Req, Resp any](b Binder, method string, fn func(ctx context.Context, c *io.Client, recv func() (Req, error)) (Resp, error)) {
+	b.Bind(method, func(ctx context.Context, s *Stream) (interface{}, error) {
+		return fn(ctx, s.Client(), func() (Req, error) {
+			var req Req
+			err := s.Recv(&req)
+			return req, err
+		})
+	})
+}
+
+// Call 发起调用,等待响应
+func Call[Req, Resp any](ctx context.Context, c Caller, method string, req Req) (Resp, error) {
+	var resp Resp
+	s, err := c.NewStream(ctx, method, req)
+	if err != nil {
+		return resp, err
+	}
+	err = s.Response(&resp)
+	return resp, err
+}
+
+// NewServerStream 发起服务端流的调用,通过Recv接收响应,直到返回EOF
+func NewServerStream[Req, Resp any](ctx context.Context, c Caller, method string, req Req) (*ServerStream[Resp], error) {
+	s, err := c.NewStream(ctx, method, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ServerStream[Resp]{s: s}, nil
+}
+
+// ServerStream 服务端流的调用方
+type ServerStream[Resp any] struct {
+	s *Stream
+}
+
+// Recv 接收响应,调用成功结束后返回EOF
+func (this *ServerStream[Resp]) Recv() (Resp, error) {
+	var resp Resp
+	err := this.s.Recv(&resp)
+	return resp, err
+}
+
+// Cancel 取消调用,通知对方
+func (this *ServerStream[Resp]) Cancel() {
+	this.s.Cancel()
+}
+
+// NewClientStream 发起客户端流的调用,通过Send发送请求,CloseAndRecv结束发送并等待响应
+func NewClientStream[Req, Resp any](ctx context.Context, c Caller, method string) (*ClientStream[Req, Resp], error) {
+	s, err := c.NewStream(ctx, method, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStream[Req, Resp]{s: s}, nil
+}
+
+// ClientStream 客户端流的调用方
+type ClientStream[Req, Resp any] struct {
+	s *Stream
+}
+
+// Send 发送请求
+func (this *ClientStream[Req, Resp]) Send(req Req) error {
+	return this.s.Send(req)
+}
+
+// CloseAndRecv 结束发送,等待响应
+func (this *ClientStream[Req, Resp]) CloseAndRecv() (Resp, error) {
+	var resp Resp
+	if err := this.s.CloseSend(); err != nil {
+		return resp, err
+	}
+	err := this.s.Response(&resp)
+	return resp, err
+}
+
+// Cancel 取消调用,通知对方
+func (this *ClientStream[Req, Resp]) Cancel() {
+	this.s.Cancel()
+}
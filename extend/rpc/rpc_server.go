@@ -3,29 +3,88 @@ package rpc
 import (
 	"context"
 	"github.com/injoyai/base/g"
-	"github.com/injoyai/base/maps"
-	"github.com/injoyai/base/maps/wait"
-	"github.com/injoyai/conv"
 	"github.com/injoyai/io"
+	"github.com/injoyai/io/codec"
 	"github.com/injoyai/io/listen"
+	"sync"
 	"time"
 )
 
-type Handler func(ctx context.Context, c *io.Client, msg *io.Model) (interface{}, error)
-
 type Server struct {
 	*io.Server
-	bind *maps.Safe
-	wait *wait.Entity
+	handlers *handlers
+	timeout  time.Duration //调用客户端的超时时间
+	mu       sync.RWMutex
+	conns    map[*io.Client]*Conn
+	codecs   []string //支持的编码格式,nil表示全部已注册的
 }
 
-func (this *Server) Bind(Type string, handler Handler) {
-	this.bind.Set(Type, handler)
+// SetCodecs 设置支持的编码格式,注册时按客户端的优先级选择第一个支持的,都不支持则使用json
+func (this *Server) SetCodecs(names ...string) *Server {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.codecs = names
+	return this
 }
 
-func (this *Server) Do(key, Type string, data interface{}) (interface{}, error) {
+// selectCodec 选择客户端支持的编码格式
+func (this *Server) selectCodec(names []string) codec.Codec {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	for _, name := range names {
+		c := codec.Get(name)
+		if c == nil {
+			continue
+		}
+		if this.codecs == nil {
+			return c
+		}
+		for _, v := range this.codecs {
+			if v == name {
+				return c
+			}
+		}
+	}
+	return codec.JSON
+}
+
+// Bind 注册处理函数,推荐使用Register注册具体类型的处理函数
+func (this *Server) Bind(method string, handler Handler) {
+	this.handlers.Bind(method, handler)
+}
+
+// GetConn 获取客户端的连接,用于调用客户端注册的处理函数
+func (this *Server) GetConn(key string) *Conn {
 	c := this.GetClient(key)
-	return do(c, this.wait, Type, data)
+	if c == nil {
+		return nil
+	}
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.conns[c]
+}
+
+// GetConnByName 获取注册名称的连接,有多个时返回任意一个
+func (this *Server) GetConnByName(name string) *Conn {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	for c, conn := range this.conns {
+		if c.Tag().GetVar(io.Register+".name").String() == name {
+			return conn
+		}
+	}
+	return nil
+}
+
+// Do 调用客户端注册的处理函数
+func (this *Server) Do(key, method string, data interface{}) (interface{}, error) {
+	conn := this.GetConn(key)
+	if conn == nil {
+		return nil, Errorf(CodeUnavailable, "客户端(%s)未连接", key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+	return Call[interface{}, interface{}](ctx, conn, method, data)
 }
 
 func (this *Server) Run() error {
@@ -33,32 +92,66 @@ func (this *Server) Run() error {
 }
 
 func (this *Server) dealFunc(c *io.Client, msg io.Message) {
-	dealFunc(this.bind, this.wait, c, msg)
+	this.mu.RLock()
+	conn := this.conns[c]
+	this.mu.RUnlock()
+	if conn != nil {
+		conn.deal(c, msg)
+	}
 }
 
+// NewServer 新建服务,waitTimeout是调用客户端(Do)的超时时间
 func NewServer(port int, waitTimeout time.Duration, option ...io.OptionServer) (*Server, error) {
-	s, err := listen.NewTCPServer(port, option...)
+	ser := &Server{
+		handlers: newHandlers(),
+		timeout:  waitTimeout,
+		conns:    make(map[*io.Client]*Conn),
+	}
+	//在选项中设置,服务创建后会运行超时机制,之后不能再设置
+	_, err := listen.NewTCPServer(port, append(option, func(s *io.Server) {
+		ser.Server = s
+		s.ClientManage.SetOptions(func(c *io.Client) {
+			c.SetReadWriteWithPkg()
+		})
+		s.SetConnectFunc(func(c *io.Client) error {
+			ser.mu.Lock()
+			defer ser.mu.Unlock()
+			ser.conns[c] = newConn(c, ser.handlers, 2)
+			return nil
+		})
+		s.SetDealFunc(ser.dealFunc)
+		s.SetCloseFunc(func(c *io.Client, err error) {
+			ser.mu.Lock()
+			conn := ser.conns[c]
+			delete(ser.conns, c)
+			ser.mu.Unlock()
+			if conn != nil {
+				conn.close(err)
+			}
+		})
+		s.SetTimeout(io.DefaultTimeout)
+		s.SetTimeoutInterval(io.DefaultKeepAlive)
+	})...)
 	if err != nil {
 		return nil, err
 	}
-	ser := &Server{
-		Server: s,
-		bind:   maps.NewSafe(),
-		wait:   wait.New(waitTimeout),
-	}
-	s.SetReadWriteWithPkg()
-	s.SetDealFunc(ser.dealFunc)
-	s.SetTimeout(io.DefaultTimeout)
-	s.SetTimeoutInterval(io.DefaultKeepAlive)
-	ser.Bind(io.Register, func(ctx context.Context, c *io.Client, msg *io.Model) (interface{}, error) {
+	ser.Bind(io.Register, func(ctx context.Context, s *Stream) (interface{}, error) {
+		cfg := new(ClientConfig)
+		if err := s.Decode(cfg); err != nil {
+			return nil, Errorf(CodeBadRequest, "解析请求数据失败: %v", err)
+		}
 		key := g.UUID()
-		m := conv.NewMap(msg.Data)
+		c := s.Client()
 		c.Tag().Set(io.Register, true)
 		c.Tag().Set(io.Register+".key", key)
-		c.Tag().Set(io.Register+".name", m.GetString("name"))
-		c.Tag().Set(io.Register+".memo", m.GetString("memo"))
-		c.Tag().Set(io.Register+".version", m.GetString("version"))
-		return g.Map{"key": key}, nil
+		c.Tag().Set(io.Register+".name", cfg.Name)
+		c.Tag().Set(io.Register+".memo", cfg.Memo)
+		c.Tag().Set(io.Register+".version", cfg.Version)
+		//之后的数据(包括本次响应)使用协商的编码格式
+		cc := ser.selectCodec(cfg.Codecs)
+		s.Conn().setCodec(cc)
+		c.Tag().Set(io.Register+".codec", cc.Name())
+		return registerRes{Key: key, Codec: cc.Name()}, nil
 	})
 	return ser, nil
 }
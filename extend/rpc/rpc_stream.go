@@ -0,0 +1,177 @@
+package rpc
+
+import (
+	"context"
+	"github.com/injoyai/io"
+	goio "io"
+	"sync"
+)
+
+func newStream(c *Conn, id uint32, method string, caller bool) *Stream {
+	s := &Stream{
+		conn:   c,
+		id:     id,
+		method: method,
+		caller: caller,
+		done:   make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Stream 一次调用,调用方和被调用方都可以发送和接收流数据
+type Stream struct {
+	conn       *Conn
+	id         uint32
+	method     string
+	caller     bool               //是否是调用方
+	ctx        context.Context    //被调用方的上下文,调用方取消或超时后取消
+	cancel     context.CancelFunc //
+	req        payload            //请求的数据,被调用方
+	mu         sync.Mutex
+	cond       *sync.Cond
+	buf        []payload
+	recvClosed bool          //调用方已经结束发送,被调用方
+	sendClosed bool          //调用方已经结束发送,调用方
+	resp       payload       //响应的数据,调用方
+	err        error         //调用结束的错误
+	finished   bool          //
+	done       chan struct{} //调用结束
+}
+
+// Method 调用的方法
+func (this *Stream) Method() string {
+	return this.method
+}
+
+// Conn 调用所在的连接
+func (this *Stream) Conn() *Conn {
+	return this.conn
+}
+
+// Client 调用所在的客户端
+func (this *Stream) Client() *io.Client {
+	return this.conn.c
+}
+
+// Done 调用结束
+func (this *Stream) Done() <-chan struct{} {
+	return this.done
+}
+
+// Decode 解析请求的数据,被调用方
+func (this *Stream) Decode(v interface{}) error {
+	return this.req.decode(v)
+}
+
+// Send 发送流数据
+func (this *Stream) Send(v interface{}) error {
+	p, err := this.conn.marshal(v)
+	if err != nil {
+		return Errorf(CodeBadRequest, "编码数据失败: %v", err)
+	}
+	this.mu.Lock()
+	err = this.err
+	if err == nil && this.finished {
+		err = NewError(CodeUnavailable, "调用已结束")
+	}
+	if err == nil && this.sendClosed {
+		err = io.ErrWriteClosed
+	}
+	this.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return this.conn.write(&frame{Kind: frameData, Codec: p.codec, ID: this.id, Data: p.data})
+}
+
+// CloseSend 结束发送,调用方,被调用方接收完数据后返回EOF
+func (this *Stream) CloseSend() error {
+	this.mu.Lock()
+	if !this.caller || this.sendClosed || this.finished {
+		this.mu.Unlock()
+		return nil
+	}
+	this.sendClosed = true
+	this.mu.Unlock()
+	return this.conn.write(&frame{Kind: frameEnd, ID: this.id})
+}
+
+// Recv 接收流数据,调用方在调用成功结束后返回EOF,被调用方在调用方结束发送后返回EOF
+func (this *Stream) Recv(v interface{}) error {
+	this.mu.Lock()
+	for len(this.buf) == 0 {
+		switch {
+		case this.err != nil:
+			this.mu.Unlock()
+			return this.err
+		case this.finished, this.recvClosed:
+			this.mu.Unlock()
+			return goio.EOF
+		}
+		this.cond.Wait()
+	}
+	p := this.buf[0]
+	this.buf[0] = payload{}
+	this.buf = this.buf[1:]
+	this.mu.Unlock()
+	if err := p.decode(v); err != nil {
+		return Errorf(CodeBadRequest, "解析数据失败: %v", err)
+	}
+	return nil
+}
+
+// Response 等待调用结束,解析响应的数据,调用方
+func (this *Stream) Response(v interface{}) error {
+	<-this.done
+	if this.err != nil {
+		return this.err
+	}
+	if err := this.resp.decode(v); err != nil {
+		return Errorf(CodeBadRequest, "解析响应数据失败: %v", err)
+	}
+	return nil
+}
+
+// Cancel 取消调用,调用方
+func (this *Stream) Cancel() {
+	if this.caller && this.finish(payload{}, NewError(CodeCanceled, context.Canceled.Error())) {
+		this.conn.write(&frame{Kind: frameCancel, ID: this.id})
+	}
+}
+
+func (this *Stream) push(p payload) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if !this.finished {
+		this.buf = append(this.buf, p)
+		this.cond.Broadcast()
+	}
+}
+
+func (this *Stream) closeRecv() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.recvClosed = true
+	this.cond.Broadcast()
+}
+
+// finish 结束调用,从连接中移除,返回是否是第一次结束
+func (this *Stream) finish(resp payload, err error) bool {
+	this.mu.Lock()
+	if this.finished {
+		this.mu.Unlock()
+		return false
+	}
+	this.finished = true
+	this.resp = resp
+	this.err = err
+	close(this.done)
+	this.cond.Broadcast()
+	this.mu.Unlock()
+	if this.cancel != nil {
+		this.cancel()
+	}
+	this.conn.remove(this)
+	return true
+}
@@ -0,0 +1,345 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/injoyai/io"
+	"github.com/injoyai/io/codec"
+	goio "io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type addReq struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer(0, time.Second, func(s *io.Server) { s.Debug(false) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	go s.Run()
+	return s
+}
+
+func newTestClient(t *testing.T, s *Server, name string) *Client {
+	c := NewClient(&ClientConfig{Address: s.Listener().Addr(), Name: name}, func(c *io.Client) { c.Debug(false) })
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCall(t *testing.T) {
+	s := newTestServer(t)
+	Register(s, "add", func(ctx context.Context, c *io.Client, req addReq) (int, error) {
+		return req.A + req.B, nil
+	})
+	Register(s, "fail", func(ctx context.Context, c *io.Client, req string) (string, error) {
+		if req == "bad" {
+			return "", NewError(CodeBadRequest, "错误的请求")
+		}
+		return "", errors.New("处理失败")
+	})
+	c := newTestClient(t, s, "test")
+	ctx := context.Background()
+
+	if sum, err := Call[addReq, int](ctx, c, "add", addReq{A: 1, B: 2}); err != nil || sum != 3 {
+		t.Fatalf("预期(3),得到(%d): %v", sum, err)
+	}
+	for _, v := range []struct {
+		method string
+		req    string
+		code   int
+	}{
+		{"fail", "bad", CodeBadRequest},
+		{"fail", "", CodeInternal},
+		{"unknown", "", CodeNotFound},
+	} {
+		_, err := Call[string, string](ctx, c, v.method, v.req)
+		if CodeOf(err) != v.code {
+			t.Fatalf("%s(%s) 预期错误码(%d),得到(%v)", v.method, v.req, v.code, err)
+		}
+	}
+	//兼容无类型的调用
+	if res, err := c.Do("add", addReq{A: 2, B: 3}); err != nil || res != float64(5) {
+		t.Fatalf("预期(5),得到(%v): %v", res, err)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	s := newTestServer(t)
+	RegisterServerStream(s, "count", func(ctx context.Context, c *io.Client, n int, send func(int) error) error {
+		for i := 0; i < n; i++ {
+			if err := send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	c := newTestClient(t, s, "test")
+
+	stream, err := NewServerStream[int, int](context.Background(), c, "count", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		n, err := stream.Recv()
+		if err == goio.EOF {
+			if i != 100 {
+				t.Fatalf("预期接收(100)次,得到(%d)", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("预期(%d),得到(%d)", i, n)
+		}
+	}
+}
+
+// TestClientStream 同时多个客户端流,每个调用使用同一个连接
+func TestClientStream(t *testing.T) {
+	s := newTestServer(t)
+	RegisterClientStream(s, "sum", func(ctx context.Context, c *io.Client, recv func() (int, error)) (int, error) {
+		sum := 0
+		for {
+			n, err := recv()
+			if err == goio.EOF {
+				return sum, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			sum += n
+		}
+	})
+	c := newTestClient(t, s, "test")
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := NewClientStream[int, int](context.Background(), c, "sum")
+			if err != nil {
+				errs <- err
+				return
+			}
+			for n := 1; n <= 100; n++ {
+				if err := stream.Send(n); err != nil {
+					errs <- err
+					return
+				}
+			}
+			sum, err := stream.CloseAndRecv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if sum != 5050 {
+				errs <- errors.New("结果错误")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s := newTestServer(t)
+	canceled := make(chan error, 1)
+	RegisterServerStream(s, "watch", func(ctx context.Context, c *io.Client, req string, send func(string) error) error {
+		send(req)
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return ctx.Err()
+	})
+	c := newTestClient(t, s, "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := NewServerStream[string, string](ctx, c, "watch", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := stream.Recv(); err != nil || msg != "hello" {
+		t.Fatalf("预期(hello),得到(%s): %v", msg, err)
+	}
+	cancel()
+	if _, err := stream.Recv(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("预期取消,得到(%v)", err)
+	}
+	select {
+	case err := <-canceled:
+		if err != context.Canceled {
+			t.Fatalf("预期取消,得到(%v)", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("服务端未取消")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	s := newTestServer(t)
+	result := make(chan error, 1)
+	Register(s, "sleep", func(ctx context.Context, c *io.Client, req string) (string, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			result <- errors.New("未收到截止时间")
+			return "", nil
+		}
+		<-ctx.Done()
+		result <- ctx.Err()
+		return "", ctx.Err()
+	})
+	c := newTestClient(t, s, "test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	if _, err := Call[string, string](ctx, c, "sleep", ""); !errors.Is(err, context.DeadlineExceeded) || CodeOf(err) != CodeDeadline {
+		t.Fatalf("预期超时,得到(%v)", err)
+	}
+	select {
+	case err := <-result:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("预期超时,得到(%v)", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("服务端未超时")
+	}
+}
+
+// TestServerCall 服务端调用客户端注册的处理函数
+func TestServerCall(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s, "device")
+	Register(c, "hello", func(ctx context.Context, c *io.Client, name string) (string, error) {
+		return "hello " + name, nil
+	})
+	//注册后服务端才知道名称
+	if _, err := Call[string, string](context.Background(), c, "unknown", ""); CodeOf(err) != CodeNotFound {
+		t.Fatal(err)
+	}
+
+	conn := s.GetConnByName("device")
+	if conn == nil {
+		t.Fatal("客户端未注册")
+	}
+	if msg, err := Call[string, string](context.Background(), conn, "hello", "server"); err != nil || msg != "hello server" {
+		t.Fatalf("预期(hello server),得到(%s): %v", msg, err)
+	}
+	if res, err := s.Do(conn.Client().GetKey(), "hello", "do"); err != nil || res != "hello do" {
+		t.Fatalf("预期(hello do),得到(%v): %v", res, err)
+	}
+
+	//连接断开后结束调用
+	Register(c, "block", func(ctx context.Context, c *io.Client, req string) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	go func() {
+		<-time.After(time.Millisecond * 100)
+		conn.Client().Close()
+	}()
+	if _, err := Call[string, string](context.Background(), conn, "block", ""); CodeOf(err) != CodeUnavailable {
+		t.Fatalf("预期连接断开,得到(%v)", err)
+	}
+}
+
+// TestCodec 注册时协商编码格式,之后的调用使用协商的格式
+func TestCodec(t *testing.T) {
+	s := newTestServer(t)
+	Register(s, "add", func(ctx context.Context, c *io.Client, req addReq) (int, error) {
+		return req.A + req.B, nil
+	})
+	RegisterServerStream(s, "count", func(ctx context.Context, c *io.Client, n int, send func(addReq) error) error {
+		for i := 0; i < n; i++ {
+			if err := send(addReq{A: i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for _, name := range codec.Names() {
+		c := NewClient(&ClientConfig{Address: s.Listener().Addr(), Name: name, Codecs: []string{name}}, func(c *io.Client) { c.Debug(false) })
+		t.Cleanup(func() { c.Close() })
+		ctx := context.Background()
+		if sum, err := Call[addReq, int](ctx, c, "add", addReq{A: 1, B: 2}); err != nil || sum != 3 {
+			t.Fatalf("%s: 预期(3),得到(%d): %v", name, sum, err)
+		}
+		stream, err := NewServerStream[int, addReq](ctx, c, "count", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if res, err := stream.Recv(); err != nil || res.A != i {
+				t.Fatalf("%s: 预期(%d),得到(%d): %v", name, i, res.A, err)
+			}
+		}
+		conn := s.GetConnByName(name)
+		if conn == nil || conn.Codec().Name() != name {
+			t.Fatalf("预期服务端使用(%s)", name)
+		}
+		if cc, _ := c.getConn(ctx); cc.Codec().Name() != name {
+			t.Fatalf("预期客户端使用(%s),得到(%s)", name, cc.Codec().Name())
+		}
+	}
+
+	//服务端不支持时按客户端的优先级选择
+	s.SetCodecs("json", "cbor")
+	c := NewClient(&ClientConfig{Address: s.Listener().Addr(), Name: "limit", Codecs: []string{"msgpack", "cbor"}}, func(c *io.Client) { c.Debug(false) })
+	t.Cleanup(func() { c.Close() })
+	if _, err := Call[addReq, int](context.Background(), c, "add", addReq{}); err != nil {
+		t.Fatal(err)
+	}
+	if conn := s.GetConnByName("limit"); conn == nil || conn.Codec() != codec.CBOR {
+		t.Fatal("预期服务端使用(cbor)")
+	}
+}
+
+// BenchmarkCodec 比较各编码格式每次调用写入的字节数(bytes/call)
+func BenchmarkCodec(b *testing.B) {
+	s, err := NewServer(0, time.Second, func(s *io.Server) { s.Debug(false) })
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	go s.Run()
+	type report struct {
+		DeviceID string             `json:"deviceID"`
+		Time     int64              `json:"time"`
+		Values   map[string]float64 `json:"values"`
+	}
+	Register(s, "report", func(ctx context.Context, c *io.Client, req report) (bool, error) {
+		return true, nil
+	})
+	req := report{DeviceID: "860000000000001", Time: 1714552200000, Values: map[string]float64{"temperature": 25.5, "humidity": 61, "voltage": 3.3}}
+
+	for _, name := range codec.Names() {
+		b.Run(name, func(b *testing.B) {
+			c := NewClient(&ClientConfig{Address: s.Listener().Addr(), Codecs: []string{name}}, func(c *io.Client) { c.Debug(false) })
+			defer c.Close()
+			ctx := context.Background()
+			conn, err := c.getConn(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			start := conn.Client().WriteCount
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Call[report, bool](ctx, conn, "report", req); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(conn.Client().WriteCount-start)/float64(b.N), "bytes/call")
+		})
+	}
+}
@@ -16,6 +16,26 @@ type Config struct {
 	Debug      bool   //调试模式
 }
 
+// Dial 连接RabbitMQ,并打开通道
+func Dial(address string) (*Client, error) {
+	conn, err := amqp.DialConfig(address, amqp.Config{
+		Dial: amqp.DefaultDial(io.DefaultConnectTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Client{
+		conn:    conn,
+		channel: channel,
+		ctx:     context.Background(),
+	}, nil
+}
+
 type Client struct {
 	//cfg     *Config
 	conn    *amqp.Connection
@@ -27,6 +47,11 @@ func (this *Client) Closed() bool {
 	return this.conn.IsClosed()
 }
 
+// Close 关闭连接,读取的消费者也会关闭
+func (this *Client) Close() error {
+	return this.conn.Close()
+}
+
 func (this *Client) ReadWriteCloser(cfg *Config) (io.AReadWriteCloser, error) {
 	r, err := this.AckReader(cfg)
 	if err != nil {
@@ -44,9 +69,15 @@ func (this *Client) AckReader(cfg *Config) (io.AReader, error) {
 	if err != nil {
 		return nil, err
 	}
+	//订阅队列,手动确认
+	delivery, err := this.channel.Consume(queue.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
 	return &AckReader{
-		Client: this,
-		queue:  queue,
+		Client:   this,
+		queue:    queue,
+		delivery: delivery,
 	}, nil
 }
 
@@ -63,17 +94,15 @@ func (this *Client) Writer(cfg *Config) (io.Writer, error) {
 
 type AckReader struct {
 	*Client
-	queue amqp.Queue
+	queue    amqp.Queue
+	delivery <-chan amqp.Delivery
 }
 
+// ReadAck 等待一个消息,连接关闭后返回错误
 func (this *AckReader) ReadAck() (io.Acker, error) {
-	//获取一个消息
-	msg, ok, err := this.channel.Get(this.queue.Name, false)
-	if err != nil {
-		return nil, err
-	}
+	msg, ok := <-this.delivery
 	if !ok {
-		return nil, nil
+		return nil, io.ErrReadClosed
 	}
 	return &Message{&msg}, nil
 }
@@ -0,0 +1,540 @@
+package io
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+
+加密传输,在读写实例上加密,可以用于隧道,桥接,代理等任意客户端
+
+密钥交换:
+双方生成临时密钥(X25519),通过预共享密钥(PSK)或者客户端固定服务端公钥(ServerKey)认证,至少需要一种
+客户端 -> 服务端 版本(1字节)+算法(1字节)+标识(1字节)+临时公钥(32字节)
+服务端 -> 客户端 版本(1字节)+临时公钥(32字节)+确认(32字节)
+客户端 -> 服务端 确认(32字节)
+确认是HMAC-SHA256(握手内容),预共享密钥或服务端私钥不一致时确认失败
+
+数据:
+长度 2字节(大端),加密后的长度
+加密数据 类型(1字节)+数据,ChaCha20-Poly1305或AES-256-GCM,长度作为附加数据
+每个方向单独的密钥,随机数是递增的序号,重放,乱序,篡改都会校验失败,校验失败后连接不可用
+
+更新密钥:
+发送的字节数或者时间达到设置后,发送更新类型的数据,然后双方根据当前密钥派生新的密钥,序号重置
+
+c.SetCipherClient(&io.CipherConfig{PSK: []byte("psk")})
+s.SetOptions(func(c *io.Client) { c.SetCipherServer(&io.CipherConfig{PSK: []byte("psk")}) })
+
+*/
+
+const (
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
+	CipherAESGCM           = "aes-256-gcm"
+
+	cipherVersion     = 0x01
+	cipherFlagPinned  = 0x01 //客户端固定了服务端公钥
+	cipherHelloSize   = 3 + curve25519.PointSize
+	cipherReplySize   = 1 + curve25519.PointSize + sha256.Size
+	cipherHeadSize    = 2
+	cipherMaxData     = 16 << 10 //单个数据包最大的明文长度
+	cipherOverhead    = 16       //算法的校验长度,两种算法相同
+	cipherMaxRecord   = 1 + cipherMaxData + cipherOverhead
+	cipherLabel       = "injoyai/io cipher v1"
+	cipherRekeyLabel  = "injoyai/io cipher rekey"
+	cipherTypeData    = 0x00
+	cipherTypeRekey   = 0x01
+	cipherMaxSequence = 1 << 32 //序号达到后强制更新密钥
+)
+
+// CipherConfig 加密传输配置,预共享密钥和服务端公钥至少需要一种
+type CipherConfig struct {
+	PSK           []byte        //预共享密钥,双方相同
+	PrivateKey    []byte        //服务端私钥(32字节),客户端固定服务端公钥时需要
+	ServerKey     []byte        //客户端固定的服务端公钥(32字节),防止中间人
+	Cipher        string        //加密算法,客户端默认ChaCha20-Poly1305,服务端为空表示都支持
+	RekeyBytes    int64         //发送多少字节后更新密钥,默认DefaultCipherRekeyBytes,小于0不更新
+	RekeyInterval time.Duration //多久更新一次密钥,默认DefaultCipherRekeyInterval,小于0不更新
+}
+
+// GenerateCipherKey 生成服务端的密钥对(X25519),私钥配置在服务端,公钥配置在客户端
+func GenerateCipherKey() (privateKey, publicKey []byte, err error) {
+	privateKey = make([]byte, curve25519.ScalarSize)
+	if _, err = rand.Read(privateKey); err != nil {
+		return nil, nil, err
+	}
+	publicKey, err = CipherPublicKey(privateKey)
+	return
+}
+
+// CipherPublicKey 根据私钥计算公钥
+func CipherPublicKey(privateKey []byte) ([]byte, error) {
+	return curve25519.X25519(privateKey, curve25519.Basepoint)
+}
+
+// NewCipherClient 客户端加密,第一次读写时进行密钥交换
+func NewCipherClient(i ReadWriteCloser, cfg *CipherConfig) *CipherConn {
+	return newCipherConn(i, cfg, false)
+}
+
+// NewCipherServer 服务端加密,第一次读写时进行密钥交换
+func NewCipherServer(i ReadWriteCloser, cfg *CipherConfig) *CipherConn {
+	return newCipherConn(i, cfg, true)
+}
+
+func newCipherConn(i ReadWriteCloser, cfg *CipherConfig, server bool) *CipherConn {
+	c := *cfg
+	if c.RekeyBytes == 0 {
+		c.RekeyBytes = DefaultCipherRekeyBytes
+	}
+	if c.RekeyInterval == 0 {
+		c.RekeyInterval = DefaultCipherRekeyInterval
+	}
+	return &CipherConn{
+		i:      i,
+		r:      bufio.NewReaderSize(i, cipherHeadSize+cipherMaxRecord),
+		cfg:    &c,
+		server: server,
+		rdata:  make([]byte, cipherMaxRecord),
+		wdata:  make([]byte, cipherHeadSize+cipherMaxRecord),
+	}
+}
+
+// SetCipherClient 加密传输(客户端),替换读写实例,需要在Run之前设置,例如在选项中,重连后重新交换密钥
+func (this *Client) SetCipherClient(cfg *CipherConfig) *Client {
+	return this.setCipher(NewCipherClient(this.i, cfg))
+}
+
+// SetCipherServer 加密传输(服务端),替换读写实例,需要在Run之前设置,例如在服务的选项中
+func (this *Client) SetCipherServer(cfg *CipherConfig) *Client {
+	return this.setCipher(NewCipherServer(this.i, cfg))
+}
+
+func (this *Client) setCipher(i *CipherConn) *Client {
+	this.i = i
+	this.reader = i
+	if this.buf != nil {
+		this.buf.Reset(i)
+	}
+	return this
+}
+
+// CipherConn 加密的读写实例,实现了net.Conn,地址和超时时间使用原始连接的
+type CipherConn struct {
+	i      ReadWriteCloser
+	r      *bufio.Reader
+	cfg    *CipherConfig
+	server bool
+
+	once sync.Once
+	err  error //密钥交换的错误
+
+	rmu   sync.Mutex
+	recv  *cipherState
+	rdata []byte //读取的加密数据,解密后复用
+	rbuf  []byte //未读取完的明文
+	rerr  error
+
+	wmu       sync.Mutex
+	send      *cipherState
+	wdata     []byte
+	sent      int64     //更新密钥之后发送的字节数
+	rekeyTime time.Time //上次更新密钥的时间
+	werr      error
+}
+
+// Read 读取解密后的数据
+func (this *CipherConn) Read(p []byte) (int, error) {
+	if err := this.handshake(); err != nil {
+		return 0, err
+	}
+	this.rmu.Lock()
+	defer this.rmu.Unlock()
+	for len(this.rbuf) == 0 {
+		if this.rerr != nil {
+			return 0, this.rerr
+		}
+		this.rerr = this.readRecord()
+	}
+	n := copy(p, this.rbuf)
+	this.rbuf = this.rbuf[n:]
+	return n, nil
+}
+
+// Write 加密后写入,大的数据会分成多个数据包
+func (this *CipherConn) Write(p []byte) (int, error) {
+	if err := this.handshake(); err != nil {
+		return 0, err
+	}
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	if this.werr != nil {
+		return 0, this.werr
+	}
+	n := 0
+	for len(p) > 0 {
+		if this.needRekey() {
+			if this.werr = this.writeRecord(cipherTypeRekey, nil); this.werr != nil {
+				return n, this.werr
+			}
+			if this.werr = this.send.rekey(); this.werr != nil {
+				return n, this.werr
+			}
+			this.sent = 0
+			this.rekeyTime = time.Now()
+		}
+		size := len(p)
+		if size > cipherMaxData {
+			size = cipherMaxData
+		}
+		if this.werr = this.writeRecord(cipherTypeData, p[:size]); this.werr != nil {
+			return n, this.werr
+		}
+		n += size
+		this.sent += int64(size)
+		p = p[size:]
+	}
+	return n, nil
+}
+
+// Close 关闭原始连接
+func (this *CipherConn) Close() error {
+	return this.i.Close()
+}
+
+func (this *CipherConn) LocalAddr() net.Addr {
+	if c, ok := this.i.(net.Conn); ok {
+		return c.LocalAddr()
+	}
+	return cipherAddr{}
+}
+
+func (this *CipherConn) RemoteAddr() net.Addr {
+	if c, ok := this.i.(net.Conn); ok {
+		return c.RemoteAddr()
+	}
+	return cipherAddr{}
+}
+
+func (this *CipherConn) SetDeadline(t time.Time) error {
+	if c, ok := this.i.(net.Conn); ok {
+		return c.SetDeadline(t)
+	}
+	return errors.New("不支持设置超时时间")
+}
+
+func (this *CipherConn) SetReadDeadline(t time.Time) error {
+	if c, ok := this.i.(net.Conn); ok {
+		return c.SetReadDeadline(t)
+	}
+	return errors.New("不支持设置超时时间")
+}
+
+func (this *CipherConn) SetWriteDeadline(t time.Time) error {
+	if c, ok := this.i.(net.Conn); ok {
+		return c.SetWriteDeadline(t)
+	}
+	return errors.New("不支持设置超时时间")
+}
+
+func (this *CipherConn) needRekey() bool {
+	return this.send.seq >= cipherMaxSequence ||
+		(this.cfg.RekeyBytes > 0 && this.sent >= this.cfg.RekeyBytes) ||
+		(this.cfg.RekeyInterval > 0 && time.Since(this.rekeyTime) >= this.cfg.RekeyInterval)
+}
+
+// readRecord 读取一个数据包,更新类型的数据包会更新接收的密钥
+func (this *CipherConn) readRecord() error {
+	head := make([]byte, cipherHeadSize)
+	if _, err := io.ReadFull(this.r, head); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint16(head))
+	if size < 1+cipherOverhead || size > cipherMaxRecord {
+		return ErrCipherAuth
+	}
+	data := this.rdata[:size]
+	if _, err := io.ReadFull(this.r, data); err != nil {
+		return err
+	}
+	p, err := this.recv.aead.Open(data[:0], this.recv.nonce(), data, head)
+	if err != nil {
+		return ErrCipherAuth
+	}
+	switch p[0] {
+	case cipherTypeData:
+		this.rbuf = p[1:]
+	case cipherTypeRekey:
+		return this.recv.rekey()
+	default:
+		return ErrCipherAuth
+	}
+	return nil
+}
+
+func (this *CipherConn) writeRecord(t byte, p []byte) error {
+	bs := this.wdata[:cipherHeadSize+1+len(p)]
+	binary.BigEndian.PutUint16(bs, uint16(1+len(p)+cipherOverhead))
+	bs[cipherHeadSize] = t
+	copy(bs[cipherHeadSize+1:], p)
+	bs = this.send.aead.Seal(bs[:cipherHeadSize], this.send.nonce(), bs[cipherHeadSize:], bs[:cipherHeadSize])
+	_, err := this.i.Write(bs)
+	return err
+}
+
+//================================Handshake================================
+
+func (this *CipherConn) handshake() error {
+	this.once.Do(func() {
+		if this.server {
+			this.err = this.serverHandshake()
+		} else {
+			this.err = this.clientHandshake()
+		}
+	})
+	return this.err
+}
+
+func (this *CipherConn) clientHandshake() error {
+	if len(this.cfg.PSK) == 0 && len(this.cfg.ServerKey) == 0 {
+		return ErrCipherHandshake.wrap(errors.New("需要预共享密钥或服务端公钥"))
+	}
+	suite := byte(cipherSuiteChaCha20Poly1305)
+	if len(this.cfg.Cipher) > 0 {
+		s, ok := cipherSuites[this.cfg.Cipher]
+		if !ok {
+			return ErrCipherHandshake.wrap(errors.New("不支持的加密算法: " + this.cfg.Cipher))
+		}
+		suite = s
+	}
+	flag := byte(0)
+	if len(this.cfg.ServerKey) > 0 {
+		flag |= cipherFlagPinned
+	}
+	priv, pub, err := GenerateCipherKey()
+	if err != nil {
+		return err
+	}
+
+	hello := append([]byte{cipherVersion, suite, flag}, pub...)
+	if _, err := this.i.Write(hello); err != nil {
+		return err
+	}
+	reply := make([]byte, cipherReplySize)
+	if _, err := io.ReadFull(this.r, reply); err != nil {
+		return err
+	}
+	if reply[0] != cipherVersion {
+		return ErrCipherHandshake.wrap(errors.New("版本不一致"))
+	}
+	peer := reply[1 : 1+curve25519.PointSize]
+
+	secret, err := curve25519.X25519(priv, peer)
+	if err != nil {
+		return ErrCipherHandshake.wrap(err)
+	}
+	if flag&cipherFlagPinned > 0 {
+		es, err := curve25519.X25519(priv, this.cfg.ServerKey)
+		if err != nil {
+			return ErrCipherHandshake.wrap(err)
+		}
+		secret = append(secret, es...)
+	}
+	keys, err := newCipherKeys(this.cfg.PSK, secret, hello, peer)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(reply[1+curve25519.PointSize:], keys.confirm(keys.serverConfirm)) {
+		return ErrCipherHandshake.wrap(errors.New("服务端认证失败,预共享密钥或服务端公钥错误"))
+	}
+	if _, err := this.i.Write(keys.confirm(keys.clientConfirm)); err != nil {
+		return err
+	}
+	return this.init(suite, keys.clientKey, keys.serverKey)
+}
+
+func (this *CipherConn) serverHandshake() error {
+	hello := make([]byte, cipherHelloSize)
+	if _, err := io.ReadFull(this.r, hello); err != nil {
+		return err
+	}
+	if hello[0] != cipherVersion {
+		return ErrCipherHandshake.wrap(errors.New("版本不一致"))
+	}
+	suite, flag, peer := hello[1], hello[2], hello[3:]
+	if _, ok := cipherSuiteNames[suite]; !ok ||
+		(len(this.cfg.Cipher) > 0 && cipherSuites[this.cfg.Cipher] != suite) {
+		return ErrCipherHandshake.wrap(errors.New("加密算法不一致"))
+	}
+	pinned := flag&cipherFlagPinned > 0
+	if pinned && len(this.cfg.PrivateKey) == 0 {
+		return ErrCipherHandshake.wrap(errors.New("未设置服务端私钥"))
+	}
+	if !pinned && len(this.cfg.PSK) == 0 {
+		return ErrCipherHandshake.wrap(errors.New("需要预共享密钥或服务端公钥"))
+	}
+	priv, pub, err := GenerateCipherKey()
+	if err != nil {
+		return err
+	}
+
+	secret, err := curve25519.X25519(priv, peer)
+	if err != nil {
+		return ErrCipherHandshake.wrap(err)
+	}
+	if pinned {
+		es, err := curve25519.X25519(this.cfg.PrivateKey, peer)
+		if err != nil {
+			return ErrCipherHandshake.wrap(err)
+		}
+		secret = append(secret, es...)
+	}
+	keys, err := newCipherKeys(this.cfg.PSK, secret, hello, pub)
+	if err != nil {
+		return err
+	}
+	reply := append([]byte{cipherVersion}, pub...)
+	reply = append(reply, keys.confirm(keys.serverConfirm)...)
+	if _, err := this.i.Write(reply); err != nil {
+		return err
+	}
+	confirm := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(this.r, confirm); err != nil {
+		return err
+	}
+	if !hmac.Equal(confirm, keys.confirm(keys.clientConfirm)) {
+		return ErrCipherHandshake.wrap(errors.New("客户端认证失败,预共享密钥错误"))
+	}
+	return this.init(suite, keys.serverKey, keys.clientKey)
+}
+
+// init 设置发送和接收的密钥
+func (this *CipherConn) init(suite byte, sendKey, recvKey []byte) (err error) {
+	if this.send, err = newCipherState(suite, sendKey); err != nil {
+		return err
+	}
+	if this.recv, err = newCipherState(suite, recvKey); err != nil {
+		return err
+	}
+	this.rekeyTime = time.Now()
+	return nil
+}
+
+// cipherKeys 握手派生的密钥,HKDF-SHA256(共享密钥,预共享密钥,握手内容)
+type cipherKeys struct {
+	transcript    []byte
+	clientKey     []byte
+	serverKey     []byte
+	clientConfirm []byte
+	serverConfirm []byte
+}
+
+func newCipherKeys(psk, secret, hello, serverPub []byte) (*cipherKeys, error) {
+	h := sha256.New()
+	h.Write([]byte(cipherLabel))
+	h.Write(hello)
+	h.Write(serverPub)
+	keys := &cipherKeys{transcript: h.Sum(nil)}
+	r := hkdf.New(sha256.New, secret, psk, keys.transcript)
+	for _, v := range []*[]byte{&keys.clientKey, &keys.serverKey, &keys.clientConfirm, &keys.serverConfirm} {
+		*v = make([]byte, 32)
+		if _, err := io.ReadFull(r, *v); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (this *cipherKeys) confirm(key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(this.transcript)
+	return h.Sum(nil)
+}
+
+//================================State================================
+
+const (
+	cipherSuiteChaCha20Poly1305 = 0x01
+	cipherSuiteAESGCM           = 0x02
+)
+
+var (
+	cipherSuites = map[string]byte{
+		CipherChaCha20Poly1305: cipherSuiteChaCha20Poly1305,
+		CipherAESGCM:           cipherSuiteAESGCM,
+	}
+	cipherSuiteNames = map[byte]string{
+		cipherSuiteChaCha20Poly1305: CipherChaCha20Poly1305,
+		cipherSuiteAESGCM:           CipherAESGCM,
+	}
+)
+
+// cipherState 单个方向的密钥和序号
+type cipherState struct {
+	suite byte
+	key   []byte
+	aead  cipher.AEAD
+	seq   uint64
+	buf   []byte
+}
+
+func newCipherState(suite byte, key []byte) (*cipherState, error) {
+	s := &cipherState{suite: suite}
+	return s, s.setKey(key)
+}
+
+func (this *cipherState) setKey(key []byte) (err error) {
+	switch this.suite {
+	case cipherSuiteAESGCM:
+		var block cipher.Block
+		if block, err = aes.NewCipher(key); err == nil {
+			this.aead, err = cipher.NewGCM(block)
+		}
+	default:
+		this.aead, err = chacha20poly1305.New(key)
+	}
+	if err != nil {
+		return err
+	}
+	this.key = key
+	this.seq = 0
+	this.buf = make([]byte, this.aead.NonceSize())
+	return nil
+}
+
+// nonce 递增的序号作为随机数,不能重复使用
+func (this *cipherState) nonce() []byte {
+	binary.BigEndian.PutUint64(this.buf[len(this.buf)-8:], this.seq)
+	this.seq++
+	return this.buf
+}
+
+// rekey 根据当前密钥派生新的密钥
+func (this *cipherState) rekey() error {
+	key := make([]byte, len(this.key))
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, this.key, []byte(cipherRekeyLabel)), key); err != nil {
+		return err
+	}
+	return this.setKey(key)
+}
+
+// cipherAddr 原始连接不是net.Conn时的地址
+type cipherAddr struct{}
+
+func (cipherAddr) Network() string { return "cipher" }
+
+func (cipherAddr) String() string { return "cipher" }
@@ -0,0 +1,248 @@
+package io
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newConnPair 本地TCP连接的两端
+func newConnPair(t *testing.T) (net.Conn, net.Conn) {
+	a, b := newTCPPair(t)
+	return a.ReadWriteCloser().(net.Conn), b.ReadWriteCloser().(net.Conn)
+}
+
+// recordConn 记录最后一次写入的数据,drop时不发送
+type recordConn struct {
+	net.Conn
+	mu   sync.Mutex
+	drop bool
+	last []byte
+}
+
+func (this *recordConn) Write(p []byte) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.last = append([]byte(nil), p...)
+	if this.drop {
+		return len(p), nil
+	}
+	return this.Conn.Write(p)
+}
+
+// cipherEcho 双方同时写入和读取,校验数据
+func cipherEcho(t *testing.T, a, b *CipherConn, size int) {
+	want := make([]byte, size)
+	rand.Read(want)
+	errs := make(chan error, 2)
+	for _, v := range [][2]*CipherConn{{a, b}, {b, a}} {
+		w, r := v[0], v[1]
+		go func() {
+			_, err := w.Write(want)
+			if err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			got := make([]byte, size)
+			if _, err := io.ReadFull(r, got); err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, want) {
+				errs <- errors.New("数据错误")
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCipher(t *testing.T) {
+	for _, name := range []string{CipherChaCha20Poly1305, CipherAESGCM} {
+		t.Run(name, func(t *testing.T) {
+			c1, c2 := newConnPair(t)
+			a := NewCipherClient(c1, &CipherConfig{PSK: []byte("psk"), Cipher: name})
+			b := NewCipherServer(c2, &CipherConfig{PSK: []byte("psk")})
+			cipherEcho(t, a, b, 1<<20)
+			if a.send.suite != cipherSuites[name] || b.send.suite != cipherSuites[name] {
+				t.Fatalf("加密算法错误")
+			}
+		})
+	}
+}
+
+func TestCipherRekey(t *testing.T) {
+	c1, c2 := newConnPair(t)
+	a := NewCipherClient(c1, &CipherConfig{PSK: []byte("psk"), RekeyBytes: 64 << 10})
+	b := NewCipherServer(c2, &CipherConfig{PSK: []byte("psk"), RekeyInterval: time.Millisecond * 10})
+	cipherEcho(t, a, b, 1<<20)
+	//按字节更新,1MB数据每64KB更新一次
+	a.wmu.Lock()
+	seq := a.send.seq
+	a.wmu.Unlock()
+	if seq > 4 {
+		t.Fatalf("密钥未更新,序号(%d)", seq)
+	}
+	b.wmu.Lock()
+	key := b.send.key
+	b.wmu.Unlock()
+	time.Sleep(time.Millisecond * 20)
+	cipherEcho(t, a, b, 100)
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	if bytes.Equal(key, b.send.key) {
+		t.Fatal("按时间更新密钥失败")
+	}
+}
+
+func TestCipherPinned(t *testing.T) {
+	priv, pub, err := GenerateCipherKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, _ := GenerateCipherKey()
+	for _, v := range []struct {
+		name   string
+		client *CipherConfig
+		server *CipherConfig
+		ok     bool
+	}{
+		{"固定公钥", &CipherConfig{ServerKey: pub}, &CipherConfig{PrivateKey: priv}, true},
+		{"固定公钥和预共享密钥", &CipherConfig{ServerKey: pub, PSK: []byte("psk")}, &CipherConfig{PrivateKey: priv, PSK: []byte("psk")}, true},
+		{"公钥错误", &CipherConfig{ServerKey: other}, &CipherConfig{PrivateKey: priv}, false},
+		{"服务端没有私钥", &CipherConfig{ServerKey: pub}, &CipherConfig{PSK: []byte("psk")}, false},
+		{"预共享密钥错误", &CipherConfig{PSK: []byte("psk")}, &CipherConfig{PSK: []byte("error")}, false},
+		{"没有认证", &CipherConfig{}, &CipherConfig{PrivateKey: priv}, false},
+		{"服务端没有认证", &CipherConfig{PSK: []byte("psk")}, &CipherConfig{PrivateKey: priv}, false},
+		{"加密算法不一致", &CipherConfig{PSK: []byte("psk"), Cipher: CipherAESGCM}, &CipherConfig{PSK: []byte("psk"), Cipher: CipherChaCha20Poly1305}, false},
+	} {
+		t.Run(v.name, func(t *testing.T) {
+			c1, c2 := newConnPair(t)
+			a := NewCipherClient(c1, v.client)
+			b := NewCipherServer(c2, v.server)
+			go func() {
+				//服务端失败时关闭连接,客户端读取返回错误
+				if err := b.handshake(); err != nil {
+					b.Close()
+				}
+			}()
+			err := a.handshake()
+			if v.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				cipherEcho(t, a, b, 100)
+				return
+			}
+			if err == nil {
+				t.Fatal("预期密钥交换失败")
+			}
+		})
+	}
+}
+
+func TestCipherReplay(t *testing.T) {
+	c1, c2 := newConnPair(t)
+	rc := &recordConn{Conn: c1}
+	a := NewCipherClient(rc, &CipherConfig{PSK: []byte("psk")})
+	b := NewCipherServer(c2, &CipherConfig{PSK: []byte("psk")})
+	cipherEcho(t, a, b, 100)
+
+	//重放上一个数据包
+	rc.mu.Lock()
+	last := rc.last
+	rc.mu.Unlock()
+	if _, err := c1.Write(last); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Read(make([]byte, 100)); !errors.Is(err, ErrCipherAuth) {
+		t.Fatalf("预期校验失败,得到(%v)", err)
+	}
+	//校验失败后连接不可用
+	if _, err := b.Read(make([]byte, 100)); !errors.Is(err, ErrCipherAuth) {
+		t.Fatalf("预期校验失败,得到(%v)", err)
+	}
+}
+
+func TestCipherTamper(t *testing.T) {
+	c1, c2 := newConnPair(t)
+	rc := &recordConn{Conn: c1}
+	a := NewCipherClient(rc, &CipherConfig{PSK: []byte("psk")})
+	b := NewCipherServer(c2, &CipherConfig{PSK: []byte("psk")})
+	cipherEcho(t, a, b, 100)
+
+	rc.mu.Lock()
+	rc.drop = true
+	rc.mu.Unlock()
+	a.Write([]byte("hello"))
+	rc.mu.Lock()
+	last := rc.last
+	rc.mu.Unlock()
+	last[len(last)-1] ^= 0x01
+	if _, err := c1.Write(last); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Read(make([]byte, 100)); !errors.Is(err, ErrCipherAuth) {
+		t.Fatalf("预期校验失败,得到(%v)", err)
+	}
+}
+
+// TestCipherMux 客户端加密后使用多路复用
+func TestCipherMux(t *testing.T) {
+	a, b := newTCPPair(t)
+	raw := a.ReadWriteCloser().(net.Conn)
+	cfg := &CipherConfig{PSK: []byte("psk"), RekeyBytes: 32 << 10}
+	a.SetCipherClient(cfg)
+	b.SetCipherServer(cfg)
+	if conn, ok := a.NetConn(); !ok || conn.RemoteAddr().String() != raw.RemoteAddr().String() {
+		t.Fatal("加密后地址错误")
+	}
+	client, server := NewMuxClient(a), NewMuxServer(b)
+	go a.Run()
+	go b.Run()
+	go muxEcho(server)
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := client.OpenStream()
+			if err != nil {
+				errs <- err
+				return
+			}
+			want := make([]byte, 100<<10)
+			rand.Read(want)
+			go func() {
+				s.Write(want)
+				s.Close()
+			}()
+			got, err := io.ReadAll(s)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, want) {
+				errs <- errors.New("数据错误")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
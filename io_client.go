@@ -7,10 +7,11 @@ import (
 	"fmt"
 	"github.com/injoyai/base/maps"
 	"github.com/injoyai/conv"
-	"github.com/injoyai/io/buf"
+	"github.com/injoyai/io/codec"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -95,18 +96,21 @@ type Client struct {
 	running      uint32             //是否在运行,1是运行,0是没运行
 	closed       uint32             //是否关闭(不公开,做原子操作),0是未关闭,1是已关闭
 	closeErr     error              //错误信息
-	ctx          context.Context    //子级上下文
+	ctx          context.Context    //子级上下文,重连时替换,通过Ctx读取
 	cancel       context.CancelFunc //子级上下文
 	ctxParent    context.Context    //父级上下文,主动关闭时,用于关闭redial,好像没啥用得用一个协程来监听
 	cancelParent context.CancelFunc //父级上下文,主动关闭时,用于关闭redial
 
 	//runtime
+	mu          sync.RWMutex    //字段锁,重连或恢复会话时替换的字段(ctx,session,tag,dialFunc)
 	Key         string          //自定义标识
 	*logger                     //日志
 	pointer     string          //唯一标识,指针地址
 	i           ReadWriteCloser //接口,实例,传入的原始参数
+	reader      io.Reader       //buf读取的实例,可能是封装后的i
 	buf         *bufio.Reader   //buffer
-	tag         *maps.Safe      //标签,用于记录连接的一些信息
+	poll        atomic.Value    //*pollConn,epoll模式的连接
+	tag         *maps.Safe      //标签,用于记录连接的一些信息,恢复会话时替换
 	CreateTime  time.Time       //创建时间,对象创建时间,重连不会改变
 	DialTime    time.Time       //连接时间,每次重连会改变
 	ReadTime    time.Time       //本次连接,最后读取到数据的时间
@@ -130,6 +134,11 @@ type Client struct {
 	//处理数据事件,可以进行打印或者其他逻辑操作
 	dealFunc []func(c *Client, msg Message) (ack bool)
 
+	//处理数据的中间件和处理函数,handler是组合后的调用链,最后执行dealFunc和handleFunc
+	middleware []Middleware
+	handler    Handler
+	handleFunc []Handler
+
 	//写入数据事件,可以进行封装或者打印等操作
 	writeFunc []func(p []byte) ([]byte, error)
 
@@ -138,6 +147,37 @@ type Client struct {
 
 	//当key变化时触发
 	keyChangeFunc []func(c *Client, oldKey string)
+
+	//会话,重连后恢复,不会重置,服务端恢复会话时替换,其他协程通过getSession读取
+	session *session
+
+	//记录器,记录原始收发数据
+	recorder  *Recorder
+	recordBuf *recordReader //按帧记录接收的数据
+
+	//日志采样,限制收发数据的日志数量
+	logSample *logSample
+
+	//处理完成后自动归还数据到字节池
+	autoRelease bool
+
+	//合并写入,Write先缓存,再批量写入
+	coalesce *coalesce
+
+	//编解码,WriteAny写入结构数据时使用,nil时根据conv转换
+	codec codec.Codec
+
+	//链路追踪,重连后保留
+	tracer     Tracer
+	messageCtx atomic.Value //当前处理数据的上下文,messageContext
+
+	//panic,默认恢复用户函数的panic,恢复后执行panicFunc
+	noRecover bool
+	panicFunc PanicFunc
+
+	//心跳,心跳数据不会交给dealFunc处理
+	heartbeat     *heartbeat
+	heartbeatFunc []func(c *Client, rtt time.Duration)
 }
 
 //================================Nature================================
@@ -163,8 +203,10 @@ func (this *Client) reset(i ReadWriteCloser, key string, options ...OptionClient
 	//例如用户在option中设置了Close
 	//初始化之后会判断错误信息
 	//所以这里得初始化错误
+	this.mu.Lock()
 	this.closeErr = nil
 	this.ctx, this.cancel = context.WithCancel(this.ctxParent)
+	this.mu.Unlock()
 	//父级上下文保留
 	//this.ctxParent = this.ctxParent
 	//this.cancelParent= this.cancelParent
@@ -177,7 +219,12 @@ func (this *Client) reset(i ReadWriteCloser, key string, options ...OptionClient
 	this.i = i
 	//buf在下面的处理
 	//this.buf
-	this.tag = nil //是否保留tag信息,重新设置就能覆盖
+	//开启会话时保留tag信息,会话也不会重置
+	this.mu.Lock()
+	if this.session == nil {
+		this.tag = nil
+	}
+	this.mu.Unlock()
 	//使用的是第一次连接的时间
 	//this.CreateTime=this.CreateTime
 	//当连接成功的时候会进行重置操作
@@ -193,9 +240,22 @@ func (this *Client) reset(i ReadWriteCloser, key string, options ...OptionClient
 	this.closeFunc = nil
 	this.readFunc = nil
 	this.dealFunc = nil
+	this.middleware = nil
+	this.handler = nil
+	this.handleFunc = nil
 	this.writeFunc = nil
 	this.writeResultFunc = nil
 	this.keyChangeFunc = nil
+	this.heartbeat = nil
+	this.heartbeatFunc = nil
+	this.recorder = nil
+	this.recordBuf = nil
+	this.logSample = nil
+	this.autoRelease = false
+	this.coalesce = nil
+	this.codec = nil
+	this.noRecover = false
+	this.panicFunc = nil
 
 	/*
 
@@ -203,7 +263,7 @@ func (this *Client) reset(i ReadWriteCloser, key string, options ...OptionClient
 
 	 */
 
-	defaultReadFunc := ReadFuncToAck(buf.Read1KB)
+	defaultReadFunc := readAckWith1KB
 	switch v := i.(type) {
 	case nil:
 
@@ -218,8 +278,14 @@ func (this *Client) reset(i ReadWriteCloser, key string, options ...OptionClient
 		i = NewReadWriteCloser(mReader, i, i)
 
 	}
-	//默认buf大小,可自定义缓存大小
-	this.buf = bufio.NewReaderSize(i, DefaultBufferSize+1)
+	//默认buf大小,可自定义缓存大小,重连时复用之前的buf,减少内存分配
+	//第一次读取时再新建(Buffer),epoll模式的客户端不读取则不占用
+	this.reader = i
+	if this.buf != nil && this.buf.Size() == DefaultBufferSize+1 {
+		this.buf.Reset(i)
+	} else {
+		this.buf = nil
+	}
 
 	//设置默认的Option
 	this.SetKey(key) //设置唯一标识
@@ -276,6 +342,8 @@ func (this *Client) GetTag(key string, def ...string) string {
 
 // Tag 自定义信息,方便记录连接信息 例:c.Tag().GetString("imei")
 func (this *Client) Tag() *maps.Safe {
+	this.mu.Lock()
+	defer this.mu.Unlock()
 	if this.tag == nil {
 		this.tag = maps.NewSafe()
 	}
@@ -0,0 +1,259 @@
+package io
+
+import (
+	"context"
+	"github.com/injoyai/io/buf"
+	"net"
+	"sync"
+	"time"
+)
+
+//================================Batch================================
+
+// WriteBatch 批量写入,每条数据分别执行writeFunc和writeResultFunc,合并成一次写入
+// TCP/Unix连接使用writev(net.Buffers),其他连接合并成一个数据包写入,开启会话时逐条写入
+func (this *Client) WriteBatch(ps [][]byte) (n int, err error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
+	if this.getSession() != nil {
+		//会话需要对每条数据编号
+		for _, p := range ps {
+			m, err := this.Write(p)
+			n += m
+			if err != nil {
+				return n, err
+			}
+		}
+		return n, nil
+	}
+
+	//每条数据的写入结果,nil表示未写入
+	results := make([]error, len(ps))
+	written := make([]bool, len(ps))
+	_, span := this.startSpan(this.MessageContext(), SpanWrite, Field{"count", len(ps)})
+	defer func() {
+		err = dealErr(err)
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.SetAttributes(Field{FieldBytes, n})
+		span.End()
+		for i := range ps {
+			e := dealErr(results[i])
+			if e == nil && !written[i] {
+				e = err
+			}
+			for _, v := range this.writeResultFunc {
+				v(this, e)
+			}
+		}
+	}()
+	defer func() {
+		//writeFunc的panic,关闭该客户端
+		if !this.noRecover {
+			if e := recover(); e != nil {
+				n, err = 0, this.recoverPanic(PanicWrite, e)
+				_ = this.CloseWithErr(err)
+			}
+		}
+	}()
+
+	//执行写入函数,错误的数据跳过,不影响其他数据
+	frames := make([][]byte, 0, len(ps))
+	index := make([]int, 0, len(ps))
+	for i, p := range ps {
+		p, e := this.encodeWrite(p)
+		if e != nil {
+			results[i] = e
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		frames = append(frames, p)
+		index = append(index, i)
+	}
+	if len(frames) == 0 {
+		return 0, err
+	}
+
+	//写入数据
+	written64, writeErr := this.writeFrames(frames)
+	n = int(written64)
+
+	//统计每条数据的写入结果,部分写入的数据算失败
+	remain := n
+	number := uint64(0)
+	for j, p := range frames {
+		if remain >= len(p) {
+			remain -= len(p)
+			written[index[j]] = true
+			number++
+			if this.recorder != nil {
+				this.recorder.record(this, RecordWrite, p)
+			}
+			continue
+		}
+		results[index[j]] = writeErr
+		remain = 0
+	}
+	if n > 0 {
+		this.WriteTime = time.Now()
+		this.WriteCount += uint64(n)
+		this.WriteNumber += number
+		select {
+		case this.timeoutReset <- struct{}{}:
+		default:
+		}
+	}
+	if writeErr != nil {
+		return n, writeErr
+	}
+	return n, err
+}
+
+// encodeWrite 执行写入函数,处理写入的数据,进行封装或者打印等操作
+func (this *Client) encodeWrite(p []byte) (_ []byte, err error) {
+	for _, f := range this.writeFunc {
+		p, err = f(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// writeFrames 写入多个数据包,TCP/Unix连接使用writev
+func (this *Client) writeFrames(frames [][]byte) (int64, error) {
+	if len(frames) == 1 {
+		n, err := this.i.Write(frames[0])
+		return int64(n), err
+	}
+	switch this.i.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		//WriteTo会修改切片,需要复制
+		bufs := append(net.Buffers(nil), frames...)
+		return bufs.WriteTo(this.i)
+	}
+	size := 0
+	for _, p := range frames {
+		size += len(p)
+	}
+	data := buf.Get(size)[:0]
+	defer buf.Put(data)
+	for _, p := range frames {
+		data = append(data, p...)
+	}
+	n, err := this.i.Write(data)
+	return int64(n), err
+}
+
+//================================Coalesce================================
+
+// SetWriteWithCoalesce 合并写入,类似Nagle算法,Write先缓存数据,
+// 缓存达到maxBytes或者等待maxDelay之后通过WriteBatch批量写入,maxDelay<=0时只按maxBytes写入,
+// Write立即返回,写入结果通过SetWriteResultFunc获取,连接断开时缓存的数据返回错误,Flush可以立即写入
+func (this *Client) SetWriteWithCoalesce(maxDelay time.Duration, maxBytes int) *Client {
+	if maxDelay <= 0 && maxBytes <= 0 {
+		this.coalesce = nil
+		return this
+	}
+	this.coalesce = &coalesce{
+		c:        this,
+		ctx:      this.Ctx(),
+		maxDelay: maxDelay,
+		maxBytes: maxBytes,
+	}
+	return this
+}
+
+// Flush 立即写入合并写入的缓存数据
+func (this *Client) Flush() error {
+	if this.coalesce == nil {
+		return nil
+	}
+	return this.coalesce.flush()
+}
+
+type coalesce struct {
+	c        *Client
+	ctx      context.Context //单次连接的上下文,重连后丢弃之前的数据
+	maxDelay time.Duration
+	maxBytes int
+
+	mu      sync.Mutex
+	pending [][]byte
+	size    int
+	timer   *time.Timer
+
+	flushMu sync.Mutex //保证写入顺序
+}
+
+func (this *coalesce) write(p []byte) (int, error) {
+	if this.ctx.Err() != nil {
+		return 0, this.c.Err()
+	}
+	//复制数据,调用者可能会复用
+	data := append(buf.Get(len(p))[:0], p...)
+	this.mu.Lock()
+	this.pending = append(this.pending, data)
+	this.size += len(p)
+	full := this.maxBytes > 0 && this.size >= this.maxBytes
+	if !full && this.timer == nil && this.maxDelay > 0 {
+		this.timer = time.AfterFunc(this.maxDelay, func() { _ = this.flush() })
+	}
+	this.mu.Unlock()
+	if full {
+		return len(p), this.flush()
+	}
+	return len(p), nil
+}
+
+func (this *coalesce) flush() error {
+	this.flushMu.Lock()
+	defer this.flushMu.Unlock()
+
+	this.mu.Lock()
+	pending := this.pending
+	this.pending, this.size = nil, 0
+	if this.timer != nil {
+		this.timer.Stop()
+		this.timer = nil
+	}
+	this.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	defer func() {
+		for _, p := range pending {
+			buf.Put(p)
+		}
+	}()
+	if this.ctx.Err() != nil {
+		//连接已断开,丢弃的数据也需要返回写入结果
+		err := this.c.Err()
+		if err == nil {
+			err = ErrWriteClosed
+		}
+		for range pending {
+			for _, v := range this.c.writeResultFunc {
+				v(this.c, err)
+			}
+		}
+		return err
+	}
+	_, err := this.c.WriteBatch(pending)
+	return err
+}
+
+//================================ClientManage================================
+
+// WriteClientAllBatch 批量写入所有客户端
+func (this *ClientManage) WriteClientAllBatch(ps [][]byte) {
+	this.RangeClient(func(key string, c *Client) bool {
+		c.WriteBatch(ps)
+		return true
+	})
+}
@@ -0,0 +1,283 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTCPClient 新建TCP客户端,服务端的数据写入到ch
+func newTCPClient(t testing.TB, ch chan<- []byte) *Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			bs := make([]byte, 4096)
+			n, err := conn.Read(bs)
+			if err != nil {
+				return
+			}
+			if ch != nil {
+				ch <- bs[:n]
+			}
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(conn, func(c *Client) { c.Debug(false) })
+	t.Cleanup(func() { c.CloseAll() })
+	return c
+}
+
+// readAll 读取指定长度的数据
+func readAll(t *testing.T, ch <-chan []byte, length int) []byte {
+	t.Helper()
+	var result []byte
+	for len(result) < length {
+		select {
+		case bs := <-ch:
+			result = append(result, bs...)
+		case <-time.After(time.Second):
+			t.Fatalf("读取超时,已读取(%s)", result)
+		}
+	}
+	return result
+}
+
+func TestWriteBatch(t *testing.T) {
+	ch := make(chan []byte, 100)
+	tcp := newTCPClient(t, ch)
+	a, b := newPipeClient(t)
+	go func() {
+		for {
+			bs := make([]byte, 4096)
+			n, err := b.Read(bs)
+			if err != nil {
+				return
+			}
+			ch <- bs[:n]
+		}
+	}()
+
+	for name, c := range map[string]*Client{"tcp": tcp, "pipe": a} {
+		t.Run(name, func(t *testing.T) {
+			results := 0
+			c.SetWriteResultFunc(func(c *Client, err error) {
+				if err != nil {
+					t.Error(err)
+				}
+				results++
+			})
+			c.SetWriteFunc(func(p []byte) ([]byte, error) {
+				return append([]byte("<"), append(p, '>')...), nil
+			})
+			n, err := c.WriteBatch([][]byte{[]byte("a"), []byte("bc"), []byte("def")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 12 || results != 3 || c.WriteNumber != 3 {
+				t.Fatalf("写入统计错误,字节(%d),结果(%d),次数(%d)", n, results, c.WriteNumber)
+			}
+			if s := string(readAll(t, ch, n)); s != "<a><bc><def>" {
+				t.Fatalf("数据错误(%s)", s)
+			}
+		})
+	}
+}
+
+func TestWriteBatchError(t *testing.T) {
+	a, b := newPipeClient(t)
+	go b.Run()
+	errTest := errors.New("test")
+	var results []error
+	a.SetWriteResultFunc(func(c *Client, err error) { results = append(results, err) })
+	a.SetWriteFunc(func(p []byte) ([]byte, error) {
+		if string(p) == "bad" {
+			return nil, errTest
+		}
+		return p, nil
+	})
+	_, err := a.WriteBatch([][]byte{[]byte("ok"), []byte("bad"), []byte("ok")})
+	if err != errTest {
+		t.Fatalf("预期错误(%v),得到(%v)", errTest, err)
+	}
+	if len(results) != 3 || results[0] != nil || results[1] != errTest || results[2] != nil {
+		t.Fatalf("写入结果错误: %v", results)
+	}
+}
+
+func TestWriteCoalesce(t *testing.T) {
+	ch := make(chan []byte, 100)
+	c := newTCPClient(t, ch)
+	c.SetWriteWithCoalesce(time.Millisecond*20, 10)
+	var mu sync.Mutex
+	results := 0
+	c.SetWriteResultFunc(func(c *Client, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results++
+	})
+
+	//等待延迟之后写入
+	c.WriteString("a")
+	c.WriteString("b")
+	select {
+	case <-ch:
+		t.Fatal("未到延迟时间")
+	case <-time.After(time.Millisecond * 5):
+	}
+	if s := string(readAll(t, ch, 2)); s != "ab" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+	//数据到达之后才会更新统计
+	for i := 0; i < 100; i++ {
+		mu.Lock()
+		n := results
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if c.WriteNumber != 2 {
+		t.Fatalf("预期写入2次,得到(%d)", c.WriteNumber)
+	}
+
+	//达到最大字节立即写入
+	buf := []byte("0123456789")
+	c.Write(buf)
+	copy(buf, "xxxxxxxxxx")
+	if s := string(readAll(t, ch, 10)); s != "0123456789" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+
+	//手动写入
+	c.WriteString("c")
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, ch, 1)
+	mu.Lock()
+	defer mu.Unlock()
+	if results != 4 {
+		t.Fatalf("预期4个写入结果,得到(%d)", results)
+	}
+}
+
+// TestWriteCoalesceSize maxDelay<=0时只按maxBytes写入
+func TestWriteCoalesceSize(t *testing.T) {
+	ch := make(chan []byte, 100)
+	c := newTCPClient(t, ch)
+	c.SetWriteWithCoalesce(0, 4)
+	c.WriteString("ab")
+	select {
+	case bs := <-ch:
+		t.Fatalf("未到最大字节,不应该写入(%s)", bs)
+	case <-time.After(time.Millisecond * 50):
+	}
+	c.WriteString("cd")
+	if s := string(readAll(t, ch, 4)); s != "abcd" {
+		t.Fatalf("数据错误(%s)", s)
+	}
+}
+
+// TestWriteCoalesceClosed 连接断开时缓存的数据返回错误
+func TestWriteCoalesceClosed(t *testing.T) {
+	c := newTCPClient(t, nil)
+	c.SetWriteWithCoalesce(time.Hour, 0)
+	var mu sync.Mutex
+	var errs []error
+	c.SetWriteResultFunc(func(c *Client, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+	c.WriteString("a")
+	c.WriteString("b")
+	c.Close()
+	if err := c.Flush(); !errors.Is(err, ErrHandClose) {
+		t.Fatalf("预期(%v),得到(%v)", ErrHandClose, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 2 {
+		t.Fatalf("预期2个写入结果,得到(%d)", len(errs))
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrHandClose) {
+			t.Fatalf("预期(%v),得到(%v)", ErrHandClose, err)
+		}
+	}
+}
+
+// newBenchManage 新建多个TCP客户端,服务端丢弃数据
+func newBenchManage(b *testing.B, num int) *ClientManage {
+	m := NewClientManage("bench", NewLoggerWithNull())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	for i := 0; i < num; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		c := NewClient(conn, func(c *Client) { c.Debug(false) })
+		b.Cleanup(func() { c.CloseAll() })
+		m.mKey[fmt.Sprint(i)] = c
+	}
+	return m
+}
+
+// BenchmarkWriteClientAll 逐条写入所有客户端
+func BenchmarkWriteClientAll(b *testing.B) {
+	m := newBenchManage(b, 8)
+	msg := bytes.Repeat([]byte{1}, 64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 16; j++ {
+			m.WriteClientAll(msg)
+		}
+	}
+}
+
+// BenchmarkWriteClientAllBatch 批量写入所有客户端
+func BenchmarkWriteClientAllBatch(b *testing.B) {
+	m := newBenchManage(b, 8)
+	msg := bytes.Repeat([]byte{1}, 64)
+	batch := make([][]byte, 16)
+	for i := range batch {
+		batch[i] = msg
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.WriteClientAllBatch(batch)
+	}
+}
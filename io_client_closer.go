@@ -3,7 +3,6 @@ package io
 import (
 	"context"
 	"errors"
-	"fmt"
 	"sync/atomic"
 	"time"
 )
@@ -14,7 +13,7 @@ func (this *Client) Redial(options ...OptionClient) *Client {
 		//等待1秒之后开始重连,防止无限制连接断开
 		<-time.After(time.Second)
 		if err := this.MustDial(this.ctxParent, func(c *Client) { c.Redial(options...) }); err != nil {
-			this.Errorf("[%s] 重连错误,%v\n", this.GetKey(), err)
+			this.logf(LevelError, err, "重连错误,%v", err)
 			return
 		}
 	})
@@ -43,10 +42,19 @@ func (this *Client) SetRedialMaxNum(n int) *Client {
 
 // SetDialFunc 设置连接函数
 func (this *Client) SetDialFunc(fn DialFunc) *Client {
+	this.mu.Lock()
+	defer this.mu.Unlock()
 	this.dialFunc = fn
 	return this
 }
 
+// getDialFunc 获取连接函数,CloseAll可能在其他协程清除
+func (this *Client) getDialFunc() DialFunc {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.dialFunc
+}
+
 // SetDialWithNil 设置连接函数为nil
 func (this *Client) SetDialWithNil() *Client {
 	this.SetDialFunc(nil)
@@ -91,7 +99,9 @@ func (this *Client) timer(ctx context.Context, dealErr func(error) error, interv
 }
 
 // For 循环执行
+// 生命周期(单次连接),重连之后上下文会替换,原先的循环需要结束
 func (this *Client) For(fn func(ctx context.Context) error) (err error) {
+	ctx := this.Ctx()
 	for {
 		select {
 		//case <-this.DoneAll():
@@ -101,7 +111,7 @@ func (this *Client) For(fn func(ctx context.Context) error) (err error) {
 		//logs.Debug("DoneAll")
 		//this.CloseAll()
 		//return this.Err()
-		case <-this.Done():
+		case <-ctx.Done():
 			//1. 调用了Close方法
 			//2. 连接报错触发了Close
 			return this.Err()
@@ -109,10 +119,10 @@ func (this *Client) For(fn func(ctx context.Context) error) (err error) {
 			_ = this.CloseWithErr(func() (err error) {
 				defer func() {
 					if e := recover(); e != nil {
-						err = fmt.Errorf("%v", e)
+						err = this.recoverPanic(PanicRun, e)
 					}
 				}()
-				return fn(this.Ctx())
+				return fn(ctx)
 			}())
 		}
 	}
@@ -132,6 +142,8 @@ func (this *Client) DoneAll() <-chan struct{} {
 
 // Ctx 子级上下文,生命周期(单次连接)
 func (this *Client) Ctx() context.Context {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
 	return this.ctx
 }
 
@@ -142,6 +154,8 @@ func (this *Client) Done() <-chan struct{} {
 
 // Err 错误信息
 func (this *Client) Err() error {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
 	return this.closeErr
 }
 
@@ -166,16 +180,24 @@ func (this *Client) CloseAll() error {
 	return this.CloseAllWithErr(ErrHandClose)
 }
 
+// CloseAllWithErr 主动关闭,不会重试,err为nil时不关闭,关闭的错误信息是ErrHandClose
 func (this *Client) CloseAllWithErr(err error) error {
 	if err == nil {
 		return nil
 	}
 	//关闭重试函数
 	this.SetDialWithNil()
-	//关闭父级上下文
-	this.cancelParent()
-	//关闭子级
-	return this.CloseWithErr(ErrHandClose)
+	//已经关闭过的情况,也需要关闭父级上下文
+	defer this.cancelParent()
+	//关闭子级,在错误信息赋值之后,执行关闭函数之前,关闭父级上下文
+	//确保子级关闭信号之后一定有错误信息,关闭函数(例如重连)能判断父级已关闭
+	return this.closeWithErr(ErrHandClose, func(closer Closer) error {
+		this.cancelParent()
+		if closer == nil {
+			return nil
+		}
+		return closer.Close()
+	})
 }
 
 // Close 主动关闭,会重试(如果设置了重连)
@@ -209,13 +231,20 @@ func (this *Client) closeWithErr(closeErr error, fn ...func(Closer) error) (err
 			return
 		}
 		//先赋值错误,再赋值关闭,确保关闭后一定有错误信息
+		this.mu.Lock()
 		this.closeErr = dealErr(closeErr)
+		cancel := this.cancel
+		this.mu.Unlock()
 		//关闭子级上下文
-		this.cancel()
+		cancel()
 		//关闭写队列
 		if this.writeQueue != nil {
 			close(this.writeQueue)
 		}
+		//取消epoll监听,需要在关闭实例之前
+		if pc, _ := this.poll.Load().(*pollConn); pc != nil {
+			pc.remove()
+		}
 		//关闭实例,可自定义关闭方式,例如设置超时
 		if len(fn) == 0 && this.i != nil {
 			err = this.i.Close()
@@ -224,15 +253,19 @@ func (this *Client) closeWithErr(closeErr error, fn ...func(Closer) error) (err
 				err = v(this.i)
 			}
 		}
+		//会话断开,等待正在写入的数据完成,之后写入的数据缓存到重连后补发
+		if s := this.getSession(); s != nil {
+			s.detach(this)
+		}
 		//生成错误信息
 		//msg := Message(this.closeErr.Error())
 		////打印错误信息
 		//this.logger.Errorf("[%s] %s\n", this.GetKey(), msg.String())
-		this.logger.Errorf("[%s] 断开连接: %v\n", this.GetKey(), this.closeErr)
+		this.logf(LevelError, this.closeErr, "断开连接: %v", this.closeErr)
 
 		//执行用户设置的错误函数,需要最后执行,防止后续操作无法执行,如果设置了重连不会执行到下一步
 		if this.closeFunc != nil {
-			this.closeFunc(this.CtxAll(), this, this.closeErr)
+			this.callClose(this.closeErr)
 		}
 
 		////执行用户设置的错误函数
@@ -259,7 +292,7 @@ func (this *Client) MustDial(ctx context.Context, options ...OptionClient) error
 			return errors.New("上下文关闭")
 
 		case <-timer.C:
-			if this.dialFunc == nil {
+			if this.getDialFunc() == nil {
 				//未设置重连函数
 				//this.Errorf("[%s] 连接断开(%v),未设置重连函数\n", this.GetKey(), this.Err())
 				return errors.New("未设置重连函数")
@@ -277,16 +310,17 @@ func (this *Client) MustDial(ctx context.Context, options ...OptionClient) error
 				t = this.redialMaxTime
 			}
 
-			this.Logger.Errorf("[%s] %v,等待%d秒重试\n", this.GetKey(), dealErr(err), t/time.Second)
+			this.logf(LevelError, dealErr(err), "%v,等待%d秒重试", dealErr(err), t/time.Second)
 			timer.Reset(t)
 		}
 	}
 }
 
 // Dial 连接,成功后执行Option
-func (this *Client) Dial(options ...OptionClient) error {
+func (this *Client) Dial(options ...OptionClient) (err error) {
 
-	if this.dialFunc == nil {
+	dialFunc := this.getDialFunc()
+	if dialFunc == nil {
 		//未设置重连函数
 		return errors.New("无效连接函数")
 	}
@@ -299,8 +333,18 @@ func (this *Client) Dial(options ...OptionClient) error {
 
 	default:
 
+		//链路追踪
+		ctx, span := this.startSpan(this.ctxParent, SpanDial)
+		defer func() {
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.SetAttributes(Field{FieldKey, this.GetKey()})
+			span.End()
+		}()
+
 		//尝试进行连接,返回ReadWriteCloser和唯一标识key
-		i, key, err := this.dialFunc(this.ctx)
+		i, key, err := dialFunc(ctx)
 		if err != nil {
 			if len(key) > 0 {
 				//尝试设置key,如果错误也返回key的话
@@ -320,7 +364,7 @@ func (this *Client) Dial(options ...OptionClient) error {
 
 		//连接成功事件
 		for _, f := range this.connectFunc {
-			if err := this.CloseWithErr(f(this)); err != nil {
+			if err := this.CloseWithErr(this.callConnect(f)); err != nil {
 				return err
 			}
 		}
@@ -71,7 +71,7 @@ func (this *Client) SetConnectFunc(f func(c *Client) error) *Client {
 // SetConnectWithLog 设置连接成功打印日志
 func (this *Client) SetConnectWithLog() *Client {
 	return this.SetConnectFunc(func(c *Client) error {
-		this.Infof("[%s] 连接服务端成功...\n", this.GetKey())
+		this.logf(LevelInfo, nil, "连接服务端成功...")
 		return nil
 	})
 }
@@ -96,7 +96,7 @@ func (this *Client) SetReadAckFunc(fn func(r *bufio.Reader) (Acker, error)) *Cli
 	this.readFunc = func(reader *bufio.Reader) (Acker, error) {
 
 		if fn == nil {
-			fn = ReadFuncToAck(buf.Read1KB)
+			fn = readAckWith1KB
 		}
 
 		//执行用户设置的函数
@@ -110,12 +110,6 @@ func (this *Client) SetReadAckFunc(fn func(r *bufio.Reader) (Acker, error)) *Cli
 			this.ReadTime = time.Now()
 			this.ReadCount += uint64(len(bs))
 
-			//尝试加入通道,如果设置了监听,则有效
-			select {
-			case this.latestChan <- bs:
-			default:
-			}
-
 			//尝试加入通道,超时定时器重置
 			select {
 			case this.timeoutReset <- struct{}{}:
@@ -131,20 +125,18 @@ func (this *Client) SetReadAckFunc(fn func(r *bufio.Reader) (Acker, error)) *Cli
 
 // SetReadWithPkg 使用默认读包方式
 func (this *Client) SetReadWithPkg() *Client {
-	return this.SetReadFunc(ReadWithPkg)
+	return this.SetReadAckFunc(ReadAckWithPkg)
 }
 
 // SetReadWith1KB 每次读取1字节
 func (this *Client) SetReadWith1KB() {
-	this.SetReadFunc(buf.Read1KB)
+	this.SetReadAckFunc(readAckWith1KB)
 }
 
 // SetReadWithKB 读取固定字节长度
 func (this *Client) SetReadWithKB(n uint) *Client {
-	return this.SetReadFunc(func(buf *bufio.Reader) ([]byte, error) {
-		bytes := make([]byte, n<<10)
-		length, err := buf.Read(bytes)
-		return bytes[:length], err
+	return this.SetReadFunc(func(r *bufio.Reader) ([]byte, error) {
+		return buf.ReadMost(r, int(n<<10))
 	})
 }
 
@@ -262,13 +254,17 @@ func (this *Client) SetDealWithNil() *Client {
 func (this *Client) SetDealWithLog() *Client {
 	return this.SetDealFunc(func(c *Client, msg Message) {
 		//打印实际接收的数据,方便调试
-		this.logger.Readln("["+c.GetKey()+"] ", msg)
+		this.logData(LevelRead, msg)
 	})
 }
 
 // SetDealWithDefault 设置默认处理数据函数,打印需要处理的数据,和处理数据ping,pong
 func (this *Client) SetDealWithDefault() *Client {
 	return this.SetDealWithLog().SetDealFunc(func(c *Client, msg Message) {
+		//设置了心跳时由心跳处理,ping作为普通数据,不再响应
+		if c.heartbeat != nil {
+			return
+		}
 		//先判断长度,减少字节转字符的内存分配,最好用指针的方式(直接用字节的指针)
 		if msg.Len() == len(Ping) || msg.Len() == len(Pong) {
 			switch msg.String() {
@@ -334,7 +330,7 @@ func (this *Client) SetWriteWithNil() *Client {
 func (this *Client) SetWriteWithLog() *Client {
 	return this.SetWriteFunc(func(p []byte) ([]byte, error) {
 		//打印实际发送的数据,方便调试
-		this.logger.Writeln("["+this.GetKey()+"] ", p)
+		this.logData(LevelWrite, p)
 		return p, nil
 	})
 }
@@ -384,7 +380,7 @@ func (this *Client) SetCloseFunc(fn func(ctx context.Context, c *Client, err err
 // SetCloseWithLog 设置关闭时打印日志
 func (this *Client) SetCloseWithLog() {
 	this.SetCloseFunc(func(ctx context.Context, c *Client, err error) {
-		this.logger.Errorf("[%s] 断开连接: %v\n", this.GetKey(), err)
+		this.logf(LevelError, err, "断开连接: %v", err)
 	})
 }
 
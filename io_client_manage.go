@@ -114,8 +114,13 @@ func (this *ClientManage) RedialClient(dial DialFunc, options ...OptionClient) *
 	return c
 }
 
-// SetClient 添加客户端
+// SetClient 添加客户端,选项中设置的关闭函数,在客户端从管理中移除之后执行
 func (this *ClientManage) SetClient(c *Client) {
+	this.setClient(c, func(c *Client) { c.Run() })
+}
+
+// setClient 设置客户端,注册成功之后执行run,例如读取数据或者加入epoll
+func (this *ClientManage) setClient(c *Client, run func(c *Client)) {
 	if c == nil {
 		return
 	}
@@ -128,7 +133,14 @@ func (this *ClientManage) SetClient(c *Client) {
 	}
 
 	c.SetOptions(this.options...)
-	c.SetCloseFunc(this.closeFunc)
+	//先从管理中移除,再执行选项中设置的关闭函数,例如Server.SetCloseFunc
+	closeFunc := c.closeFunc
+	c.SetCloseFunc(func(ctx context.Context, c *Client, err error) {
+		this.closeFunc(ctx, c, err)
+		if closeFunc != nil {
+			closeFunc(ctx, c, err)
+		}
+	})
 	c.SetKeyChangeFunc(this.keyChangeFunc)
 
 	//
@@ -136,11 +148,19 @@ func (this *ClientManage) SetClient(c *Client) {
 	// 协程执行,等待连接的后续数据,来决定后续操作
 	go func(c *Client) {
 
+		//链路追踪,包括连接事件和注册
+		_, span := c.startSpan(c.CtxAll(), SpanAccept)
+
 		//TODO 怎么判断是客户端还是服务端的连接实例
 		//前置操作,例如等待注册数据,不符合的返回错误则关闭连接
 		for _, f := range c.connectFunc {
-			if err := f(c); err != nil {
-				this.Logger.Errorf("[%s] %v\n", c.GetKey(), err)
+			if err := c.callConnect(f); err != nil {
+				span.RecordError(err)
+				span.End()
+				//连接函数已经关闭了客户端,关闭时已经打印了日志
+				if !c.Closed() {
+					this.Logger.Errorf("[%s] %v\n", c.GetKey(), err)
+				}
 				//丢弃连接,防止重复连接断开
 				_ = c.CloseAll()
 				return
@@ -159,6 +179,10 @@ func (this *ClientManage) SetClient(c *Client) {
 				this.Keep.Keep(c)
 			}
 		})
+		c.OnHeartbeat(func(c *Client, rtt time.Duration) {
+			//收到心跳数据时,保持心跳
+			this.Keep.Keep(c)
+		})
 
 		//注册成功,验证通过
 		//判断是否存在老连接,存在则关闭老连接(被挤下线)
@@ -173,7 +197,9 @@ func (this *ClientManage) SetClient(c *Client) {
 		this.mu.Lock()
 		this.mKey[c.GetKey()] = c
 		this.mu.Unlock()
-		c.Run()
+		span.SetAttributes(Field{FieldKey, c.GetKey()})
+		span.End()
+		run(c)
 
 	}(c)
 
@@ -224,6 +250,8 @@ func (this *ClientManage) GetClientDo(key string, fn func(c *Client) error) (boo
 
 // GetClientLen 获取客户端数量
 func (this *ClientManage) GetClientLen() int {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
 	return len(this.mKey)
 }
 
@@ -331,7 +359,9 @@ func (this *ClientManage) CloseClientAll() {
 	for _, v := range this.CopyClientMap() {
 		v.CloseAll()
 	}
+	this.mu.Lock()
 	this.mKey = make(map[string]*Client)
+	this.mu.Unlock()
 }
 
 // SetClientKey 重命名key,默认监听了客户端的key变化事件,通过事件来更新缓存信息
@@ -375,7 +405,9 @@ func (this *ClientManage) keyChangeFunc(newClient *Client, oldKey string) {
 
 	//更新新的客户端
 	this.mu.Lock()
-	delete(this.mKey, newClient.GetKey())
+	if this.mKey[oldKey] == newClient {
+		delete(this.mKey, oldKey)
+	}
 	this.mKey[newKey] = newClient
 	this.mu.Unlock()
 }
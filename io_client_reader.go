@@ -11,13 +11,20 @@ import (
 
 //================================Nature================================
 
-// Buffer 极大的增加读取速度
+// Buffer 极大的增加读取速度,第一次使用时新建
 func (this *Client) Buffer() *bufio.Reader {
+	if this.buf == nil {
+		this.buf = bufio.NewReaderSize(this.reader, DefaultBufferSize+1)
+	}
 	return this.buf
 }
 
 // SetBufferSize 设置缓存大小
 func (this *Client) SetBufferSize(size int) error {
+	if this.buf == nil {
+		this.buf = bufio.NewReaderSize(this.reader, size)
+		return nil
+	}
 	old := make([]byte, this.buf.Size())
 	n, err := this.buf.Read(old)
 	if err != nil {
@@ -28,19 +35,52 @@ func (this *Client) SetBufferSize(size int) error {
 	return nil
 }
 
-// Read io.reader
+// SetAutoRelease 设置处理完成后自动归还数据到字节池(buf.Get),减少内存分配
+// 只归还库的读取函数从字节池获取的数据(默认读取,SetReadWithPkg),自定义读取函数的数据不会归还
+// 开启后不能在dealFunc之外保留数据的引用(例如SetDealWithChan),需要保留的请复制,ReadLatest读取的数据不会归还
+func (this *Client) SetAutoRelease(enable bool) *Client {
+	this.autoRelease = enable
+	return this
+}
+
+// ReleaseMessage 归还数据到字节池,归还之后不能再使用,
+// 只能归还从字节池获取的数据(例如buf.Read1KB,ReadWithPkg),
+// 归还其他数据(例如引用了bufio缓存或用户的内存)会被后续的buf.Get取出并覆盖
+func ReleaseMessage(msg Message) {
+	buf.Put(msg)
+}
+
+// poolAck 从字节池获取的数据,开启自动归还时,处理完成后归还
+type poolAck []byte
+
+func (this poolAck) Ack() error      { return nil }
+func (this poolAck) Payload() []byte { return this }
+
+// readAckWith1KB 每次最多读取1KB,数据从字节池获取
+func readAckWith1KB(r *bufio.Reader) (Acker, error) {
+	bs, err := buf.Read1KB(r)
+	return poolAck(bs), err
+}
+
+// Read io.reader,设置了记录器时,每次读取的数据记录一条
 func (this *Client) Read(p []byte) (int, error) {
-	return this.Buffer().Read(p)
+	n, err := this.Buffer().Read(p)
+	this.recordFlush()
+	return n, err
 }
 
 // ReadByte 读取一字节
 func (this *Client) ReadByte() (byte, error) {
-	return this.Buffer().ReadByte()
+	b, err := this.Buffer().ReadByte()
+	this.recordFlush()
+	return b, err
 }
 
 // Read1KB 读取1kb数据
 func (this *Client) Read1KB() ([]byte, error) {
-	return buf.Read1KB(this.Buffer())
+	bs, err := buf.Read1KB(this.Buffer())
+	this.recordFlush()
+	return bs, err
 }
 
 // ReadMessage 实现MessageReader接口
@@ -56,7 +96,11 @@ func (this *Client) ReadAck() (Acker, error) {
 	if this.readFunc == nil {
 		return nil, ErrInvalidReadFunc
 	}
-	return this.readFunc(this.Buffer())
+	ack, err := this.readFunc(this.Buffer())
+	if err == nil {
+		this.recordFlush()
+	}
+	return ack, err
 }
 
 // ReadLatest 读取最新的数据
@@ -119,23 +163,81 @@ func (this *Client) Run() error {
 		}(this.Ctx())
 	}
 
+	//心跳
+	if this.heartbeat != nil {
+		go this.runHeartbeat()
+	}
+
 	//开始循环读取数据,处理数据
 	return this.For(func(ctx context.Context) (err error) {
 
 		//读取数据
 		ack, err := this.ReadAck()
-		if err != nil || len(ack.Payload()) == 0 {
+		if err != nil {
 			return err
 		}
 
-		//处理数据
-		for _, dealFunc := range this.dealFunc {
-			if dealFunc != nil && dealFunc(this, ack.Payload()) {
-				ack.Ack()
-			}
-		}
+		return this.dealRead(ctx, ack)
+	})
 
+}
+
+// dealRead 处理读取函数的结果,帧层面的心跳没有数据,需要在判断数据长度之前处理
+func (this *Client) dealRead(ctx context.Context, ack Acker) error {
+	if this.dealHeartbeatFrame(ack) {
+		return ack.Ack()
+	}
+	if len(ack.Payload()) == 0 {
 		return nil
-	})
+	}
+	return this.dealAck(ctx, ack)
+}
+
+// dealAck 处理读取到的数据,会话,心跳,链路追踪,中间件和dealFunc
+func (this *Client) dealAck(ctx context.Context, ack Acker) error {
+
+	msg := Message(ack.Payload())
+
+	//处理完成后归还数据,只归还从字节池获取的数据
+	_, release := ack.(poolAck)
+	release = release && this.autoRelease
+	defer func() {
+		if release {
+			ReleaseMessage(ack.Payload())
+		}
+	}()
+
+	//链路追踪,每条数据一个根跨度
+	ctx, span := this.startSpan(ctx, SpanRead, Field{FieldBytes, len(msg)})
+	defer span.End()
+
+	//会话数据,处理握手和确认
+	if this.session != nil {
+		var ok bool
+		if msg, ok = this.dealSession(msg); !ok {
+			return ack.Ack()
+		}
+	}
+
+	//心跳数据,不交给用户处理,帧层面的心跳已经在dealRead中处理
+	if !this.heartbeatFrame() && this.dealHeartbeat(msg) {
+		return ack.Ack()
+	}
+
+	//尝试加入通道,如果设置了监听,则有效
+	select {
+	case this.latestChan <- msg:
+		//交给ReadLatest,不归还
+		release = false
+	default:
+	}
 
+	//处理数据,执行中间件和dealFunc,dealFunc中可以通过MessageContext继续追踪
+	ctx, dealSpan := this.startSpan(ctx, SpanDeal)
+	this.setMessageContext(ctx)
+	defer func() {
+		this.setMessageContext(nil)
+		dealSpan.End()
+	}()
+	return this.handle(ctx, ack, msg)
 }
@@ -0,0 +1,81 @@
+package io
+
+import (
+	"context"
+	"github.com/injoyai/io/buf"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAutoRelease(t *testing.T) {
+	a, b := newPipeClient(t)
+	a.SetWriteWithPkg()
+	b.SetReadWithPkg().SetAutoRelease(true)
+	received := make(chan string, 10)
+	b.SetDealFunc(func(c *Client, msg Message) { received <- msg.String() })
+	go b.Run()
+	for _, v := range []string{"hello", "world"} {
+		if _, err := a.WriteString(v); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case s := <-received:
+			if s != v {
+				t.Fatalf("预期(%s),得到(%s)", v, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("未收到数据")
+		}
+	}
+
+	//ReadLatest读取的数据不会被归还
+	go a.WriteString("latest")
+	msg, err := b.ReadLatest(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.WriteString("other")
+	<-received
+	<-received
+	if string(msg) != "latest" {
+		t.Fatalf("数据被修改(%s)", msg)
+	}
+}
+
+// TestAutoReleaseCustom 自定义读取函数的数据不是从字节池获取的,不能归还
+func TestAutoReleaseCustom(t *testing.T) {
+	_, b := newPipeClient(t)
+	b.SetAutoRelease(true)
+	own := make([]byte, 1024)
+	copy(own, "custom")
+	for i := 0; i < 10; i++ {
+		if err := b.dealAck(context.Background(), Ack(own)); err != nil {
+			t.Fatal(err)
+		}
+		p := buf.Get(len(own))
+		if &p[0] == &own[0] {
+			t.Fatal("自定义读取的数据被归还到字节池")
+		}
+	}
+}
+
+func TestBufferReuse(t *testing.T) {
+	c := newClient(context.Background())
+	c.SetDialFunc(func(ctx context.Context) (ReadWriteCloser, string, error) {
+		c1, _ := net.Pipe()
+		return c1, "test", nil
+	})
+	if err := c.Dial(func(c *Client) { c.Debug(false) }); err != nil {
+		t.Fatal(err)
+	}
+	buf := c.Buffer()
+	c.Close()
+	if err := c.Dial(func(c *Client) { c.Debug(false) }); err != nil {
+		t.Fatal(err)
+	}
+	defer c.CloseAll()
+	if c.Buffer() != buf {
+		t.Fatal("重连之后应该复用buf")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"github.com/injoyai/conv"
+	"github.com/injoyai/io/codec"
 	"time"
 )
 
@@ -12,16 +13,46 @@ import (
 
 // Write 写入字节,实现io.Writer
 func (this *Client) Write(p []byte) (n int, err error) {
+	if s := this.getSession(); s != nil {
+		return s.write(this, p)
+	}
+	if this.coalesce != nil {
+		return this.coalesce.write(p)
+	}
+	return this.write(p)
+}
+
+// write 执行写入函数,并写入数据
+func (this *Client) write(p []byte) (int, error) {
+	return this.writeWith(p, true)
+}
+
+// writeWith 写入数据,encode为false时不执行写入函数,例如已经是完整数据帧的心跳
+func (this *Client) writeWith(p []byte, encode bool) (n int, err error) {
+	_, span := this.startSpan(this.MessageContext(), SpanWrite, Field{FieldBytes, len(p)})
 	defer func() {
 		err = dealErr(err)
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
 		for _, v := range this.writeResultFunc {
 			v(this, err)
 		}
 	}()
+	defer func() {
+		//writeFunc的panic,关闭该客户端
+		if !this.noRecover {
+			if e := recover(); e != nil {
+				n, err = 0, this.recoverPanic(PanicWrite, e)
+				_ = this.CloseWithErr(err)
+			}
+		}
+	}()
 
 	//执行写入函数,处理写入的数据,进行封装或者打印等操作
-	for _, f := range this.writeFunc {
-		p, err = f(p)
+	if encode {
+		p, err = this.encodeWrite(p)
 		if err != nil {
 			return 0, err
 		}
@@ -32,6 +63,9 @@ func (this *Client) Write(p []byte) (n int, err error) {
 	if err != nil {
 		return 0, err
 	}
+	if this.recorder != nil {
+		this.recorder.record(this, RecordWrite, p[:n])
+	}
 	this.WriteTime = time.Now()
 	this.WriteCount += uint64(n)
 	this.WriteNumber++
@@ -70,9 +104,36 @@ func (this *Client) WriteBase64(s string) (int, error) {
 	return this.Write(bytes)
 }
 
-// WriteAny 写入任意数据,根据conv转成字节
+// WriteAny 写入任意数据,设置了编解码时,字节和字符串原样写入,其他数据编码后写入,否则根据conv转成字节
 func (this *Client) WriteAny(any interface{}) (int, error) {
-	return this.Write(conv.Bytes(any))
+	if this.codec == nil {
+		return this.Write(conv.Bytes(any))
+	}
+	switch val := any.(type) {
+	case []byte:
+		return this.Write(val)
+	case string:
+		return this.Write([]byte(val))
+	}
+	bs, err := this.codec.Marshal(any)
+	if err != nil {
+		return 0, err
+	}
+	return this.Write(bs)
+}
+
+// SetCodec 设置编解码,用于WriteAny写入结构数据,例如流量受限时使用codec.CBOR
+func (this *Client) SetCodec(c codec.Codec) *Client {
+	this.codec = c
+	return this
+}
+
+// Codec 获取设置的编解码,未设置返回codec.JSON
+func (this *Client) Codec() codec.Codec {
+	if this.codec == nil {
+		return codec.JSON
+	}
+	return this.codec
 }
 
 // WriteSplit 写入字节,分片写入,例如udp需要写入字节小于(1500-20-8=1472)
@@ -114,8 +175,8 @@ func (this *Client) WriteChan(c chan interface{}) (int64, error) {
 }
 
 func (this *Client) WriteQueue(p []byte) (int, error) {
-	if this.closeErr != nil {
-		return 0, this.closeErr
+	if err := this.Err(); err != nil {
+		return 0, err
 	}
 	this.initQueue()
 	select {
@@ -26,9 +26,33 @@ const (
 	DefaultTimeout         = DefaultKeepAlive * 3 //默认超时时间,3个keepalive时间
 	DefaultTimeoutInterval = time.Minute          //默认离线检查间隔
 	DefaultResponseTimeout = time.Second * 10     //默认响应超时时间
+	DefaultSessionTimeout  = time.Minute * 5      //默认会话保留时间
+	DefaultSessionBuffer   = 1000                 //默认会话缓存数据数量
 
 )
 
+const (
+	DefaultDispatcherWorkers = 64   //默认数据分发协程数量
+	DefaultDispatcherBacklog = 100  //默认单个客户端最大积压数量
+	DefaultDispatcherQueue   = 1000 //默认单个协程的队列大小
+)
+
+const (
+	DefaultPollReadSize  = 64 << 10 //epoll模式单次读取的最大字节
+	DefaultPollMaxBuffer = 1 << 20  //epoll模式单个连接未解析的最大缓存,超过则关闭连接
+)
+
+const (
+	DefaultMuxWindow    = 256 << 10        //多路复用默认单个流的接收窗口
+	DefaultMuxBacklog   = 256              //多路复用默认等待接收的流数量
+	DefaultMuxKeepAlive = time.Second * 30 //多路复用默认保活间隔
+)
+
+const (
+	DefaultCipherRekeyBytes    = GB        //加密传输默认发送多少字节后更新密钥
+	DefaultCipherRekeyInterval = time.Hour //加密传输默认更新密钥的间隔
+)
+
 const (
 	B_TCP       = 0x00 // "TCP"
 	B_UDP       = 0x01 // "UDP"
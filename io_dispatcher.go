@@ -0,0 +1,241 @@
+package io
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+
+Dispatcher 数据分发,服务端共享的协程池
+
+按客户端的唯一标识(ID)分配到固定的协程,保证单个客户端的数据按顺序处理
+单个客户端积压的数据达到上限时,阻塞该客户端的数据读取(背压),不影响其他客户端
+空闲的客户端不占用处理协程
+
+s.SetDispatcher(io.NewDispatcher(16, 100))
+
+*/
+
+// NewDispatcher 新建数据分发
+// @workers 协程数量,<=0使用DefaultDispatcherWorkers
+// @backlog 单个客户端最大积压数量,<=0使用DefaultDispatcherBacklog
+func NewDispatcher(workers, backlog int) *Dispatcher {
+	if workers <= 0 {
+		workers = DefaultDispatcherWorkers
+	}
+	if backlog <= 0 {
+		backlog = DefaultDispatcherBacklog
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	this := &Dispatcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		backlog: backlog,
+		workers: make([]chan *dispatchTask, workers),
+		clients: make(map[*Client]*dispatchClient),
+	}
+	for i := range this.workers {
+		this.workers[i] = make(chan *dispatchTask, DefaultDispatcherQueue)
+		go this.run(this.workers[i])
+	}
+	return this
+}
+
+type dispatchTask struct {
+	ctx     context.Context
+	c       *Client
+	msg     Message
+	handler Handler
+}
+
+type dispatchClient struct {
+	pending int           //积压数量
+	wait    chan struct{} //积压已满时等待
+}
+
+// Dispatcher 数据分发
+type Dispatcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	backlog int
+	workers []chan *dispatchTask
+
+	mu      sync.Mutex
+	clients map[*Client]*dispatchClient
+
+	pending uint64 //等待处理的数量
+	running int64  //正在处理的数量
+	done    uint64 //处理完成的数量
+	dropped uint64 //客户端已关闭,丢弃的数量
+	blocked uint64 //背压,阻塞读取的次数
+}
+
+// DispatcherStats 数据分发的统计
+type DispatcherStats struct {
+	Workers int    //协程数量
+	Clients int    //有积压数据的客户端数量
+	Pending uint64 //等待处理的数量
+	Running int64  //正在处理的数量
+	Done    uint64 //处理完成的数量
+	Dropped uint64 //客户端已关闭,丢弃的数量
+	Blocked uint64 //背压,阻塞读取的次数
+}
+
+// Stats 统计信息
+func (this *Dispatcher) Stats() DispatcherStats {
+	this.mu.Lock()
+	clients := len(this.clients)
+	this.mu.Unlock()
+	return DispatcherStats{
+		Workers: len(this.workers),
+		Clients: clients,
+		Pending: atomic.LoadUint64(&this.pending),
+		Running: atomic.LoadInt64(&this.running),
+		Done:    atomic.LoadUint64(&this.done),
+		Dropped: atomic.LoadUint64(&this.dropped),
+		Blocked: atomic.LoadUint64(&this.blocked),
+	}
+}
+
+// Close 关闭,停止所有协程,未处理的数据会被丢弃
+func (this *Dispatcher) Close() error {
+	this.cancel()
+	return nil
+}
+
+// Middleware 中间件,后续的处理在协程池中执行
+func (this *Dispatcher) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, c *Client, msg Message) error {
+			return this.Dispatch(ctx, c, msg, next)
+		}
+	}
+}
+
+// Dispatch 分发数据,客户端积压已满时阻塞,处理返回错误或panic会关闭该客户端
+// 数据会被复制,读取函数可能会复用缓存
+func (this *Dispatcher) Dispatch(ctx context.Context, c *Client, msg Message, handler Handler) error {
+	if this.ctx.Err() != nil {
+		return ErrDispatcherClosed
+	}
+	if err := this.acquire(c); err != nil {
+		return err
+	}
+	task := &dispatchTask{
+		ctx:     ctx,
+		c:       c,
+		msg:     append(Message(nil), msg...),
+		handler: handler,
+	}
+	atomic.AddUint64(&this.pending, 1)
+	select {
+	case this.worker(c) <- task:
+		return nil
+	case <-this.ctx.Done():
+		atomic.AddUint64(&this.pending, ^uint64(0))
+		this.release(c)
+		return ErrDispatcherClosed
+	}
+}
+
+// worker 根据客户端的唯一标识选择协程
+func (this *Dispatcher) worker(c *Client) chan *dispatchTask {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(c.ID()))
+	return this.workers[h.Sum32()%uint32(len(this.workers))]
+}
+
+// acquire 占用客户端的积压数量,已满则等待,客户端或分发关闭返回错误
+func (this *Dispatcher) acquire(c *Client) error {
+	this.mu.Lock()
+	for {
+		s, ok := this.clients[c]
+		if !ok {
+			s = &dispatchClient{}
+			this.clients[c] = s
+		}
+		if s.pending < this.backlog {
+			s.pending++
+			this.mu.Unlock()
+			return nil
+		}
+		if s.wait == nil {
+			s.wait = make(chan struct{})
+		}
+		wait := s.wait
+		this.mu.Unlock()
+		atomic.AddUint64(&this.blocked, 1)
+		select {
+		case <-wait:
+		case <-c.Done():
+			return c.Err()
+		case <-this.ctx.Done():
+			return ErrDispatcherClosed
+		}
+		this.mu.Lock()
+	}
+}
+
+// release 释放客户端的积压数量,没有积压时删除记录
+func (this *Dispatcher) release(c *Client) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	s, ok := this.clients[c]
+	if !ok {
+		return
+	}
+	s.pending--
+	if s.wait != nil {
+		close(s.wait)
+		s.wait = nil
+	}
+	if s.pending <= 0 {
+		delete(this.clients, c)
+	}
+}
+
+func (this *Dispatcher) run(ch chan *dispatchTask) {
+	for {
+		select {
+		case <-this.ctx.Done():
+			return
+		case task := <-ch:
+			atomic.AddUint64(&this.pending, ^uint64(0))
+			this.do(task)
+			this.release(task.c)
+		}
+	}
+}
+
+func (this *Dispatcher) do(task *dispatchTask) {
+	c := task.c
+	if c.Closed() {
+		atomic.AddUint64(&this.dropped, 1)
+		return
+	}
+	atomic.AddInt64(&this.running, 1)
+	defer func() {
+		atomic.AddInt64(&this.running, -1)
+		atomic.AddUint64(&this.done, 1)
+	}()
+	defer func() {
+		if !c.noRecover {
+			if e := recover(); e != nil {
+				_ = c.CloseWithErr(c.recoverPanic(PanicDeal, e))
+			}
+		}
+	}()
+	if err := task.handler(task.ctx, c, task.msg); err != nil {
+		_ = c.CloseWithErr(err)
+	}
+}
+
+//================================ClientManage================================
+
+// SetDispatcher 设置数据分发,所有客户端共享协程池
+func (this *ClientManage) SetDispatcher(d *Dispatcher) {
+	this.Use(d.Middleware())
+}
@@ -0,0 +1,135 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherOrder(t *testing.T) {
+	d := NewDispatcher(4, 10)
+	defer d.Close()
+	m := NewClientManage("test", NewLoggerWithNull())
+	m.SetOptions(func(c *Client) {
+		c.Debug(false)
+		c.SetReadFunc(func(buf *bufio.Reader) ([]byte, error) {
+			b, err := buf.ReadByte()
+			return []byte{b}, err
+		})
+	})
+	m.SetDispatcher(d)
+
+	var mu sync.Mutex
+	result := map[*Client][]byte{}
+	m.Handle(func(ctx context.Context, c *Client, msg Message) error {
+		time.Sleep(time.Microsecond * 100)
+		mu.Lock()
+		result[c] = append(result[c], msg...)
+		mu.Unlock()
+		return nil
+	})
+
+	var servers []*Client
+	for i := 0; i < 3; i++ {
+		a, b := newPipeClient(t)
+		servers = append(servers, b)
+		m.SetClient(b)
+		go func() {
+			for i := 0; i < 100; i++ {
+				a.Write([]byte{byte(i)})
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for d.Stats().Done < 300 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, c := range servers {
+		list := result[c]
+		if len(list) != 100 {
+			t.Fatalf("预期处理100条,得到(%d)", len(list))
+		}
+		for i, v := range list {
+			if int(v) != i {
+				t.Fatalf("处理顺序错误: %v", list)
+			}
+		}
+	}
+	if stats := d.Stats(); stats.Pending != 0 || stats.Clients != 0 {
+		t.Fatalf("统计错误: %+v", stats)
+	}
+}
+
+func TestDispatcherBackPressure(t *testing.T) {
+	d := NewDispatcher(1, 2)
+	defer d.Close()
+	c, _ := newPipeClient(t)
+	block := make(chan struct{})
+	handler := func(ctx context.Context, c *Client, msg Message) error {
+		<-block
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := d.Dispatch(context.Background(), c, Message("a"), handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//积压已满,阻塞
+	dispatched := make(chan error, 1)
+	go func() { dispatched <- d.Dispatch(context.Background(), c, Message("b"), handler) }()
+	select {
+	case <-dispatched:
+		t.Fatal("积压已满应该阻塞")
+	case <-time.After(time.Millisecond * 50):
+	}
+	if d.Stats().Blocked == 0 {
+		t.Fatal("预期背压次数")
+	}
+
+	//其他客户端不受影响
+	other, _ := newPipeClient(t)
+	if err := d.Dispatch(context.Background(), other, Message("c"), func(ctx context.Context, c *Client, msg Message) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	close(block)
+	select {
+	case err := <-dispatched:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("处理之后应该解除阻塞")
+	}
+}
+
+func TestDispatcherError(t *testing.T) {
+	d := NewDispatcher(2, 2)
+	defer d.Close()
+	errTest := errors.New("test")
+	c, _ := newPipeClient(t)
+	d.Dispatch(context.Background(), c, Message("a"), func(ctx context.Context, c *Client, msg Message) error {
+		return errTest
+	})
+	select {
+	case <-c.Done():
+		if !errors.Is(c.Err(), errTest) {
+			t.Fatalf("预期错误(%v),得到(%v)", errTest, c.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("返回错误应该关闭客户端")
+	}
+
+	d.Close()
+	c2, _ := newPipeClient(t)
+	if err := d.Dispatch(context.Background(), c2, Message("a"), nil); err != ErrDispatcherClosed {
+		t.Fatalf("预期错误(%v),得到(%v)", ErrDispatcherClosed, err)
+	}
+}
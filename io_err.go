@@ -1,9 +1,14 @@
 package io
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net"
+	"os"
 	"strings"
+	"sync/atomic"
+	"syscall"
 )
 
 var (
@@ -12,26 +17,273 @@ var (
 )
 
 var (
-	ErrHandClose          = errors.New("主动关闭")
-	ErrRemoteClose        = errors.New("远程端主动关闭连接")
-	ErrRemoteCloseUnusual = errors.New("远程端意外关闭连接")
-	ErrCloseClose         = errors.New("关闭已关闭连接")
-	ErrWriteClosed        = errors.New("写入已关闭连接")
-	ErrReadClosed         = errors.New("读取已关闭连接")
-	ErrPortNull           = errors.New("端口不足或绑定未释放")
-	ErrRemoteOff          = errors.New("远程服务可能未开启")
-	ErrNetworkUnusual     = errors.New("网络异常")
-	ErrWithContext        = errors.New("上下文关闭")
-	ErrWithTimeout        = errors.New("超时")
-	ErrWithConnectTimeout = errors.New("连接超时")
-	ErrWithReadTimeout    = errors.New("读超时")
-	ErrWithWriteTimeout   = errors.New("写超时")
-	ErrInvalidReadFunc    = errors.New("无效数据读取函数")
-	ErrMaxConnect         = errors.New("到达最大连接数")
-	ErrUseReadMessage     = errors.New("不支持,请使用ReadMessage")
-	ErrUseReadAck         = errors.New("不支持,请使用ReadAck")
+	ErrHandClose          = newError(ErrorKindClosed, "hand_close")
+	ErrRemoteClose        = newError(ErrorKindRemoteClosed, "remote_close")
+	ErrRemoteCloseUnusual = newError(ErrorKindReset, "remote_close_unusual")
+	ErrCloseClose         = newError(ErrorKindClosed, "close_closed")
+	ErrWriteClosed        = newError(ErrorKindClosed, "write_closed")
+	ErrReadClosed         = newError(ErrorKindClosed, "read_closed")
+	ErrPortNull           = newError(ErrorKindResource, "port_null")
+	ErrRemoteOff          = newError(ErrorKindRefused, "remote_off")
+	ErrNetworkUnusual     = newError(ErrorKindNetwork, "network_unusual")
+	ErrWithContext        = newError(ErrorKindClosed, "context")
+	ErrWithTimeout        = newError(ErrorKindTimeout, "timeout")
+	ErrWithConnectTimeout = newError(ErrorKindTimeout, "connect_timeout")
+	ErrWithReadTimeout    = newError(ErrorKindTimeout, "read_timeout")
+	ErrWithWriteTimeout   = newError(ErrorKindTimeout, "write_timeout")
+	ErrWithHeartbeat      = newError(ErrorKindTimeout, "heartbeat_timeout")
+	ErrSessionBufferFull  = newError(ErrorKindResource, "session_buffer_full")
+	ErrSessionExpired     = newError(ErrorKindClosed, "session_expired")
+	ErrInvalidReadFunc    = newError(ErrorKindInvalid, "invalid_read_func")
+	ErrMaxConnect         = newError(ErrorKindResource, "max_connect")
+	ErrUseReadMessage     = newError(ErrorKindInvalid, "use_read_message")
+	ErrUseReadAck         = newError(ErrorKindInvalid, "use_read_ack")
+	ErrDispatcherClosed   = newError(ErrorKindClosed, "dispatcher_closed")
+	ErrPollUnsupported    = newError(ErrorKindInvalid, "poll_unsupported")
+	ErrPollBufferFull     = newError(ErrorKindResource, "poll_buffer_full")
+	ErrPollClosed         = newError(ErrorKindClosed, "poll_closed")
+	ErrMuxReset           = newError(ErrorKindReset, "mux_reset")
+	ErrMuxFrame           = newError(ErrorKindProtocol, "mux_frame")
+	ErrMuxBacklogFull     = newError(ErrorKindResource, "mux_backlog_full")
+	ErrCipherHandshake    = newError(ErrorKindProtocol, "cipher_handshake")
+	ErrCipherAuth         = newError(ErrorKindProtocol, "cipher_auth")
 )
 
+//================================Kind================================
+
+// ErrorKind 错误分类
+type ErrorKind uint8
+
+const (
+	ErrorKindUnknown      ErrorKind = iota //未知
+	ErrorKindRemoteClosed                  //远程端正常关闭
+	ErrorKindRefused                       //连接被拒绝
+	ErrorKindTimeout                       //超时
+	ErrorKindReset                         //连接被重置,远程端意外关闭
+	ErrorKindClosed                        //主动关闭或已关闭
+	ErrorKindProtocol                      //协议错误,例如数据解析失败
+	ErrorKindNetwork                       //网络不可达
+	ErrorKindResource                      //资源不足,例如端口,缓存
+	ErrorKindInvalid                       //使用错误,例如未设置读取函数
+	ErrorKindPanic                         //用户函数panic
+)
+
+func (this ErrorKind) String() string {
+	switch this {
+	case ErrorKindRemoteClosed:
+		return "remote_closed"
+	case ErrorKindRefused:
+		return "refused"
+	case ErrorKindTimeout:
+		return "timeout"
+	case ErrorKindReset:
+		return "reset"
+	case ErrorKindClosed:
+		return "closed"
+	case ErrorKindProtocol:
+		return "protocol"
+	case ErrorKindNetwork:
+		return "network"
+	case ErrorKindResource:
+		return "resource"
+	case ErrorKindInvalid:
+		return "invalid"
+	case ErrorKindPanic:
+		return "panic"
+	}
+	return "unknown"
+}
+
+//================================Language================================
+
+type Language string
+
+const (
+	LanguageZH Language = "zh" //中文
+	LanguageEN Language = "en" //英文
+)
+
+var language atomic.Value
+
+// SetLanguage 设置错误信息的语言,默认中文,需要在Messages中存在
+func SetLanguage(l Language) {
+	language.Store(l)
+}
+
+// GetLanguage 获取错误信息的语言
+func GetLanguage() Language {
+	if l, ok := language.Load().(Language); ok {
+		return l
+	}
+	return LanguageZH
+}
+
+// Messages 错误信息语言包,key为错误编号或者错误分类名称,可以自行添加语言
+var Messages = map[Language]map[string]string{
+	LanguageZH: {
+		"hand_close":           "主动关闭",
+		"remote_close":         "远程端主动关闭连接",
+		"remote_close_unusual": "远程端意外关闭连接",
+		"close_closed":         "关闭已关闭连接",
+		"write_closed":         "写入已关闭连接",
+		"read_closed":          "读取已关闭连接",
+		"port_null":            "端口不足或绑定未释放",
+		"remote_off":           "远程服务可能未开启",
+		"network_unusual":      "网络异常",
+		"context":              "上下文关闭",
+		"timeout":              "超时",
+		"connect_timeout":      "连接超时",
+		"read_timeout":         "读超时",
+		"write_timeout":        "写超时",
+		"heartbeat_timeout":    "心跳超时",
+		"session_buffer_full":  "会话缓存已满",
+		"session_expired":      "会话已过期",
+		"invalid_read_func":    "无效数据读取函数",
+		"max_connect":          "到达最大连接数",
+		"use_read_message":     "不支持,请使用ReadMessage",
+		"use_read_ack":         "不支持,请使用ReadAck",
+		"dispatcher_closed":    "数据分发已关闭",
+		"poll_unsupported":     "不支持epoll模式",
+		"poll_buffer_full":     "epoll缓存已满",
+		"poll_closed":          "epoll已关闭",
+		"mux_reset":            "流被重置",
+		"mux_frame":            "无效的多路复用帧",
+		"mux_backlog_full":     "等待接收的流已满",
+		"cipher_handshake":     "密钥交换失败",
+		"cipher_auth":          "数据校验失败,可能被篡改或重放",
+
+		"unknown":       "未知错误",
+		"remote_closed": "远程端关闭",
+		"refused":       "连接被拒绝",
+		"reset":         "连接被重置",
+		"closed":        "连接已关闭",
+		"protocol":      "协议错误",
+		"network":       "网络错误",
+		"resource":      "资源不足",
+		"invalid":       "使用错误",
+		"panic":         "程序异常",
+	},
+	LanguageEN: {
+		"hand_close":           "closed by user",
+		"remote_close":         "connection closed by remote",
+		"remote_close_unusual": "connection reset by remote",
+		"close_closed":         "close of closed connection",
+		"write_closed":         "write to closed connection",
+		"read_closed":          "read from closed connection",
+		"port_null":            "no available port or address already in use",
+		"remote_off":           "connection refused, remote service may be down",
+		"network_unusual":      "network unreachable",
+		"context":              "context closed",
+		"timeout":              "timeout",
+		"connect_timeout":      "connect timeout",
+		"read_timeout":         "read timeout",
+		"write_timeout":        "write timeout",
+		"heartbeat_timeout":    "heartbeat timeout",
+		"session_buffer_full":  "session buffer full",
+		"session_expired":      "session expired",
+		"invalid_read_func":    "invalid read function",
+		"max_connect":          "max connections reached",
+		"use_read_message":     "not supported, use ReadMessage",
+		"use_read_ack":         "not supported, use ReadAck",
+		"dispatcher_closed":    "dispatcher closed",
+		"poll_unsupported":     "poll mode unsupported",
+		"poll_buffer_full":     "poll buffer full",
+		"poll_closed":          "poll closed",
+		"mux_reset":            "stream reset",
+		"mux_frame":            "invalid mux frame",
+		"mux_backlog_full":     "stream accept backlog full",
+		"cipher_handshake":     "cipher handshake failed",
+		"cipher_auth":          "message authentication failed, tampered or replayed",
+
+		"unknown":       "unknown error",
+		"remote_closed": "closed by remote",
+		"refused":       "connection refused",
+		"reset":         "connection reset",
+		"closed":        "connection closed",
+		"protocol":      "protocol error",
+		"network":       "network error",
+		"resource":      "resource exhausted",
+		"invalid":       "invalid usage",
+		"panic":         "panic",
+	},
+}
+
+func message(code string) string {
+	if msg, ok := Messages[GetLanguage()][code]; ok {
+		return msg
+	}
+	return Messages[LanguageZH][code]
+}
+
+//================================Error================================
+
+func newError(kind ErrorKind, code string) *Error {
+	return &Error{Kind: kind, Code: code}
+}
+
+// NewError 新建分类错误,包装原始错误
+func NewError(kind ErrorKind, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// Error 分类错误,错误信息根据语言包显示,原始错误可以通过errors.Unwrap获取
+type Error struct {
+	Kind ErrorKind //错误分类
+	Code string    //错误编号,对应语言包,为空则使用原始错误的信息
+	Err  error     //原始错误
+}
+
+func (this *Error) Error() string {
+	switch {
+	case len(this.Code) > 0:
+		return message(this.Code)
+	case this.Err != nil:
+		return this.Err.Error()
+	}
+	return message(this.Kind.String())
+}
+
+func (this *Error) Unwrap() error {
+	return this.Err
+}
+
+// Is 错误编号相同,或者没有编号时错误分类相同
+func (this *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	if len(t.Code) > 0 {
+		return t.Code == this.Code
+	}
+	return t.Err == nil && t.Kind == this.Kind
+}
+
+// wrap 包装原始错误,保留错误编号
+func (this *Error) wrap(err error) *Error {
+	return &Error{Kind: this.Kind, Code: this.Code, Err: err}
+}
+
+// ErrorKindOf 获取错误的分类
+func ErrorKindOf(err error) ErrorKind {
+	if err == nil {
+		return ErrorKindUnknown
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	if e := classify(err); e != nil {
+		return e.Kind
+	}
+	return ErrorKindUnknown
+}
+
+// IsErrorKind 判断错误是否是该分类
+func IsErrorKind(err error, kind ErrorKind) bool {
+	return err != nil && ErrorKindOf(err) == kind
+}
+
 // 错误处理 错误信息处理
 func dealErr(err error) error {
 	if DealErr == nil {
@@ -40,39 +292,84 @@ func dealErr(err error) error {
 	return DealErr(err)
 }
 
-// defaultDealErr 错误处理,常见整理成中文
+// defaultDealErr 错误处理,常见错误进行分类,并包装原始错误
+// 已经是*Error的错误(例如ErrHandClose)原样返回,可以继续用==判断,
+// 分类后的错误是新的*Error(包装了原始错误),和预定义错误(例如ErrRemoteOff)需要用errors.Is判断,
+// io.EOF原样返回,可以通过ErrorKindOf获取分类(ErrorKindRemoteClosed)
 func defaultDealErr(err error) error {
-	if err != nil {
-		s := err.Error()
-		switch {
-		case err == io.EOF:
+	if err == nil || err == io.EOF {
+		return err
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if e := classify(err); e != nil {
+		return e.wrap(err)
+	}
+	return err
+}
 
-		case strings.Contains(s, "An existing connection was forcibly closed by the remote host"):
-			return ErrRemoteCloseUnusual
+// classify 对原始错误进行分类,未知错误返回nil
+func classify(err error) *Error {
+	switch {
+	case err == io.EOF || errors.Is(err, io.EOF):
+		return ErrRemoteClose
 
-		case strings.Contains(s, "use of closed network connection"):
-			switch {
-			case strings.Contains(s, "close tcp"):
+	case errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed):
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			switch opErr.Op {
+			case "close":
 				return ErrCloseClose
-
-			case strings.Contains(s, "write tcp"):
+			case "write":
 				return ErrWriteClosed
-
-			case strings.Contains(s, "read tcp"):
+			case "read":
 				return ErrReadClosed
-
 			}
+		}
+		return ErrCloseClose
 
-		case strings.Contains(s, "bind: An operation on a socket could not be performed because the system lacked sufficient buffer space or because a queue was full"):
-			return ErrPortNull
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrRemoteOff
 
-		case strings.Contains(s, "connectex: No connection could be made because the target machine actively refused it."):
-			return ErrRemoteOff
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE):
+		return ErrRemoteCloseUnusual
 
-		case strings.Contains(s, "A socket operation was attempted to an unreachable network"):
-			return ErrNetworkUnusual
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return ErrNetworkUnusual
+
+	case errors.Is(err, syscall.EADDRINUSE), errors.Is(err, syscall.EADDRNOTAVAIL), errors.Is(err, syscall.ENOBUFS):
+		return ErrPortNull
+
+	case errors.Is(err, context.Canceled):
+		return ErrWithContext
+
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrWithTimeout
 
-		}
 	}
-	return err
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrWithTimeout
+	}
+
+	//兼容windows的错误信息
+	s := err.Error()
+	switch {
+	case strings.Contains(s, "An existing connection was forcibly closed by the remote host"):
+		return ErrRemoteCloseUnusual
+
+	case strings.Contains(s, "bind: An operation on a socket could not be performed because the system lacked sufficient buffer space or because a queue was full"):
+		return ErrPortNull
+
+	case strings.Contains(s, "No connection could be made because the target machine actively refused it"):
+		return ErrRemoteOff
+
+	case strings.Contains(s, "A socket operation was attempted to an unreachable network"):
+		return ErrNetworkUnusual
+
+	}
+	return nil
 }
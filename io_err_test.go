@@ -0,0 +1,107 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDealErr(t *testing.T) {
+	//获取一个未监听的端口
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = net.Dial("tcp", addr)
+	if err == nil {
+		t.Skip("端口已被占用")
+	}
+
+	for _, v := range []struct {
+		err  error
+		kind ErrorKind
+		is   error
+	}{
+		{err, ErrorKindRefused, ErrRemoteOff},
+		{EOF, ErrorKindRemoteClosed, EOF},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, ErrorKindReset, ErrRemoteCloseUnusual},
+		{&net.OpError{Op: "write", Err: net.ErrClosed}, ErrorKindClosed, ErrWriteClosed},
+		{fmt.Errorf("wrap: %w", syscall.ENETUNREACH), ErrorKindNetwork, ErrNetworkUnusual},
+		{DecodePkgErr(), ErrorKindProtocol, &Error{Kind: ErrorKindProtocol}},
+	} {
+		e := dealErr(v.err)
+		if ErrorKindOf(e) != v.kind {
+			t.Errorf("%v: 预期分类(%s),得到(%s)", v.err, v.kind, ErrorKindOf(e))
+		}
+		if !errors.Is(e, v.is) {
+			t.Errorf("%v: 预期(%v)", v.err, v.is)
+		}
+		//保留原始错误
+		if !errors.Is(e, v.err) {
+			t.Errorf("%v: 未保留原始错误", v.err)
+		}
+	}
+	if errors.Is(dealErr(ErrRemoteClose), ErrRemoteOff) {
+		t.Error("错误编号不同,不应该相等")
+	}
+
+	//预定义错误和EOF原样返回,可以用==判断
+	for _, err := range []error{ErrHandClose, ErrWithHeartbeat, EOF} {
+		if dealErr(err) != err {
+			t.Errorf("预期(%v)原样返回,得到(%#v)", err, dealErr(err))
+		}
+	}
+}
+
+func TestCloseAllWithErr(t *testing.T) {
+	c := NewClient(NewReadWriteCloser(nil, nil, nil), func(c *Client) { c.Debug(false) })
+	c.CloseAllWithErr(errors.New("other"))
+	if c.Err() != ErrHandClose {
+		t.Errorf("预期(%v),得到(%v)", ErrHandClose, c.Err())
+	}
+}
+
+func DecodePkgErr() error {
+	_, err := DecodePkg([]byte{0x01})
+	return err
+}
+
+func TestErrorLanguage(t *testing.T) {
+	defer SetLanguage(LanguageZH)
+	if ErrRemoteOff.Error() != "远程服务可能未开启" {
+		t.Errorf("中文错误信息错误: %s", ErrRemoteOff)
+	}
+	SetLanguage(LanguageEN)
+	if ErrRemoteOff.Error() != "connection refused, remote service may be down" {
+		t.Errorf("英文错误信息错误: %s", ErrRemoteOff)
+	}
+	if e := NewError(ErrorKindTimeout, nil); e.Error() != "timeout" {
+		t.Errorf("英文错误信息错误: %s", e)
+	}
+}
+
+func TestDisconnectKind(t *testing.T) {
+	a, b := newPipeClient(t)
+	kind := make(chan ErrorKind, 1)
+	a.OnDisconnect(func(ctx context.Context, c *Client, err error) { kind <- ErrorKindOf(err) })
+	go a.Run()
+	go b.Run()
+	b.CloseAll()
+	select {
+	case k := <-kind:
+		if k != ErrorKindRemoteClosed {
+			t.Errorf("预期(%s),得到(%s)", ErrorKindRemoteClosed, k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未断开连接")
+	}
+	if !IsErrorKind(b.Err(), ErrorKindClosed) {
+		t.Errorf("预期(%s),得到(%v)", ErrorKindClosed, b.Err())
+	}
+}
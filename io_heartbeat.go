@@ -0,0 +1,287 @@
+package io
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+type HeartbeatMode uint8
+
+const (
+	HeartbeatActive  HeartbeatMode = iota //主动模式,定时发送ping,等待pong,例如服务端发起
+	HeartbeatPassive                      //被动模式,等待对方的ping,响应pong,例如客户端发起
+)
+
+// NewHeartbeat 新建心跳配置,使用自定义的ping/pong数据,没有帧级别的封装
+// ping/pong需要和业务数据区分,相同内容的业务数据会被当作心跳,不会交给处理函数
+// ping或pong为空时返回nil,即不启用心跳
+func NewHeartbeat(mode HeartbeatMode, interval time.Duration, ping, pong []byte) *Heartbeat {
+	if len(ping) == 0 || len(pong) == 0 {
+		return nil
+	}
+	ping = append([]byte(nil), ping...)
+	pong = append([]byte(nil), pong...)
+	return &Heartbeat{
+		Mode:     mode,
+		Interval: interval,
+		Ping:     func() []byte { return append([]byte(nil), ping...) },
+		Pong:     func(Message) []byte { return append([]byte(nil), pong...) },
+		IsPing:   func(msg Message) bool { return bytes.Equal(msg, ping) },
+		IsPong:   func(msg Message) bool { return bytes.Equal(msg, pong) },
+	}
+}
+
+// NewHeartbeatWithPkg 新建心跳配置,使用通用封装包,需要配合SetReadWriteWithPkg使用
+// ping/pong是完整的封装包,直接写入,不经过写入函数(WriteWithPkg)再次封装
+func NewHeartbeatWithPkg(mode HeartbeatMode, interval time.Duration) *Heartbeat {
+	return &Heartbeat{
+		Mode:     mode,
+		Interval: interval,
+		Frame:    true,
+		Ping:     NewPkgPing,
+		Pong:     func(ping Message) []byte { return NewPkgPong() },
+		IsPing: func(msg Message) bool {
+			p, err := DecodePkg(msg)
+			return err == nil && p.IsPing()
+		},
+		IsPong: func(msg Message) bool {
+			p, err := DecodePkg(msg)
+			return err == nil && p.IsPong()
+		},
+	}
+}
+
+// NewHeartbeatWithSimple 新建心跳配置,使用简易封装包,需要配合SetReadWriteWithSimple使用
+func NewHeartbeatWithSimple(mode HeartbeatMode, interval time.Duration) *Heartbeat {
+	return &Heartbeat{
+		Mode:     mode,
+		Interval: interval,
+		Ping:     func() []byte { return NewSimplePing().Bytes() },
+		Pong: func(ping Message) []byte {
+			p, err := DecodeSimple(ping)
+			if err != nil {
+				p = NewSimplePing()
+			}
+			return p.Resp(nil).Bytes()
+		},
+		IsPing: func(msg Message) bool {
+			p, err := DecodeSimple(msg)
+			return err == nil && p.Control.Type == OprPing && !p.Control.IsResponse
+		},
+		IsPong: func(msg Message) bool {
+			p, err := DecodeSimple(msg)
+			return err == nil && p.Control.Type == OprPing && p.Control.IsResponse
+		},
+	}
+}
+
+/*
+Heartbeat 心跳配置
+主动模式: 每个间隔发送一次ping,未收到pong则记一次丢失,收到pong时计算RTT
+被动模式: 每个间隔内需要收到对方的ping,未收到则记一次丢失
+连续丢失达到MaxMiss次则关闭连接,心跳数据不会交给用户的处理函数
+*/
+type Heartbeat struct {
+	Mode     HeartbeatMode             //模式,主动或被动
+	Interval time.Duration             //心跳间隔
+	MaxMiss  int                       //最大连续丢失次数,默认3
+	Ping     func() []byte             //生成ping数据
+	Pong     func(ping Message) []byte //根据ping生成pong数据
+	IsPing   func(msg Message) bool    //判断是否是ping
+	IsPong   func(msg Message) bool    //判断是否是pong
+
+	//ping/pong是完整的数据帧,写入时不经过写入函数和会话,
+	//读取时按读取函数解析出的帧判断(Framer),没有数据的控制帧也能识别
+	Frame bool
+}
+
+func (this *Heartbeat) isPing(msg Message) bool {
+	return this.IsPing != nil && this.IsPing(msg)
+}
+
+func (this *Heartbeat) isPong(msg Message) bool {
+	return this.IsPong != nil && this.IsPong(msg)
+}
+
+func (this *Heartbeat) maxMiss() int {
+	if this.MaxMiss <= 0 {
+		return 3
+	}
+	return this.MaxMiss
+}
+
+// heartbeat 单个客户端的心跳状态
+type heartbeat struct {
+	*Heartbeat
+	mu       sync.Mutex
+	sendTime time.Time     //最后发送ping的时间,收到pong后清空
+	beat     bool          //被动模式,本次间隔内是否收到ping
+	miss     int           //连续丢失次数
+	rtt      time.Duration //最近一次往返时间
+}
+
+// tick 定时检查,返回需要发送的ping数据
+func (this *heartbeat) tick() ([]byte, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	switch this.Mode {
+	case HeartbeatPassive:
+		if this.beat {
+			this.miss = 0
+		} else {
+			this.miss++
+		}
+		this.beat = false
+		if this.miss >= this.maxMiss() {
+			return nil, ErrWithHeartbeat
+		}
+		return nil, nil
+	default:
+		if !this.sendTime.IsZero() {
+			this.miss++
+		}
+		if this.miss >= this.maxMiss() {
+			return nil, ErrWithHeartbeat
+		}
+		this.sendTime = time.Now()
+		if this.Ping == nil {
+			return nil, nil
+		}
+		return this.Ping(), nil
+	}
+}
+
+//================================Client================================
+
+// SetHeartbeat 设置心跳,需要在Run之前设置,nil表示取消心跳
+func (this *Client) SetHeartbeat(h *Heartbeat) *Client {
+	if h == nil {
+		this.heartbeat = nil
+		return this
+	}
+	this.heartbeat = &heartbeat{Heartbeat: h}
+	return this
+}
+
+// OnHeartbeat 心跳事件,收到心跳时触发,rtt为最近一次往返时间(主动模式有效)
+func (this *Client) OnHeartbeat(f func(c *Client, rtt time.Duration)) *Client {
+	this.heartbeatFunc = append(this.heartbeatFunc, f)
+	return this
+}
+
+// RTT 最近一次心跳往返时间
+func (this *Client) RTT() time.Duration {
+	if this.heartbeat == nil {
+		return 0
+	}
+	this.heartbeat.mu.Lock()
+	defer this.heartbeat.mu.Unlock()
+	return this.heartbeat.rtt
+}
+
+// HeartbeatMiss 连续丢失心跳的次数
+func (this *Client) HeartbeatMiss() int {
+	if this.heartbeat == nil {
+		return 0
+	}
+	this.heartbeat.mu.Lock()
+	defer this.heartbeat.mu.Unlock()
+	return this.heartbeat.miss
+}
+
+// runHeartbeat 运行心跳定时器,生命周期(单次连接)
+func (this *Client) runHeartbeat() {
+	h := this.heartbeat
+	if h == nil || h.Interval <= 0 {
+		return
+	}
+	this.Timer(h.Interval, func() error {
+		ping, err := h.tick()
+		if err != nil {
+			return err
+		}
+		if len(ping) > 0 {
+			_, err = this.writeHeartbeat(ping)
+		}
+		return err
+	})
+}
+
+// writeHeartbeat 写入心跳数据,数据帧直接写入,否则和普通数据一样封装
+func (this *Client) writeHeartbeat(p []byte) (int, error) {
+	if this.heartbeatFrame() {
+		if s := this.getSession(); s != nil {
+			s.wmu.Lock()
+			defer s.wmu.Unlock()
+		}
+		return this.writeWith(p, false)
+	}
+	return this.writeRaw(p)
+}
+
+// heartbeatFrame 是否在帧层面处理心跳
+func (this *Client) heartbeatFrame() bool {
+	return this.heartbeat != nil && this.heartbeat.Frame
+}
+
+// dealHeartbeatFrame 在帧层面处理心跳,优先使用读取函数提供的完整帧
+func (this *Client) dealHeartbeatFrame(ack Acker) bool {
+	if !this.heartbeatFrame() {
+		return false
+	}
+	msg := Message(ack.Payload())
+	if f, ok := ack.(Framer); ok && f.Frame() != nil {
+		msg = f.Frame()
+	}
+	return len(msg) > 0 && this.dealHeartbeat(msg)
+}
+
+// dealHeartbeat 处理心跳数据,返回true表示是心跳数据,不需要后续处理
+func (this *Client) dealHeartbeat(msg Message) bool {
+	h := this.heartbeat
+	if h == nil {
+		return false
+	}
+	switch {
+	case h.isPing(msg):
+		h.mu.Lock()
+		h.beat = true
+		h.miss = 0
+		rtt := h.rtt
+		h.mu.Unlock()
+		if h.Pong != nil {
+			if _, err := this.writeHeartbeat(h.Pong(msg)); err != nil {
+				return true
+			}
+		}
+		for _, f := range this.heartbeatFunc {
+			f(this, rtt)
+		}
+		return true
+
+	case h.isPong(msg):
+		h.mu.Lock()
+		if !h.sendTime.IsZero() {
+			h.rtt = time.Since(h.sendTime)
+			h.sendTime = time.Time{}
+		}
+		h.beat = true
+		h.miss = 0
+		rtt := h.rtt
+		h.mu.Unlock()
+		for _, f := range this.heartbeatFunc {
+			f(this, rtt)
+		}
+		return true
+
+	}
+	return false
+}
+
+//================================ClientManage================================
+
+// SetHeartbeat 设置客户端心跳
+func (this *ClientManage) SetHeartbeat(h *Heartbeat) {
+	this.SetOptions(func(c *Client) { c.SetHeartbeat(h) })
+}
@@ -0,0 +1,200 @@
+package io
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newPipeClient(t *testing.T) (*Client, *Client) {
+	c1, c2 := net.Pipe()
+	a := NewClient(c1, func(c *Client) { c.Debug(false) })
+	b := NewClient(c2, func(c *Client) { c.Debug(false) })
+	t.Cleanup(func() {
+		a.CloseAll()
+		b.CloseAll()
+	})
+	return a, b
+}
+
+func newTestHeartbeat(mode HeartbeatMode, interval time.Duration) *Heartbeat {
+	return NewHeartbeat(mode, interval, []byte{0xFF, 'p', 'i', 'n', 'g'}, []byte{0xFF, 'p', 'o', 'n', 'g'})
+}
+
+func TestHeartbeat(t *testing.T) {
+	for name, v := range map[string]struct {
+		fn     func(HeartbeatMode, time.Duration) *Heartbeat
+		option OptionClient
+	}{
+		"string": {newTestHeartbeat, func(c *Client) {}},
+		"pkg":    {NewHeartbeatWithPkg, func(c *Client) { c.SetReadWriteWithPkg() }},
+		"simple": {NewHeartbeatWithSimple, func(c *Client) {}},
+	} {
+		fn := v.fn
+		option := v.option
+		t.Run(name, func(t *testing.T) {
+			a, b := newPipeClient(t)
+			a.SetOptions(option)
+			b.SetOptions(option)
+			received := make(chan Message, 10)
+			a.SetHeartbeat(fn(HeartbeatActive, time.Millisecond*20))
+			b.SetHeartbeat(fn(HeartbeatPassive, 0))
+			b.SetDealFunc(func(c *Client, msg Message) { received <- msg })
+			beat := make(chan time.Duration, 10)
+			a.OnHeartbeat(func(c *Client, rtt time.Duration) {
+				select {
+				case beat <- rtt:
+				default:
+				}
+			})
+			go a.Run()
+			go b.Run()
+
+			select {
+			case rtt := <-beat:
+				if rtt <= 0 {
+					t.Errorf("预期RTT大于0,得到(%v)", rtt)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("未收到心跳响应")
+			}
+			select {
+			case msg := <-received:
+				t.Errorf("心跳数据未被过滤: %x", msg)
+			default:
+			}
+			if a.RTT() <= 0 || a.HeartbeatMiss() != 0 {
+				t.Errorf("心跳状态错误,RTT(%v),丢失(%d)", a.RTT(), a.HeartbeatMiss())
+			}
+		})
+	}
+}
+
+// TestHeartbeatWithPkg 通用封装包的心跳直接写入,不会重复封装,对端的ping/pong没有数据也能识别
+func TestHeartbeatWithPkg(t *testing.T) {
+	newClient := func(mode HeartbeatMode, interval time.Duration) (*Client, net.Conn, chan Message, chan struct{}) {
+		c1, c2 := net.Pipe()
+		c := NewClient(c1, func(c *Client) {
+			c.Debug(false)
+			c.SetReadWriteWithPkg()
+			c.SetHeartbeat(NewHeartbeatWithPkg(mode, interval))
+		})
+		t.Cleanup(func() {
+			c.CloseAll()
+			c2.Close()
+		})
+		received := make(chan Message, 10)
+		c.SetDealFunc(func(c *Client, msg Message) { received <- msg })
+		beat := make(chan struct{}, 10)
+		c.OnHeartbeat(func(c *Client, rtt time.Duration) { beat <- struct{}{} })
+		go c.Run()
+		return c, c2, received, beat
+	}
+	read := func(conn net.Conn, want []byte) {
+		got := make([]byte, len(want))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("预期(%x),得到(%x)", want, got)
+		}
+	}
+
+	//主动模式,发送的ping不会重复封装,能识别对端的pong
+	_, conn, _, beat := newClient(HeartbeatActive, time.Millisecond*20)
+	read(conn, NewPkgPing())
+	conn.Write(NewPkgPong())
+	select {
+	case <-beat:
+	case <-time.After(time.Second):
+		t.Fatal("未识别pong")
+	}
+
+	//被动模式,对端的ping响应pong
+	_, conn, received, beat := newClient(HeartbeatPassive, 0)
+	conn.Write(NewPkgPing())
+	read(conn, NewPkgPong())
+	select {
+	case <-beat:
+	case <-time.After(time.Second):
+		t.Fatal("未识别ping")
+	}
+
+	//数据是ping字符串的普通数据,交给dealFunc,不响应pong
+	conn.Write(NewPkg(0, []byte(Ping)).Bytes())
+	select {
+	case msg := <-received:
+		if msg.String() != Ping {
+			t.Fatalf("预期(%s),得到(%s)", Ping, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到数据")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+	if n, _ := conn.Read(make([]byte, 64)); n > 0 {
+		t.Fatal("普通数据ping不应响应")
+	}
+}
+
+func TestHeartbeatMiss(t *testing.T) {
+	a, b := newPipeClient(t)
+	h := newTestHeartbeat(HeartbeatActive, time.Millisecond*10)
+	h.MaxMiss = 2
+	a.SetHeartbeat(h)
+	//对端不响应pong
+	b.SetDealWithNil()
+	go a.Run()
+	go b.Run()
+	select {
+	case <-a.Done():
+		if a.Err() != ErrWithHeartbeat {
+			t.Errorf("预期(%v),得到(%v)", ErrWithHeartbeat, a.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("心跳丢失未关闭连接")
+	}
+}
+
+func TestHeartbeatPassive(t *testing.T) {
+	a, b := newPipeClient(t)
+	h := newTestHeartbeat(HeartbeatPassive, time.Millisecond*10)
+	h.MaxMiss = 2
+	a.SetHeartbeat(h)
+	go a.Run()
+	go b.Run()
+	select {
+	case <-a.Done():
+		if a.Err() != ErrWithHeartbeat {
+			t.Errorf("预期(%v),得到(%v)", ErrWithHeartbeat, a.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到ping未关闭连接")
+	}
+}
+
+// TestHeartbeatUserData 自定义心跳数据时,内容是ping的业务数据交给dealFunc
+func TestHeartbeatUserData(t *testing.T) {
+	if NewHeartbeat(HeartbeatActive, time.Second, nil, []byte(Pong)) != nil {
+		t.Fatal("心跳数据为空时应不启用心跳")
+	}
+	a, b := newPipeClient(t)
+	b.SetHeartbeat(newTestHeartbeat(HeartbeatPassive, 0))
+	received := make(chan Message, 10)
+	b.SetDealFunc(func(c *Client, msg Message) { received <- msg })
+	go a.Run()
+	go b.Run()
+	if _, err := a.Write([]byte(Ping)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.String() != Ping {
+			t.Fatalf("预期(%s),得到(%s)", Ping, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("业务数据被当作心跳")
+	}
+}
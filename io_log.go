@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/injoyai/logs"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -39,6 +40,25 @@ func (this Level) Name() string {
 	return ""
 }
 
+// String 英文名称,用于结构化日志
+func (this Level) String() string {
+	switch this {
+	case LevelAll:
+		return "all"
+	case LevelWrite:
+		return "write"
+	case LevelRead:
+		return "read"
+	case LevelInfo:
+		return "info"
+	case LevelError:
+		return "error"
+	case LevelNone:
+		return "none"
+	}
+	return fmt.Sprintf("level(%d)", int(this))
+}
+
 func defaultLogger() *logger {
 	return NewLogger(stdoutLogger)
 }
@@ -68,6 +88,7 @@ type logger struct {
 	debug  bool                  //是否打印调试
 	level  Level                 //日志等级
 	coding func(p []byte) string //编码
+	field  FieldLogger           //结构化日志,设置后优先使用
 }
 
 func (this *logger) Debug(b ...bool) {
@@ -78,6 +99,24 @@ func (this *logger) SetLevel(level Level) {
 	this.level = level
 }
 
+// SetFieldLogger 设置结构化日志,设置后日志以字段的形式输出,nil表示取消
+func (this *logger) SetFieldLogger(l FieldLogger) {
+	this.field = l
+}
+
+// enable 是否打印该等级的日志
+func (this *logger) enable(level Level) bool {
+	return this.debug && level >= this.level
+}
+
+// encode 按设置的编码方式编码字节
+func (this *logger) encode(p []byte) string {
+	if this.coding == nil {
+		this.SetPrintWithUTF8()
+	}
+	return this.coding(p)
+}
+
 func (this *logger) SetLevelAll() {
 	this.SetLevel(LevelAll)
 }
@@ -111,38 +150,55 @@ func (this *logger) SetPrintWithBase64() {
 
 // Readln 打印读取到的数据
 func (this *logger) Readln(prefix string, p []byte) {
-	if this.debug && LevelRead >= this.level {
-		if this.coding == nil {
-			this.SetPrintWithUTF8()
+	if this.enable(LevelRead) {
+		if this.field != nil {
+			this.fieldData(LevelRead, prefix, p)
+			return
 		}
-		this.Logger.Readf("%s%s\n", prefix, this.coding(p))
+		this.Logger.Readf("%s%s\n", prefix, this.encode(p))
 	}
 }
 
 // Writeln 打印写入的数据
 func (this *logger) Writeln(prefix string, p []byte) {
-	if this.debug && LevelWrite >= this.level {
-		if this.coding == nil {
-			this.SetPrintWithUTF8()
+	if this.enable(LevelWrite) {
+		if this.field != nil {
+			this.fieldData(LevelWrite, prefix, p)
+			return
 		}
-		this.Logger.Writef("%s%s\n", prefix, this.coding(p))
+		this.Logger.Writef("%s%s\n", prefix, this.encode(p))
 	}
 }
 
 // Infof 打印信息
 func (this *logger) Infof(format string, v ...interface{}) {
-	if this.debug && LevelInfo >= this.level {
+	if this.enable(LevelInfo) {
+		if this.field != nil {
+			this.field.Log(LevelInfo, strings.TrimSpace(fmt.Sprintf(format, v...)))
+			return
+		}
 		this.Logger.Infof(format, v...)
 	}
 }
 
 // Errorf 打印错误
 func (this *logger) Errorf(format string, v ...interface{}) {
-	if this.debug && LevelError >= this.level {
+	if this.enable(LevelError) {
+		if this.field != nil {
+			this.field.Log(LevelError, strings.TrimSpace(fmt.Sprintf(format, v...)))
+			return
+		}
 		this.Logger.Errorf(format, v...)
 	}
 }
 
+func (this *logger) fieldData(level Level, prefix string, p []byte) {
+	this.field.Log(level, strings.TrimSpace(prefix),
+		Field{FieldBytes, len(p)},
+		Field{FieldData, this.encode(p)},
+	)
+}
+
 // Printf 自定义打印
 func (this *logger) Printf(format string, v ...interface{}) {
 	if this.debug {
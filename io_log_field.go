@@ -0,0 +1,192 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	FieldKey       = "key"       //客户端标识
+	FieldAddr      = "addr"      //远程地址
+	FieldDirection = "direction" //数据方向,read或write
+	FieldBytes     = "bytes"     //字节数
+	FieldData      = "data"      //数据,按SetPrintWithHEX等设置的编码
+	FieldError     = "error"     //错误
+	FieldDropped   = "dropped"   //采样丢弃的日志数量
+)
+
+// Field 日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// FieldLogger 结构化日志,兼容go1.18,go1.21及以上可以使用NewFieldLoggerWithSlog
+type FieldLogger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// FieldLoggerFunc 函数实现FieldLogger
+type FieldLoggerFunc func(level Level, msg string, fields ...Field)
+
+func (this FieldLoggerFunc) Log(level Level, msg string, fields ...Field) {
+	this(level, msg, fields...)
+}
+
+// NewFieldLoggerWithJSON 输出json格式的日志,每行一条
+func NewFieldLoggerWithJSON(w Writer) FieldLogger {
+	return &jsonLogger{w: w}
+}
+
+type jsonLogger struct {
+	mu sync.Mutex
+	w  Writer
+}
+
+func (this *jsonLogger) Log(level Level, msg string, fields ...Field) {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(`{"time":`)
+	this.write(buf, time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	this.write(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	this.write(buf, msg)
+	for _, v := range fields {
+		buf.WriteByte(',')
+		this.write(buf, v.Key)
+		buf.WriteByte(':')
+		value := v.Value
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		this.write(buf, value)
+	}
+	buf.WriteString("}\n")
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	_, _ = this.w.Write(buf.Bytes())
+}
+
+func (this *jsonLogger) write(buf *bytes.Buffer, v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		bs, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(bs)
+}
+
+//================================Sample================================
+
+// logSample 日志采样,每个周期最多打印limit条收发数据
+type logSample struct {
+	mu       sync.Mutex
+	limit    int
+	interval time.Duration
+	start    time.Time
+	count    int
+	dropped  int
+}
+
+// allow 是否允许打印,返回之前丢弃的数量
+func (this *logSample) allow() (int, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if now := time.Now(); now.Sub(this.start) >= this.interval {
+		this.start = now
+		this.count = 0
+	}
+	if this.count >= this.limit {
+		this.dropped++
+		return 0, false
+	}
+	this.count++
+	dropped := this.dropped
+	this.dropped = 0
+	return dropped, true
+}
+
+//================================Client================================
+
+// SetLogSample 设置收发数据的日志采样,每个周期最多打印limit条,防止频繁通讯的设备日志过多
+// limit<=0表示不采样
+func (this *Client) SetLogSample(limit int, interval time.Duration) *Client {
+	if limit <= 0 || interval <= 0 {
+		this.logSample = nil
+		return this
+	}
+	this.logSample = &logSample{limit: limit, interval: interval}
+	return this
+}
+
+// logFields 客户端的日志字段
+func (this *Client) logFields(err error, fields ...Field) []Field {
+	list := []Field{{FieldKey, this.GetKey()}}
+	if c, ok := this.NetConn(); ok && c.RemoteAddr() != nil {
+		list = append(list, Field{FieldAddr, c.RemoteAddr().String()})
+	}
+	list = append(list, fields...)
+	if err != nil {
+		list = append(list, Field{FieldError, err})
+	}
+	return list
+}
+
+// logf 打印客户端日志,设置了结构化日志时附带客户端字段
+func (this *Client) logf(level Level, err error, format string, v ...interface{}) {
+	if !this.logger.enable(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	if this.logger.field != nil {
+		this.logger.field.Log(level, msg, this.logFields(err)...)
+		return
+	}
+	switch level {
+	case LevelError:
+		this.logger.Errorf("[%s] %s\n", this.GetKey(), msg)
+	default:
+		this.logger.Infof("[%s] %s\n", this.GetKey(), msg)
+	}
+}
+
+// logData 打印收发的数据,受采样限制
+func (this *Client) logData(level Level, p []byte) {
+	if !this.logger.enable(level) {
+		return
+	}
+	dropped := 0
+	if this.logSample != nil {
+		var ok bool
+		if dropped, ok = this.logSample.allow(); !ok {
+			return
+		}
+	}
+	if this.logger.field != nil {
+		direction := RecordRead
+		if level == LevelWrite {
+			direction = RecordWrite
+		}
+		fields := []Field{
+			{FieldDirection, direction},
+			{FieldBytes, len(p)},
+			{FieldData, this.logger.encode(p)},
+		}
+		if dropped > 0 {
+			fields = append(fields, Field{FieldDropped, dropped})
+		}
+		this.logger.field.Log(level, level.String(), this.logFields(nil, fields...)...)
+		return
+	}
+	if dropped > 0 {
+		this.logger.Infof("[%s] 日志采样,丢弃%d条\n", this.GetKey(), dropped)
+	}
+	switch level {
+	case LevelRead:
+		this.logger.Readln("["+this.GetKey()+"] ", p)
+	case LevelWrite:
+		this.logger.Writeln("["+this.GetKey()+"] ", p)
+	}
+}
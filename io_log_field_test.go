@@ -0,0 +1,79 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFieldLoggerWithJSON(t *testing.T) {
+	a, b := newPipeClient(t)
+	buf := bytes.NewBuffer(nil)
+	a.Debug()
+	a.SetKey("a")
+	a.SetFieldLogger(NewFieldLoggerWithJSON(buf))
+	a.SetPrintWithHEX()
+	go b.Run()
+	a.WriteString("hi")
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]interface{}{
+		"level":        "write",
+		FieldKey:       "a",
+		FieldDirection: RecordWrite,
+		FieldBytes:     float64(2),
+		FieldData:      "6869",
+	} {
+		if m[k] != v {
+			t.Errorf("字段(%s)预期(%v),得到(%v)", k, v, m[k])
+		}
+	}
+}
+
+func TestLogSample(t *testing.T) {
+	a, b := newPipeClient(t)
+	var list []map[string]interface{}
+	a.Debug()
+	a.SetFieldLogger(FieldLoggerFunc(func(level Level, msg string, fields ...Field) {
+		m := map[string]interface{}{}
+		for _, v := range fields {
+			m[v.Key] = v.Value
+		}
+		list = append(list, m)
+	}))
+	a.SetLogSample(2, time.Millisecond*50)
+	go b.Run()
+	for i := 0; i < 5; i++ {
+		a.WriteString("hi")
+	}
+	if len(list) != 2 {
+		t.Fatalf("预期打印2条,得到(%d)", len(list))
+	}
+	<-time.After(time.Millisecond * 60)
+	a.WriteString("hi")
+	if len(list) != 3 || list[2][FieldDropped] != 3 {
+		t.Fatalf("预期丢弃3条,得到(%v)", list)
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	a, b := newPipeClient(t)
+	l, c := NewLoggerChan()
+	a.SetLogger(l)
+	a.SetKey("a")
+	go b.Run()
+	a.WriteString("hi")
+	select {
+	case bs := <-c:
+		if !strings.HasSuffix(string(bs), "[发送] [a] hi\n") {
+			t.Errorf("日志格式错误: %s", bs)
+		}
+	default:
+		t.Error("未打印日志")
+	}
+}
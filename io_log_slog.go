@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package io
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewFieldLoggerWithSlog 使用log/slog输出结构化日志,收发数据为Debug等级
+func NewFieldLoggerWithSlog(l *slog.Logger) FieldLogger {
+	return &slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (this *slogLogger) Log(level Level, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, v := range fields {
+		attrs = append(attrs, slog.Any(v.Key, v.Value))
+	}
+	this.l.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelRead, LevelWrite:
+		return slog.LevelDebug
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
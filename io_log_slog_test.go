@@ -0,0 +1,23 @@
+//go:build go1.21
+
+package io
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestFieldLoggerWithSlog(t *testing.T) {
+	a, b := newPipeClient(t)
+	buf := bytes.NewBuffer(nil)
+	a.Debug()
+	a.SetKey("a")
+	a.SetFieldLogger(NewFieldLoggerWithSlog(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	go b.Run()
+	a.WriteString("hi")
+	if s := buf.String(); !strings.Contains(s, "key=a") || !strings.Contains(s, "bytes=2") {
+		t.Errorf("日志格式错误: %s", s)
+	}
+}
@@ -0,0 +1,247 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+中间件,处理数据的调用链,类似http的中间件
+
+c.Use(io.MiddlewareRecover(), io.MiddlewareLog())
+执行顺序: Recover > Log > ... > dealFunc(SetDealFunc等设置的函数) > handleFunc(Handle设置的函数)
+
+返回错误会关闭连接,中间件可以不调用next来中断处理
+
+*/
+
+// Handler 处理数据,返回错误会关闭连接
+type Handler func(ctx context.Context, c *Client, msg Message) error
+
+// Middleware 中间件
+type Middleware func(next Handler) Handler
+
+type ackerKey struct{}
+
+// AckerFromContext 获取当前数据的确认,不存在返回nil
+func AckerFromContext(ctx context.Context) Acker {
+	a, _ := ctx.Value(ackerKey{}).(Acker)
+	return a
+}
+
+// Use 添加中间件,先添加的在外层
+func (this *Client) Use(middleware ...Middleware) *Client {
+	this.middleware = append(this.middleware, middleware...)
+	handler := Handler(this.dealHandler)
+	for i := len(this.middleware) - 1; i >= 0; i-- {
+		if this.middleware[i] != nil {
+			handler = this.middleware[i](handler)
+		}
+	}
+	this.handler = handler
+	return this
+}
+
+// Handle 添加处理函数,在dealFunc之后执行,返回错误时中断后续处理
+func (this *Client) Handle(h Handler) *Client {
+	this.handleFunc = append(this.handleFunc, h)
+	return this
+}
+
+// handle 执行调用链
+func (this *Client) handle(ctx context.Context, ack Acker, msg Message) (err error) {
+	defer func() {
+		if !this.noRecover {
+			if e := recover(); e != nil {
+				err = this.recoverPanic(PanicDeal, e)
+			}
+		}
+	}()
+	ctx = context.WithValue(ctx, ackerKey{}, ack)
+	if this.handler != nil {
+		return this.handler(ctx, this, msg)
+	}
+	return this.dealHandler(ctx, this, msg)
+}
+
+// dealHandler 调用链的最后一环,兼容之前的dealFunc
+func (this *Client) dealHandler(ctx context.Context, c *Client, msg Message) error {
+	for _, dealFunc := range this.dealFunc {
+		if dealFunc != nil && dealFunc(c, msg) {
+			if ack := AckerFromContext(ctx); ack != nil {
+				ack.Ack()
+			}
+		}
+	}
+	for _, h := range this.handleFunc {
+		if h != nil {
+			if err := h(ctx, c, msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//================================ClientManage================================
+
+// Use 添加客户端的中间件
+func (this *ClientManage) Use(middleware ...Middleware) {
+	this.SetOptions(func(c *Client) { c.Use(middleware...) })
+}
+
+// Handle 添加客户端的处理函数
+func (this *ClientManage) Handle(h Handler) {
+	this.SetOptions(func(c *Client) { c.Handle(h) })
+}
+
+//================================Middleware================================
+
+// MiddlewareRecover 恢复处理数据时的panic,打印堆栈并执行panic事件,不关闭连接
+func MiddlewareRecover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, c *Client, msg Message) (err error) {
+			defer func() {
+				if e := recover(); e != nil {
+					_ = c.recoverPanic(PanicDeal, e)
+					err = nil
+				}
+			}()
+			return next(ctx, c, msg)
+		}
+	}
+}
+
+// MiddlewareLog 打印处理数据的耗时和错误
+func MiddlewareLog() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, c *Client, msg Message) error {
+			start := time.Now()
+			err := next(ctx, c, msg)
+			if err != nil {
+				c.logf(LevelError, err, "处理数据错误(%v),耗时%s", err, time.Since(start))
+			} else {
+				c.logf(LevelInfo, nil, "处理数据完成,耗时%s", time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// MiddlewareTimeout 处理数据超时,超时返回ErrWithTimeout,上下文会被取消
+// 超时之后不会中断处理函数,只是不再等待,处理函数仍会在协程中执行完成,需要自行监听上下文
+// 处理函数收到的是数据的副本,超时之后读取缓存或自动归还(SetAutoRelease)不会影响处理中的数据
+func MiddlewareTimeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, c *Client, msg Message) error {
+			if timeout <= 0 {
+				return next(ctx, c, msg)
+			}
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			msg = append(Message(nil), msg...)
+			done := make(chan error, 1)
+			go func() {
+				defer func() {
+					if e := recover(); e != nil {
+						done <- c.recoverPanic(PanicDeal, e)
+					}
+				}()
+				done <- next(ctx, c, msg)
+			}()
+			select {
+			case err := <-done:
+				return err
+			case <-ctx.Done():
+				return ErrWithTimeout
+			}
+		}
+	}
+}
+
+// MiddlewareAck 处理成功(没有返回错误)时确认数据
+func MiddlewareAck() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, c *Client, msg Message) error {
+			if err := next(ctx, c, msg); err != nil {
+				return err
+			}
+			if ack := AckerFromContext(ctx); ack != nil {
+				return ack.Ack()
+			}
+			return nil
+		}
+	}
+}
+
+// MiddlewareMetrics 统计处理数据的数量,错误数量和耗时
+func MiddlewareMetrics(m *Metrics) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, c *Client, msg Message) error {
+			start := time.Now()
+			err := next(ctx, c, msg)
+			m.add(time.Since(start), err)
+			return err
+		}
+	}
+}
+
+//================================Metrics================================
+
+// NewMetrics 新建处理数据的统计
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Metrics 处理数据的统计,并发安全
+type Metrics struct {
+	count    uint64 //处理数量
+	errCount uint64 //错误数量
+	cost     int64  //总耗时
+	maxCost  int64  //最大耗时
+}
+
+func (this *Metrics) add(cost time.Duration, err error) {
+	atomic.AddUint64(&this.count, 1)
+	if err != nil {
+		atomic.AddUint64(&this.errCount, 1)
+	}
+	atomic.AddInt64(&this.cost, int64(cost))
+	for {
+		old := atomic.LoadInt64(&this.maxCost)
+		if int64(cost) <= old || atomic.CompareAndSwapInt64(&this.maxCost, old, int64(cost)) {
+			return
+		}
+	}
+}
+
+// Count 处理数量
+func (this *Metrics) Count() uint64 {
+	return atomic.LoadUint64(&this.count)
+}
+
+// ErrCount 错误数量
+func (this *Metrics) ErrCount() uint64 {
+	return atomic.LoadUint64(&this.errCount)
+}
+
+// AvgCost 平均耗时
+func (this *Metrics) AvgCost() time.Duration {
+	count := this.Count()
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&this.cost) / int64(count))
+}
+
+// MaxCost 最大耗时
+func (this *Metrics) MaxCost() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this.maxCost))
+}
+
+func (this *Metrics) String() string {
+	return fmt.Sprintf("数量: %d, 错误: %d, 平均耗时: %s, 最大耗时: %s", this.Count(), this.ErrCount(), this.AvgCost(), this.MaxCost())
+}
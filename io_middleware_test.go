@@ -0,0 +1,240 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testAck struct {
+	payload []byte
+	acked   *int32
+}
+
+func (this testAck) Payload() []byte { return this.payload }
+
+func (this testAck) Ack() error {
+	atomic.AddInt32(this.acked, 1)
+	return nil
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	a, b := newPipeClient(t)
+	order := make(chan string, 10)
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, c *Client, msg Message) error {
+				order <- name
+				return next(ctx, c, msg)
+			}
+		}
+	}
+	b.Use(mark("1"), mark("2"))
+	b.SetDealFunc(func(c *Client, msg Message) { order <- "deal" })
+	b.Handle(func(ctx context.Context, c *Client, msg Message) error {
+		order <- "handle"
+		return nil
+	})
+	go b.Run()
+	a.WriteString("hello")
+
+	var list []string
+	for i := 0; i < 4; i++ {
+		select {
+		case v := <-order:
+			list = append(list, v)
+		case <-time.After(time.Second):
+			t.Fatal("超时")
+		}
+	}
+	if s := strings.Join(list, ","); s != "1,2,deal,handle" {
+		t.Fatalf("执行顺序错误: %s", s)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	a, b := newPipeClient(t)
+	dealt := make(chan struct{}, 1)
+	b.Use(func(next Handler) Handler {
+		return func(ctx context.Context, c *Client, msg Message) error {
+			if msg.String() == "drop" {
+				return nil
+			}
+			return next(ctx, c, msg)
+		}
+	})
+	b.SetDealWithNil().SetDealFunc(func(c *Client, msg Message) { dealt <- struct{}{} })
+	go b.Run()
+	a.WriteString("drop")
+	select {
+	case <-dealt:
+		t.Fatal("应该被中断")
+	case <-time.After(time.Millisecond * 50):
+	}
+	a.WriteString("keep")
+	select {
+	case <-dealt:
+	case <-time.After(time.Second):
+		t.Fatal("未处理数据")
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	a, b := newPipeClient(t)
+	errTest := errors.New("test")
+	b.Use(MiddlewareLog())
+	b.Handle(func(ctx context.Context, c *Client, msg Message) error { return errTest })
+	go b.Run()
+	a.WriteString("hello")
+	select {
+	case <-b.Done():
+		if !errors.Is(b.Err(), errTest) {
+			t.Fatalf("预期错误(%v),得到(%v)", errTest, b.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("返回错误应该关闭连接")
+	}
+}
+
+func TestMiddlewareRecover(t *testing.T) {
+	a, b := newPipeClient(t)
+	dealt := make(chan struct{}, 1)
+	b.Use(MiddlewareRecover())
+	b.SetDealWithNil().SetDealFunc(func(c *Client, msg Message) {
+		if msg.String() == "panic" {
+			panic("test")
+		}
+		dealt <- struct{}{}
+	})
+	go b.Run()
+	a.WriteString("panic")
+	a.WriteString("hello")
+	select {
+	case <-dealt:
+	case <-time.After(time.Second):
+		t.Fatal("恢复之后应该继续处理")
+	}
+	if b.Closed() {
+		t.Fatal("恢复之后不应该关闭连接")
+	}
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	a, b := newPipeClient(t)
+	canceled := make(chan struct{})
+	b.Use(MiddlewareTimeout(time.Millisecond * 20))
+	b.Handle(func(ctx context.Context, c *Client, msg Message) error {
+		<-ctx.Done()
+		close(canceled)
+		return nil
+	})
+	go b.Run()
+	a.WriteString("hello")
+	select {
+	case <-b.Done():
+		if !errors.Is(b.Err(), ErrWithTimeout) {
+			t.Fatalf("预期超时,得到(%v)", b.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("超时应该关闭连接")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("上下文应该被取消")
+	}
+}
+
+// TestMiddlewareTimeoutRelease 超时之后处理函数仍在使用数据,不能使用会被归还的数据
+func TestMiddlewareTimeoutRelease(t *testing.T) {
+	a, b := newPipeClient(t)
+	pooled := make(chan []byte, 1)
+	b.SetReadAckFunc(func(r *bufio.Reader) (Acker, error) {
+		ack, err := readAckWith1KB(r)
+		if err == nil {
+			pooled <- ack.Payload()
+		}
+		return ack, err
+	})
+	b.SetAutoRelease(true)
+	b.Use(MiddlewareTimeout(time.Millisecond * 20))
+	result := make(chan error, 1)
+	b.Handle(func(ctx context.Context, c *Client, msg Message) error {
+		p := <-pooled
+		if len(p) > 0 && len(msg) > 0 && &p[0] == &msg[0] {
+			result <- errors.New("处理函数使用了会被归还的数据")
+			return nil
+		}
+		<-ctx.Done()
+		<-time.After(time.Millisecond * 20)
+		if string(msg) != "hello" {
+			result <- fmt.Errorf("超时之后数据被修改: %s", msg)
+			return nil
+		}
+		result <- nil
+		return nil
+	})
+	go b.Run()
+	a.WriteString("hello")
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("处理函数未执行完成")
+	}
+}
+
+func TestMiddlewareAckMetrics(t *testing.T) {
+	a, b := newPipeClient(t)
+	var acked int32
+	m := NewMetrics()
+	b.SetReadAckFunc(func(buf *bufio.Reader) (Acker, error) {
+		bs, err := buf.ReadByte()
+		return testAck{payload: []byte{bs}, acked: &acked}, err
+	})
+	b.SetDealWithNil()
+	b.Use(MiddlewareMetrics(m), MiddlewareAck())
+	b.Handle(func(ctx context.Context, c *Client, msg Message) error {
+		if AckerFromContext(ctx) == nil {
+			t.Error("上下文中没有确认")
+		}
+		return nil
+	})
+	go b.Run()
+	a.WriteString("abc")
+	deadline := time.Now().Add(time.Second)
+	for m.Count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if m.Count() != 3 || m.ErrCount() != 0 {
+		t.Fatalf("统计错误: %s", m)
+	}
+	if n := atomic.LoadInt32(&acked); n != 3 {
+		t.Fatalf("预期确认3次,得到(%d)", n)
+	}
+}
+
+func TestClientManageUse(t *testing.T) {
+	m := NewClientManage("test", NewLoggerWithNull())
+	metrics := NewMetrics()
+	m.SetOptions(func(c *Client) { c.Debug(false) })
+	m.Use(MiddlewareMetrics(metrics))
+	a, b := newPipeClient(t)
+	m.SetClient(b)
+	time.Sleep(time.Millisecond * 20)
+	a.WriteString("hello")
+	deadline := time.Now().Add(time.Second)
+	for metrics.Count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if metrics.Count() != 1 {
+		t.Fatalf("预期处理1次,得到(%d)", metrics.Count())
+	}
+}
@@ -3,6 +3,7 @@ package io
 import (
 	"encoding/json"
 	"github.com/injoyai/base/g"
+	"github.com/injoyai/io/codec"
 	"net/http"
 )
 
@@ -23,6 +24,24 @@ func (this *Model) Bytes() g.Bytes {
 	return bs
 }
 
+// Encode 使用编解码编码,c为nil时使用json
+func (this *Model) Encode(c codec.Codec) ([]byte, error) {
+	if c == nil {
+		c = codec.JSON
+	}
+	return c.Marshal(this)
+}
+
+// DecodeModel 使用编解码解码,c为nil时使用json
+func DecodeModel(c codec.Codec, bs []byte) (*Model, error) {
+	if c == nil {
+		c = codec.JSON
+	}
+	m := new(Model)
+	err := c.Unmarshal(bs, m)
+	return m, err
+}
+
 func (this *Model) IsSucc() bool {
 	return this.Code == http.StatusOK
 }
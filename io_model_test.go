@@ -0,0 +1,46 @@
+package io
+
+import (
+	"bytes"
+	"github.com/injoyai/io/codec"
+	"testing"
+)
+
+func TestModelEncode(t *testing.T) {
+	m := &Model{Type: "write", Code: 200, UID: "1", Data: map[string]interface{}{"v": 1.5}}
+	for _, c := range []codec.Codec{nil, codec.JSON, codec.CBOR, codec.MsgPack} {
+		bs, err := m.Encode(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeModel(c, bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != m.Type || got.Code != m.Code || got.UID != m.UID || got.Data.(map[string]interface{})["v"] != 1.5 {
+			t.Fatalf("预期(%s),得到(%s)", m.String(), got.String())
+		}
+	}
+}
+
+func TestWriteAnyWithCodec(t *testing.T) {
+	ch := make(chan []byte, 10)
+	c := newTCPClient(t, ch)
+	c.SetCodec(codec.MsgPack)
+
+	for _, v := range []struct {
+		value interface{}
+		want  []byte
+	}{
+		{[]byte{1, 2}, []byte{1, 2}},
+		{"ab", []byte("ab")},
+		{map[string]int{"a": 1}, []byte{0x81, 0xa1, 'a', 0x01}},
+	} {
+		if _, err := c.WriteAny(v.value); err != nil {
+			t.Fatal(err)
+		}
+		if bs := readAll(t, ch, len(v.want)); !bytes.Equal(bs, v.want) {
+			t.Fatalf("预期(%x),得到(%x)", v.want, bs)
+		}
+	}
+}
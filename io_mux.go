@@ -0,0 +1,492 @@
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+
+多路复用,在一个客户端上同时传输多个流,类似yamux
+
+帧:
+类型 1字节
+流ID 4字节(大端)
+长度 4字节(大端)
+数据 n字节
+
+open 打开流
+data 数据,受对方接收窗口限制,对方读取后通过window增加窗口
+close 关闭写入,对方读取完缓存的数据后返回EOF,双方都关闭后释放流
+reset 重置流,例如等待接收的流已满,读写都返回错误
+window 窗口更新,数据为4字节的增量
+ping/pong 保活,流ID为0
+
+客户端使用奇数流ID,服务端使用偶数流ID,防止同时打开时冲突
+接管客户端的读取函数和处理函数,需要执行客户端的Run
+
+m := io.NewMuxClient(c)
+go c.Run()
+stream, err := m.OpenStream()
+
+*/
+
+const (
+	muxOpen   = 0x01
+	muxData   = 0x02
+	muxClose  = 0x03
+	muxReset  = 0x04
+	muxWindow = 0x05
+	muxPing   = 0x06
+	muxPong   = 0x07
+
+	muxHeadSize   = 9
+	muxMaxFrame   = 64 << 10  //单帧最大数据长度
+	muxInitWindow = 256 << 10 //协议的初始窗口,更大的窗口在打开流时通过window通知对方
+)
+
+// OptionMux 多路复用选项
+type OptionMux func(m *Mux)
+
+// NewMuxClient 客户端多路复用,使用奇数流ID
+func NewMuxClient(c *Client, options ...OptionMux) *Mux {
+	return newMux(c, 1, options...)
+}
+
+// NewMuxServer 服务端多路复用,使用偶数流ID
+func NewMuxServer(c *Client, options ...OptionMux) *Mux {
+	return newMux(c, 2, options...)
+}
+
+func newMux(c *Client, firstID uint32, options ...OptionMux) *Mux {
+	m := &Mux{
+		c:         c,
+		nextID:    firstID - 2,
+		parity:    firstID % 2,
+		streams:   make(map[uint32]*Stream),
+		window:    DefaultMuxWindow,
+		backlog:   DefaultMuxBacklog,
+		keepAlive: DefaultMuxKeepAlive,
+		done:      c.Done(),
+		closed:    make(chan struct{}),
+		lastRecv:  time.Now().UnixNano(),
+	}
+	for _, v := range options {
+		v(m)
+	}
+	m.accept = make(chan *Stream, m.backlog)
+	c.SetReadFunc(readMux)
+	c.SetDealFunc(m.deal)
+	go m.run()
+	return m
+}
+
+// Mux 多路复用
+type Mux struct {
+	c         *Client
+	nextID    uint32
+	parity    uint32 //本端流ID的奇偶,创建后不变,对方的流ID奇偶相反
+	mu        sync.Mutex
+	wmu       sync.Mutex //写入锁,帧需要完整写入
+	streams   map[uint32]*Stream
+	accept    chan *Stream
+	window    uint32
+	backlog   int
+	keepAlive time.Duration
+	done      <-chan struct{} //客户端单次连接的上下文,重连后的连接需要新建多路复用
+	closed    chan struct{}
+	closeOnce sync.Once
+	err       error
+	lastRecv  int64 //最后收到数据的时间
+}
+
+// SetWindow 设置单个流的接收窗口,不能小于默认窗口
+func (this *Mux) SetWindow(size uint32) *Mux {
+	if size >= muxInitWindow {
+		this.window = size
+	}
+	return this
+}
+
+// SetBacklog 设置等待接收的流数量,超过则重置新打开的流
+func (this *Mux) SetBacklog(n int) *Mux {
+	if n > 0 {
+		this.backlog = n
+	}
+	return this
+}
+
+// SetKeepAlive 设置保活间隔,超过3个间隔未收到数据则关闭客户端,小于等于0不启用
+func (this *Mux) SetKeepAlive(interval time.Duration) *Mux {
+	this.keepAlive = interval
+	return this
+}
+
+// Client 底层客户端
+func (this *Mux) Client() *Client {
+	return this.c
+}
+
+// Done 关闭信号
+func (this *Mux) Done() <-chan struct{} {
+	return this.closed
+}
+
+// Err 关闭的错误
+func (this *Mux) Err() error {
+	<-this.closed
+	return this.err
+}
+
+// NumStreams 当前流的数量
+func (this *Mux) NumStreams() int {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return len(this.streams)
+}
+
+// OpenStream 打开流,不等待对方响应
+func (this *Mux) OpenStream() (*Stream, error) {
+	select {
+	case <-this.closed:
+		return nil, this.err
+	default:
+	}
+	s := this.newStream(atomic.AddUint32(&this.nextID, 2))
+	if err := this.writeFrame(muxOpen, s.id, nil); err != nil {
+		this.remove(s.id)
+		return nil, err
+	}
+	s.sendWindowUpdate()
+	return s, nil
+}
+
+// AcceptStream 等待对方打开的流
+func (this *Mux) AcceptStream() (*Stream, error) {
+	select {
+	case s := <-this.accept:
+		return s, nil
+	case <-this.closed:
+		return nil, this.err
+	}
+}
+
+// Close 关闭客户端,所有流返回错误
+func (this *Mux) Close() error {
+	return this.c.CloseWithErr(ErrHandClose)
+}
+
+// run 等待客户端关闭,定时保活
+func (this *Mux) run() {
+	var tick <-chan time.Time
+	if this.keepAlive > 0 {
+		t := time.NewTicker(this.keepAlive)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-this.done:
+			err := this.c.Err()
+			if err == nil {
+				err = ErrHandClose
+			}
+			this.close(err)
+			return
+		case <-tick:
+			if time.Since(time.Unix(0, atomic.LoadInt64(&this.lastRecv))) > this.keepAlive*3 {
+				this.c.CloseWithErr(ErrWithHeartbeat)
+				continue
+			}
+			this.writeFrame(muxPing, 0, nil)
+		}
+	}
+}
+
+func (this *Mux) close(err error) {
+	this.closeOnce.Do(func() {
+		this.err = err
+		close(this.closed)
+		this.mu.Lock()
+		streams := this.streams
+		this.streams = make(map[uint32]*Stream)
+		this.mu.Unlock()
+		for _, s := range streams {
+			s.setErr(err)
+		}
+	})
+}
+
+func (this *Mux) newStream(id uint32) *Stream {
+	s := &Stream{
+		id:         id,
+		m:          this,
+		recvWindow: this.window,
+		sendWindow: muxInitWindow,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	this.mu.Lock()
+	this.streams[id] = s
+	this.mu.Unlock()
+	return s
+}
+
+func (this *Mux) get(id uint32) *Stream {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.streams[id]
+}
+
+func (this *Mux) remove(id uint32) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.streams, id)
+}
+
+func (this *Mux) writeFrame(Type uint8, id uint32, data []byte) error {
+	bs := make([]byte, muxHeadSize+len(data))
+	bs[0] = Type
+	binary.BigEndian.PutUint32(bs[1:5], id)
+	binary.BigEndian.PutUint32(bs[5:9], uint32(len(data)))
+	copy(bs[muxHeadSize:], data)
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	_, err := this.c.Write(bs)
+	return err
+}
+
+// deal 处理收到的帧,在客户端的读取协程中执行,不能阻塞
+func (this *Mux) deal(c *Client, msg Message) {
+	bs := msg.Bytes()
+	if len(bs) < muxHeadSize {
+		return
+	}
+	atomic.StoreInt64(&this.lastRecv, time.Now().UnixNano())
+	id, data := binary.BigEndian.Uint32(bs[1:5]), bs[muxHeadSize:]
+	switch bs[0] {
+	case muxPing:
+		this.writeFrame(muxPong, id, nil)
+	case muxPong:
+	case muxOpen:
+		//流ID需要是对方的
+		if id == 0 || id%2 == this.parity || this.get(id) != nil {
+			this.writeFrame(muxReset, id, nil)
+			return
+		}
+		s := this.newStream(id)
+		select {
+		case this.accept <- s:
+			s.sendWindowUpdate()
+		default:
+			this.remove(id)
+			this.writeFrame(muxReset, id, nil)
+			c.logf(LevelError, ErrMuxBacklogFull, "[%d] %v", id, ErrMuxBacklogFull)
+		}
+	default:
+		s := this.get(id)
+		if s == nil {
+			//流已经释放,对方还在写入
+			if bs[0] == muxData {
+				this.writeFrame(muxReset, id, nil)
+			}
+			return
+		}
+		switch bs[0] {
+		case muxData:
+			if err := s.push(data); err != nil {
+				this.remove(id)
+				s.setErr(err)
+				this.writeFrame(muxReset, id, nil)
+			}
+		case muxWindow:
+			if len(data) == 4 {
+				s.addSendWindow(binary.BigEndian.Uint32(data))
+			}
+		case muxClose:
+			s.remoteClose()
+		case muxReset:
+			this.remove(id)
+			s.setErr(ErrMuxReset)
+		}
+	}
+}
+
+// readMux 读取一帧
+func readMux(r *bufio.Reader) ([]byte, error) {
+	head := make([]byte, muxHeadSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(head[5:9])
+	if length > muxMaxFrame {
+		return nil, ErrMuxFrame
+	}
+	bs := make([]byte, muxHeadSize+int(length))
+	copy(bs, head)
+	_, err := io.ReadFull(r, bs[muxHeadSize:])
+	return bs, err
+}
+
+// Stream 多路复用的流
+type Stream struct {
+	id           uint32
+	m            *Mux
+	mu           sync.Mutex
+	cond         *sync.Cond
+	buf          [][]byte
+	recvWindow   uint32 //对方还能发送的字节数
+	consumed     uint32 //已读取但还未通知对方的字节数
+	sendWindow   uint32 //还能发送的字节数
+	localClosed  bool
+	remoteClosed bool
+	err          error
+}
+
+// ID 流ID
+func (this *Stream) ID() uint32 {
+	return this.id
+}
+
+// Read 读取数据,对方关闭后返回EOF
+func (this *Stream) Read(p []byte) (int, error) {
+	this.mu.Lock()
+	for len(this.buf) == 0 {
+		switch {
+		case this.err != nil:
+			this.mu.Unlock()
+			return 0, this.err
+		case this.remoteClosed:
+			this.mu.Unlock()
+			return 0, io.EOF
+		}
+		this.cond.Wait()
+	}
+	n := copy(p, this.buf[0])
+	if n == len(this.buf[0]) {
+		this.buf[0] = nil
+		this.buf = this.buf[1:]
+	} else {
+		this.buf[0] = this.buf[0][n:]
+	}
+	this.consumed += uint32(n)
+	var update uint32
+	if this.consumed >= this.m.window/2 {
+		update, this.consumed = this.consumed, 0
+		this.recvWindow += update
+	}
+	this.mu.Unlock()
+	if update > 0 {
+		this.writeWindow(update)
+	}
+	return n, nil
+}
+
+// Write 写入数据,对方接收窗口为0时阻塞
+func (this *Stream) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		this.mu.Lock()
+		for this.sendWindow == 0 && this.err == nil && !this.localClosed {
+			this.cond.Wait()
+		}
+		switch {
+		case this.err != nil:
+			this.mu.Unlock()
+			return total, this.err
+		case this.localClosed:
+			this.mu.Unlock()
+			return total, ErrWriteClosed
+		}
+		n := uint32(len(p))
+		if n > this.sendWindow {
+			n = this.sendWindow
+		}
+		if n > muxMaxFrame {
+			n = muxMaxFrame
+		}
+		this.sendWindow -= n
+		this.mu.Unlock()
+		if err := this.m.writeFrame(muxData, this.id, p[:n]); err != nil {
+			return total, err
+		}
+		total += int(n)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// Close 关闭写入,对方读取完数据后返回EOF,双方都关闭后释放
+func (this *Stream) Close() error {
+	this.mu.Lock()
+	if this.localClosed || this.err != nil {
+		this.mu.Unlock()
+		return nil
+	}
+	this.localClosed = true
+	remote := this.remoteClosed
+	this.cond.Broadcast()
+	this.mu.Unlock()
+	if remote {
+		this.m.remove(this.id)
+	}
+	return this.m.writeFrame(muxClose, this.id, nil)
+}
+
+// sendWindowUpdate 接收窗口大于协议的初始窗口时,通知对方
+func (this *Stream) sendWindowUpdate() {
+	if this.recvWindow > muxInitWindow {
+		this.writeWindow(this.recvWindow - muxInitWindow)
+	}
+}
+
+func (this *Stream) writeWindow(n uint32) {
+	bs := make([]byte, 4)
+	binary.BigEndian.PutUint32(bs, n)
+	this.m.writeFrame(muxWindow, this.id, bs)
+}
+
+func (this *Stream) push(p []byte) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if uint32(len(p)) > this.recvWindow {
+		return ErrMuxFrame
+	}
+	this.recvWindow -= uint32(len(p))
+	if len(p) > 0 {
+		//客户端可能开启了自动归还(SetAutoRelease),需要复制
+		this.buf = append(this.buf, append([]byte(nil), p...))
+		this.cond.Broadcast()
+	}
+	return nil
+}
+
+func (this *Stream) addSendWindow(n uint32) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.sendWindow += n
+	this.cond.Broadcast()
+}
+
+func (this *Stream) remoteClose() {
+	this.mu.Lock()
+	this.remoteClosed = true
+	local := this.localClosed
+	this.cond.Broadcast()
+	this.mu.Unlock()
+	if local {
+		this.m.remove(this.id)
+	}
+}
+
+func (this *Stream) setErr(err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.err == nil {
+		this.err = err
+	}
+	this.cond.Broadcast()
+}
@@ -0,0 +1,300 @@
+package io
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTCPPair 本地TCP连接的两端,net.Pipe没有缓存,双方同时写入会阻塞
+func newTCPPair(t *testing.T) (*Client, *Client) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewClient(c1, func(c *Client) { c.Debug(false) })
+	b := NewClient(<-accepted, func(c *Client) { c.Debug(false) })
+	t.Cleanup(func() {
+		a.CloseAll()
+		b.CloseAll()
+	})
+	return a, b
+}
+
+func newMuxPair(t *testing.T, options ...OptionMux) (*Mux, *Mux) {
+	a, b := newTCPPair(t)
+	ma := NewMuxClient(a, options...)
+	mb := NewMuxServer(b, options...)
+	go a.Run()
+	go b.Run()
+	return ma, mb
+}
+
+// muxEcho 接收流并原样返回,对方关闭写入后关闭
+func muxEcho(m *Mux) {
+	for {
+		s, err := m.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(s, s)
+			s.Close()
+		}()
+	}
+}
+
+func TestMuxStreams(t *testing.T) {
+	client, server := newMuxPair(t)
+	go muxEcho(server)
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := client.OpenStream()
+			if err != nil {
+				errs <- err
+				return
+			}
+			want := bytes.Repeat([]byte(fmt.Sprintf("stream-%d;", i)), 1000)
+			go func() {
+				s.Write(want)
+				s.Close()
+			}()
+			got, err := io.ReadAll(s)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, want) {
+				errs <- fmt.Errorf("流(%d)数据错误,长度(%d)", s.ID(), len(got))
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	//双方都关闭后释放
+	deadline := time.Now().Add(time.Second)
+	for client.NumStreams()+server.NumStreams() > 0 && time.Now().Before(deadline) {
+		<-time.After(time.Millisecond * 10)
+	}
+	if n := client.NumStreams() + server.NumStreams(); n != 0 {
+		t.Errorf("流未释放: %d", n)
+	}
+}
+
+// TestMuxBothOpen 双方同时打开流,接收对方的流时判断流ID的奇偶,本端也在打开流
+func TestMuxBothOpen(t *testing.T) {
+	client, server := newMuxPair(t)
+	go muxEcho(client)
+	go muxEcho(server)
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		m := client
+		if i%2 == 1 {
+			m = server
+		}
+		wg.Add(1)
+		go func(m *Mux, i int) {
+			defer wg.Done()
+			s, err := m.OpenStream()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if s.ID()%2 != uint32(i+1)%2 {
+				errs <- fmt.Errorf("流(%d)的奇偶错误", s.ID())
+			}
+			want := []byte(fmt.Sprintf("stream-%d", i))
+			go func() {
+				s.Write(want)
+				s.Close()
+			}()
+			got, err := io.ReadAll(s)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, want) {
+				errs <- fmt.Errorf("流(%d)数据错误: %s", s.ID(), got)
+			}
+		}(m, i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMuxFlowControl(t *testing.T) {
+	client, server := newMuxPair(t)
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//对方不读取,写满窗口后阻塞
+	written := make(chan struct{})
+	go func() {
+		s.Write(make([]byte, DefaultMuxWindow+1))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("超过窗口的数据未阻塞")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	remote, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(remote, make([]byte, DefaultMuxWindow+1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("读取后未恢复写入")
+	}
+
+	//慢速读取大量数据
+	data := make([]byte, 4<<20)
+	rand.Read(data)
+	go func() {
+		s.Write(data)
+		s.Close()
+	}()
+	got := bytes.NewBuffer(nil)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := remote.Read(buf)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatalf("数据错误,长度(%d)", got.Len())
+	}
+}
+
+func TestMuxClose(t *testing.T) {
+	client, server := newMuxPair(t)
+	s, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write([]byte("hello"))
+	s.Close()
+	if _, err := s.Write([]byte("hello")); err != ErrWriteClosed {
+		t.Fatalf("预期(%v),得到(%v)", ErrWriteClosed, err)
+	}
+
+	//半关闭,对方仍然可以写入
+	remote, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(remote)
+	if err != nil || string(got) != "hello" {
+		t.Fatalf("数据错误(%s),错误(%v)", got, err)
+	}
+	remote.Write([]byte("world"))
+	remote.Close()
+	got, err = io.ReadAll(s)
+	if err != nil || string(got) != "world" {
+		t.Fatalf("数据错误(%s),错误(%v)", got, err)
+	}
+
+	//关闭客户端,所有流返回错误
+	remote2, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := remote2.Read(make([]byte, 1))
+		result <- err
+	}()
+	client.Close()
+	select {
+	case err := <-result:
+		if err != ErrHandClose {
+			t.Fatalf("预期(%v),得到(%v)", ErrHandClose, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("关闭后读取未返回")
+	}
+	if _, err := client.OpenStream(); err != ErrHandClose {
+		t.Fatalf("预期(%v),得到(%v)", ErrHandClose, err)
+	}
+}
+
+func TestMuxBacklog(t *testing.T) {
+	client, _ := newMuxPair(t, func(m *Mux) { m.SetBacklog(1) })
+	s1, _ := client.OpenStream()
+	s2, _ := client.OpenStream()
+	if _, err := s1.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Read(make([]byte, 1)); err != ErrMuxReset {
+		t.Fatalf("预期(%v),得到(%v)", ErrMuxReset, err)
+	}
+	if client.NumStreams() != 1 {
+		t.Fatalf("预期1个流,得到(%d)", client.NumStreams())
+	}
+}
+
+func TestMuxKeepAlive(t *testing.T) {
+	a, b := newTCPPair(t)
+	m := NewMuxClient(a, func(m *Mux) { m.SetKeepAlive(time.Millisecond * 20) })
+	//对端不是多路复用,不响应ping
+	b.SetDealWithNil()
+	go a.Run()
+	go b.Run()
+	select {
+	case <-m.Done():
+		if m.Err() != ErrWithHeartbeat {
+			t.Fatalf("预期(%v),得到(%v)", ErrWithHeartbeat, m.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到保活超时")
+	}
+
+	//正常响应时保持连接
+	client, _ := newMuxPair(t, func(m *Mux) { m.SetKeepAlive(time.Millisecond * 20) })
+	select {
+	case <-client.Done():
+		t.Fatalf("保活失败: %v", client.Err())
+	case <-time.After(time.Millisecond * 200):
+	}
+}
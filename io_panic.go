@@ -0,0 +1,78 @@
+package io
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+const (
+	PanicConnect = "connect" //连接事件,connectFunc
+	PanicDeal    = "deal"    //处理数据,dealFunc和中间件
+	PanicWrite   = "write"   //写入数据,writeFunc
+	PanicClose   = "close"   //关闭事件,closeFunc
+	PanicRun     = "run"     //For循环中的其他panic
+)
+
+// PanicFunc panic事件,恢复用户函数的panic之后执行
+type PanicFunc func(c *Client, event string, e interface{}, stack []byte)
+
+// SetRecoverPanic 设置是否恢复用户函数的panic,默认开启,恢复后只关闭该客户端
+func (this *Client) SetRecoverPanic(b bool) *Client {
+	this.noRecover = !b
+	return this
+}
+
+// SetPanicFunc 设置panic事件,恢复用户函数的panic之后执行
+func (this *Client) SetPanicFunc(fn PanicFunc) *Client {
+	this.panicFunc = fn
+	return this
+}
+
+// recoverPanic 处理恢复的panic,打印堆栈,执行panic事件,返回错误
+func (this *Client) recoverPanic(event string, e interface{}) error {
+	stack := debug.Stack()
+	err := NewError(ErrorKindPanic, fmt.Errorf("%s panic: %v", event, e))
+	this.logf(LevelError, err, "%v\n%s", err, stack)
+	if this.panicFunc != nil {
+		func() {
+			//防止事件panic
+			defer func() { _ = recover() }()
+			this.panicFunc(this, event, e, stack)
+		}()
+	}
+	return err
+}
+
+// SetRecoverPanic 设置客户端是否恢复用户函数的panic
+func (this *ClientManage) SetRecoverPanic(b bool) {
+	this.SetOptions(func(c *Client) { c.SetRecoverPanic(b) })
+}
+
+// SetPanicFunc 设置客户端的panic事件
+func (this *ClientManage) SetPanicFunc(fn PanicFunc) {
+	this.SetOptions(func(c *Client) { c.SetPanicFunc(fn) })
+}
+
+// callConnect 执行连接事件,恢复panic
+func (this *Client) callConnect(f func(c *Client) error) (err error) {
+	defer func() {
+		if !this.noRecover {
+			if e := recover(); e != nil {
+				err = this.recoverPanic(PanicConnect, e)
+			}
+		}
+	}()
+	return f(this)
+}
+
+// callClose 执行关闭事件,恢复panic,客户端已经关闭,只打印日志
+func (this *Client) callClose(err error) {
+	defer func() {
+		if !this.noRecover {
+			if e := recover(); e != nil {
+				_ = this.recoverPanic(PanicClose, e)
+			}
+		}
+	}()
+	this.closeFunc(this.CtxAll(), this, err)
+}
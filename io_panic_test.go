@@ -0,0 +1,166 @@
+package io
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newPanicFunc panic事件,恢复的事件写入通道
+func newPanicFunc(t *testing.T) (PanicFunc, <-chan string) {
+	ch := make(chan string, 10)
+	return func(c *Client, event string, e interface{}, stack []byte) {
+		if len(stack) == 0 {
+			t.Error("堆栈为空")
+		}
+		ch <- event
+	}, ch
+}
+
+func waitPanic(t *testing.T, ch <-chan string, event string) {
+	t.Helper()
+	select {
+	case v := <-ch:
+		if v != event {
+			t.Fatalf("预期事件(%s),得到(%s)", event, v)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("未触发OnPanic(%s)", event)
+	}
+}
+
+func waitPanicClosed(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case <-c.Done():
+		if !IsErrorKind(c.Err(), ErrorKindPanic) {
+			t.Fatalf("预期panic错误,得到(%v)", c.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("panic之后应该关闭客户端")
+	}
+}
+
+func TestPanicDeal(t *testing.T) {
+	fn, ch := newPanicFunc(t)
+	a, b := newPipeClient(t)
+	b.SetPanicFunc(fn)
+	b.SetDealFunc(func(c *Client, msg Message) { panic("deal") })
+	go b.Run()
+	a.WriteString("hello")
+	waitPanic(t, ch, PanicDeal)
+	waitPanicClosed(t, b)
+	if a.Closed() {
+		t.Fatal("不应该影响其他客户端")
+	}
+}
+
+func TestPanicWrite(t *testing.T) {
+	fn, ch := newPanicFunc(t)
+	a, b := newPipeClient(t)
+	go b.Run()
+	a.SetPanicFunc(fn)
+	a.SetWriteFunc(func(p []byte) ([]byte, error) { panic("write") })
+	_, err := a.WriteString("hello")
+	if !IsErrorKind(err, ErrorKindPanic) {
+		t.Fatalf("预期panic错误,得到(%v)", err)
+	}
+	waitPanic(t, ch, PanicWrite)
+	waitPanicClosed(t, a)
+}
+
+func TestPanicConnect(t *testing.T) {
+	fn, ch := newPanicFunc(t)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c, _ := NewDial(func(ctx context.Context) (ReadWriteCloser, string, error) {
+		return c1, "test", nil
+	}, func(c *Client) {
+		c.Debug(false)
+		c.SetPanicFunc(fn)
+		c.SetConnectFunc(func(c *Client) error { panic("connect") })
+	})
+	defer c.CloseAll()
+	waitPanic(t, ch, PanicConnect)
+	waitPanicClosed(t, c)
+}
+
+func TestPanicConnectManage(t *testing.T) {
+	fn, ch := newPanicFunc(t)
+	m := NewClientManage("test", NewLoggerWithNull())
+	t.Cleanup(func() { m.Close() })
+	m.SetOptions(func(c *Client) { c.Debug(false) })
+	m.SetPanicFunc(fn)
+	m.SetConnectFunc(func(c *Client) error {
+		if c.GetKey() == "bad" {
+			panic("connect")
+		}
+		return nil
+	})
+	_, bad := newPipeClient(t)
+	bad.SetKey("bad")
+	m.SetClient(bad)
+	waitPanic(t, ch, PanicConnect)
+	select {
+	case <-bad.Done():
+	case <-time.After(time.Second):
+		t.Fatal("panic之后应该关闭客户端")
+	}
+
+	//其他客户端正常
+	_, good := newPipeClient(t)
+	good.SetKey("good")
+	m.SetClient(good)
+	deadline := time.Now().Add(time.Second)
+	for m.GetClient("good") == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if m.GetClient("good") == nil {
+		t.Fatal("其他客户端应该正常连接")
+	}
+}
+
+func TestPanicClose(t *testing.T) {
+	fn, ch := newPanicFunc(t)
+	a, _ := newPipeClient(t)
+	a.SetPanicFunc(fn)
+	a.SetCloseFunc(func(ctx context.Context, c *Client, err error) { panic("close") })
+	a.CloseWithErr(ErrHandClose)
+	waitPanic(t, ch, PanicClose)
+}
+
+func TestPanicMiddleware(t *testing.T) {
+	fn, ch := newPanicFunc(t)
+	a, b := newPipeClient(t)
+	b.SetPanicFunc(fn)
+	var once sync.Once
+	dealt := make(chan struct{}, 1)
+	b.Use(MiddlewareRecover())
+	b.SetDealFunc(func(c *Client, msg Message) {
+		once.Do(func() { panic("deal") })
+		dealt <- struct{}{}
+	})
+	go b.Run()
+	a.WriteString("hello")
+	waitPanic(t, ch, PanicDeal)
+	a.WriteString("hello")
+	select {
+	case <-dealt:
+	case <-time.After(time.Second):
+		t.Fatal("恢复之后应该继续处理")
+	}
+}
+
+func TestPanicDisable(t *testing.T) {
+	a, _ := newPipeClient(t)
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("关闭恢复之后应该panic")
+		}
+	}()
+	a.SetRecoverPanic(false)
+	a.SetWriteFunc(func(p []byte) ([]byte, error) { panic("write") })
+	a.WriteString("hello")
+}
@@ -3,9 +3,11 @@ package io
 import (
 	"bufio"
 	"compress/gzip"
+	"encoding/binary"
 	"fmt"
 	"github.com/injoyai/base/bytes"
 	"github.com/injoyai/conv"
+	"github.com/injoyai/io/buf"
 	"github.com/injoyai/logs"
 	"hash/crc32"
 	"io"
@@ -232,31 +234,31 @@ func (this *Pkg) ReadWriteTag(c *Client, key string) error {
 func DecodePkg(bs []byte) (*Pkg, error) {
 
 	//校验基础数据长度
-	if len(bs) <= pkgBaseLength {
-		return nil, fmt.Errorf("数据长度小于(%d)", pkgBaseLength)
+	if len(bs) < pkgBaseLength {
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("数据长度小于(%d)", pkgBaseLength))
 	}
 
 	//校验帧头
 	if bs[0] != pkgStart[0] && bs[1] != pkgStart[1] {
-		return nil, fmt.Errorf("帧头错误,预期(%x),得到(%x)", pkgStart, bs[:2])
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("帧头错误,预期(%x),得到(%x)", pkgStart, bs[:2]))
 	}
 
 	//获取总数据长度
-	length := conv.Int(bs[2:6])
+	length := int(binary.BigEndian.Uint32(bs[2:6]))
 
 	//校验总长度
 	if len(bs) != length {
-		return nil, fmt.Errorf("数据总长度错误,预期(%d),得到(%d)", length, len(bs))
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("数据总长度错误,预期(%d),得到(%d)", length, len(bs)))
 	}
 
 	//校验crc32
-	if crc1, crc2 := crc32.ChecksumIEEE(bs[:length-6]), conv.Uint32(bs[length-6:length-2]); crc1 != crc2 {
-		return nil, fmt.Errorf("数据CRC校验错误,预期(%x),得到(%x)", crc1, crc2)
+	if crc1, crc2 := crc32.ChecksumIEEE(bs[:length-6]), binary.BigEndian.Uint32(bs[length-6:length-2]); crc1 != crc2 {
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("数据CRC校验错误,预期(%x),得到(%x)", crc1, crc2))
 	}
 
 	//校验帧尾
 	if bs[length-2] != pkgEnd[0] && bs[length-1] != bs[1] {
-		return nil, fmt.Errorf("帧尾错误,预期(%x),得到(%x)", pkgEnd, bs[length-2:])
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("帧尾错误,预期(%x),得到(%x)", pkgEnd, bs[length-2:]))
 	}
 
 	p := &Pkg{
@@ -315,48 +317,71 @@ func DealWithPkg(c *Client, bs Message) (*Pkg, error) {
 	return p, nil
 }
 
+// pkgAck 没有数据的控制帧,Frame返回完整的帧
+type pkgAck struct {
+	frame []byte
+}
+
+func (this *pkgAck) Payload() []byte { return nil }
+func (this *pkgAck) Ack() error      { return nil }
+func (this *pkgAck) Frame() []byte   { return this.frame }
+
 func WriteWithPkg(req []byte) ([]byte, error) {
 	return NewPkg(0, req).Bytes(), nil
 }
 
-func ReadWithPkg(buf *bufio.Reader) (result []byte, err error) {
-	var bs []byte
+// ReadWithPkg 读取默认分包,数据从字节池获取,处理完成后可以通过ReleaseMessage归还
+func ReadWithPkg(r *bufio.Reader) ([]byte, error) {
+	ack, err := ReadAckWithPkg(r)
+	if err != nil {
+		return nil, err
+	}
+	return ack.Payload(), nil
+}
+
+// ReadAckWithPkg 读取默认分包,没有数据的控制帧(例如ping/pong)保留完整的帧,用于在帧层面判断心跳
+func ReadAckWithPkg(r *bufio.Reader) (Acker, error) {
+	var head [6]byte
 	for {
 
-		bs = make([]byte, 2)
-		n, err := buf.Read(bs)
+		n, err := r.Read(head[:2])
 		if err != nil {
-			return result, err
+			return nil, err
 		}
 
-		if n == 2 && bs[0] == pkgStart[0] && bs[1] == pkgStart[1] {
+		if n == 2 && head[0] == pkgStart[0] && head[1] == pkgStart[1] {
 			//帧头
-			result = append(result, bs...)
-
-			bs = make([]byte, 4)
-			n, err = buf.Read(bs)
+			n, err = r.Read(head[2:6])
 			if err != nil {
-				return result, err
+				return nil, err
 			}
 			if n == 4 {
 				//长度
-				length := conv.Int(bs)
-
-				if length > pkgBaseLength {
-					result = append(result, bs...)
-					length -= 6
-
-					bs = make([]byte, length)
-					_, err = io.ReadAtLeast(buf, bs, length)
-					result = append(result, bs...)
+				length := int(binary.BigEndian.Uint32(head[2:6]))
 
-					//return result, nil
+				if length >= pkgBaseLength {
+					result := buf.Get(length)
+					copy(result, head[:])
+					if _, err = io.ReadFull(r, result[6:]); err != nil {
+						buf.Put(result)
+						return nil, err
+					}
 
 					p, err := DecodePkg(result)
 					if err != nil {
+						buf.Put(result)
 						return nil, err
 					}
-					return p.Data, nil
+					if p.Control&0x30 == ControlGzip {
+						//解压后的数据是新的内存
+						buf.Put(result)
+						return Ack(p.Data), nil
+					}
+					if len(p.Data) == 0 {
+						return &pkgAck{frame: result[:length]}, nil
+					}
+					//数据移到开头,保持容量,方便归还到字节池
+					return poolAck(result[:copy(result, p.Data)]), nil
 				}
 			}
 		}
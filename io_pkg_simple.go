@@ -134,15 +134,15 @@ func NewSimple(control SimpleControl, data SimpleData, msgID ...uint8) *Simple {
 }
 
 func DecodeSimple(bs []byte) (*Simple, error) {
-	if len(bs) < 7 {
-		return nil, fmt.Errorf("数据长度小于(%d)", 7)
+	if len(bs) < 6 {
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("数据长度小于(%d)", 6))
 	}
 	if bs[0] != 0x68 {
-		return nil, fmt.Errorf("帧头错误,预期(0x68),得到(%x)", bs[0])
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("帧头错误,预期(0x68),得到(%x)", bs[0]))
 	}
 	length := conv.Int(bs[1:3])
 	if len(bs) != length+3 {
-		return nil, fmt.Errorf("数据总长度错误,预期(%d),得到(%d)", length+3, len(bs))
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("数据总长度错误,预期(%d),得到(%d)", length+3, len(bs)))
 	}
 
 	p := &Simple{
@@ -156,7 +156,7 @@ func DecodeSimple(bs []byte) (*Simple, error) {
 	}
 	sum := p.sum(bs[:len(bs)-1])
 	if sum != bs[len(bs)-1] {
-		return nil, fmt.Errorf("数据校验错误,预期(%x),得到(%x)", sum, bs[len(bs)-1])
+		return nil, NewError(ErrorKindProtocol, fmt.Errorf("数据校验错误,预期(%x),得到(%x)", sum, bs[len(bs)-1]))
 	}
 
 	data := bs[5 : len(bs)-1]
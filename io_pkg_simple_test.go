@@ -20,6 +20,24 @@ func TestDecodeSimple(t *testing.T) {
 	t.Log(p.Data.SMap())
 }
 
+// TestDecodeSimpleEmpty 没有数据的包(例如ping)是6字节,需要能解析
+func TestDecodeSimpleEmpty(t *testing.T) {
+	bs := NewSimplePing().Bytes()
+	if len(bs) != 6 {
+		t.Fatalf("预期长度(6),得到(%d)", len(bs))
+	}
+	p, err := DecodeSimple(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Control.Type != OprPing || len(p.Data) != 0 {
+		t.Fatalf("解析错误: %#v", p)
+	}
+	if _, err := DecodeSimple(bs[:5]); err == nil {
+		t.Fatal("长度不足,预期错误")
+	}
+}
+
 func TestNewSimple(t *testing.T) {
 	p := &Simple{
 		Control: SimpleControl{
@@ -74,3 +74,69 @@ func TestNewPkg(t *testing.T) {
 
 	}
 }
+
+func BenchmarkReadWithPkg(b *testing.B) {
+	bs := NewPkg(20, bytes.Repeat([]byte{1}, 512)).Bytes()
+	r := bufio.NewReader(&loopReader{data: bs})
+	for _, release := range []bool{false, true} {
+		name := "alloc"
+		if release {
+			name = "release"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg, err := ReadWithPkg(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if release {
+					ReleaseMessage(msg)
+				}
+			}
+		})
+	}
+}
+
+// loopReader 循环返回相同的数据
+type loopReader struct {
+	data   []byte
+	offset int
+}
+
+func (this *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, this.data[this.offset:])
+	this.offset = (this.offset + n) % len(this.data)
+	return n, nil
+}
+
+// TestDecodePkgEmpty 没有数据的包(例如ping/pong)长度等于基础长度,需要能解析
+func TestDecodePkgEmpty(t *testing.T) {
+	for _, bs := range [][]byte{NewPkgPing(), NewPkgPong(), NewPkg(1, nil).Bytes()} {
+		if len(bs) != pkgBaseLength {
+			t.Fatalf("预期长度(%d),得到(%d)", pkgBaseLength, len(bs))
+		}
+		p, err := DecodePkg(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Data) != 0 {
+			t.Fatalf("预期没有数据,得到(%x)", p.Data)
+		}
+		//读取时保留完整的帧
+		ack, err := ReadAckWithPkg(bufio.NewReader(bytes.NewReader(bs)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f, ok := ack.(Framer); !ok || !bytes.Equal(f.Frame(), bs) || len(ack.Payload()) != 0 {
+			t.Fatalf("预期帧(%x),得到(%#v)", bs, ack)
+		}
+	}
+	if p, err := DecodePkg(NewPkgPing()); err != nil || !p.IsPing() {
+		t.Fatalf("预期ping,得到(%v,%v)", p, err)
+	}
+	//小于基础长度
+	if _, err := DecodePkg(NewPkgPing()[:pkgBaseLength-1]); err == nil {
+		t.Fatal("长度不足,预期错误")
+	}
+}
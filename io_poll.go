@@ -0,0 +1,185 @@
+package io
+
+import (
+	"bufio"
+	"errors"
+	"github.com/injoyai/io/buf"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+
+Poll epoll模式,适用于大量空闲连接的服务端,例如NB-IoT设备
+
+默认模式每个客户端占用一个读取协程和bufio缓存,
+epoll模式只在连接可读时读取数据,数据缓存在连接的缓存中,
+通过读取函数(readFunc)解析出完整的数据后,交给中间件和dealFunc处理,
+空闲的连接不占用协程和读取缓存,写入和事件等和默认模式一致
+
+仅支持Linux的TCP连接,其他情况使用默认模式
+
+s.SetPoll(true)
+
+注意:
+1. 数据在epoll协程中处理,耗时的处理需要配合SetDispatcher使用
+2. 读取到缓存末尾时会返回错误,等待后续数据再重新解析,读取函数需要返回读取的错误
+3. 客户端的读取超时(SetTimeout)无效,可以使用服务端的超时机制
+
+*/
+
+// SetPoll 设置epoll模式,仅支持Linux的TCP连接,不支持时使用默认模式
+func (this *Server) SetPoll(enable bool) *Server {
+	this.poll = enable
+	return this
+}
+
+// errPollMore 缓存的数据不完整,等待后续数据
+var errPollMore = errors.New("poll: need more data")
+
+var pollReaderPool = sync.Pool{New: func() interface{} {
+	return bufio.NewReaderSize(nil, DefaultBufferSize+1)
+}}
+
+// run 加入epoll,不支持的连接使用默认模式
+func (this *poller) run(c *Client) {
+	if err := this.add(c); err != nil {
+		c.Run()
+	}
+}
+
+// startPoll 标记为运行中,防止重复执行Run,数据由epoll读取
+func (this *Client) startPoll() {
+	atomic.StoreUint32(&this.running, 1)
+	if this.heartbeat != nil {
+		go this.runHeartbeat()
+	}
+}
+
+// pollDecoder 连接的数据解析,缓存读取到的数据,解析出完整的数据并处理
+type pollDecoder struct {
+	c   *Client
+	buf pollBuffer
+}
+
+// deal 处理读取到的数据,返回错误会关闭连接
+func (this *pollDecoder) deal(p []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = this.c.recoverPanic(PanicRun, e)
+		}
+	}()
+	this.buf.write(p)
+	defer this.buf.shrink()
+	for this.buf.Len() > 0 && !this.c.Closed() {
+		n, e := this.decode()
+		if e != nil {
+			return e
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if this.buf.Len() > DefaultPollMaxBuffer {
+		return ErrPollBufferFull
+	}
+	return nil
+}
+
+// decode 解析一条数据并处理,返回使用的字节数,数据不完整返回0
+func (this *pollDecoder) decode() (int, error) {
+	c := this.c
+	if c.readFunc == nil {
+		return 0, ErrInvalidReadFunc
+	}
+	r := &pollReader{data: this.buf.Bytes()}
+	reader := pollReaderPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+	defer func() {
+		//数据可能引用了bufio的缓存,处理完成之后再归还
+		reader.Reset(nil)
+		pollReaderPool.Put(reader)
+	}()
+
+	ack, err := c.readFunc(reader)
+	if err != nil {
+		if errors.Is(err, errPollMore) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	//bufio会预读数据,减去未使用的部分
+	n := r.off - reader.Buffered()
+	c.recordRead(this.buf.Bytes()[:n])
+	if ack != nil {
+		if err := c.dealRead(c.Ctx(), ack); err != nil {
+			return n, err
+		}
+	}
+	this.buf.next(n)
+	return n, nil
+}
+
+// pollReader 读取缓存的数据,读取完返回errPollMore
+type pollReader struct {
+	data []byte
+	off  int
+}
+
+func (this *pollReader) Read(p []byte) (int, error) {
+	if this.off >= len(this.data) {
+		return 0, errPollMore
+	}
+	n := copy(p, this.data[this.off:])
+	this.off += n
+	return n, nil
+}
+
+// pollBuffer 连接的读取缓存,没有数据时归还到字节池,空闲连接不占用缓存
+type pollBuffer struct {
+	data []byte
+	off  int
+}
+
+// Len 未处理的字节数
+func (this *pollBuffer) Len() int {
+	return len(this.data) - this.off
+}
+
+// Bytes 未处理的数据
+func (this *pollBuffer) Bytes() []byte {
+	return this.data[this.off:]
+}
+
+func (this *pollBuffer) next(n int) {
+	this.off += n
+}
+
+func (this *pollBuffer) write(p []byte) {
+	if cap(this.data)-len(this.data) < len(p) {
+		//空间不足,移动到开头,还是不足则扩容
+		size := this.Len() + len(p)
+		data := this.data[:0]
+		if size > cap(this.data) {
+			if size < cap(this.data)*2 {
+				size = cap(this.data) * 2
+			}
+			data = buf.Get(size)[:0]
+		}
+		data = append(data, this.Bytes()...)
+		if cap(data) != cap(this.data) {
+			buf.Put(this.data)
+		}
+		this.data, this.off = data, 0
+	}
+	this.data = append(this.data, p...)
+}
+
+// shrink 数据处理完时归还缓存
+func (this *pollBuffer) shrink() {
+	if this.Len() == 0 && this.data != nil {
+		buf.Put(this.data)
+		this.data, this.off = nil, 0
+	}
+}
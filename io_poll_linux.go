@@ -0,0 +1,196 @@
+//go:build linux
+
+package io
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// newPoller 新建epoll,连接按文件描述符分配到num个epoll协程
+func newPoller(num int) (*poller, error) {
+	if num <= 0 {
+		num = 1
+	}
+	p := &poller{}
+	for i := 0; i < num; i++ {
+		e, err := newEpoll()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.list = append(p.list, e)
+	}
+	return p, nil
+}
+
+type poller struct {
+	list []*epoll
+}
+
+// add 加入epoll,只支持实现了syscall.Conn的连接,例如*net.TCPConn
+func (this *poller) add(c *Client) error {
+	sc, ok := c.i.(syscall.Conn)
+	if !ok {
+		return ErrPollUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	if err := rc.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return err
+	}
+	return this.list[fd%len(this.list)].add(c, rc, fd)
+}
+
+// Close 关闭所有epoll协程,已加入的连接没有协程读取,会被关闭(ErrPollClosed)
+func (this *poller) Close() error {
+	for _, e := range this.list {
+		e.Close()
+	}
+	return nil
+}
+
+func newEpoll() (*epoll, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	e := &epoll{
+		fd:    fd,
+		conns: make(map[int]*pollConn),
+		buf:   make([]byte, DefaultPollReadSize),
+	}
+	go e.run()
+	return e, nil
+}
+
+type epoll struct {
+	fd     int
+	closed uint32
+	mu     sync.RWMutex
+	conns  map[int]*pollConn
+	buf    []byte //读取缓存,只在epoll协程中使用
+}
+
+func (this *epoll) add(c *Client, rc syscall.RawConn, fd int) error {
+	pc := &pollConn{pollDecoder: pollDecoder{c: c}, e: this, fd: fd, rc: rc}
+	this.mu.Lock()
+	if atomic.LoadUint32(&this.closed) == 1 {
+		this.mu.Unlock()
+		return ErrPollClosed
+	}
+	this.conns[fd] = pc
+	this.mu.Unlock()
+	c.poll.Store(pc)
+	//连接函数(例如读取注册数据)可能预读了后续的数据,先处理,之后不再使用buf
+	if c.buf != nil {
+		//记录已经使用的数据,缓存的数据交给epoll按帧记录
+		c.recordFlush()
+		if n := c.buf.Buffered(); n > 0 {
+			p, _ := c.buf.Peek(n)
+			if err := pc.deal(p); err != nil {
+				c.buf = nil
+				_ = c.CloseWithErr(err)
+				return nil
+			}
+		}
+		c.buf = nil
+	}
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+	if err := syscall.EpollCtl(this.fd, syscall.EPOLL_CTL_ADD, fd, event); err != nil {
+		c.poll.Store((*pollConn)(nil))
+		pc.remove()
+		return err
+	}
+	c.startPoll()
+	//加入的过程中被关闭了
+	if c.Closed() {
+		pc.remove()
+	}
+	return nil
+}
+
+// Close 关闭,epoll协程在下次等待超时后退出,关闭已加入的连接
+func (this *epoll) Close() error {
+	this.mu.Lock()
+	atomic.StoreUint32(&this.closed, 1)
+	list := make([]*pollConn, 0, len(this.conns))
+	for _, pc := range this.conns {
+		list = append(list, pc)
+	}
+	this.mu.Unlock()
+	//关闭时会取消监听(remove),不能持有锁
+	for _, pc := range list {
+		_ = pc.c.CloseWithErr(ErrPollClosed)
+	}
+	return nil
+}
+
+func (this *epoll) run() {
+	defer syscall.Close(this.fd)
+	events := make([]syscall.EpollEvent, 128)
+	for atomic.LoadUint32(&this.closed) == 0 {
+		n, err := syscall.EpollWait(this.fd, events, 1000)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		for i := 0; i < n; i++ {
+			this.mu.RLock()
+			pc := this.conns[int(events[i].Fd)]
+			this.mu.RUnlock()
+			if pc != nil {
+				pc.read(this.buf)
+			}
+		}
+	}
+}
+
+// pollConn epoll模式的连接
+type pollConn struct {
+	pollDecoder
+	e  *epoll
+	fd int
+	rc syscall.RawConn
+}
+
+// read 连接可读时读取数据并处理,读取不会阻塞
+func (this *pollConn) read(p []byte) {
+	n := 0
+	var err error
+	if e := this.rc.Read(func(fd uintptr) bool {
+		n, err = syscall.Read(int(fd), p)
+		return true
+	}); e != nil {
+		err = e
+	}
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+		return
+	case err != nil:
+	case n == 0:
+		err = io.EOF
+	default:
+		err = this.deal(p[:n])
+	}
+	if err != nil {
+		_ = this.c.CloseWithErr(err)
+	}
+}
+
+// remove 取消监听,需要在关闭连接之前执行,否则文件描述符可能被复用
+func (this *pollConn) remove() {
+	this.e.mu.Lock()
+	defer this.e.mu.Unlock()
+	if this.e.conns[this.fd] == this {
+		delete(this.e.conns, this.fd)
+		_ = syscall.EpollCtl(this.e.fd, syscall.EPOLL_CTL_DEL, this.fd, nil)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package io
+
+// newPoller 仅支持Linux
+func newPoller(num int) (*poller, error) {
+	return nil, ErrPollUnsupported
+}
+
+type poller struct{}
+
+func (this *poller) add(c *Client) error {
+	return ErrPollUnsupported
+}
+
+func (this *poller) Close() error {
+	return nil
+}
+
+// pollConn epoll模式的连接
+type pollConn struct {
+	pollDecoder
+}
+
+func (this *pollConn) remove() {}
@@ -0,0 +1,307 @@
+package io
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollDecoder(t *testing.T) {
+	c, _ := newPipeClient(t)
+	c.SetReadWriteWithPkg()
+	var result []string
+	c.SetDealFunc(func(c *Client, msg Message) {
+		result = append(result, msg.String())
+	})
+	list := []string{"a", "bc", strings.Repeat("d", 5000)}
+	var data []byte
+	for _, s := range list {
+		bs, err := c.encodeWrite([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, bs...)
+	}
+
+	d := &pollDecoder{c: c}
+	check := func(n int) {
+		t.Helper()
+		if len(result) != n {
+			t.Fatalf("预期%d条数据,得到(%d)", n, len(result))
+		}
+		for i, v := range result {
+			if v != list[i%len(list)] {
+				t.Fatalf("数据错误(%s)", v)
+			}
+		}
+		if d.buf.data != nil {
+			t.Fatal("处理完成后应该归还缓存")
+		}
+	}
+
+	//拆包
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := d.deal(data[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(3)
+
+	//粘包
+	if err := d.deal(append(append([]byte(nil), data...), data...)); err != nil {
+		t.Fatal(err)
+	}
+	check(9)
+}
+
+func TestPollDecoderLine(t *testing.T) {
+	c, _ := newPipeClient(t)
+	c.SetReadFunc(func(buf *bufio.Reader) ([]byte, error) {
+		return buf.ReadBytes('\n')
+	})
+	var result []string
+	c.SetDealFunc(func(c *Client, msg Message) {
+		result = append(result, msg.String())
+	})
+	d := &pollDecoder{c: c}
+	for _, s := range []string{"ab", "c\nd", "\n", "ef"} {
+		if err := d.deal([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(result, "") != "abc\nd\n" || d.buf.Len() != 2 {
+		t.Fatalf("数据错误(%q),剩余(%d)", result, d.buf.Len())
+	}
+}
+
+// newPollServer 新建epoll模式的服务端,回复收到的数据
+func newPollServer(t testing.TB, poll bool, options ...OptionServer) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(func() (Listener, error) { return &testListener{l}, nil }, func(s *Server) {
+		s.Debug(false)
+		s.SetPoll(poll)
+		s.ClientManage.SetOptions(func(c *Client) {
+			c.SetReadWriteWithPkg()
+		})
+		s.SetDealFunc(func(c *Client, msg Message) {
+			c.Write(msg)
+		})
+		s.SetOptions(options...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	go s.Run()
+	return s, l.Addr().String()
+}
+
+// waitClientLen 等待服务端的客户端数量
+func waitClientLen(t testing.TB, s *Server, n int) {
+	deadline := time.Now().Add(time.Second * 10)
+	for s.GetClientLen() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("预期%d个客户端,得到(%d)", n, s.GetClientLen())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestServerPoll(t *testing.T) {
+	s, addr := newPollServer(t, true)
+
+	var clients []*Client
+	for i := 0; i < 10; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewClient(conn, func(c *Client) {
+			c.Debug(false)
+			c.SetReadWriteWithPkg()
+		})
+		defer c.CloseAll()
+		clients = append(clients, c)
+	}
+	waitClientLen(t, s, 10)
+
+	if runtime.GOOS == "linux" {
+		for _, c := range s.CopyClientMap() {
+			for i := 0; !c.Running() && i < 100; i++ {
+				time.Sleep(time.Millisecond * 10)
+			}
+			if pc, _ := c.poll.Load().(*pollConn); pc == nil {
+				t.Fatal("预期使用epoll模式")
+			}
+		}
+	}
+
+	for i, c := range clients {
+		msg := strings.Repeat("x", i*1000+1)
+		if _, err := c.WriteString(msg); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != msg {
+			t.Fatalf("数据错误,长度(%d)", len(resp))
+		}
+	}
+
+	//客户端关闭
+	clients[0].CloseAll()
+	waitClientLen(t, s, 9)
+
+	//服务端关闭
+	var c *Client
+	s.RangeClient(func(key string, v *Client) bool {
+		c = v
+		return false
+	})
+	c.CloseAll()
+	waitClientLen(t, s, 8)
+}
+
+// TestServerPollBuffered 连接函数读取注册数据时预读了后续的数据,加入epoll后需要处理
+func TestServerPollBuffered(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		_, addr := newPollServer(t, poll, func(s *Server) {
+			s.SetConnectFunc(func(c *Client) error {
+				msg, err := c.ReadMessage()
+				if err != nil {
+					return err
+				}
+				c.SetKey(string(msg))
+				return nil
+			})
+		})
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewClient(conn, func(c *Client) {
+			c.Debug(false)
+			c.SetReadWriteWithPkg()
+		})
+		//注册数据和后续的数据在同一次写入
+		register, _ := WriteWithPkg([]byte("register"))
+		first, _ := WriteWithPkg([]byte("first"))
+		if _, err := conn.Write(append(register, first...)); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != "first" {
+			t.Fatalf("epoll(%v) 预期(first),得到(%s)", poll, resp)
+		}
+		c.CloseAll()
+	}
+}
+
+// benchmarkIdle 空闲连接的内存占用,包括客户端的连接
+func benchmarkIdle(b *testing.B, poll bool) {
+	const num = 1000
+	for i := 0; i < b.N; i++ {
+		s, addr := newPollServer(b, poll)
+		usage := func() uint64 {
+			runtime.GC()
+			m := runtime.MemStats{}
+			runtime.ReadMemStats(&m)
+			return m.HeapInuse + m.StackInuse
+		}
+		before := usage()
+		conns := make([]net.Conn, 0, num)
+		for j := 0; j < num; j++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			conns = append(conns, conn)
+		}
+		waitClientLen(b, s, num)
+		b.ReportMetric(float64(usage()-before)/num, "B/conn")
+		for _, conn := range conns {
+			conn.Close()
+		}
+		s.Close()
+	}
+}
+
+// BenchmarkIdle 默认模式空闲连接的内存占用
+func BenchmarkIdle(b *testing.B) {
+	benchmarkIdle(b, false)
+}
+
+// BenchmarkIdlePoll epoll模式空闲连接的内存占用
+func BenchmarkIdlePoll(b *testing.B) {
+	benchmarkIdle(b, true)
+}
+
+// TestPollClose 关闭epoll时关闭已加入的连接,之后不能再加入
+func TestPollClose(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("仅支持Linux")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	p, err := newPoller(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConn := func() (*Client, net.Conn) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		peer, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewClient(peer, func(c *Client) { c.Debug(false) })
+		t.Cleanup(func() { c.CloseAll() })
+		return c, conn
+	}
+
+	c, conn := newConn()
+	if err := p.add(c); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	select {
+	case <-c.Done():
+		if c.Err() != ErrPollClosed {
+			t.Errorf("预期(%v),得到(%v)", ErrPollClosed, c.Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("关闭epoll未关闭连接")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Fatalf("预期对端连接被关闭,得到(%v)", err)
+	}
+
+	c, _ = newConn()
+	if err := p.add(c); err != ErrPollClosed {
+		t.Fatalf("预期(%v),得到(%v)", ErrPollClosed, err)
+	}
+}
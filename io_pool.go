@@ -49,12 +49,17 @@ func (this *Pool) Get() (c *Client, _ error) {
 			go c.Run()
 		}
 	}()
-	this.mu.RLock()
-	for _, v := range this.pool {
-		this.mu.RUnlock()
+	this.mu.Lock()
+	for k, v := range this.pool {
+		//移除已经关闭的客户端
+		if v.Closed() {
+			delete(this.pool, k)
+			continue
+		}
+		this.mu.Unlock()
 		return v, nil
 	}
-	this.mu.RUnlock()
+	this.mu.Unlock()
 	return this.new()
 }
 
@@ -96,10 +101,13 @@ func (this *Pool) WriteAny(any interface{}) (int, error) {
 
 // Close 实现io.Closer接口
 func (this *Pool) Close() error {
-	for _, v := range this.pool {
+	this.mu.Lock()
+	pool := this.pool
+	this.pool = make(map[string]*Client)
+	this.mu.Unlock()
+	for _, v := range pool {
 		v.CloseAll()
 	}
-	this.pool = make(map[string]*Client)
 	return nil
 }
 
@@ -49,6 +49,11 @@ type Acker interface {
 	Ack() error
 }
 
+// Framer 读取的数据可以提供完整的数据帧,例如没有数据的控制帧,用于在帧层面判断心跳
+type Framer interface {
+	Frame() []byte
+}
+
 //===============================MReader===============================
 
 // MReader 读取分包后的数据
@@ -0,0 +1,189 @@
+package io
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	RecordRead  = "read"  //接收的数据
+	RecordWrite = "write" //发送的数据
+)
+
+// Record 一条通讯记录,按行保存为json
+type Record struct {
+	Time time.Time `json:"time"` //时间
+	Type string    `json:"type"` //方向,read或write
+	Key  string    `json:"key"`  //客户端标识
+	Data string    `json:"data"` //数据,16进制
+}
+
+// Bytes 记录的原始数据
+func (this *Record) Bytes() []byte {
+	bs, _ := hex.DecodeString(this.Data)
+	return bs
+}
+
+// NewRecorder 新建记录器,记录客户端的原始收发数据,每行一条json记录
+func NewRecorder(w Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// NewRecorderWithFile 新建记录器,记录到文件,追加写入
+func NewRecorderWithFile(filename string) (*Recorder, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f), nil
+}
+
+// NewRecorderWithFunc 新建记录器,原始收发数据交给函数处理,例如转换成其他格式(pcapng),
+// Type是RecordRead或RecordWrite,p只在函数内有效,需要保留的话自行复制
+func NewRecorderWithFunc(fn func(c *Client, Type string, p []byte)) *Recorder {
+	return &Recorder{fn: fn}
+}
+
+// Recorder 通讯记录器,可以用dial.Replay回放
+type Recorder struct {
+	mu sync.Mutex
+	w  Writer
+	fn func(c *Client, Type string, p []byte)
+}
+
+// Record 写入一条记录
+func (this *Recorder) Record(r *Record) error {
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.w == nil {
+		return nil
+	}
+	_, err = this.w.Write(append(bs, '\n'))
+	return err
+}
+
+func (this *Recorder) record(c *Client, Type string, p []byte) {
+	if this.fn != nil {
+		this.fn(c, Type, p)
+		return
+	}
+	_ = this.Record(&Record{
+		Time: time.Now(),
+		Type: Type,
+		Key:  c.GetKey(),
+		Data: hex.EncodeToString(p),
+	})
+}
+
+// Close 关闭记录器
+func (this *Recorder) Close() error {
+	if c, ok := this.w.(Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ReadRecords 读取所有记录
+func ReadRecords(r Reader) ([]*Record, error) {
+	list := []*Record(nil)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*MB)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := new(Record)
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, err
+		}
+		list = append(list, record)
+	}
+	return list, scanner.Err()
+}
+
+// ReadRecordsWithFile 读取文件的所有记录
+func ReadRecordsWithFile(filename string) ([]*Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecords(f)
+}
+
+// recordReader 缓存读取到的原始数据,读取函数分包之后按帧记录(recordFlush),
+// 直接读取(Read,ReadByte,Read1KB,Copy)的数据每次读取后记录
+type recordReader struct {
+	c       *Client
+	r       Reader
+	pending []byte //已读取,还未记录的数据
+}
+
+func (this *recordReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	if n > 0 && this.c.recorder != nil {
+		this.pending = append(this.pending, p[:n]...)
+	}
+	return n, err
+}
+
+//================================Client================================
+
+// SetRecorder 设置记录器,记录原始收发数据,
+// 接收的数据按读取函数的分包记录,每帧一条(分包前的原始数据),写入的数据是封装后的数据,
+// epoll模式(SetPoll)的客户端同样按帧记录
+func (this *Client) SetRecorder(r *Recorder) *Client {
+	if this.recorder == nil && r != nil {
+		rr := &recordReader{c: this, r: this.reader}
+		switch {
+		case this.buf == nil:
+			//buf在第一次读取时新建
+		case this.buf.Buffered() == 0:
+			this.buf.Reset(rr)
+		default:
+			//保留已缓存的数据
+			rr.r = this.buf
+			this.buf = bufio.NewReaderSize(rr, this.buf.Size())
+		}
+		this.reader = rr
+		this.recordBuf = rr
+	}
+	this.recorder = r
+	return this
+}
+
+// recordFlush 记录读取函数已经使用的数据(一帧),bufio预读的数据留到下一帧
+func (this *Client) recordFlush() {
+	rr := this.recordBuf
+	if rr == nil || this.buf == nil {
+		return
+	}
+	n := len(rr.pending) - this.buf.Buffered()
+	if n <= 0 {
+		return
+	}
+	this.recordRead(rr.pending[:n])
+	rr.pending = rr.pending[:copy(rr.pending, rr.pending[n:])]
+}
+
+// recordRead 记录接收的一帧数据
+func (this *Client) recordRead(p []byte) {
+	if this.recorder != nil && len(p) > 0 {
+		this.recorder.record(this, RecordRead, p)
+	}
+}
+
+//================================ClientManage================================
+
+// SetRecorder 设置客户端的记录器,所有客户端记录到同一个记录器
+func (this *ClientManage) SetRecorder(r *Recorder) {
+	this.SetOptions(func(c *Client) { c.SetRecorder(r) })
+}
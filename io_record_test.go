@@ -0,0 +1,156 @@
+package io
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	a, b := newPipeClient(t)
+	buf := bytes.NewBuffer(nil)
+	r := NewRecorder(buf)
+	a.SetKey("a")
+	a.SetRecorder(r)
+	a.SetReadWriteWithPkg()
+	b.SetReadWriteWithPkg()
+	received := make(chan Message, 1)
+	a.SetDealFunc(func(c *Client, msg Message) { received <- msg })
+	b.SetDealFunc(func(c *Client, msg Message) { c.Write(msg) })
+	go a.Run()
+	go b.Run()
+
+	a.WriteString("hello")
+	select {
+	case msg := <-received:
+		if msg.String() != "hello" {
+			t.Fatalf("预期(hello),得到(%s)", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到数据")
+	}
+
+	list, err := ReadRecords(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("预期2条记录,得到(%d)", len(list))
+	}
+	for i, Type := range []string{RecordWrite, RecordRead} {
+		if list[i].Type != Type || list[i].Key != "a" {
+			t.Errorf("记录错误: %#v", list[i])
+		}
+		//记录的是封装后的原始数据
+		p, err := DecodePkg(list[i].Bytes())
+		if err != nil || string(p.Data) != "hello" {
+			t.Errorf("记录数据错误: %s, %v", list[i].Data, err)
+		}
+	}
+}
+
+// TestRecorderRead 直接读取的数据也会记录,不会一直缓存
+func TestRecorderRead(t *testing.T) {
+	a, b := newPipeClient(t)
+	buf := bytes.NewBuffer(nil)
+	a.SetRecorder(NewRecorder(buf))
+	go b.WriteString("hello world")
+
+	got := []byte(nil)
+	for len(got) < len("hello world") {
+		p := make([]byte, 4)
+		n, err := a.Read(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p[:n]...)
+		//只缓存还未读取的数据
+		if len(a.recordBuf.pending) != a.buf.Buffered() {
+			t.Fatalf("读取之后未记录的数据(%s)", a.recordBuf.pending)
+		}
+	}
+
+	list, err := ReadRecords(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := []byte(nil)
+	for _, v := range list {
+		recorded = append(recorded, v.Bytes()...)
+	}
+	if string(recorded) != "hello world" {
+		t.Fatalf("预期记录(hello world),得到(%s)", recorded)
+	}
+}
+
+// lockBuffer 并发安全的缓存,服务端的客户端在不同协程记录
+type lockBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (this *lockBuffer) Write(p []byte) (int, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.buf.Write(p)
+}
+
+func (this *lockBuffer) Bytes() []byte {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]byte(nil), this.buf.Bytes()...)
+}
+
+// TestRecorderFrame 接收的数据按帧记录,包括epoll模式
+func TestRecorderFrame(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		buf := &lockBuffer{}
+		_, addr := newPollServer(t, poll, func(s *Server) {
+			s.SetRecorder(NewRecorder(buf))
+		})
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewClient(conn, func(c *Client) {
+			c.Debug(false)
+			c.SetReadWriteWithPkg()
+		})
+		//两帧数据在同一次写入
+		first, _ := WriteWithPkg([]byte("first"))
+		second, _ := WriteWithPkg([]byte("second"))
+		if _, err := conn.Write(append(first, second...)); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"first", "second"} {
+			resp, err := c.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(resp) != want {
+				t.Fatalf("epoll(%v) 预期(%s),得到(%s)", poll, want, resp)
+			}
+		}
+		c.CloseAll()
+
+		list, err := ReadRecords(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reads := []string(nil)
+		for _, v := range list {
+			if v.Type == RecordRead {
+				p, err := DecodePkg(v.Bytes())
+				if err != nil {
+					t.Fatalf("epoll(%v) 记录的不是完整的帧: %s", poll, v.Data)
+				}
+				reads = append(reads, string(p.Data))
+			}
+		}
+		if len(reads) != 2 || reads[0] != "first" || reads[1] != "second" {
+			t.Fatalf("epoll(%v) 预期按帧记录(first,second),得到(%v)", poll, reads)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/injoyai/base/maps"
 	"github.com/injoyai/base/safe"
+	"runtime"
 	"sync/atomic"
 	"time"
 )
@@ -67,6 +68,7 @@ type Server struct {
 	tag       *maps.Safe //tag
 	listener  Listener   //listener
 	running   uint32     //是否在运行
+	poll      bool       //是否使用epoll模式
 	startTime time.Time  //运行时间
 	closeTime time.Time  //关闭时间
 }
@@ -111,6 +113,7 @@ func (this *Server) Close() error {
 	return this.Closer.Close()
 }
 
+// SetCloseFunc 设置客户端的关闭函数,客户端从管理中移除之后执行
 func (this *Server) SetCloseFunc(fn func(c *Client, err error)) *Server {
 	this.ClientManage.SetOptions(func(c *Client) {
 		c.SetCloseFunc(func(ctx context.Context, c *Client, err error) {
@@ -162,6 +165,18 @@ func (this *Server) Run() error {
 	this.startTime = time.Now()
 	this.Infof("[%s] 开启服务成功...\n", this.GetKey())
 
+	//epoll模式,不支持时使用默认模式
+	run := func(c *Client) { c.Run() }
+	if this.poll {
+		p, err := newPoller(runtime.NumCPU())
+		if err != nil {
+			this.Errorf("[%s] 开启epoll模式失败,使用默认模式: %v\n", this.GetKey(), err)
+		} else {
+			defer p.Close()
+			run = p.run
+		}
+	}
+
 	//执行监听连接
 	for {
 		select {
@@ -183,7 +198,7 @@ func (this *Server) Run() error {
 		x.SetLogger(this.logger)
 		x.SetKey(key)
 		x.Tag().Set("address", key)
-		this.ClientManage.SetClient(x)
+		this.ClientManage.setClient(x, run)
 
 	}
 }
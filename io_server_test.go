@@ -0,0 +1,46 @@
+package io
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestServerCloseFunc 服务端设置的关闭函数,在客户端从管理中移除之后执行
+func TestServerCloseFunc(t *testing.T) {
+	type event struct {
+		key     string
+		removed bool
+	}
+	closed := make(chan event, 1)
+	var s *Server
+	s, addr := newPollServer(t, false, func(s *Server) {
+		s.SetCloseFunc(func(c *Client, err error) {
+			closed <- event{c.GetKey(), s.GetClient(c.GetKey()) == nil}
+		})
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitClientLen(t, s, 1)
+	key := ""
+	s.RangeClient(func(k string, c *Client) bool {
+		key = k
+		return false
+	})
+	conn.Close()
+
+	select {
+	case e := <-closed:
+		if e.key != key {
+			t.Fatalf("预期客户端(%s),得到(%s)", key, e.key)
+		}
+		if !e.removed {
+			t.Fatal("执行关闭函数时,客户端应该已经从管理中移除")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("未执行服务端设置的关闭函数")
+	}
+	waitClientLen(t, s, 0)
+}
@@ -0,0 +1,379 @@
+package io
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/injoyai/base/maps"
+	"github.com/injoyai/conv"
+	"sync"
+	"time"
+)
+
+/*
+
+会话帧(在用户的分包协议之内):
+类型 1字节
+序号 4字节(大端)
+数据 n字节
+
+握手:
+1. 客户端连接成功后发送hello(已接收序号,会话ID,恢复令牌),首次连接会话ID为空
+2. 服务端校验令牌,恢复会话(标识,标签,未确认数据)或新建会话,响应welcome(已接收序号,会话ID,恢复令牌)
+3. 双方补发对方未接收的数据,之后每收到一帧数据响应一次确认
+
+*/
+
+const (
+	sessionRaw     = 0x00 //原始数据,不需要确认,例如心跳
+	sessionData    = 0x01 //数据,需要确认
+	sessionAck     = 0x02 //确认
+	sessionHello   = 0x03 //请求恢复会话
+	sessionWelcome = 0x04 //响应会话信息
+)
+
+func encodeSession(Type uint8, seq uint32, data []byte) []byte {
+	bs := make([]byte, 5+len(data))
+	bs[0] = Type
+	binary.BigEndian.PutUint32(bs[1:5], seq)
+	copy(bs[5:], data)
+	return bs
+}
+
+func encodeSessionToken(id, token string) []byte {
+	bs := []byte{byte(len(id))}
+	bs = append(bs, id...)
+	bs = append(bs, byte(len(token)))
+	bs = append(bs, token...)
+	return bs
+}
+
+func decodeSessionToken(bs []byte) (id, token string) {
+	if len(bs) == 0 || len(bs) < 1+int(bs[0]) {
+		return
+	}
+	id, bs = string(bs[1:1+bs[0]]), bs[1+bs[0]:]
+	if len(bs) == 0 || len(bs) < 1+int(bs[0]) {
+		return
+	}
+	token = string(bs[1 : 1+bs[0]])
+	return
+}
+
+func newSessionID() string {
+	bs := make([]byte, 16)
+	_, _ = rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+type sessionFrame struct {
+	seq  uint32
+	data []byte
+}
+
+func newSession(max int) *session {
+	return &session{max: max, activeTime: time.Now()}
+}
+
+// session 会话状态,重连时不会重置
+type session struct {
+	mu         sync.Mutex     //状态锁
+	wmu        sync.Mutex     //发送锁,保证数据按序号顺序发送
+	id         string         //会话ID
+	token      string         //恢复令牌
+	sendSeq    uint32         //最后发送的序号
+	recvSeq    uint32         //最后接收的序号
+	unacked    []sessionFrame //未确认的数据
+	ready      bool           //当前连接是否握手完成
+	max        int            //最大缓存数量
+	activeTime time.Time      //最后活跃时间
+	client     *Client        //当前绑定的客户端(服务端)
+	store      *sessionStore  //会话存储(服务端)
+}
+
+// prune 删除已确认的数据
+func (this *session) prune(seq uint32) {
+	i := 0
+	for ; i < len(this.unacked) && this.unacked[i].seq <= seq; i++ {
+	}
+	this.unacked = this.unacked[i:]
+}
+
+// write 缓存数据,握手完成则发送,断开期间的数据等待重连后补发
+// 服务端的客户端断开后会从管理中移除,会话未过期时仍可以写入,恢复会话后通过新的客户端发送
+func (this *session) write(c *Client, p []byte) (int, error) {
+	if this.store == nil {
+		select {
+		case <-c.DoneAll():
+			return 0, c.Err()
+		default:
+		}
+	} else if this.store.expired(this) {
+		return 0, ErrSessionExpired
+	}
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	this.mu.Lock()
+	if len(this.unacked) >= this.max {
+		this.mu.Unlock()
+		return 0, ErrSessionBufferFull
+	}
+	this.sendSeq++
+	f := sessionFrame{seq: this.sendSeq, data: append([]byte(nil), p...)}
+	this.unacked = append(this.unacked, f)
+	ready := this.ready
+	if this.client != nil {
+		//服务端,写入当前绑定的客户端,可能是恢复会话后的新客户端
+		c = this.client
+	}
+	this.mu.Unlock()
+	if ready {
+		//写入失败也已经缓存,重连后补发,连接断开时会等待写入完成再取消就绪(detach)
+		_, _ = c.write(encodeSession(sessionData, f.seq, f.data))
+	}
+	return len(p), nil
+}
+
+// replay 握手完成,发送响应(可选)并补发对方未接收的数据
+func (this *session) replay(c *Client, peerRecv uint32, welcome bool) {
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	this.mu.Lock()
+	this.prune(peerRecv)
+	frames := append([]sessionFrame(nil), this.unacked...)
+	recv, id, token := this.recvSeq, this.id, this.token
+	this.ready = true
+	this.mu.Unlock()
+	if welcome {
+		if _, err := c.write(encodeSession(sessionWelcome, recv, encodeSessionToken(id, token))); err != nil {
+			return
+		}
+	}
+	for _, f := range frames {
+		if _, err := c.write(encodeSession(sessionData, f.seq, f.data)); err != nil {
+			return
+		}
+	}
+}
+
+// detach 连接断开,等待正在写入的数据完成,取消就绪,之后的数据缓存到重连后补发
+// 服务端的会话可能已经恢复到新的客户端,老客户端断开时不处理
+func (this *session) detach(c *Client) {
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.client == nil || this.client == c {
+		this.ready = false
+	}
+}
+
+// hello 发送握手数据,请求恢复会话
+func (this *session) hello(c *Client) error {
+	this.mu.Lock()
+	this.ready = false
+	bs := encodeSession(sessionHello, this.recvSeq, encodeSessionToken(this.id, this.token))
+	this.mu.Unlock()
+	_, err := this.send(c, bs)
+	return err
+}
+
+// send 写入控制数据(握手,确认,原始数据),和数据使用同一个发送锁,
+// 会话的数据会在多个协程写入(用户,读取协程,重连协程)
+func (this *session) send(c *Client, p []byte) (int, error) {
+	this.wmu.Lock()
+	defer this.wmu.Unlock()
+	return c.write(p)
+}
+
+func newSessionStore(timeout time.Duration) *sessionStore {
+	return &sessionStore{timeout: timeout, m: maps.NewSafe()}
+}
+
+// sessionStore 服务端的会话存储
+type sessionStore struct {
+	timeout time.Duration
+	m       *maps.Safe
+}
+
+// expired 会话是否过期,断开连接并且超过保留时间
+func (this *sessionStore) expired(s *session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return (s.client == nil || s.client.Closed()) && time.Since(s.activeTime) > this.timeout
+}
+
+// get 根据会话ID和令牌获取会话
+func (this *sessionStore) get(id, token string) *session {
+	v, ok := this.m.Get(id)
+	if !ok || len(id) == 0 {
+		return nil
+	}
+	s := v.(*session)
+	if subtle.ConstantTimeCompare([]byte(s.token), []byte(token)) != 1 {
+		return nil
+	}
+	if this.expired(s) {
+		this.m.Del(id)
+		return nil
+	}
+	return s
+}
+
+// set 新增会话,并清理过期的会话
+func (this *sessionStore) set(s *session) {
+	this.m.Range(func(key, value interface{}) bool {
+		if this.expired(value.(*session)) {
+			this.m.Del(key)
+		}
+		return true
+	})
+	this.m.Set(s.id, s)
+}
+
+//================================Client================================
+
+// SetSession 开启会话(客户端),需要服务端也开启会话
+// 重连后恢复会话,断开期间写入的数据会缓存,重连成功后按顺序补发,标签也会保留
+func (this *Client) SetSession() *Client {
+	if this.session == nil {
+		this.session = newSession(DefaultSessionBuffer)
+	}
+	return this.SetConnectFunc(func(c *Client) error {
+		return c.session.hello(c)
+	})
+}
+
+// SessionID 会话ID,未开启或未握手时为空
+func (this *Client) SessionID() string {
+	s := this.getSession()
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// getSession 获取会话,服务端恢复会话时会替换
+func (this *Client) getSession() *session {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.session
+}
+
+// writeRaw 写入不需要确认的数据,例如心跳
+func (this *Client) writeRaw(p []byte) (int, error) {
+	if s := this.getSession(); s != nil {
+		return s.send(this, encodeSession(sessionRaw, 0, p))
+	}
+	return this.write(p)
+}
+
+// dealSession 处理会话数据,返回需要交给用户处理的数据
+func (this *Client) dealSession(msg Message) (Message, bool) {
+	s := this.session
+	if len(msg) < 5 {
+		return msg, true
+	}
+	seq := binary.BigEndian.Uint32(msg[1:5])
+	data := msg[5:]
+
+	s.mu.Lock()
+	s.activeTime = time.Now()
+	s.mu.Unlock()
+
+	switch msg[0] {
+	case sessionRaw:
+		return data, true
+
+	case sessionData:
+		s.mu.Lock()
+		repeat := seq <= s.recvSeq
+		if !repeat {
+			s.recvSeq = seq
+		}
+		recv := s.recvSeq
+		s.mu.Unlock()
+		_, _ = s.send(this, encodeSession(sessionAck, recv, nil))
+		return data, !repeat
+
+	case sessionAck:
+		s.mu.Lock()
+		s.prune(seq)
+		s.mu.Unlock()
+
+	case sessionHello:
+		if s.store != nil {
+			id, token := decodeSessionToken(data)
+			this.resumeSession(id, token, seq)
+		}
+
+	case sessionWelcome:
+		id, token := decodeSessionToken(data)
+		s.mu.Lock()
+		if s.id != id {
+			//服务端新建了会话,重新计算接收序号
+			s.recvSeq = 0
+		}
+		s.id, s.token = id, token
+		s.mu.Unlock()
+		s.replay(this, seq, false)
+
+	default:
+		return msg, true
+
+	}
+	return nil, false
+}
+
+// resumeSession 服务端恢复会话,恢复失败则新建会话
+func (this *Client) resumeSession(id, token string, peerRecv uint32) {
+	store := this.session.store
+	if old := store.get(id, token); old != nil && old != this.session {
+		//恢复会话,继承老客户端的标识和标签
+		old.mu.Lock()
+		prev := old.client
+		old.client = this
+		old.mu.Unlock()
+		if prev != nil {
+			tag := prev.Tag()
+			this.Tag().Range(func(key, value interface{}) bool {
+				tag.Set(key, value)
+				return true
+			})
+			this.mu.Lock()
+			this.tag = tag
+			this.mu.Unlock()
+			this.SetKey(prev.GetKey())
+		}
+		this.mu.Lock()
+		this.session = old
+		this.mu.Unlock()
+		this.logf(LevelInfo, nil, "恢复会话(%s)", id)
+	} else if old == nil {
+		//新建会话
+		s := this.session
+		s.mu.Lock()
+		s.id, s.token = newSessionID(), newSessionID()
+		s.mu.Unlock()
+		store.set(s)
+		peerRecv = 0
+	}
+	this.session.replay(this, peerRecv, true)
+}
+
+//================================ClientManage================================
+
+// SetSession 开启会话(服务端),客户端重连后恢复到同一个客户端(标识,标签),
+// 恢复后管理中的是新的客户端,之前的客户端仍可以写入,数据通过会话发送到新的客户端,
+// 断开期间写入的数据会缓存,恢复后补发,断开超过timeout(默认DefaultSessionTimeout)的会话会被清理,之后写入返回ErrSessionExpired
+func (this *ClientManage) SetSession(timeout ...time.Duration) {
+	store := newSessionStore(conv.DefaultDuration(DefaultSessionTimeout, timeout...))
+	this.SetOptions(func(c *Client) {
+		c.session = newSession(DefaultSessionBuffer)
+		c.session.store = store
+		c.session.client = c
+	})
+}
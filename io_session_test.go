@@ -0,0 +1,215 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+type testListener struct{ net.Listener }
+
+func (this *testListener) Accept() (ReadWriteCloser, string, error) {
+	c, err := this.Listener.Accept()
+	if err != nil {
+		return nil, "", err
+	}
+	return c, c.RemoteAddr().String(), nil
+}
+
+func (this *testListener) Addr() string { return this.Listener.Addr().String() }
+
+func TestSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	received := make(chan string, 100)
+	s, err := NewServer(func() (Listener, error) { return &testListener{l}, nil }, func(s *Server) {
+		s.Debug(false)
+		s.SetSession()
+		s.ClientManage.SetOptions(func(c *Client) {
+			c.SetReadWriteWithPkg()
+		})
+		s.SetConnectFunc(func(c *Client) error {
+			c.SetKey("test")
+			c.Tag().Set("name", "session")
+			return nil
+		})
+		s.SetDealFunc(func(c *Client, msg Message) {
+			received <- msg.String()
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Run()
+
+	conns := make(chan net.Conn, 10)
+	c := Redial(func(ctx context.Context) (ReadWriteCloser, string, error) {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			conns <- c
+		}
+		return c, addr, err
+	}, func(c *Client) {
+		c.Debug(false)
+		c.SetReadWriteWithPkg()
+		c.SetSession()
+	})
+	defer c.CloseAll()
+
+	expect := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case msg := <-received:
+				if msg != fmt.Sprint(i) {
+					t.Fatalf("预期(%d),得到(%s)", i, msg)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatalf("未收到数据(%d)", i)
+			}
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		c.WriteString(fmt.Sprint(i))
+	}
+	expect(5)
+	id := c.SessionID()
+	if len(id) == 0 {
+		t.Fatal("会话未建立")
+	}
+	first := s.GetClient("test")
+
+	//断开底层连接,断开期间写入数据
+	(<-conns).Close()
+	<-c.Done()
+	for i := 0; i < 10; i++ {
+		c.WriteString(fmt.Sprint(i))
+	}
+	expect(10)
+	select {
+	case msg := <-received:
+		t.Fatalf("收到重复数据(%s)", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	if c.SessionID() != id {
+		t.Errorf("会话ID变化,预期(%s),得到(%s)", id, c.SessionID())
+	}
+	sc := s.GetClient("test")
+	if sc == nil || sc == first {
+		t.Fatal("服务端未恢复会话")
+	}
+	if sc.Tag().GetString("name") != "session" {
+		t.Errorf("标签未保留")
+	}
+}
+
+// TestSessionServerWrite 服务端的客户端断开期间写入的数据缓存,恢复会话后补发,之前的客户端仍可以写入
+func TestSessionServerWrite(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	clients := make(chan *Client, 10)
+	s, err := NewServer(func() (Listener, error) { return &testListener{l}, nil }, func(s *Server) {
+		s.Debug(false)
+		s.SetSession()
+		s.ClientManage.SetOptions(func(c *Client) {
+			c.SetReadWriteWithPkg()
+		})
+		s.SetConnectFunc(func(c *Client) error {
+			c.SetKey("test")
+			clients <- c
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Run()
+
+	received := make(chan string, 100)
+	conns := make(chan net.Conn, 10)
+	c := Redial(func(ctx context.Context) (ReadWriteCloser, string, error) {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			conns <- c
+		}
+		return c, addr, err
+	}, func(c *Client) {
+		c.Debug(false)
+		c.SetReadWriteWithPkg()
+		c.SetSession()
+		c.SetDealFunc(func(c *Client, msg Message) {
+			received <- msg.String()
+		})
+	})
+	defer c.CloseAll()
+
+	expect := func(list ...string) {
+		t.Helper()
+		for _, v := range list {
+			select {
+			case msg := <-received:
+				if msg != v {
+					t.Fatalf("预期(%s),得到(%s)", v, msg)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatalf("未收到数据(%s)", v)
+			}
+		}
+	}
+
+	first := <-clients
+	if _, err := first.WriteString("a"); err != nil {
+		t.Fatal(err)
+	}
+	expect("a")
+
+	//断开底层连接,客户端1秒后重连,断开期间服务端写入数据
+	(<-conns).Close()
+	<-first.DoneAll()
+	for _, v := range []string{"b", "c"} {
+		if _, err := first.WriteString(v); err != nil {
+			t.Fatalf("断开期间写入失败: %v", err)
+		}
+	}
+	expect("b", "c")
+
+	second := <-clients
+	deadline := time.Now().Add(time.Second)
+	for s.GetClient("test") != second && time.Now().Before(deadline) {
+		<-time.After(time.Millisecond * 10)
+	}
+	if s.GetClient("test") != second {
+		t.Fatal("管理中应该是恢复会话后的客户端")
+	}
+	//之前的客户端写入,通过新的客户端发送
+	if _, err := first.WriteString("d"); err != nil {
+		t.Fatal(err)
+	}
+	expect("d")
+}
+
+// TestSessionExpired 服务端的会话过期后写入返回错误
+func TestSessionExpired(t *testing.T) {
+	a, _ := newPipeClient(t)
+	a.CloseAll()
+	s := newSession(DefaultSessionBuffer)
+	s.store = newSessionStore(time.Millisecond)
+	s.client = a
+	s.activeTime = time.Now().Add(-time.Second)
+	if _, err := s.write(a, []byte("hello")); err != ErrSessionExpired {
+		t.Fatalf("预期(%v),得到(%v)", ErrSessionExpired, err)
+	}
+}
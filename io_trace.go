@@ -0,0 +1,230 @@
+package io
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+
+链路追踪,OpenTelemetry风格的接口,不依赖otel的SDK,可以自行适配
+
+io.dial    连接,Client.Dial
+io.accept  服务端接受连接,ClientManage.SetClient,包括连接事件
+io.read    读取到一帧数据,每条数据的根跨度,包括会话,心跳和数据处理
+io.deal    数据处理,dealFunc,可以通过Client.MessageContext继续追踪
+io.write   写入数据,在数据处理中写入时,父级为io.deal
+
+*/
+
+const (
+	SpanDial   = "io.dial"
+	SpanAccept = "io.accept"
+	SpanRead   = "io.read"
+	SpanDeal   = "io.deal"
+	SpanWrite  = "io.write"
+)
+
+// Span 追踪跨度
+type Span interface {
+	SetAttributes(attrs ...Field)
+	RecordError(err error)
+	End()
+}
+
+// Tracer 链路追踪,返回的上下文需要包含新的跨度
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, attrs ...Field) (context.Context, Span)
+}
+
+type tracerKey struct{}
+
+type spanKey struct{}
+
+// ContextWithTracer 上下文中设置追踪,通过该上下文新建的客户端默认使用该追踪
+func ContextWithTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// TracerFromContext 获取上下文中的追踪,不存在则返回空操作的追踪
+func TracerFromContext(ctx context.Context) Tracer {
+	if ctx != nil {
+		if t, ok := ctx.Value(tracerKey{}).(Tracer); ok && t != nil {
+			return t
+		}
+	}
+	return nopTracer{}
+}
+
+// ContextWithSpan 上下文中设置跨度,用于适配其他追踪
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 获取上下文中的跨度,不存在则返回空操作的跨度
+func SpanFromContext(ctx context.Context) Span {
+	if ctx != nil {
+		if span, ok := ctx.Value(spanKey{}).(Span); ok && span != nil {
+			return span
+		}
+	}
+	return nopSpan{}
+}
+
+//================================Nop================================
+
+type nopTracer struct{}
+
+func (nopTracer) StartSpan(ctx context.Context, name string, attrs ...Field) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(attrs ...Field) {}
+
+func (nopSpan) RecordError(err error) {}
+
+func (nopSpan) End() {}
+
+//================================Memory================================
+
+// NewMemoryTracer 内存追踪,记录结束的跨度,用于测试
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+// MemoryTracer 内存追踪
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []*MemorySpan
+}
+
+func (this *MemoryTracer) StartSpan(ctx context.Context, name string, attrs ...Field) (context.Context, Span) {
+	span := &MemorySpan{
+		tracer:    this,
+		Name:      name,
+		Attrs:     attrs,
+		StartTime: time.Now(),
+	}
+	if parent, ok := SpanFromContext(ctx).(*MemorySpan); ok {
+		span.Parent = parent
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// Spans 已结束的跨度,按结束顺序
+func (this *MemoryTracer) Spans() []*MemorySpan {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return append([]*MemorySpan(nil), this.spans...)
+}
+
+// Reset 清空记录的跨度
+func (this *MemoryTracer) Reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.spans = nil
+}
+
+// MemorySpan 内存追踪的跨度
+type MemorySpan struct {
+	tracer    *MemoryTracer
+	mu        sync.Mutex
+	Name      string
+	Parent    *MemorySpan
+	Attrs     []Field
+	Err       error
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func (this *MemorySpan) SetAttributes(attrs ...Field) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.Attrs = append(this.Attrs, attrs...)
+}
+
+func (this *MemorySpan) RecordError(err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.Err = err
+}
+
+func (this *MemorySpan) End() {
+	this.mu.Lock()
+	ended := !this.EndTime.IsZero()
+	if !ended {
+		this.EndTime = time.Now()
+	}
+	this.mu.Unlock()
+	if !ended {
+		this.tracer.mu.Lock()
+		this.tracer.spans = append(this.tracer.spans, this)
+		this.tracer.mu.Unlock()
+	}
+}
+
+// Attr 获取属性值
+func (this *MemorySpan) Attr(key string) (interface{}, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for i := len(this.Attrs) - 1; i >= 0; i-- {
+		if this.Attrs[i].Key == key {
+			return this.Attrs[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+//================================Client================================
+
+// messageContext 当前处理数据的上下文
+type messageContext struct {
+	ctx context.Context
+}
+
+// SetTracer 设置链路追踪,重连后保留,未设置时使用上下文中的追踪(ContextWithTracer)
+func (this *Client) SetTracer(t Tracer) *Client {
+	this.tracer = t
+	return this
+}
+
+// getTracer 获取链路追踪,优先客户端设置,其次父级上下文
+func (this *Client) getTracer() Tracer {
+	if this.tracer != nil {
+		return this.tracer
+	}
+	return TracerFromContext(this.ctxParent)
+}
+
+// startSpan 开始跨度,附带客户端标识,返回的上下文包含追踪,下游新建的客户端可以继续追踪
+func (this *Client) startSpan(ctx context.Context, name string, attrs ...Field) (context.Context, Span) {
+	t := this.getTracer()
+	if _, ok := t.(nopTracer); ok {
+		return ctx, nopSpan{}
+	}
+	ctx, span := t.StartSpan(ctx, name, append([]Field{{FieldKey, this.GetKey()}}, attrs...)...)
+	return ContextWithTracer(ctx, t), span
+}
+
+// MessageContext 当前处理数据的上下文,在dealFunc中使用可以继续追踪,
+// 例如 io.NewDialWithContext(c.MessageContext(),dial),不在处理数据时返回连接的上下文
+func (this *Client) MessageContext() context.Context {
+	if v, ok := this.messageCtx.Load().(messageContext); ok && v.ctx != nil {
+		return v.ctx
+	}
+	return this.Ctx()
+}
+
+func (this *Client) setMessageContext(ctx context.Context) {
+	this.messageCtx.Store(messageContext{ctx: ctx})
+}
+
+//================================ClientManage================================
+
+// SetTracer 设置客户端的链路追踪
+func (this *ClientManage) SetTracer(t Tracer) {
+	this.SetOptions(func(c *Client) { c.SetTracer(t) })
+}
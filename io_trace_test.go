@@ -0,0 +1,112 @@
+package io
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitSpan 等待指定名称的跨度结束
+func waitSpan(t *testing.T, tracer *MemoryTracer, name string) *MemorySpan {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, v := range tracer.Spans() {
+			if v.Name == name {
+				return v
+			}
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Fatalf("未找到跨度(%s)", name)
+	return nil
+}
+
+func TestTraceMessage(t *testing.T) {
+	tracer := NewMemoryTracer()
+	a, b := newPipeClient(t)
+	b.SetTracer(tracer)
+	b.SetDealFunc(func(c *Client, msg Message) {
+		//处理数据时连接下游,继续追踪
+		c1, _ := net.Pipe()
+		down, err := NewDialWithContext(c.MessageContext(), func(ctx context.Context) (ReadWriteCloser, string, error) {
+			return c1, "down", nil
+		}, func(c *Client) { c.Debug(false) })
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		down.CloseAll()
+		c.WriteString("pong")
+	})
+	go a.Run()
+	go b.Run()
+	if _, err := a.WriteString("ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	write := waitSpan(t, tracer, SpanWrite)
+	dial := waitSpan(t, tracer, SpanDial)
+	deal := waitSpan(t, tracer, SpanDeal)
+	read := waitSpan(t, tracer, SpanRead)
+	if read.Parent != nil {
+		t.Error("读取应该是根跨度")
+	}
+	if deal.Parent != read || dial.Parent != deal || write.Parent != deal {
+		t.Fatal("跨度的父级错误")
+	}
+	if v, _ := read.Attr(FieldBytes); v != 4 {
+		t.Errorf("预期4字节,得到(%v)", v)
+	}
+	if v, _ := dial.Attr(FieldKey); v != "down" {
+		t.Errorf("预期标识down,得到(%v)", v)
+	}
+
+	//处理结束后,写入的父级为空
+	tracer.Reset()
+	b.WriteString("hello")
+	if write := waitSpan(t, tracer, SpanWrite); write.Parent != nil {
+		t.Error("处理结束后写入不应该有父级")
+	}
+}
+
+func TestTraceDialError(t *testing.T) {
+	tracer := NewMemoryTracer()
+	_, err := NewDialWithContext(ContextWithTracer(context.Background(), tracer), func(ctx context.Context) (ReadWriteCloser, string, error) {
+		return nil, "", ErrRemoteOff
+	})
+	if err == nil {
+		t.Fatal("预期连接错误")
+	}
+	if dial := waitSpan(t, tracer, SpanDial); dial.Err != ErrRemoteOff {
+		t.Errorf("预期错误(%v),得到(%v)", ErrRemoteOff, dial.Err)
+	}
+}
+
+func TestTraceAccept(t *testing.T) {
+	tracer := NewMemoryTracer()
+	m := NewClientManage("test", NewLoggerWithNull())
+	m.SetTracer(tracer)
+	m.SetOptions(func(c *Client) { c.Debug(false) })
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := NewClient(c1, func(c *Client) { c.SetKey("device") })
+	defer c.CloseAll()
+	m.SetClient(c)
+	accept := waitSpan(t, tracer, SpanAccept)
+	if v, _ := accept.Attr(FieldKey); v != "device" {
+		t.Errorf("预期标识device,得到(%v)", v)
+	}
+}
+
+func TestTraceNop(t *testing.T) {
+	a, _ := newPipeClient(t)
+	if _, ok := a.getTracer().(nopTracer); !ok {
+		t.Fatal("默认应该是空操作")
+	}
+	if a.MessageContext() != a.Ctx() {
+		t.Fatal("不在处理数据时应该返回连接的上下文")
+	}
+	SpanFromContext(context.Background()).End()
+}
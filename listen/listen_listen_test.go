@@ -2,13 +2,24 @@ package listen
 
 import (
 	"errors"
+	"flag"
 	"github.com/injoyai/io"
 	"github.com/injoyai/io/dial"
 	"testing"
 	"time"
 )
 
+// manual 手动测试,会一直运行或者需要固定端口,使用 go test -run xxx -manual 运行
+var manual = flag.Bool("manual", false, "运行手动测试")
+
+func skipManual(t *testing.T) {
+	if !*manual {
+		t.Skip("手动测试,使用-manual运行")
+	}
+}
+
 func TestTCPServer(t *testing.T) {
+	skipManual(t)
 	s, err := NewTCPServer(10089)
 	if err != nil {
 		t.Error(err)
@@ -27,6 +38,7 @@ func TestTCPServer(t *testing.T) {
 }
 
 func TestRedial(t *testing.T) {
+	skipManual(t)
 	dial.RedialTCP(":10086", func(c *io.Client) {
 		c.SetPrintWithUTF8()
 		c.Debug()
@@ -36,6 +48,7 @@ func TestRedial(t *testing.T) {
 }
 
 func TestRunUDPServer(t *testing.T) {
+	skipManual(t)
 	RunUDPServer(20001, func(s *io.Server) {
 		s.Debug()
 		s.SetPrintWithHEX()
@@ -47,13 +60,16 @@ func TestRunUDPServer(t *testing.T) {
 
 // 测试传输速度
 func TestIOSpeed(t *testing.T) {
+	skipManual(t)
 	start := time.Now() //当前时间
 	length := 20 << 20  //传输的数据大小
 	go RunTCPServer(io.DefaultPort, func(s *io.Server) {
 		s.SetLevel(io.LevelInfo)
 		s.Debug(false)
-		s.SetReadWith1KB() //100毫秒
-		s.SetReadWithMB(1) //65毫秒
+		s.ClientManage.SetOptions(func(c *io.Client) {
+			//c.SetReadWith1KB() //100毫秒
+			c.SetReadWithKB(1024) //65毫秒
+		})
 		s.SetDealFunc(func(c *io.Client, msg io.Message) {
 			t.Log("数据长度: ", msg.Len())
 			t.Log("传输耗时: ", time.Now().Sub(start))
@@ -71,6 +87,7 @@ func TestIOSpeed(t *testing.T) {
 }
 
 func TestServerErr(t *testing.T) {
+	skipManual(t)
 	s, err := NewTCPServer(1)
 	if err != nil {
 		t.Error(err)
@@ -1,167 +1,122 @@
 package listen
 
 import (
-	"context"
+	"encoding/binary"
 	"errors"
-	"github.com/injoyai/base/g"
-	"github.com/injoyai/conv"
 	"github.com/injoyai/io"
 	"github.com/injoyai/io/dial"
+	goio "io"
+	"sync/atomic"
 )
 
 /*
-Client
-抽象管道概念
-例如 用485线通讯,正常的TCP连接 都属于管道
-需要 客户端对客户端 客户端对服务端 2种方式
-需要 一个管道通讯多个io数据,并且不能长期占用 写入前建议分包
-只做数据加密(可选),不解析数据,不分包数据
+隧道
+客户端监听本地端口,所有连接通过一个隧道连接转发到服务端,由服务端连接目标地址并交换数据
+隧道使用多路复用(io.Mux),每个连接对应隧道中的一个流,互不影响
+流的第一个数据是目标地址,2字节长度(大端)+地址
+加密(可选) 隧道客户端的选项中设置c.SetCipherClient,服务端的选项中设置c.SetCipherServer
 
-提供io.Reader io.Writer接口
-写入数据会封装一层(封装连接信息,动作,数据)
-mod
-*/
-
-const (
-	TypeConnect  = 0x01
-	TypeWrite    = 0x02
-	TypeClose    = 0x03
-	TypeRequest  = 0x00
-	TypeResponse = 0x01
-)
-
-func decodeTunnelMessage(bs []byte) (*TunnelMessage, error) {
-	if len(bs) < 2 {
-		return nil, errors.New("数据异常")
-	}
-	return &TunnelMessage{
-		Type:  bs[0],
-		Model: bs[1],
-		Data:  bs[2:],
-	}, nil
-}
-
-type TunnelMessage struct {
-	Type  uint8  //类型
-	Model uint8  //模式
-	Data  []byte //数据
-}
+本地连接 --- NewTunnelClient ===隧道=== NewTunnelServer --- proxyAddr
 
-func (this *TunnelMessage) Bytes() g.Bytes {
-	data := []byte(nil)
-	data = append(data, this.Type, this.Model)
-	data = append(data, this.Data...)
-	return data
-}
+*/
 
-func NewTunnelClient(s *io.Server, tunDial io.DialFunc, proxyAddr string, options ...io.OptionClient) {
-	pool := io.NewPool(tunDial, options...)
+// NewTunnelClient 隧道客户端,本地服务的连接通过隧道转发到proxyAddr
+// 一直连接隧道直到成功,断开后自动重连,关闭隧道使用CloseAll
+func NewTunnelClient(s *io.Server, tunDial io.DialFunc, proxyAddr string, options ...io.OptionClient) *io.Client {
+	mux := &atomic.Value{}
+	tun := io.Redial(tunDial, func(c *io.Client) {
+		c.SetOptions(options...)
+		//每次连接成功,新建多路复用
+		mux.Store(io.NewMuxClient(c))
+	})
 	s.ClientManage.SetOptions(func(c *io.Client) {
 		c.SetConnectFunc(func(client *io.Client) error {
-			tun, err := pool.Get()
+			m, _ := mux.Load().(*io.Mux)
+			if m == nil || tun.Closed() {
+				return errors.New("隧道未连接")
+			}
+			stream, err := m.OpenStream()
 			if err != nil {
 				return err
 			}
-
-			{
-				client.SetReadWith1KB()
-				client.SetDealFunc(func(c *io.Client, msg io.Message) {
-					//写入数据
-					tun.Write(append([]byte{TypeWrite, TypeRequest}, msg.Bytes()...))
-				})
-				client.SetCloseFunc(func(ctx context.Context, c *io.Client, err error) {
-					//发送关闭信息
-					tun.Write(append([]byte{TypeClose, TypeRequest}, conv.Bytes(err)...))
-					//放回连接池
-					pool.Put(tun)
-				})
+			if _, err := stream.Write(encodeTunnelAddr(proxyAddr)); err != nil {
+				stream.Close()
+				return err
 			}
-
-			{
-				tun.SetKey(client.GetKey())
-				tun.SetReadWriteWithPkg()
-				tun.SetCloseWithCloser(client)
-				tun.SetDealFunc(func(c *io.Client, msg io.Message) {
-					m, err := decodeTunnelMessage(msg.Bytes())
-					if err != nil {
-						tun.Write(append([]byte{TypeClose, TypeRequest}, []byte(err.Error())...))
-						client.CloseWithErr(errors.New(err.Error()))
-						return
-					}
-					switch m.Type {
-					case TypeConnect:
-						//连接成功响应
-					case TypeWrite:
-						//写入数据
-						client.Write(m.Data)
-					default: //TypeClose
-						client.CloseWithErr(errors.New(string(m.Data)))
-					}
-				})
-
-				//发送连接信息
-				_, err = tun.WriteRead(append([]byte{TypeConnect, TypeRequest}, []byte(proxyAddr)...), io.DefaultConnectTimeout)
-				if err != nil {
-					return err
+			client.SetReadWith1KB()
+			client.SetDealFunc(func(c *io.Client, msg io.Message) {
+				if _, err := stream.Write(msg.Bytes()); err != nil {
+					c.CloseWithErr(err)
 				}
-			}
-
+			})
+			go func() {
+				goio.Copy(client, stream)
+				client.CloseWithErr(io.ErrRemoteClose)
+			}()
+			go func() {
+				<-client.Done()
+				stream.Close()
+			}()
 			return nil
 		})
 	})
+	return tun
 }
 
+// NewTunnelServer 隧道服务端,每个隧道连接可以同时转发多个连接
 func NewTunnelServer(s *io.Server) {
 	s.ClientManage.SetOptions(func(c *io.Client) {
 		c.SetConnectFunc(func(tun *io.Client) error {
-			var c *io.Client
-			tun.SetReadWriteWithPkg()
-			tun.SetDealFunc(func(c2 *io.Client, msg io.Message) {
-				m, err := decodeTunnelMessage(msg.Bytes())
-				if err == nil {
-					switch m.Type {
-					case TypeConnect:
-						switch m.Model {
-						default:
-							c, err = dial.NewTCP(string(m.Data), func(c *io.Client) {
-								c.Debug(false)
-								c.SetReadWith1KB()
-								c.SetDealFunc(func(c *io.Client, msg io.Message) {
-									//写入数据
-									tun.Write(append([]byte{TypeWrite, TypeRequest}, msg.Bytes()...))
-								})
-								c.SetCloseFunc(func(ctx context.Context, c *io.Client, err error) {
-									//发送关闭信息
-									tun.Write(append([]byte{TypeClose, TypeRequest}, conv.Bytes(err)...))
-								})
-								tun.SetCloseWithCloser(c)
-							})
-							if err != nil {
-								//发送关闭信息
-								tun.Write(append([]byte{TypeClose, TypeRequest}, []byte(err.Error())...))
-								return
-							}
-							tun.Write(append([]byte{TypeConnect, TypeResponse}, []byte("连接成功")...))
-							go c.Run()
-						}
-					case TypeWrite:
-						if c == nil || c.Closed() {
-							//发送关闭信息
-							tun.Write(append([]byte{TypeClose, TypeRequest}, []byte("无连接")...))
-							return
-						}
-						//写入数据
-						c.Write(m.Data)
-					case TypeClose:
-						//关闭连接
-						if c != nil {
-							c.Close()
-						}
-
+			m := io.NewMuxServer(tun)
+			go func() {
+				for {
+					stream, err := m.AcceptStream()
+					if err != nil {
+						return
 					}
+					go dealTunnelStream(tun, stream)
 				}
-			})
+			}()
 			return nil
 		})
 	})
 }
+
+// dealTunnelStream 读取目标地址,连接并交换数据,任意一端结束后关闭
+func dealTunnelStream(tun *io.Client, stream *io.Stream) {
+	defer stream.Close()
+	addr, err := decodeTunnelAddr(stream)
+	if err != nil {
+		tun.Errorf("[%s] 隧道数据错误: %v\n", tun.GetKey(), err)
+		return
+	}
+	i, _, err := dial.TCP(addr)
+	if err != nil {
+		tun.Errorf("[%s] 连接目标(%s)失败: %v\n", tun.GetKey(), addr, err)
+		return
+	}
+	defer i.Close()
+	go func() {
+		goio.Copy(i, stream)
+		i.Close()
+	}()
+	goio.Copy(stream, i)
+}
+
+func encodeTunnelAddr(addr string) []byte {
+	bs := make([]byte, 2, 2+len(addr))
+	binary.BigEndian.PutUint16(bs, uint16(len(addr)))
+	return append(bs, addr...)
+}
+
+func decodeTunnelAddr(r goio.Reader) (string, error) {
+	bs := make([]byte, 2)
+	if _, err := goio.ReadFull(r, bs); err != nil {
+		return "", err
+	}
+	bs = make([]byte, binary.BigEndian.Uint16(bs))
+	if _, err := goio.ReadFull(r, bs); err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
@@ -1,12 +1,108 @@
 package listen
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/injoyai/io"
 	"github.com/injoyai/io/dial"
+	goio "io"
+	"net"
+	"sync"
 	"testing"
+	"time"
 )
 
+// TestTunnel 多个连接同时通过一个隧道转发
+func TestTunnel(t *testing.T) {
+	testTunnel(t, func(c *io.Client) {}, func(c *io.Client) {})
+}
+
+// TestTunnelCipher 加密隧道
+func TestTunnelCipher(t *testing.T) {
+	cfg := &io.CipherConfig{PSK: []byte("psk")}
+	testTunnel(t, func(c *io.Client) { c.SetCipherServer(cfg) }, func(c *io.Client) { c.SetCipherClient(cfg) })
+}
+
+func testTunnel(t *testing.T, serverOption, clientOption io.OptionClient) {
+	//目标服务,原样返回
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				goio.Copy(c, c)
+			}()
+		}
+	}()
+
+	tunServer, err := NewTCPServer(0, func(s *io.Server) {
+		s.Debug(false)
+		s.ClientManage.SetOptions(serverOption)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunServer.Close()
+	NewTunnelServer(tunServer)
+	go tunServer.Run()
+
+	local, err := NewTCPServer(0, func(s *io.Server) { s.Debug(false) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	tun := NewTunnelClient(local, dial.WithTCP(tunServer.Listener().Addr()), target.Addr().String(), func(c *io.Client) {
+		c.Debug(false)
+		clientOption(c)
+	})
+	defer tun.CloseAll()
+	go local.Run()
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := net.Dial("tcp", local.Listener().Addr())
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer c.Close()
+			want := bytes.Repeat([]byte(fmt.Sprintf("session-%d;", i)), 10000)
+			go c.Write(want)
+			c.SetReadDeadline(time.Now().Add(time.Second * 5))
+			got := make([]byte, len(want))
+			if _, err := goio.ReadFull(c, got); err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, want) {
+				errs <- fmt.Errorf("会话(%d)数据错误", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := tunServer.GetClientLen(); n != 1 {
+		t.Errorf("预期1个隧道连接,得到(%d)", n)
+	}
+}
+
 func TestNewTunnelServer(t *testing.T) {
+	skipManual(t)
 	s, err := NewTCPServer(20088, func(s *io.Server) {
 		s.Debug(true)
 		s.SetPrintWithUTF8()
@@ -20,6 +116,7 @@ func TestNewTunnelServer(t *testing.T) {
 }
 
 func TestNewTunnelClient(t *testing.T) {
+	skipManual(t)
 	s, err := NewTCPServer(20086, func(s *io.Server) {
 		s.Debug(true)
 		s.SetPrintWithHEX()
@@ -16,9 +16,9 @@ func main() {
 		logs.Err(err)
 		return
 	}
-	s.Bind("/test", func(ctx context.Context, c *io.Client, msg *io.Model) (interface{}, error) {
+	rpc.Register(s, "/test", func(ctx context.Context, c *io.Client, req int) (int, error) {
 		<-time.After(time.Millisecond * 100)
-		return msg.Data, nil
+		return req, nil
 	})
 	go s.Run()
 	c := rpc.NewClient(&rpc.ClientConfig{